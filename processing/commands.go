@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	databasePackage "github.com/kaspa-live/kaspa-graph-inspector/processing/database"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/graphexport"
+	configPackage "github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/config"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/logging"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/network/blocksource"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/network/fakekaspad"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/parquetexport"
+	processingPackage "github.com/kaspa-live/kaspa-graph-inspector/processing/processing"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/syntheticdag"
+)
+
+// migrateDatabase runs the migrate subcommands. The database is not migrated up beforehand.
+func migrateDatabase(config *configPackage.Config) {
+	connectionString, err := databasePackage.MigrationConnectionString(config.DatabaseConnectionString, databaseConnectionOptions(config))
+	if err != nil {
+		logging.LogErrorAndExit("Could not run migrate %s: %s", config.MigrateCommand, err)
+	}
+	switch config.MigrateCommand {
+	case configPackage.MigrateUpCommand:
+		err = databasePackage.Migrate(connectionString)
+	case configPackage.MigrateDownCommand:
+		if config.MigrateDown.ToVersion >= 0 {
+			err = databasePackage.MigrateDownTo(connectionString, uint(config.MigrateDown.ToVersion))
+		} else {
+			err = databasePackage.MigrateDown(connectionString, config.MigrateDown.Steps)
+		}
+	case configPackage.MigrateForceCommand:
+		err = databasePackage.ForceMigrationVersion(connectionString, config.MigrateForce.ToVersion)
+	case configPackage.MigrateStatusCommand:
+		var status *databasePackage.MigrationStatus
+		status, err = databasePackage.GetMigrationStatus(connectionString)
+		if err == nil {
+			fmt.Printf("Version:        %d\n", status.Version)
+			fmt.Printf("Dirty:          %t\n", status.IsDirty)
+			fmt.Printf("Latest version: %d\n", status.LatestVersion)
+			fmt.Printf("Pending:        %v\n", status.PendingVersions)
+		}
+	}
+	if err != nil {
+		logging.LogErrorAndExit("Could not run migrate %s: %s", config.MigrateCommand, err)
+	}
+}
+
+// check runs the check command, connecting to the node only to repair the violations found
+func check(ctx context.Context, config *configPackage.Config, database *databasePackage.Database) {
+	var blockSource blocksource.BlockSource
+	if config.Check.Repair {
+		blockSource, _ = newBlockSource(config)
+		defer blockSource.Close()
+	}
+
+	processing, err := processingPackage.NewProcessing(config, database.Storage(), blockSource)
+	if err != nil {
+		logging.LogErrorAndExit("Could not initialize processing: %s", err)
+	}
+	report, err := processing.CheckConsistency(ctx, config.Check.Repair)
+	if err != nil {
+		database.Close()
+		logging.LogErrorAndExit("Could not check the database consistency: %s", err)
+	}
+	if config.Check.Repair {
+		logging.Logger().Infof("%d violations repaired, %d blocks could not be repaired", report.Repaired, len(report.Unrepairable))
+	}
+	if len(report.Remaining) > 0 {
+		database.Close()
+		logging.LogErrorAndExit("The database breaks %d consistency invariants", len(report.Remaining))
+	}
+	logging.Logger().Infof("The database is consistent")
+}
+
+// exportDatabase writes a snapshot of the database to the output file
+func exportDatabase(ctx context.Context, config *configPackage.Config, database *databasePackage.Database) {
+	file, err := os.Create(config.Export.Output)
+	if err != nil {
+		logging.LogErrorAndExit("Could not create %s: %s", config.Export.Output, err)
+	}
+	var header *databasePackage.SnapshotHeader
+	err = database.RunInTransaction(ctx, func(databaseTransaction *pg.Tx) error {
+		// A retried transaction writes the snapshot again from the start
+		err := rewind(file)
+		if err != nil {
+			return err
+		}
+		err = file.Truncate(0)
+		if err != nil {
+			return err
+		}
+		header, err = database.Export(databaseTransaction, file)
+		return err
+	})
+	if err == nil {
+		err = file.Close()
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(config.Export.Output)
+		database.Close()
+		logging.LogErrorAndExit("Could not export the database to %s: %s", config.Export.Output, err)
+	}
+	logging.Logger().Infof("Exported the %s database (schema version %d) to %s",
+		header.Network, header.SchemaVersion, config.Export.Output)
+}
+
+// importDatabase replaces the content of the database with the input snapshot file.
+// The snapshot must be of the configured network.
+func importDatabase(ctx context.Context, config *configPackage.Config, database *databasePackage.Database) {
+	file, err := os.Open(config.Import.Input)
+	if err != nil {
+		logging.LogErrorAndExit("Could not open %s: %s", config.Import.Input, err)
+	}
+	defer file.Close()
+
+	var header *databasePackage.SnapshotHeader
+	err = database.RunInTransaction(ctx, func(databaseTransaction *pg.Tx) error {
+		// A retried transaction reads the snapshot again from the start
+		err := rewind(file)
+		if err != nil {
+			return err
+		}
+		header, err = database.Import(databaseTransaction, file, config.NetName)
+		return err
+	})
+	if err != nil {
+		database.Close()
+		logging.LogErrorAndExit("Could not import %s: %s", config.Import.Input, err)
+	}
+	logging.Logger().Infof("Imported %s, created on %s, into the database", config.Import.Input,
+		time.UnixMilli(header.CreatedAt).UTC().Format(time.RFC3339))
+}
+
+func rewind(file *os.File) error {
+	_, err := file.Seek(0, io.SeekStart)
+	return err
+}
+
+// prune deletes the blocks below the DAA score given by the options, or by default
+// below the DAA score of the pruning point of the node
+func prune(ctx context.Context, config *configPackage.Config, database *databasePackage.Database) {
+	daaScore := config.Prune.BelowDAAScore
+	if daaScore == 0 {
+		blockSource, _ := newBlockSource(config)
+		defer blockSource.Close()
+
+		dagInfo, err := blockSource.GetBlockDAGInfo(ctx)
+		if err != nil {
+			logging.LogErrorAndExit("Could not get the DAG info from the node: %s", err)
+		}
+		pruningPoint, err := blockSource.GetBlock(ctx, dagInfo.PruningPointHash, false)
+		if err != nil {
+			logging.LogErrorAndExit("Could not get the pruning point %s from the node: %s", dagInfo.PruningPointHash, err)
+		}
+		daaScore = pruningPoint.Block.Header.DAAScore
+		logging.Logger().Infof("Pruning the blocks below the pruning point %s of the node", dagInfo.PruningPointHash)
+	}
+
+	var prunedBlockCount int
+	err := database.RunInTransaction(ctx, func(databaseTransaction *pg.Tx) error {
+		var err error
+		prunedBlockCount, err = database.PruneBlocksBelowDAAScore(databaseTransaction, daaScore)
+		return err
+	})
+	if err != nil {
+		database.Close()
+		logging.LogErrorAndExit("Could not prune the blocks below DAA score %d: %s", daaScore, err)
+	}
+	logging.Logger().Infof("Pruned %d blocks below DAA score %d", prunedBlockCount, daaScore)
+}
+
+// stats prints statistics about the content of the database
+func stats(ctx context.Context, database *databasePackage.Database) {
+	var databaseStats *databasePackage.Stats
+	err := database.RunInTransaction(ctx, func(databaseTransaction *pg.Tx) error {
+		var err error
+		databaseStats, err = database.GetStats(databaseTransaction)
+		return err
+	})
+	if err != nil {
+		database.Close()
+		logging.LogErrorAndExit("Could not get the database statistics: %s", err)
+	}
+	fmt.Printf("Blocks:         %d (%d in the virtual selected parent chain, %d blue, %d red)\n",
+		databaseStats.BlockCount, databaseStats.ChainBlockCount, databaseStats.BlueBlockCount, databaseStats.RedBlockCount)
+	fmt.Printf("Edges:          %d\n", databaseStats.EdgeCount)
+	fmt.Printf("Height groups:  %d\n", databaseStats.HeightGroupCount)
+	fmt.Printf("Heights:        %d to %d\n", databaseStats.MinHeight, databaseStats.MaxHeight)
+	fmt.Printf("Segments:       %d (%d after a gap)\n", databaseStats.SegmentCount, databaseStats.GapCount)
+	fmt.Printf("DAA scores:     %d to %d\n", databaseStats.MinDAAScore, databaseStats.MaxDAAScore)
+	fmt.Printf("Database size:  %s\n", databaseStats.DatabaseSize)
+}
+
+// exportGraph writes the blocks within the range given by the options and the edges between them
+// as a graph to the output file
+func exportGraph(ctx context.Context, config *configPackage.Config, database *databasePackage.Database) {
+	blockRange := &databasePackage.BlockRange{
+		By:   databasePackage.RangeByHeight,
+		From: config.Graph.From,
+		To:   config.Graph.To,
+	}
+	if config.Graph.By == "daa-score" {
+		blockRange.By = databasePackage.RangeByDAAScore
+	}
+	options := &graphexport.Options{
+		Range:               blockRange,
+		SelectedParentEdges: config.Graph.SelectedParentEdges,
+	}
+
+	var graph *graphexport.Graph
+	err := database.RunInTransaction(ctx, func(databaseTransaction *pg.Tx) error {
+		var err error
+		graph, err = graphexport.Load(databaseTransaction, database, options)
+		return err
+	})
+	if err != nil {
+		database.Close()
+		logging.LogErrorAndExit("Could not load the graph: %s", err)
+	}
+
+	file, err := os.Create(config.Graph.Output)
+	if err != nil {
+		database.Close()
+		logging.LogErrorAndExit("Could not create %s: %s", config.Graph.Output, err)
+	}
+	err = graphexport.Write(file, config.Graph.Format, graph)
+	if err == nil {
+		err = file.Close()
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(config.Graph.Output)
+		database.Close()
+		logging.LogErrorAndExit("Could not write the graph to %s: %s", config.Graph.Output, err)
+	}
+	logging.Logger().Infof("Exported %d blocks and %d edges to %s", len(graph.Nodes), len(graph.Edges), config.Graph.Output)
+}
+
+// exportParquet writes the blocks stored since the last export and their edges to Parquet files
+func exportParquet(ctx context.Context, config *configPackage.Config, database *databasePackage.Database) {
+	result, err := parquetexport.Export(ctx, database, &parquetexport.Options{
+		Directory:     config.Parquet.OutputDir,
+		PartitionSize: config.Parquet.PartitionSize,
+		MinDepth:      config.Parquet.MinDepth,
+		BatchSize:     config.Parquet.BatchSize,
+		Full:          config.Parquet.Full,
+	})
+	if err != nil {
+		database.Close()
+		logging.LogErrorAndExit("Could not export the database to %s: %s", config.Parquet.OutputDir, err)
+	}
+	logging.Logger().Infof("Exported %d blocks and %d edges to %s, up to block ID %d",
+		result.BlockCount, result.EdgeCount, config.Parquet.OutputDir, result.LastBlockID)
+}
+
+// loadTest processes a synthetic DAG served by a fake node within the process, its blocks being generated
+// at the configured rate, then reports the processing rate. The processed blocks are stored in `storage`,
+// which `closeStorage` closes before exiting on failures.
+func loadTest(ctx context.Context, stopSignals context.CancelFunc, config *configPackage.Config,
+	storage databasePackage.Storage, closeStorage func()) {
+
+	options := config.LoadTest
+	// The blocks are generated by rounds of concurrent blocks
+	var roundInterval time.Duration
+	timestampInterval := time.Second
+	if options.BPS > 0 {
+		roundInterval = time.Duration(float64(time.Second) * float64(options.Width) / options.BPS)
+		timestampInterval = roundInterval
+	}
+
+	dag := fakekaspad.NewDAG()
+	server := fakekaspad.NewServer(dag)
+	server.SetNetworkName(config.NetParams().Name)
+	generator, err := syntheticdag.NewGenerator(dag.Block(dag.HashOf(fakekaspad.GenesisName)), &syntheticdag.Options{
+		K:             options.K,
+		Width:         options.Width,
+		MaxParents:    options.MaxParents,
+		RedRate:       options.RedRate,
+		ReorgRate:     options.ReorgRate,
+		ReorgDepth:    options.ReorgDepth,
+		RoundInterval: timestampInterval,
+		Seed:          options.Seed,
+	})
+	if err != nil {
+		logging.LogErrorAndExit("Could not create the synthetic DAG generator: %s", err)
+	}
+	rpcClient, err := server.NewRPCClient(processingPackage.RpcRouteCapacity)
+	if err != nil {
+		logging.LogErrorAndExit("Could not connect to the fake node: %s", err)
+	}
+	processing, err := processingPackage.NewProcessing(config, storage, rpcClient)
+	if err != nil {
+		logging.LogErrorAndExit("Could not initialize processing: %s", err)
+	}
+	err = processing.Start(ctx)
+	if err != nil && ctx.Err() == nil {
+		logging.LogErrorAndExit("Could not initialize processing: %s", err)
+	}
+
+	logging.Logger().Infof("Generating %d blocks at %.1f blocks per second with K=%d", options.Blocks, options.BPS, options.K)
+	start := time.Now()
+	nextRound := start
+	blockCount, redCount := 0, 0
+	for blockCount < options.Blocks && processing.Err() == nil && ctx.Err() == nil {
+		blocks, err := generator.Next()
+		if err != nil {
+			logging.LogErrorAndExit("Could not generate the synthetic DAG: %s", err)
+		}
+		for _, block := range blocks {
+			err = server.AddGHOSTDAGBlock(block)
+			if err != nil {
+				logging.LogErrorAndExit("Could not add block %s to the fake node: %s", block.Hash, err)
+			}
+			redCount += len(block.MergeSetReds)
+		}
+		blockCount += len(blocks)
+
+		if roundInterval == 0 {
+			// Without a block rate, the next blocks get generated once the previous ones got processed
+			err = waitProcessed(ctx, server, processing)
+			if err != nil {
+				break
+			}
+			continue
+		}
+		nextRound = nextRound.Add(roundInterval)
+		select {
+		case <-processing.Done():
+		case <-time.After(time.Until(nextRound)):
+		}
+	}
+	generationDuration := time.Since(start)
+	err = waitProcessed(ctx, server, processing)
+	processingDuration := time.Since(start)
+	// Stopping the processing restores the default signal behavior
+	stopSignals()
+	shutdown(processing, rpcClient, nil, config.ShutdownTimeout)
+
+	if processing.Err() != nil {
+		closeStorage()
+		logging.LogErrorAndExit("Processing stopped: %s", processing.Err())
+	}
+	if err != nil {
+		closeStorage()
+		logging.LogErrorAndExit("Load test interrupted after %d blocks", blockCount)
+	}
+	logging.Logger().Infof("Generated %d blocks, %d of them red, in %s (%.1f blocks per second)",
+		blockCount, redCount, generationDuration, float64(blockCount)/generationDuration.Seconds())
+	logging.Logger().Infof("Processed them in %s (%.1f blocks per second)",
+		processingDuration, float64(blockCount)/processingDuration.Seconds())
+}
+
+// waitProcessed waits for the blocks added to the fake node to be processed
+func waitProcessed(ctx context.Context, server *fakekaspad.Server, processing *processingPackage.Processing) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-processing.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	err := server.WaitDelivered(ctx)
+	if err != nil {
+		return err
+	}
+	return processing.WaitForIdleEvents(ctx)
+}
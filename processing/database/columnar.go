@@ -0,0 +1,62 @@
+package database
+
+import (
+	"github.com/go-pg/pg/v10"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/database/model"
+)
+
+// EdgeWithDAAScore is an edge along with the DAA score of the block it starts from
+type EdgeWithDAAScore struct {
+	model.Edge
+	FromDAAScore uint64 `pg:"from_daa_score,use_zero"`
+}
+
+// SettledBlockIDBound returns the highest block ID below which all the blocks have a DAA score
+// lower or equal to the highest stored DAA score minus `minDepth`. The GHOSTDAG data of the blocks
+// below the bound are unlikely to change anymore. Returns 0 if the database stores no such block.
+func (db *Database) SettledBlockIDBound(databaseTransaction *pg.Tx, minDepth uint64) (uint64, error) {
+	var result struct {
+		Bound uint64
+	}
+	_, err := databaseTransaction.QueryOne(&result, `
+		SELECT COALESCE(MIN(id) - 1, (SELECT COALESCE(MAX(id), 0) FROM blocks)) AS bound
+		FROM blocks
+		WHERE daa_score > (SELECT MAX(daa_score) FROM blocks) - ?::NUMERIC`, minDepth)
+	if err != nil {
+		return 0, err
+	}
+	return result.Bound, nil
+}
+
+// BlocksByIDRange returns the blocks having an ID greater than `afterID` and lower or equal to `toID`,
+// ordered by ID
+func (db *Database) BlocksByIDRange(databaseTransaction *pg.Tx, afterID uint64, toID uint64) ([]*model.Block, error) {
+	var blocks []*model.Block
+	err := databaseTransaction.Model(&blocks).
+		Where("id > ?", afterID).
+		Where("id <= ?", toID).
+		Order("id").
+		Select()
+	if err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+// EdgesByIDRange returns the edges whose most recent block has an ID greater than `afterID` and lower
+// or equal to `toID`. An edge gets stored once both its blocks are, so every edge belongs to the range
+// of the most recent of its blocks.
+func (db *Database) EdgesByIDRange(databaseTransaction *pg.Tx, afterID uint64, toID uint64) ([]*EdgeWithDAAScore, error) {
+	var edges []*EdgeWithDAAScore
+	_, err := databaseTransaction.Query(&edges, `
+		SELECT edges.*, blocks.daa_score AS from_daa_score
+		FROM edges
+		JOIN blocks ON blocks.id = edges.from_block_id
+		WHERE GREATEST(edges.from_block_id, edges.to_block_id) > ?0
+			AND GREATEST(edges.from_block_id, edges.to_block_id) <= ?1
+		ORDER BY edges.from_block_id, edges.to_block_id`, afterID, toID)
+	if err != nil {
+		return nil, err
+	}
+	return edges, nil
+}
@@ -1,8 +1,15 @@
 package database
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"net"
+	"net/url"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/go-pg/pg/extra/pgdebug/v10"
 	"github.com/go-pg/pg/v10"
@@ -18,9 +25,37 @@ var (
 	}
 )
 
+// ConnectionOptions tunes the connections to the database.
+// Zero values keep the defaults of the PostgreSQL client, or disable the timeouts.
+type ConnectionOptions struct {
+	// PoolSize is the maximum number of connections
+	PoolSize int
+	// IdleTimeout is the duration after which an idle connection is closed
+	IdleTimeout time.Duration
+	// ReadTimeout and WriteTimeout bound the socket reads and writes of a query
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// StatementTimeout is the server-side statement_timeout of the connections
+	StatementTimeout time.Duration
+	// TransactionTimeout bounds the duration of a whole transaction, retries excluded
+	TransactionTimeout time.Duration
+	// TransactionRetries is the number of times a transaction failing with a transient
+	// error, such as a lost connection or a serialization failure, is run again
+	TransactionRetries int
+	// TLSCAFile holds the certificate authorities verifying the certificate of the server
+	TLSCAFile string
+	// TLSCertFile and TLSKeyFile hold the client certificate and its private key
+	TLSCertFile string
+	TLSKeyFile  string
+}
+
 // Connect connects to the database mentioned in the config variable.
-func Connect(connectionString string) (*Database, error) {
-	migrator, driver, err := openMigrator(connectionString)
+func Connect(connectionString string, connectionOptions *ConnectionOptions) (*Database, error) {
+	migrationConnectionString, err := MigrationConnectionString(connectionString, connectionOptions)
+	if err != nil {
+		return nil, err
+	}
+	migrator, driver, err := openMigrator(migrationConnectionString)
 	if err != nil {
 		return nil, err
 	}
@@ -30,18 +65,22 @@ func Connect(connectionString string) (*Database, error) {
 	}
 	if !isCurrent {
 		log.Warnf("Database is not current (version %d). Migrating...", version)
-		err := migrate(connectionString)
+		err := migrate(migrationConnectionString)
 		if err != nil {
 			return nil, errors.Wrapf(err, "could not migrate database")
 		}
 	}
 
-	connectionOptions, err := pg.ParseURL(connectionString)
+	pgOptions, err := pg.ParseURL(connectionString)
+	if err != nil {
+		return nil, err
+	}
+	err = connectionOptions.apply(pgOptions)
 	if err != nil {
 		return nil, err
 	}
 
-	pgDB := pg.Connect(connectionOptions)
+	pgDB := pg.Connect(pgOptions)
 	pgDB.AddQueryHook(&pgdebug.DebugHook{
 		Verbose: false, // Set to `true` to print all queries
 	})
@@ -51,7 +90,101 @@ func Connect(connectionString string) (*Database, error) {
 		return nil, errors.Wrapf(err, "could not validate database timezone")
 	}
 
-	return New(pgDB), nil
+	database := New(pgDB)
+	database.transactionTimeout = connectionOptions.TransactionTimeout
+	database.transactionRetries = connectionOptions.TransactionRetries
+	return database, nil
+}
+
+// apply sets the pool, timeout and TLS options of `pgOptions`
+func (o *ConnectionOptions) apply(pgOptions *pg.Options) error {
+	if o.PoolSize > 0 {
+		pgOptions.PoolSize = o.PoolSize
+	}
+	if o.IdleTimeout > 0 {
+		pgOptions.IdleTimeout = o.IdleTimeout
+	}
+	if o.ReadTimeout > 0 {
+		pgOptions.ReadTimeout = o.ReadTimeout
+	}
+	if o.WriteTimeout > 0 {
+		pgOptions.WriteTimeout = o.WriteTimeout
+	}
+	if o.StatementTimeout > 0 {
+		statementTimeout := o.StatementTimeout.Milliseconds()
+		pgOptions.OnConnect = func(ctx context.Context, connection *pg.Conn) error {
+			_, err := connection.ExecContext(ctx, "SET statement_timeout = ?", statementTimeout)
+			return err
+		}
+	}
+	return o.applyTLS(pgOptions)
+}
+
+// applyTLS adds the certificate authorities and the client certificate to the TLS
+// configuration that `pgOptions` got from the sslmode of the connection string
+func (o *ConnectionOptions) applyTLS(pgOptions *pg.Options) error {
+	if o.TLSCAFile == "" && o.TLSCertFile == "" && o.TLSKeyFile == "" {
+		return nil
+	}
+	if pgOptions.TLSConfig == nil {
+		return errors.Errorf("the TLS files cannot be used with sslmode=disable")
+	}
+	if (o.TLSCertFile == "") != (o.TLSKeyFile == "") {
+		return errors.Errorf("the client certificate and its key must be given together")
+	}
+
+	tlsConfig := pgOptions.TLSConfig.Clone()
+	if tlsConfig.ServerName == "" {
+		host, _, err := net.SplitHostPort(pgOptions.Addr)
+		if err != nil {
+			return err
+		}
+		tlsConfig.ServerName = host
+	}
+	if o.TLSCAFile != "" {
+		caCertificates, err := os.ReadFile(o.TLSCAFile)
+		if err != nil {
+			return errors.Wrapf(err, "could not read the certificate authorities")
+		}
+		rootCAs := x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(caCertificates) {
+			return errors.Errorf("no certificate found in %s", o.TLSCAFile)
+		}
+		tlsConfig.RootCAs = rootCAs
+		// The certificate of the server is always verified against the given certificate authorities
+		tlsConfig.InsecureSkipVerify = false
+	}
+	if o.TLSCertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(o.TLSCertFile, o.TLSKeyFile)
+		if err != nil {
+			return errors.Wrapf(err, "could not load the client certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	pgOptions.TLSConfig = tlsConfig
+	return nil
+}
+
+// MigrationConnectionString returns `connectionString` along with the TLS files of
+// `connectionOptions`, in the form expected by the migrations
+func MigrationConnectionString(connectionString string, connectionOptions *ConnectionOptions) (string, error) {
+	if connectionOptions.TLSCAFile == "" && connectionOptions.TLSCertFile == "" {
+		return connectionString, nil
+	}
+	connectionURL, err := url.Parse(connectionString)
+	if err != nil {
+		return "", err
+	}
+	query := connectionURL.Query()
+	if connectionOptions.TLSCAFile != "" {
+		query.Set("sslrootcert", connectionOptions.TLSCAFile)
+	}
+	if connectionOptions.TLSCertFile != "" {
+		query.Set("sslcert", connectionOptions.TLSCertFile)
+		query.Set("sslkey", connectionOptions.TLSKeyFile)
+	}
+	connectionURL.RawQuery = query.Encode()
+	return connectionURL.String(), nil
 }
 
 func validateTimeZone(db *pg.DB) error {
@@ -0,0 +1,252 @@
+package database
+
+import (
+	"github.com/go-pg/pg/v10"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/database/model"
+	"github.com/kaspanet/kaspad/domain/consensus/model/externalapi"
+)
+
+// BlockReference identifies a block breaking a consistency invariant
+type BlockReference struct {
+	ID        uint64
+	BlockHash string
+	Height    uint64
+}
+
+// HeightGroupViolation describes a height group whose size or block indexes
+// do not match the blocks stored at its height
+type HeightGroupViolation struct {
+	Height     uint64
+	Size       uint32
+	BlockCount uint32
+}
+
+// BlocksWithMismatchedEdges returns the blocks whose outgoing edges do not match their
+// parent IDs, or whose edges hold coordinates differing from the blocks they link
+func (db *Database) BlocksWithMismatchedEdges(databaseTransaction *pg.Tx) ([]BlockReference, error) {
+	var results []BlockReference
+	_, err := databaseTransaction.Query(&results, `
+		SELECT b.id, b.block_hash, b.height FROM blocks b
+		WHERE ARRAY(SELECT p::BIGINT FROM jsonb_array_elements_text(b.parent_ids) p ORDER BY 1)
+				<> ARRAY(SELECT e.to_block_id FROM edges e WHERE e.from_block_id = b.id ORDER BY 1)
+			OR EXISTS (
+				SELECT 1 FROM edges e LEFT JOIN blocks pb ON pb.id = e.to_block_id
+				WHERE e.from_block_id = b.id
+					AND (e.from_height <> b.height OR e.from_height_group_index <> b.height_group_index
+						OR pb.id IS NULL OR e.to_height <> pb.height OR e.to_height_group_index <> pb.height_group_index))
+		ORDER BY b.height`)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// BlocksWithUnresolvedParents returns the blocks having parent IDs that reference no stored block
+func (db *Database) BlocksWithUnresolvedParents(databaseTransaction *pg.Tx) ([]BlockReference, error) {
+	var results []BlockReference
+	_, err := databaseTransaction.Query(&results, `
+		SELECT b.id, b.block_hash, b.height FROM blocks b
+		WHERE EXISTS (
+			SELECT 1 FROM jsonb_array_elements_text(b.parent_ids) p LEFT JOIN blocks pb ON pb.id = p::BIGINT
+			WHERE pb.id IS NULL)
+		ORDER BY b.height`)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// BlocksWithWrongHeight returns the blocks whose height differs from the highest height of
+// their stored parents plus one. Blocks without stored parents, such as the pruning point
+// or the blocks whose parents were pruned, may lie at any height.
+func (db *Database) BlocksWithWrongHeight(databaseTransaction *pg.Tx) ([]BlockReference, error) {
+	var results []BlockReference
+	_, err := databaseTransaction.Query(&results, `
+		SELECT b.id, b.block_hash, b.height FROM blocks b
+		WHERE b.height <> COALESCE((
+			SELECT MAX(pb.height) + 1 FROM jsonb_array_elements_text(b.parent_ids) p JOIN blocks pb ON pb.id = p::BIGINT), b.height)
+		ORDER BY b.height`)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// BlocksWithoutSelectedParent returns the blocks having parents but no selected parent,
+// or a selected parent that references no stored block.
+// The block identified by `pruningPointID` is exempted.
+func (db *Database) BlocksWithoutSelectedParent(databaseTransaction *pg.Tx, pruningPointID uint64) ([]BlockReference, error) {
+	var results []BlockReference
+	_, err := databaseTransaction.Query(&results, `
+		SELECT b.id, b.block_hash, b.height FROM blocks b LEFT JOIN blocks sp ON sp.id = b.selected_parent_id
+		WHERE b.id <> ? AND jsonb_array_length(b.parent_ids) > 0 AND sp.id IS NULL
+		ORDER BY b.height`, pruningPointID)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// BlocksWithUnresolvedMergeSet returns the blocks having merge set IDs that reference no stored block
+func (db *Database) BlocksWithUnresolvedMergeSet(databaseTransaction *pg.Tx) ([]BlockReference, error) {
+	var results []BlockReference
+	_, err := databaseTransaction.Query(&results, `
+		SELECT b.id, b.block_hash, b.height FROM blocks b
+		WHERE EXISTS (
+			SELECT 1 FROM jsonb_array_elements_text(b.merge_set_red_ids || b.merge_set_blue_ids) m
+				LEFT JOIN blocks mb ON mb.id = m::BIGINT
+			WHERE mb.id IS NULL)
+		ORDER BY b.height`)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// HeightGroupsWithWrongSize returns the height groups whose size differs from the number of
+// blocks at their height, along with the heights whose blocks do not have distinct
+// height group indexes ranging from 0 to the number of blocks
+func (db *Database) HeightGroupsWithWrongSize(databaseTransaction *pg.Tx) ([]HeightGroupViolation, error) {
+	var results []HeightGroupViolation
+	_, err := databaseTransaction.Query(&results, `
+		SELECT COALESCE(hg.height, c.height) AS height, COALESCE(hg.size, 0) AS size, COALESCE(c.block_count, 0) AS block_count
+		FROM height_groups hg FULL OUTER JOIN (
+			SELECT height, COUNT(*) AS block_count, COUNT(DISTINCT height_group_index) AS index_count,
+				MAX(height_group_index) AS max_index
+			FROM blocks GROUP BY height) c ON c.height = hg.height
+		WHERE hg.size IS DISTINCT FROM c.block_count::INT
+			OR c.index_count <> c.block_count OR c.max_index + 1 <> c.block_count
+		ORDER BY 1`)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// UpdateBlockParents replaces the parent IDs, height and height group index of the block
+// identified by `blockID` and `blockHash`
+func (db *Database) UpdateBlockParents(databaseTransaction *pg.Tx, blockID uint64, blockHash *externalapi.DomainHash,
+	parentIDs []uint64, height uint64, heightGroupIndex uint32) error {
+
+	return db.moveBlock(databaseTransaction, blockID, blockHash, func(block *model.Block) {
+		block.ParentIDs = parentIDs
+		block.Height = height
+		block.HeightGroupIndex = heightGroupIndex
+	}, "UPDATE blocks SET parent_ids = ?, height = ?, height_group_index = ? WHERE id = ?",
+		parentIDs, height, heightGroupIndex, blockID)
+}
+
+// DeleteEdgesFromBlock deletes all the edges going from the block identified by `blockID` to its parents
+func (db *Database) DeleteEdgesFromBlock(databaseTransaction *pg.Tx, blockID uint64) error {
+	_, err := databaseTransaction.Exec("DELETE FROM edges WHERE from_block_id = ?", blockID)
+	return err
+}
+
+// RefreshEdgesOfBlock copies the height and height group index of the block identified by
+// `blockID` to all the edges going from or to it.
+// The from height is the partition column of the edges table, so in TimescaleDB mode the edges
+// going from the block are deleted and reinserted rather than updated, as in moveBlock.
+func (db *Database) RefreshEdgesOfBlock(databaseTransaction *pg.Tx, blockID uint64) error {
+	mode, err := db.partitioningMode(databaseTransaction)
+	if err != nil {
+		return err
+	}
+	if mode == PartitioningTimescaleDB {
+		err = db.reinsertEdgesFromBlock(databaseTransaction, blockID)
+	} else {
+		_, err = databaseTransaction.Exec(`
+			UPDATE edges SET from_height = b.height, from_height_group_index = b.height_group_index
+			FROM blocks b WHERE b.id = ? AND edges.from_block_id = b.id`, blockID)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = databaseTransaction.Exec(`
+		UPDATE edges SET to_height = b.height, to_height_group_index = b.height_group_index
+		FROM blocks b WHERE b.id = ? AND edges.to_block_id = b.id`, blockID)
+	return err
+}
+
+// reinsertEdgesFromBlock deletes the edges going from the block identified by `blockID` and reinserts
+// them with the height and height group index of the block
+func (db *Database) reinsertEdgesFromBlock(databaseTransaction *pg.Tx, blockID uint64) error {
+	block, err := db.GetBlock(databaseTransaction, blockID)
+	if err != nil {
+		return err
+	}
+	var edges []model.Edge
+	err = databaseTransaction.Model(&edges).Where("from_block_id = ?", blockID).Select()
+	if err != nil || len(edges) == 0 {
+		return err
+	}
+	err = db.DeleteEdgesFromBlock(databaseTransaction, blockID)
+	if err != nil {
+		return err
+	}
+	for i := range edges {
+		edges[i].FromHeight = block.Height
+		edges[i].FromHeightGroupIndex = block.HeightGroupIndex
+	}
+	_, err = databaseTransaction.Model(&edges).Insert()
+	return err
+}
+
+// RenumberHeightGroup gives the blocks at `height` distinct height group indexes ranging from 0
+// to their count, keeping their order, and updates the edges and the size of the height group accordingly.
+// The height group is deleted if no block is left at `height`.
+func (db *Database) RenumberHeightGroup(databaseTransaction *pg.Tx, height uint64) error {
+	_, err := databaseTransaction.Exec(`
+		UPDATE blocks SET height_group_index = r.index
+		FROM (SELECT id, ROW_NUMBER() OVER (ORDER BY height_group_index, id) - 1 AS index FROM blocks WHERE height = ?) r
+		WHERE blocks.id = r.id AND blocks.height_group_index <> r.index`, height)
+	if err != nil {
+		return err
+	}
+	_, err = databaseTransaction.Exec(`
+		UPDATE edges SET from_height_group_index = b.height_group_index
+		FROM blocks b WHERE b.height = ? AND edges.from_block_id = b.id AND edges.from_height_group_index <> b.height_group_index`, height)
+	if err != nil {
+		return err
+	}
+	_, err = databaseTransaction.Exec(`
+		UPDATE edges SET to_height_group_index = b.height_group_index
+		FROM blocks b WHERE b.height = ? AND edges.to_block_id = b.id AND edges.to_height_group_index <> b.height_group_index`, height)
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		N uint32
+	}
+	_, err = databaseTransaction.QueryOne(&result, "SELECT COUNT(*) AS N FROM blocks WHERE height = ?", height)
+	if err != nil {
+		return err
+	}
+	if result.N == 0 {
+		_, err = databaseTransaction.Exec("DELETE FROM height_groups WHERE height = ?", height)
+		return err
+	}
+	_, err = databaseTransaction.Exec(`
+		INSERT INTO height_groups (height, size) VALUES (?, ?)
+		ON CONFLICT (height) DO UPDATE SET size = EXCLUDED.size`, height, result.N)
+	return err
+}
+
+// ExistingBlockIDs returns the IDs among `blockIDs` that reference a stored block
+func (db *Database) ExistingBlockIDs(databaseTransaction *pg.Tx, blockIDs []uint64) ([]uint64, error) {
+	existingBlockIDs := make([]uint64, 0, len(blockIDs))
+	if len(blockIDs) == 0 {
+		return existingBlockIDs, nil
+	}
+	var results []struct {
+		ID uint64
+	}
+	_, err := databaseTransaction.Query(&results, "SELECT id FROM blocks WHERE id IN (?) ORDER BY id", pg.In(blockIDs))
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		existingBlockIDs = append(existingBlockIDs, result.ID)
+	}
+	return existingBlockIDs, nil
+}
@@ -2,18 +2,29 @@ package database
 
 import (
 	"context"
+	"io"
+	"net"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/go-pg/pg/v10"
 	"github.com/kaspa-live/kaspa-graph-inspector/processing/database/model"
 	"github.com/kaspa-live/kaspa-graph-inspector/processing/database/utils/lrucache"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/metrics"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/tracing"
 	"github.com/kaspanet/kaspad/domain/consensus/model/externalapi"
 	"github.com/pkg/errors"
 )
 
 type Database struct {
-	database       *pg.DB
-	blockBaseCache *lrucache.LRUCache[blockBase]
+	database           *pg.DB
+	blockBaseCache     *lrucache.LRUCache[blockBase]
+	transactionTimeout time.Duration
+	transactionRetries int
+	// partitioning is the partitioning mode of the blocks and edges tables, looked up on first use
+	partitioning string
 	sync.Mutex
 }
 
@@ -41,15 +52,112 @@ func New(pgDatabase *pg.DB) *Database {
 	return database
 }
 
-func (db *Database) RunInTransaction(transactionFunction func(*pg.Tx) error) error {
+// RunInTransaction runs `transactionFunction` in a transaction bound to `ctx`.
+// The context is then available to all the database methods via `databaseTransaction.Context()`.
+// Cancelling `ctx` does not interrupt the queries so the transaction can always be rolled back
+// cleanly: `transactionFunction` is expected to check `ctx` and return early instead.
+// Exceeding the transaction timeout does interrupt them, the server then rolling back the
+// transaction when its connection gets closed.
+// A transaction failing with a transient error is run again, so `transactionFunction`
+// must not keep any state between its runs.
+func (db *Database) RunInTransaction(ctx context.Context, transactionFunction func(*pg.Tx) error) error {
 	db.Lock()
 	defer db.Unlock()
 
-	return db.database.RunInTransaction(context.Background(), transactionFunction)
+	ctx, span := tracing.Start(ctx, "Database.RunInTransaction")
+	backoff := minTransactionRetryBackoff
+	for retry := 0; ; retry++ {
+		start := time.Now()
+		timedOut, err := db.runInTransaction(ctx, transactionFunction)
+		metrics.DatabaseTransactionDone(time.Since(start))
+		if err == nil {
+			tracing.End(span, nil)
+			return nil
+		}
+		// The cache may hold blocks inserted by the rolled back transaction
+		db.clearCache()
+		if timedOut || retry >= db.transactionRetries || !isTransientError(err) || ctx.Err() != nil {
+			tracing.End(span, err)
+			return err
+		}
+
+		log.Warnf("Retrying a database transaction in %s after a transient error: %s", backoff, err)
+		metrics.DatabaseTransactionRetried()
+		select {
+		case <-ctx.Done():
+			tracing.End(span, err)
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxTransactionRetryBackoff {
+			backoff = maxTransactionRetryBackoff
+		}
+	}
+}
+
+const (
+	minTransactionRetryBackoff = 100 * time.Millisecond
+	maxTransactionRetryBackoff = 5 * time.Second
+)
+
+// runInTransaction runs `transactionFunction` once, bounded by the transaction timeout.
+// It reports whether the transaction timed out along with its error.
+func (db *Database) runInTransaction(ctx context.Context, transactionFunction func(*pg.Tx) error) (bool, error) {
+	transactionContext := context.WithoutCancel(ctx)
+	if db.transactionTimeout > 0 {
+		var cancel context.CancelFunc
+		transactionContext, cancel = context.WithTimeout(transactionContext, db.transactionTimeout)
+		defer cancel()
+	}
+	err := db.database.RunInTransaction(transactionContext, transactionFunction)
+	if err != nil && transactionContext.Err() != nil {
+		// enhanced error description
+		return true, errors.Wrapf(err, "Transaction timed out after %s", db.transactionTimeout)
+	}
+	return false, err
+}
+
+// transientErrorCodes lists the SQLSTATE codes of the errors a transaction may succeed after
+var transientErrorCodes = map[string]struct{}{
+	"40001": {}, // serialization_failure
+	"40P01": {}, // deadlock_detected
+	"57P01": {}, // admin_shutdown
+	"57P02": {}, // crash_shutdown
+	"57P03": {}, // cannot_connect_now
+}
+
+// isTransientError reports whether `err` is caused by a lost connection to the database
+// or by a server error a transaction may succeed after
+func isTransientError(err error) bool {
+	var pgError pg.Error
+	if errors.As(err, &pgError) {
+		code := pgError.Field('C')
+		// Class 08 holds the connection exceptions
+		if strings.HasPrefix(code, "08") {
+			return true
+		}
+		_, ok := transientErrorCodes[code]
+		return ok
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netError net.Error
+	return errors.As(err, &netError)
+}
+
+// Ping checks that the database is reachable.
+// It uses its own pooled connection so it does not wait for running transactions.
+func (db *Database) Ping() error {
+	return db.database.Ping(context.Background())
 }
 
 // Load block infos into the memory cache for all blocks having a height geater or equal to minHeight
 func (db *Database) LoadCache(databaseTransaction *pg.Tx, minHeight uint64) error {
+	defer tracing.CallerSpan(databaseTransaction.Context(), tracing.BlockHeight(minHeight)).End()
+
 	var results []struct {
 		ID        uint64
 		BlockHash string
@@ -77,13 +185,29 @@ func (db *Database) LoadCache(databaseTransaction *pg.Tx, minHeight uint64) erro
 
 func (db *Database) clearCache() {
 	db.blockBaseCache = lrucache.New[blockBase](blockbaseCacheCapacity, true)
+	db.partitioning = ""
+}
+
+// partitioningMode returns the partitioning mode of the blocks and edges tables,
+// looking it up only once since the tables are converted on startup at most
+func (db *Database) partitioningMode(databaseTransaction *pg.Tx) (string, error) {
+	if db.partitioning == "" {
+		mode, err := db.PartitioningMode(databaseTransaction)
+		if err != nil {
+			return "", err
+		}
+		db.partitioning = mode
+	}
+	return db.partitioning, nil
 }
 
 func (db *Database) DoesBlockExist(databaseTransaction *pg.Tx, blockHash *externalapi.DomainHash) (bool, error) {
 	// Search cache
 	if db.blockBaseCache.Has(blockHash) {
+		metrics.BlockCacheHit()
 		return true, nil
 	}
+	metrics.BlockCacheMiss()
 
 	// Search database
 	var results []blockBase
@@ -92,7 +216,7 @@ func (db *Database) DoesBlockExist(databaseTransaction *pg.Tx, blockHash *extern
 	if err != nil {
 		return false, err
 	}
-	if len(results) != 1 {
+	if len(results) == 0 {
 		return false, nil
 	}
 	db.blockBaseCache.Add(blockHash, results[0].Clone())
@@ -100,8 +224,27 @@ func (db *Database) DoesBlockExist(databaseTransaction *pg.Tx, blockHash *extern
 	return true, nil
 }
 
+// InsertBlock stores `block`. Returns an error if a block with the same hash is already stored.
 func (db *Database) InsertBlock(databaseTransaction *pg.Tx, blockHash *externalapi.DomainHash, block *model.Block) error {
-	_, err := databaseTransaction.Model(block).Insert()
+	defer tracing.CallerSpan(databaseTransaction.Context(), tracing.BlockHash(blockHash), tracing.BlockHeight(block.Height)).End()
+
+	mode, err := db.partitioningMode(databaseTransaction)
+	if err != nil {
+		return err
+	}
+	// The unique index of the block hashes of a partitioned table includes the height, so it does
+	// not reject a block stored again at another height. This check is then the only guard against
+	// duplicates, which is enough as long as the processing is the only writer.
+	if mode != PartitioningNone {
+		exists, err := databaseTransaction.Model((*model.Block)(nil)).Where("block_hash = ?", block.BlockHash).Exists()
+		if err != nil {
+			return err
+		}
+		if exists {
+			return errors.Errorf("Block %s is already stored", block.BlockHash)
+		}
+	}
+	_, err = databaseTransaction.Model(block).Insert()
 	if err != nil {
 		return err
 	}
@@ -153,6 +296,8 @@ func (db *Database) UpdateBlockIsInVirtualSelectedParentChain(
 }
 
 func (db *Database) UpdateBlockColors(databaseTransaction *pg.Tx, blockIDsToColors map[uint64]string) error {
+	defer tracing.CallerSpan(databaseTransaction.Context(), tracing.Count(len(blockIDsToColors))).End()
+
 	for blockID, color := range blockIDsToColors {
 		_, err := databaseTransaction.Exec("UPDATE blocks SET color = ? WHERE id = ?", color, blockID)
 		if err != nil {
@@ -198,8 +343,10 @@ func (db *Database) BlockHeightByHash(databaseTransaction *pg.Tx, blockHash *ext
 func (db *Database) blockBaseByHash(databaseTransaction *pg.Tx, blockHash *externalapi.DomainHash) (*blockBase, error) {
 	// Search cache
 	if cachedBlockBase, ok := db.blockBaseCache.Get(blockHash); ok {
+		metrics.BlockCacheHit()
 		return cachedBlockBase, nil
 	}
+	metrics.BlockCacheMiss()
 
 	// Search database
 	var result blockBase
@@ -310,6 +457,44 @@ func (db *Database) HighestBlockInVirtualSelectedParentChain(databaseTransaction
 	return result, nil
 }
 
+// BlockHashesInVirtualSelectedParentChain returns the hashes of the blocks flagged as being in the
+// virtual selected parent chain and having a DAA score greater or equal to `minDAAScore`
+func (db *Database) BlockHashesInVirtualSelectedParentChain(databaseTransaction *pg.Tx, minDAAScore uint64) ([]string, error) {
+	var results []struct {
+		BlockHash string
+	}
+	_, err := databaseTransaction.Query(&results,
+		"SELECT block_hash FROM blocks WHERE is_in_virtual_selected_parent_chain = ? AND daa_score >= ?", true, minDAAScore)
+	if err != nil {
+		return nil, err
+	}
+	blockHashes := make([]string, len(results))
+	for i, result := range results {
+		blockHashes[i] = result.BlockHash
+	}
+	return blockHashes, nil
+}
+
+// BlockColors returns the colors of the blocks identified by `blockIDs`
+func (db *Database) BlockColors(databaseTransaction *pg.Tx, blockIDs []uint64) (map[uint64]string, error) {
+	blockIDsToColors := make(map[uint64]string, len(blockIDs))
+	if len(blockIDs) == 0 {
+		return blockIDsToColors, nil
+	}
+	var results []struct {
+		ID    uint64
+		Color string
+	}
+	_, err := databaseTransaction.Query(&results, "SELECT id, color FROM blocks WHERE id IN (?)", pg.In(blockIDs))
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		blockIDsToColors[result.ID] = result.Color
+	}
+	return blockIDsToColors, nil
+}
+
 func (db *Database) HeightGroupSize(databaseTransaction *pg.Tx, height uint64) (uint32, error) {
 	var result struct {
 		Size uint32
@@ -351,6 +536,87 @@ func (db *Database) InsertEdge(databaseTransaction *pg.Tx, edge *model.Edge) err
 	return nil
 }
 
+// ChildrenMissingParent returns the blocks identified by `childrenHashes` that are stored
+// without the block identified by `parentID` among their parents
+func (db *Database) ChildrenMissingParent(databaseTransaction *pg.Tx, parentID uint64, childrenHashes []string) ([]BlockReference, error) {
+	var results []BlockReference
+	if len(childrenHashes) == 0 {
+		return results, nil
+	}
+	_, err := databaseTransaction.Query(&results,
+		"SELECT id, block_hash, height FROM blocks WHERE block_hash IN (?) AND NOT parent_ids @> jsonb_build_array(?::BIGINT)",
+		pg.In(childrenHashes), parentID)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// AddBlockParent appends `parentID` to the parent IDs of the block identified by `blockID`
+func (db *Database) AddBlockParent(databaseTransaction *pg.Tx, blockID uint64, parentID uint64) error {
+	_, err := databaseTransaction.Exec("UPDATE blocks SET parent_ids = parent_ids || jsonb_build_array(?::BIGINT) WHERE id = ?",
+		parentID, blockID)
+	return err
+}
+
+// ChildBlocks returns the blocks having an edge to the block identified by `blockID`
+func (db *Database) ChildBlocks(databaseTransaction *pg.Tx, blockID uint64) ([]BlockReference, error) {
+	var results []BlockReference
+	_, err := databaseTransaction.Query(&results,
+		"SELECT b.id, b.block_hash, b.height FROM edges e JOIN blocks b ON b.id = e.from_block_id WHERE e.to_block_id = ?", blockID)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// UpdateBlockHeight moves the block identified by `blockID` and `blockHash` to `height`
+// at position `heightGroupIndex`
+func (db *Database) UpdateBlockHeight(databaseTransaction *pg.Tx, blockID uint64, blockHash *externalapi.DomainHash,
+	height uint64, heightGroupIndex uint32) error {
+
+	return db.moveBlock(databaseTransaction, blockID, blockHash, func(block *model.Block) {
+		block.Height = height
+		block.HeightGroupIndex = heightGroupIndex
+	}, "UPDATE blocks SET height = ?, height_group_index = ? WHERE id = ?", height, heightGroupIndex, blockID)
+}
+
+// moveBlock runs `query` with `params` to update the block identified by `blockID` and `blockHash`,
+// which may change its height. The height is the partition column of the blocks table, and TimescaleDB
+// does not move a row to another chunk when it is updated: the block is then rather deleted, modified
+// by `update` and reinserted.
+func (db *Database) moveBlock(databaseTransaction *pg.Tx, blockID uint64, blockHash *externalapi.DomainHash,
+	update func(block *model.Block), query string, params ...interface{}) error {
+
+	mode, err := db.partitioningMode(databaseTransaction)
+	if err != nil {
+		return err
+	}
+	if mode == PartitioningTimescaleDB {
+		block, err := db.GetBlock(databaseTransaction, blockID)
+		if err != nil {
+			return err
+		}
+		_, err = databaseTransaction.Exec("DELETE FROM blocks WHERE id = ?", blockID)
+		if err != nil {
+			return err
+		}
+		update(block)
+		_, err = databaseTransaction.Model(block).Insert()
+		if err != nil {
+			return err
+		}
+	} else {
+		_, err = databaseTransaction.Exec(query, params...)
+		if err != nil {
+			return err
+		}
+	}
+	// The cached height is outdated
+	db.blockBaseCache.Remove(blockHash)
+	return nil
+}
+
 func (db *Database) InsertOrUpdateHeightGroup(databaseTransaction *pg.Tx, heightGroup *model.HeightGroup) error {
 	_, err := databaseTransaction.Model(heightGroup).OnConflict("(height) DO UPDATE SET size = EXCLUDED.size").Insert()
 	if err != nil {
@@ -363,7 +629,7 @@ func (db *Database) InsertOrUpdateHeightGroup(databaseTransaction *pg.Tx, height
 // Returns an error if no app config does exist in the database.
 func (db *Database) GetAppConfig(databaseTransaction *pg.Tx) (*model.AppConfig, error) {
 	result := new(model.AppConfig)
-	_, err := databaseTransaction.QueryOne(result, "SELECT * FROM appConfig")
+	_, err := databaseTransaction.QueryOne(result, "SELECT * FROM app_config")
 	if err != nil {
 		return nil, err
 	}
@@ -393,6 +659,10 @@ func (db *Database) Clear(databaseTransaction *pg.Tx) error {
 		return err
 	}
 	_, err = databaseTransaction.Exec("TRUNCATE TABLE height_groups")
+	if err != nil {
+		return err
+	}
+	_, err = databaseTransaction.Exec("TRUNCATE TABLE segments")
 	return err
 }
 
@@ -0,0 +1,80 @@
+package database
+
+import (
+	"github.com/go-pg/pg/v10"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/database/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	RangeByHeight   = "height"
+	RangeByDAAScore = "daa_score"
+)
+
+// BlockRange selects the blocks having their height, or their DAA score, between From and To inclusive
+type BlockRange struct {
+	// By is either RangeByHeight or RangeByDAAScore
+	By   string
+	From uint64
+	To   uint64
+}
+
+// BlocksInRange returns the blocks within `blockRange` ordered by height and height group index.
+// Returns an error if the range holds more than `maxBlocks` blocks, unless `maxBlocks` is 0.
+func (db *Database) BlocksInRange(databaseTransaction *pg.Tx, blockRange *BlockRange, maxBlocks int) ([]*model.Block, error) {
+	if blockRange.By != RangeByHeight && blockRange.By != RangeByDAAScore {
+		return nil, errors.Errorf("Unknown block range field %s", blockRange.By)
+	}
+	var blocks []*model.Block
+	query := databaseTransaction.Model(&blocks).
+		Where("?0 BETWEEN ?1 AND ?2", pg.Ident(blockRange.By), blockRange.From, blockRange.To).
+		Order("height", "height_group_index")
+	if maxBlocks > 0 {
+		// One more block tells whether the range exceeds the limit
+		query = query.Limit(maxBlocks + 1)
+	}
+	err := query.Select()
+	if err != nil {
+		return nil, err
+	}
+	if maxBlocks > 0 && len(blocks) > maxBlocks {
+		return nil, errors.Errorf("The %s range %d to %d holds more than %d blocks",
+			blockRange.By, blockRange.From, blockRange.To, maxBlocks)
+	}
+	return blocks, nil
+}
+
+// EdgesBetweenBlocks returns the edges both starting and ending at one of the blocks identified by `blockIDs`
+func (db *Database) EdgesBetweenBlocks(databaseTransaction *pg.Tx, blockIDs []uint64) ([]*model.Edge, error) {
+	var edges []*model.Edge
+	if len(blockIDs) == 0 {
+		return edges, nil
+	}
+	_, err := databaseTransaction.Query(&edges, "SELECT * FROM edges WHERE from_block_id IN (?0) AND to_block_id IN (?0)",
+		pg.In(blockIDs))
+	if err != nil {
+		return nil, err
+	}
+	return edges, nil
+}
+
+// BlockHashesByIDs returns the hashes of the blocks identified by `blockIDs`.
+// IDs of missing blocks are left out of the result.
+func (db *Database) BlockHashesByIDs(databaseTransaction *pg.Tx, blockIDs []uint64) (map[uint64]string, error) {
+	blockIDsToHashes := make(map[uint64]string, len(blockIDs))
+	if len(blockIDs) == 0 {
+		return blockIDsToHashes, nil
+	}
+	var results []struct {
+		ID        uint64
+		BlockHash string
+	}
+	_, err := databaseTransaction.Query(&results, "SELECT id, block_hash FROM blocks WHERE id IN (?)", pg.In(blockIDs))
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		blockIDsToHashes[result.ID] = result.BlockHash
+	}
+	return blockIDsToHashes, nil
+}
@@ -0,0 +1,375 @@
+package memorystorage
+
+import (
+	"sort"
+
+	databasePackage "github.com/kaspa-live/kaspa-graph-inspector/processing/database"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/database/model"
+	"github.com/kaspanet/kaspad/domain/consensus/model/externalapi"
+	"github.com/pkg/errors"
+)
+
+// cloneBlock returns a deep copy of `block`
+func cloneBlock(block *model.Block) *model.Block {
+	clone := *block
+	clone.ParentIDs = append([]uint64(nil), block.ParentIDs...)
+	clone.MergeSetRedIDs = append([]uint64(nil), block.MergeSetRedIDs...)
+	clone.MergeSetBlueIDs = append([]uint64(nil), block.MergeSetBlueIDs...)
+	if block.SelectedParentID != nil {
+		selectedParentID := *block.SelectedParentID
+		clone.SelectedParentID = &selectedParentID
+	}
+	return &clone
+}
+
+func blockReference(block *model.Block) databasePackage.BlockReference {
+	return databasePackage.BlockReference{
+		ID:        block.ID,
+		BlockHash: block.BlockHash,
+		Height:    block.Height,
+	}
+}
+
+// sortedBlocks returns the stored blocks ordered by height and then by ID
+func (s *Storage) sortedBlocks() []*model.Block {
+	blocks := make([]*model.Block, 0, len(s.tables.blocks))
+	for _, block := range s.tables.blocks {
+		blocks = append(blocks, block)
+	}
+	sort.Slice(blocks, func(i, j int) bool {
+		if blocks[i].Height != blocks[j].Height {
+			return blocks[i].Height < blocks[j].Height
+		}
+		return blocks[i].ID < blocks[j].ID
+	})
+	return blocks
+}
+
+// blocksAtHeight returns the blocks at `height` ordered by height group index and then by ID
+func (s *Storage) blocksAtHeight(height uint64) []*model.Block {
+	blocks := make([]*model.Block, 0, len(s.tables.blockIDsByHeight[height]))
+	for id := range s.tables.blockIDsByHeight[height] {
+		blocks = append(blocks, s.tables.blocks[id])
+	}
+	sort.Slice(blocks, func(i, j int) bool {
+		if blocks[i].HeightGroupIndex != blocks[j].HeightGroupIndex {
+			return blocks[i].HeightGroupIndex < blocks[j].HeightGroupIndex
+		}
+		return blocks[i].ID < blocks[j].ID
+	})
+	return blocks
+}
+
+// blockByHash returns the block identified by `blockHash`.
+// Returns an error if `blockHash` is not stored.
+func (s *Storage) blockByHash(blockHash *externalapi.DomainHash) (*model.Block, error) {
+	id, ok := s.tables.blockIDsByHash[blockHash.String()]
+	if !ok {
+		return nil, errors.Errorf("block hash %s not found in blocks table", blockHash)
+	}
+	return s.tables.blocks[id], nil
+}
+
+// block returns the block `id`.
+// Returns an error if the block `id` does not exist.
+func (s *Storage) block(id uint64) (*model.Block, error) {
+	block, ok := s.tables.blocks[id]
+	if !ok {
+		return nil, errors.Errorf("Block %d not found", id)
+	}
+	return block, nil
+}
+
+// InsertBlock stores `block`, assigning it the next block ID unless it has one
+func (s *Storage) InsertBlock(databaseTransaction databasePackage.Transaction, blockHash *externalapi.DomainHash, block *model.Block) error {
+	t := asTransaction(databaseTransaction)
+	if _, ok := s.tables.blockIDsByHash[block.BlockHash]; ok {
+		return errors.Errorf("Block %s is already stored", block.BlockHash)
+	}
+	if block.ID == 0 {
+		s.lastBlockID++
+		block.ID = s.lastBlockID
+	} else if _, ok := s.tables.blocks[block.ID]; ok {
+		return errors.Errorf("Block %d is already stored", block.ID)
+	} else if block.ID > s.lastBlockID {
+		s.lastBlockID = block.ID
+	}
+	t.replaceBlock(block.ID, cloneBlock(block))
+	return nil
+}
+
+// GetBlock returns a block identified by `id`.
+// Returns an error if the block `id` does not exist
+func (s *Storage) GetBlock(databaseTransaction databasePackage.Transaction, id uint64) (*model.Block, error) {
+	block, err := s.block(id)
+	if err != nil {
+		return nil, err
+	}
+	return cloneBlock(block), nil
+}
+
+func (s *Storage) DoesBlockExist(databaseTransaction databasePackage.Transaction, blockHash *externalapi.DomainHash) (bool, error) {
+	_, ok := s.tables.blockIDsByHash[blockHash.String()]
+	return ok, nil
+}
+
+// ExistingBlockIDs returns the IDs among `blockIDs` that reference a stored block, ordered
+func (s *Storage) ExistingBlockIDs(databaseTransaction databasePackage.Transaction, blockIDs []uint64) ([]uint64, error) {
+	existingBlockIDs := make([]uint64, 0, len(blockIDs))
+	seen := make(map[uint64]struct{}, len(blockIDs))
+	for _, blockID := range blockIDs {
+		if _, ok := seen[blockID]; ok {
+			continue
+		}
+		seen[blockID] = struct{}{}
+		if _, ok := s.tables.blocks[blockID]; ok {
+			existingBlockIDs = append(existingBlockIDs, blockID)
+		}
+	}
+	sort.Slice(existingBlockIDs, func(i, j int) bool { return existingBlockIDs[i] < existingBlockIDs[j] })
+	return existingBlockIDs, nil
+}
+
+func (s *Storage) BlockIDByHash(databaseTransaction databasePackage.Transaction, blockHash *externalapi.DomainHash) (uint64, error) {
+	block, err := s.blockByHash(blockHash)
+	if err != nil {
+		return 0, err
+	}
+	return block.ID, nil
+}
+
+func (s *Storage) BlockIDsByHashes(databaseTransaction databasePackage.Transaction, blockHashes []*externalapi.DomainHash) ([]uint64, error) {
+	blockIDs := make([]uint64, len(blockHashes))
+	for i, blockHash := range blockHashes {
+		block, err := s.blockByHash(blockHash)
+		if err != nil {
+			return nil, err
+		}
+		blockIDs[i] = block.ID
+	}
+	return blockIDs, nil
+}
+
+func (s *Storage) BlockIDsAndHeightsByHashes(databaseTransaction databasePackage.Transaction,
+	blockHashes []*externalapi.DomainHash) ([]uint64, []uint64, error) {
+
+	blockIDs := make([]uint64, len(blockHashes))
+	blockHeights := make([]uint64, len(blockHashes))
+	for i, blockHash := range blockHashes {
+		block, err := s.blockByHash(blockHash)
+		if err != nil {
+			return nil, nil, err
+		}
+		blockIDs[i] = block.ID
+		blockHeights[i] = block.Height
+	}
+	return blockIDs, blockHeights, nil
+}
+
+// FindLatestStoredBlockIndex returns the index in a DAG ordered block hash
+// array `blockHashes` of the latest block hash that is stored
+func (s *Storage) FindLatestStoredBlockIndex(databaseTransaction databasePackage.Transaction,
+	blockHashes []*externalapi.DomainHash) (int, error) {
+
+	low := 0
+	high := len(blockHashes)
+	for (high - low) > 1 {
+		cur := (high + low) / 2
+		if _, ok := s.tables.blockIDsByHash[blockHashes[cur].String()]; ok {
+			low = cur
+		} else {
+			high = cur
+		}
+	}
+	return low, nil
+}
+
+// BlockIDByDAAScore returns the block ID of one block having the closest DAA
+// score to `blockDAAScore`
+func (s *Storage) BlockIDByDAAScore(databaseTransaction databasePackage.Transaction, blockDAAScore uint64) (uint64, error) {
+	var closest *model.Block
+	var closestDistance uint64
+	for _, block := range s.tables.blocks {
+		distance := block.DAAScore - blockDAAScore
+		if block.DAAScore < blockDAAScore {
+			distance = blockDAAScore - block.DAAScore
+		}
+		if closest == nil || distance < closestDistance || (distance == closestDistance && block.ID < closest.ID) {
+			closest, closestDistance = block, distance
+		}
+	}
+	if closest == nil {
+		return 0, errors.Errorf("No block is stored")
+	}
+	return closest.ID, nil
+}
+
+// BlockCountAtDAAScore returns the number of blocks having a DAA Score of `blockDAAScore`
+func (s *Storage) BlockCountAtDAAScore(databaseTransaction databasePackage.Transaction, blockDAAScore uint64) (uint32, error) {
+	count := uint32(0)
+	for _, block := range s.tables.blocks {
+		if block.DAAScore == blockDAAScore {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// BlockColors returns the colors of the blocks identified by `blockIDs`
+func (s *Storage) BlockColors(databaseTransaction databasePackage.Transaction, blockIDs []uint64) (map[uint64]string, error) {
+	blockIDsToColors := make(map[uint64]string, len(blockIDs))
+	for _, blockID := range blockIDs {
+		if block, ok := s.tables.blocks[blockID]; ok {
+			blockIDsToColors[blockID] = block.Color
+		}
+	}
+	return blockIDsToColors, nil
+}
+
+// HighestBlockInVirtualSelectedParentChain returns the highest block flagged as being in the
+// virtual selected parent chain, or an empty block if there is none
+func (s *Storage) HighestBlockInVirtualSelectedParentChain(databaseTransaction databasePackage.Transaction) (*model.Block, error) {
+	var highest *model.Block
+	for _, block := range s.tables.blocks {
+		if !block.IsInVirtualSelectedParentChain {
+			continue
+		}
+		if highest == nil || block.Height > highest.Height || (block.Height == highest.Height && block.ID > highest.ID) {
+			highest = block
+		}
+	}
+	if highest == nil {
+		return new(model.Block), nil
+	}
+	return cloneBlock(highest), nil
+}
+
+// BlockHashesInVirtualSelectedParentChain returns the hashes of the blocks flagged as being in the
+// virtual selected parent chain and having a DAA score greater or equal to `minDAAScore`
+func (s *Storage) BlockHashesInVirtualSelectedParentChain(databaseTransaction databasePackage.Transaction,
+	minDAAScore uint64) ([]string, error) {
+
+	blockHashes := make([]string, 0)
+	for _, block := range s.sortedBlocks() {
+		if block.IsInVirtualSelectedParentChain && block.DAAScore >= minDAAScore {
+			blockHashes = append(blockHashes, block.BlockHash)
+		}
+	}
+	return blockHashes, nil
+}
+
+// ChildBlocks returns the blocks having an edge to the block identified by `blockID`
+func (s *Storage) ChildBlocks(databaseTransaction databasePackage.Transaction, blockID uint64) ([]databasePackage.BlockReference, error) {
+	var results []databasePackage.BlockReference
+	for childID := range s.tables.edgesTo[blockID] {
+		if child, ok := s.tables.blocks[childID]; ok {
+			results = append(results, blockReference(child))
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].ID < results[j].ID })
+	return results, nil
+}
+
+// ChildrenMissingParent returns the blocks identified by `childrenHashes` that are stored
+// without the block identified by `parentID` among their parents
+func (s *Storage) ChildrenMissingParent(databaseTransaction databasePackage.Transaction, parentID uint64,
+	childrenHashes []string) ([]databasePackage.BlockReference, error) {
+
+	var results []databasePackage.BlockReference
+	seen := make(map[string]struct{}, len(childrenHashes))
+	for _, childHash := range childrenHashes {
+		if _, ok := seen[childHash]; ok {
+			continue
+		}
+		seen[childHash] = struct{}{}
+		childID, ok := s.tables.blockIDsByHash[childHash]
+		if !ok {
+			continue
+		}
+		child := s.tables.blocks[childID]
+		if !containsID(child.ParentIDs, parentID) {
+			results = append(results, blockReference(child))
+		}
+	}
+	return results, nil
+}
+
+func containsID(ids []uint64, id uint64) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Storage) UpdateBlockSelectedParent(databaseTransaction databasePackage.Transaction, blockID uint64, selectedParentID uint64) error {
+	asTransaction(databaseTransaction).updateBlock(blockID, func(block *model.Block) {
+		block.SelectedParentID = &selectedParentID
+	})
+	return nil
+}
+
+func (s *Storage) UpdateBlockMergeSet(databaseTransaction databasePackage.Transaction, blockID uint64,
+	mergeSetRedIDs []uint64, mergeSetBlueIDs []uint64) error {
+
+	asTransaction(databaseTransaction).updateBlock(blockID, func(block *model.Block) {
+		block.MergeSetRedIDs = append([]uint64(nil), mergeSetRedIDs...)
+		block.MergeSetBlueIDs = append([]uint64(nil), mergeSetBlueIDs...)
+	})
+	return nil
+}
+
+func (s *Storage) UpdateBlockIsInVirtualSelectedParentChain(databaseTransaction databasePackage.Transaction,
+	blockIDsToIsInVirtualSelectedParentChain map[uint64]bool) error {
+
+	t := asTransaction(databaseTransaction)
+	for blockID, isInVirtualSelectedParentChain := range blockIDsToIsInVirtualSelectedParentChain {
+		t.updateBlock(blockID, func(block *model.Block) {
+			block.IsInVirtualSelectedParentChain = isInVirtualSelectedParentChain
+		})
+	}
+	return nil
+}
+
+func (s *Storage) UpdateBlockColors(databaseTransaction databasePackage.Transaction, blockIDsToColors map[uint64]string) error {
+	t := asTransaction(databaseTransaction)
+	for blockID, color := range blockIDsToColors {
+		t.updateBlock(blockID, func(block *model.Block) {
+			block.Color = color
+		})
+	}
+	return nil
+}
+
+// UpdateBlockDAAScores updates DAA Scores of block ids
+func (s *Storage) UpdateBlockDAAScores(databaseTransaction databasePackage.Transaction, blockIDsToDAAScores map[uint64]uint64) error {
+	t := asTransaction(databaseTransaction)
+	for blockID, daaScore := range blockIDsToDAAScores {
+		t.updateBlock(blockID, func(block *model.Block) {
+			block.DAAScore = daaScore
+		})
+	}
+	return nil
+}
+
+// UpdateBlockParents replaces the parent IDs, height and height group index of the block
+// identified by `blockID`
+func (s *Storage) UpdateBlockParents(databaseTransaction databasePackage.Transaction, blockID uint64, blockHash *externalapi.DomainHash,
+	parentIDs []uint64, height uint64, heightGroupIndex uint32) error {
+
+	asTransaction(databaseTransaction).updateBlock(blockID, func(block *model.Block) {
+		block.ParentIDs = append([]uint64(nil), parentIDs...)
+		block.Height = height
+		block.HeightGroupIndex = heightGroupIndex
+	})
+	return nil
+}
+
+// AddBlockParent appends `parentID` to the parent IDs of the block identified by `blockID`
+func (s *Storage) AddBlockParent(databaseTransaction databasePackage.Transaction, blockID uint64, parentID uint64) error {
+	asTransaction(databaseTransaction).updateBlock(blockID, func(block *model.Block) {
+		block.ParentIDs = append(block.ParentIDs, parentID)
+	})
+	return nil
+}
@@ -0,0 +1,249 @@
+package memorystorage
+
+import (
+	"sort"
+	"time"
+
+	databasePackage "github.com/kaspa-live/kaspa-graph-inspector/processing/database"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/database/model"
+)
+
+// blocksMatching returns the blocks satisfying `isMatching`, ordered by height
+func (s *Storage) blocksMatching(isMatching func(block *model.Block) bool) []databasePackage.BlockReference {
+	var results []databasePackage.BlockReference
+	for _, block := range s.sortedBlocks() {
+		if isMatching(block) {
+			results = append(results, blockReference(block))
+		}
+	}
+	return results
+}
+
+// hasUnresolvedID returns true if any of `ids` references no stored block
+func (s *Storage) hasUnresolvedID(ids []uint64) bool {
+	for _, id := range ids {
+		if _, ok := s.tables.blocks[id]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// BlocksWithMismatchedEdges returns the blocks whose outgoing edges do not match their
+// parent IDs, or whose edges hold coordinates differing from the blocks they link
+func (s *Storage) BlocksWithMismatchedEdges(databaseTransaction databasePackage.Transaction) ([]databasePackage.BlockReference, error) {
+	return s.blocksMatching(func(block *model.Block) bool {
+		edgesFrom, _ := s.edgesOfBlock(block.ID)
+		if len(edgesFrom) != len(block.ParentIDs) {
+			return true
+		}
+		parentIDs := append([]uint64(nil), block.ParentIDs...)
+		sort.Slice(parentIDs, func(i, j int) bool { return parentIDs[i] < parentIDs[j] })
+		sort.Slice(edgesFrom, func(i, j int) bool { return edgesFrom[i].ToBlockID < edgesFrom[j].ToBlockID })
+		for i, edge := range edgesFrom {
+			if edge.ToBlockID != parentIDs[i] {
+				return true
+			}
+			if edge.FromHeight != block.Height || edge.FromHeightGroupIndex != block.HeightGroupIndex {
+				return true
+			}
+			parent, ok := s.tables.blocks[edge.ToBlockID]
+			if !ok || edge.ToHeight != parent.Height || edge.ToHeightGroupIndex != parent.HeightGroupIndex {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+// BlocksWithUnresolvedParents returns the blocks having parent IDs that reference no stored block
+func (s *Storage) BlocksWithUnresolvedParents(databaseTransaction databasePackage.Transaction) ([]databasePackage.BlockReference, error) {
+	return s.blocksMatching(func(block *model.Block) bool {
+		return s.hasUnresolvedID(block.ParentIDs)
+	}), nil
+}
+
+// BlocksWithWrongHeight returns the blocks whose height differs from the highest height of
+// their stored parents plus one. Blocks without stored parents may lie at any height.
+func (s *Storage) BlocksWithWrongHeight(databaseTransaction databasePackage.Transaction) ([]databasePackage.BlockReference, error) {
+	return s.blocksMatching(func(block *model.Block) bool {
+		hasStoredParent := false
+		highestParentHeight := uint64(0)
+		for _, parentID := range block.ParentIDs {
+			parent, ok := s.tables.blocks[parentID]
+			if !ok {
+				continue
+			}
+			if !hasStoredParent || parent.Height > highestParentHeight {
+				highestParentHeight = parent.Height
+			}
+			hasStoredParent = true
+		}
+		return hasStoredParent && block.Height != highestParentHeight+1
+	}), nil
+}
+
+// BlocksWithoutSelectedParent returns the blocks having parents but no selected parent,
+// or a selected parent that references no stored block.
+// The block identified by `pruningPointID` is exempted.
+func (s *Storage) BlocksWithoutSelectedParent(databaseTransaction databasePackage.Transaction,
+	pruningPointID uint64) ([]databasePackage.BlockReference, error) {
+
+	return s.blocksMatching(func(block *model.Block) bool {
+		if block.ID == pruningPointID || len(block.ParentIDs) == 0 {
+			return false
+		}
+		return block.SelectedParentID == nil || s.hasUnresolvedID([]uint64{*block.SelectedParentID})
+	}), nil
+}
+
+// BlocksWithUnresolvedMergeSet returns the blocks having merge set IDs that reference no stored block
+func (s *Storage) BlocksWithUnresolvedMergeSet(databaseTransaction databasePackage.Transaction) ([]databasePackage.BlockReference, error) {
+	return s.blocksMatching(func(block *model.Block) bool {
+		return s.hasUnresolvedID(block.MergeSetRedIDs) || s.hasUnresolvedID(block.MergeSetBlueIDs)
+	}), nil
+}
+
+// HeightGroupsWithWrongSize returns the height groups whose size differs from the number of
+// blocks at their height, along with the heights whose blocks do not have distinct
+// height group indexes ranging from 0 to the number of blocks
+func (s *Storage) HeightGroupsWithWrongSize(databaseTransaction databasePackage.Transaction) ([]databasePackage.HeightGroupViolation, error) {
+	heights := make(map[uint64]struct{})
+	for height := range s.tables.heightGroups {
+		heights[height] = struct{}{}
+	}
+	for height := range s.tables.blockIDsByHeight {
+		heights[height] = struct{}{}
+	}
+
+	var results []databasePackage.HeightGroupViolation
+	for height := range heights {
+		blocks := s.blocksAtHeight(height)
+		heightGroup, hasHeightGroup := s.tables.heightGroups[height]
+		violation := databasePackage.HeightGroupViolation{Height: height, BlockCount: uint32(len(blocks))}
+		if hasHeightGroup {
+			violation.Size = heightGroup.Size
+		}
+
+		isViolated := !hasHeightGroup || len(blocks) == 0 || heightGroup.Size != uint32(len(blocks))
+		// The blocks being ordered by index, distinct indexes ranging from 0 match their positions
+		for i, block := range blocks {
+			if block.HeightGroupIndex != uint32(i) {
+				isViolated = true
+			}
+		}
+		if isViolated {
+			results = append(results, violation)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Height < results[j].Height })
+	return results, nil
+}
+
+// PruneBlocksOutsideWindow deletes at most `batchSize` of the lowest blocks lying outside `window`
+// along with their edges, and removes the references to them from the remaining blocks. The blocks
+// having a DAA score of at least `keptDAAScore` are always kept.
+// Returns the number of deleted blocks.
+func (s *Storage) PruneBlocksOutsideWindow(databaseTransaction databasePackage.Transaction, window *databasePackage.RetentionWindow,
+	keptDAAScore uint64, batchSize int) (int, error) {
+
+	blocks := s.sortedBlocks()
+	var isOutside func(block *model.Block) bool
+	switch {
+	case window.Height > 0:
+		maxHeight := uint64(0)
+		for _, block := range blocks {
+			if block.Height > maxHeight {
+				maxHeight = block.Height
+			}
+		}
+		isOutside = func(block *model.Block) bool { return block.Height+window.Height < maxHeight }
+	case window.DAAScore > 0:
+		maxDAAScore := uint64(0)
+		for _, block := range blocks {
+			if block.DAAScore > maxDAAScore {
+				maxDAAScore = block.DAAScore
+			}
+		}
+		isOutside = func(block *model.Block) bool { return block.DAAScore+window.DAAScore < maxDAAScore }
+	case window.Age > 0:
+		threshold := time.Now().Add(-window.Age).UnixMilli()
+		isOutside = func(block *model.Block) bool { return block.Timestamp < threshold }
+	default:
+		return 0, nil
+	}
+
+	var prunedBlocks []*model.Block
+	for _, block := range blocks {
+		if len(prunedBlocks) == batchSize {
+			break
+		}
+		if isOutside(block) && block.DAAScore < keptDAAScore {
+			prunedBlocks = append(prunedBlocks, block)
+		}
+	}
+	s.deleteBlocks(asTransaction(databaseTransaction), prunedBlocks)
+	return len(prunedBlocks), nil
+}
+
+// deleteBlocks deletes `prunedBlocks` along with their edges, and removes the references to them
+// from the remaining blocks. The height groups they belonged to are renumbered, or deleted when left empty.
+func (s *Storage) deleteBlocks(t *transaction, prunedBlocks []*model.Block) {
+	isPruned := make(map[uint64]struct{}, len(prunedBlocks))
+	for _, block := range prunedBlocks {
+		isPruned[block.ID] = struct{}{}
+	}
+	for _, block := range prunedBlocks {
+		edgesFrom, edgesTo := s.edgesOfBlock(block.ID)
+		for _, edge := range append(edgesFrom, edgesTo...) {
+			t.replaceEdge(edge.FromBlockID, edge.ToBlockID, nil)
+		}
+		t.replaceBlock(block.ID, nil)
+	}
+
+	withoutPruned := func(ids []uint64) []uint64 {
+		remaining := make([]uint64, 0, len(ids))
+		for _, id := range ids {
+			if _, ok := isPruned[id]; !ok {
+				remaining = append(remaining, id)
+			}
+		}
+		return remaining
+	}
+	referencesPruned := func(ids []uint64) bool {
+		return len(withoutPruned(ids)) != len(ids)
+	}
+	for _, block := range s.sortedBlocks() {
+		isSelectedParentPruned := false
+		if block.SelectedParentID != nil {
+			_, isSelectedParentPruned = isPruned[*block.SelectedParentID]
+		}
+		if !isSelectedParentPruned && !referencesPruned(block.ParentIDs) &&
+			!referencesPruned(block.MergeSetRedIDs) && !referencesPruned(block.MergeSetBlueIDs) {
+			continue
+		}
+		t.updateBlock(block.ID, func(block *model.Block) {
+			block.ParentIDs = withoutPruned(block.ParentIDs)
+			block.MergeSetRedIDs = withoutPruned(block.MergeSetRedIDs)
+			block.MergeSetBlueIDs = withoutPruned(block.MergeSetBlueIDs)
+			if isSelectedParentPruned {
+				block.SelectedParentID = nil
+			}
+		})
+	}
+
+	prunedHeights := make(map[uint64]struct{})
+	for _, block := range prunedBlocks {
+		prunedHeights[block.Height] = struct{}{}
+	}
+	for height := range prunedHeights {
+		if _, ok := s.tables.heightGroups[height]; !ok {
+			continue
+		}
+		if len(s.tables.blockIDsByHeight[height]) == 0 {
+			t.replaceHeightGroup(height, nil)
+			continue
+		}
+		_ = s.RenumberHeightGroup(t, height)
+	}
+}
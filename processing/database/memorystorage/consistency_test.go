@@ -0,0 +1,129 @@
+package memorystorage
+
+import (
+	"reflect"
+	"testing"
+
+	databasePackage "github.com/kaspa-live/kaspa-graph-inspector/processing/database"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/database/model"
+)
+
+// referenceIDs returns the IDs of the blocks of `references`
+func referenceIDs(references []databasePackage.BlockReference) []uint64 {
+	ids := make([]uint64, len(references))
+	for i, reference := range references {
+		ids[i] = reference.ID
+	}
+	return ids
+}
+
+// requireViolations fails the test if `check` does not report exactly the blocks `expectedIDs`
+func requireViolations(t *testing.T, s *Storage, name string,
+	check func(databaseTransaction databasePackage.Transaction) ([]databasePackage.BlockReference, error), expectedIDs ...uint64) {
+
+	t.Helper()
+
+	run(t, s, func(databaseTransaction databasePackage.Transaction) error {
+		references, err := check(databaseTransaction)
+		if err != nil {
+			return err
+		}
+		ids := referenceIDs(references)
+		if len(ids) != 0 || len(expectedIDs) != 0 {
+			if !reflect.DeepEqual(ids, expectedIDs) {
+				t.Fatalf("%s reported the blocks %v, expected %v", name, ids, expectedIDs)
+			}
+		}
+		return nil
+	})
+}
+
+func TestConsistencyChecksOnConsistentStorage(t *testing.T) {
+	s := newDiamond(t)
+	requireViolations(t, s, "BlocksWithMismatchedEdges", s.BlocksWithMismatchedEdges)
+	requireViolations(t, s, "BlocksWithUnresolvedParents", s.BlocksWithUnresolvedParents)
+	requireViolations(t, s, "BlocksWithWrongHeight", s.BlocksWithWrongHeight)
+	requireViolations(t, s, "BlocksWithUnresolvedMergeSet", s.BlocksWithUnresolvedMergeSet)
+	requireViolations(t, s, "BlocksWithoutSelectedParent",
+		func(databaseTransaction databasePackage.Transaction) ([]databasePackage.BlockReference, error) {
+			return s.BlocksWithoutSelectedParent(databaseTransaction, 1)
+		})
+	requireConsistent(t, s)
+}
+
+func TestConsistencyChecksReportViolations(t *testing.T) {
+	s := newDiamond(t)
+	run(t, s, func(databaseTransaction databasePackage.Transaction) error {
+		// The block 5 references the missing block 42 as parent and as red
+		block := newBlock(5, 3, 0, 4)
+		block.ParentIDs = []uint64{4, 42}
+		block.MergeSetRedIDs = []uint64{42}
+		err := s.InsertBlock(databaseTransaction, blockHash(5), block)
+		if err != nil {
+			return err
+		}
+		err = s.InsertEdge(databaseTransaction, &model.Edge{FromBlockID: 5, ToBlockID: 4, FromHeight: 3, ToHeight: 2})
+		if err != nil {
+			return err
+		}
+		// The block 6 lies at a wrong height, without a selected parent nor edges
+		block = newBlock(6, 4, 0, 2)
+		block.SelectedParentID = nil
+		return s.InsertBlock(databaseTransaction, blockHash(6), block)
+	})
+
+	requireViolations(t, s, "BlocksWithMismatchedEdges", s.BlocksWithMismatchedEdges, 5, 6)
+	requireViolations(t, s, "BlocksWithUnresolvedParents", s.BlocksWithUnresolvedParents, 5)
+	requireViolations(t, s, "BlocksWithWrongHeight", s.BlocksWithWrongHeight, 6)
+	requireViolations(t, s, "BlocksWithUnresolvedMergeSet", s.BlocksWithUnresolvedMergeSet, 5)
+	requireViolations(t, s, "BlocksWithoutSelectedParent",
+		func(databaseTransaction databasePackage.Transaction) ([]databasePackage.BlockReference, error) {
+			return s.BlocksWithoutSelectedParent(databaseTransaction, 1)
+		}, 6)
+	// The pruning point is exempted from having a selected parent
+	requireViolations(t, s, "BlocksWithoutSelectedParent",
+		func(databaseTransaction databasePackage.Transaction) ([]databasePackage.BlockReference, error) {
+			return s.BlocksWithoutSelectedParent(databaseTransaction, 6)
+		})
+
+	run(t, s, func(databaseTransaction databasePackage.Transaction) error {
+		violations, err := s.HeightGroupsWithWrongSize(databaseTransaction)
+		if err != nil {
+			return err
+		}
+		expected := []databasePackage.HeightGroupViolation{
+			{Height: 3, Size: 0, BlockCount: 1},
+			{Height: 4, Size: 0, BlockCount: 1},
+		}
+		if !reflect.DeepEqual(violations, expected) {
+			t.Fatalf("HeightGroupsWithWrongSize reported %+v, expected %+v", violations, expected)
+		}
+		return nil
+	})
+}
+
+func TestHeightGroupsWithWrongSize(t *testing.T) {
+	s := newDiamond(t)
+	run(t, s, func(databaseTransaction databasePackage.Transaction) error {
+		// The height 1 holds two blocks at the same index, and the height 5 an empty height group
+		err := s.UpdateBlockHeight(databaseTransaction, 3, blockHash(3), 1, 0)
+		if err != nil {
+			return err
+		}
+		return s.InsertOrUpdateHeightGroup(databaseTransaction, &model.HeightGroup{Height: 5, Size: 2})
+	})
+	run(t, s, func(databaseTransaction databasePackage.Transaction) error {
+		violations, err := s.HeightGroupsWithWrongSize(databaseTransaction)
+		if err != nil {
+			return err
+		}
+		expected := []databasePackage.HeightGroupViolation{
+			{Height: 1, Size: 2, BlockCount: 2},
+			{Height: 5, Size: 2, BlockCount: 0},
+		}
+		if !reflect.DeepEqual(violations, expected) {
+			t.Fatalf("HeightGroupsWithWrongSize reported %+v, expected %+v", violations, expected)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,219 @@
+package memorystorage
+
+import (
+	databasePackage "github.com/kaspa-live/kaspa-graph-inspector/processing/database"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/database/model"
+	"github.com/kaspanet/kaspad/domain/consensus/model/externalapi"
+	"github.com/pkg/errors"
+)
+
+func (s *Storage) BlockHeight(databaseTransaction databasePackage.Transaction, blockID uint64) (uint64, error) {
+	block, err := s.block(blockID)
+	if err != nil {
+		return 0, err
+	}
+	return block.Height, nil
+}
+
+func (s *Storage) BlockHeightByHash(databaseTransaction databasePackage.Transaction, blockHash *externalapi.DomainHash) (uint64, error) {
+	block, err := s.blockByHash(blockHash)
+	if err != nil {
+		return 0, err
+	}
+	return block.Height, nil
+}
+
+func (s *Storage) BlockHeightGroupIndex(databaseTransaction databasePackage.Transaction, blockID uint64) (uint32, error) {
+	block, err := s.block(blockID)
+	if err != nil {
+		return 0, err
+	}
+	return block.HeightGroupIndex, nil
+}
+
+// HighestBlockHeight returns the highest height of the stored blocks among `blockIDs`, or 0 if there is none
+func (s *Storage) HighestBlockHeight(databaseTransaction databasePackage.Transaction, blockIDs []uint64) (uint64, error) {
+	highest := uint64(0)
+	for _, blockID := range blockIDs {
+		if block, ok := s.tables.blocks[blockID]; ok && block.Height > highest {
+			highest = block.Height
+		}
+	}
+	return highest, nil
+}
+
+// MaxBlockHeight returns the height of the highest stored block.
+// Returns false if no block is stored.
+func (s *Storage) MaxBlockHeight(databaseTransaction databasePackage.Transaction) (uint64, bool, error) {
+	highest, found := uint64(0), false
+	for height := range s.tables.blockIDsByHeight {
+		if !found || height > highest {
+			highest, found = height, true
+		}
+	}
+	return highest, found, nil
+}
+
+// UpdateBlockHeight moves the block identified by `blockID` to `height` at position `heightGroupIndex`
+func (s *Storage) UpdateBlockHeight(databaseTransaction databasePackage.Transaction, blockID uint64, blockHash *externalapi.DomainHash,
+	height uint64, heightGroupIndex uint32) error {
+
+	asTransaction(databaseTransaction).updateBlock(blockID, func(block *model.Block) {
+		block.Height = height
+		block.HeightGroupIndex = heightGroupIndex
+	})
+	return nil
+}
+
+// HeightGroupSize returns the size of the height group at `height`, or 0 if there is none
+func (s *Storage) HeightGroupSize(databaseTransaction databasePackage.Transaction, height uint64) (uint32, error) {
+	heightGroup, ok := s.tables.heightGroups[height]
+	if !ok {
+		return 0, nil
+	}
+	return heightGroup.Size, nil
+}
+
+func (s *Storage) InsertOrUpdateHeightGroup(databaseTransaction databasePackage.Transaction, heightGroup *model.HeightGroup) error {
+	stored := *heightGroup
+	asTransaction(databaseTransaction).replaceHeightGroup(heightGroup.Height, &stored)
+	return nil
+}
+
+// RenumberHeightGroup gives the blocks at `height` distinct height group indexes ranging from 0
+// to their count, keeping their order, and updates the edges and the size of the height group accordingly.
+// The height group is deleted if no block is left at `height`.
+func (s *Storage) RenumberHeightGroup(databaseTransaction databasePackage.Transaction, height uint64) error {
+	t := asTransaction(databaseTransaction)
+	blocks := s.blocksAtHeight(height)
+	for i, block := range blocks {
+		heightGroupIndex := uint32(i)
+		if block.HeightGroupIndex != heightGroupIndex {
+			t.updateBlock(block.ID, func(block *model.Block) {
+				block.HeightGroupIndex = heightGroupIndex
+			})
+		}
+		s.refreshEdgesOfBlock(t, block.ID)
+	}
+
+	if len(blocks) == 0 {
+		if _, ok := s.tables.heightGroups[height]; ok {
+			t.replaceHeightGroup(height, nil)
+		}
+		return nil
+	}
+	t.replaceHeightGroup(height, &model.HeightGroup{Height: height, Size: uint32(len(blocks))})
+	return nil
+}
+
+// RebaseHeights shifts the heights of all the blocks, edges and height groups down
+// so the lowest height is 0. Returns the height that became 0.
+func (s *Storage) RebaseHeights(databaseTransaction databasePackage.Transaction) (uint64, error) {
+	t := asTransaction(databaseTransaction)
+	lowest, found := uint64(0), false
+	for height := range s.tables.blockIDsByHeight {
+		if !found || height < lowest {
+			lowest, found = height, true
+		}
+	}
+	if lowest == 0 {
+		return 0, nil
+	}
+
+	for _, block := range s.sortedBlocks() {
+		t.updateBlock(block.ID, func(block *model.Block) {
+			block.Height -= lowest
+		})
+	}
+	for _, edge := range s.allEdges() {
+		t.updateEdge(edge, func(edge *model.Edge) {
+			edge.FromHeight -= lowest
+			edge.ToHeight -= lowest
+		})
+	}
+	// The height groups are all deleted first so the shifted ones do not replace the ones not shifted yet
+	heightGroups := make([]*model.HeightGroup, 0, len(s.tables.heightGroups))
+	for height, heightGroup := range s.tables.heightGroups {
+		heightGroups = append(heightGroups, heightGroup)
+		t.replaceHeightGroup(height, nil)
+	}
+	for _, heightGroup := range heightGroups {
+		if heightGroup.Height >= lowest {
+			t.replaceHeightGroup(heightGroup.Height-lowest, &model.HeightGroup{Height: heightGroup.Height - lowest, Size: heightGroup.Size})
+		}
+	}
+	return lowest, nil
+}
+
+// allEdges returns all the stored edges
+func (s *Storage) allEdges() []*model.Edge {
+	var edges []*model.Edge
+	for _, edgesFromBlock := range s.tables.edges {
+		for _, edge := range edgesFromBlock {
+			edges = append(edges, edge)
+		}
+	}
+	return edges
+}
+
+// edgesOfBlock returns the edges going from the block `blockID` and the edges going to it
+func (s *Storage) edgesOfBlock(blockID uint64) ([]*model.Edge, []*model.Edge) {
+	var edgesFrom, edgesTo []*model.Edge
+	for _, edge := range s.tables.edges[blockID] {
+		edgesFrom = append(edgesFrom, edge)
+	}
+	for fromBlockID := range s.tables.edgesTo[blockID] {
+		edgesTo = append(edgesTo, s.tables.edges[fromBlockID][blockID])
+	}
+	return edgesFrom, edgesTo
+}
+
+func (s *Storage) InsertEdge(databaseTransaction databasePackage.Transaction, edge *model.Edge) error {
+	if _, ok := s.tables.edges[edge.FromBlockID][edge.ToBlockID]; ok {
+		return errors.Errorf("Edge from block %d to block %d is already stored", edge.FromBlockID, edge.ToBlockID)
+	}
+	stored := *edge
+	asTransaction(databaseTransaction).replaceEdge(edge.FromBlockID, edge.ToBlockID, &stored)
+	return nil
+}
+
+// DeleteEdgesFromBlock deletes all the edges going from the block identified by `blockID` to its parents
+func (s *Storage) DeleteEdgesFromBlock(databaseTransaction databasePackage.Transaction, blockID uint64) error {
+	t := asTransaction(databaseTransaction)
+	edgesFrom, _ := s.edgesOfBlock(blockID)
+	for _, edge := range edgesFrom {
+		t.replaceEdge(edge.FromBlockID, edge.ToBlockID, nil)
+	}
+	return nil
+}
+
+// RefreshEdgesOfBlock copies the height and height group index of the block identified by
+// `blockID` to all the edges going from or to it
+func (s *Storage) RefreshEdgesOfBlock(databaseTransaction databasePackage.Transaction, blockID uint64) error {
+	s.refreshEdgesOfBlock(asTransaction(databaseTransaction), blockID)
+	return nil
+}
+
+func (s *Storage) refreshEdgesOfBlock(t *transaction, blockID uint64) {
+	block, ok := s.tables.blocks[blockID]
+	if !ok {
+		return
+	}
+	edgesFrom, edgesTo := s.edgesOfBlock(blockID)
+	for _, edge := range edgesFrom {
+		if edge.FromHeight != block.Height || edge.FromHeightGroupIndex != block.HeightGroupIndex {
+			t.updateEdge(edge, func(edge *model.Edge) {
+				edge.FromHeight = block.Height
+				edge.FromHeightGroupIndex = block.HeightGroupIndex
+			})
+		}
+	}
+	for _, edge := range edgesTo {
+		if edge.ToHeight != block.Height || edge.ToHeightGroupIndex != block.HeightGroupIndex {
+			t.updateEdge(edge, func(edge *model.Edge) {
+				edge.ToHeight = block.Height
+				edge.ToHeightGroupIndex = block.HeightGroupIndex
+			})
+		}
+	}
+}
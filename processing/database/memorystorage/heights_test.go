@@ -0,0 +1,209 @@
+package memorystorage
+
+import (
+	"reflect"
+	"testing"
+
+	databasePackage "github.com/kaspa-live/kaspa-graph-inspector/processing/database"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/database/model"
+)
+
+// requireConsistent fails the test if the consistency checks report a violation in `s`
+func requireConsistent(t *testing.T, s *Storage) {
+	t.Helper()
+
+	run(t, s, func(databaseTransaction databasePackage.Transaction) error {
+		mismatchedEdges, err := s.BlocksWithMismatchedEdges(databaseTransaction)
+		if err != nil {
+			return err
+		}
+		wrongHeights, err := s.BlocksWithWrongHeight(databaseTransaction)
+		if err != nil {
+			return err
+		}
+		wrongSizes, err := s.HeightGroupsWithWrongSize(databaseTransaction)
+		if err != nil {
+			return err
+		}
+		if len(mismatchedEdges) != 0 || len(wrongHeights) != 0 || len(wrongSizes) != 0 {
+			t.Fatalf("Blocks with mismatched edges %v, with a wrong height %v, height groups with a wrong size %v",
+				mismatchedEdges, wrongHeights, wrongSizes)
+		}
+		return nil
+	})
+}
+
+// requireBlockAt fails the test if the block `id` is not stored at `height` and `heightGroupIndex`
+func requireBlockAt(t *testing.T, s *Storage, id uint64, height uint64, heightGroupIndex uint32) {
+	t.Helper()
+
+	block := s.tables.blocks[id]
+	if block.Height != height || block.HeightGroupIndex != heightGroupIndex {
+		t.Fatalf("Block %d is at height %d and index %d, expected height %d and index %d",
+			id, block.Height, block.HeightGroupIndex, height, heightGroupIndex)
+	}
+}
+
+func TestUpdateBlockHeight(t *testing.T) {
+	s := newDiamond(t)
+	requireConsistent(t, s)
+
+	// The block 3 moves to the height of the block 4, whose edges keep the old coordinates until refreshed
+	run(t, s, func(databaseTransaction databasePackage.Transaction) error {
+		err := s.UpdateBlockHeight(databaseTransaction, 3, blockHash(3), 2, 1)
+		if err != nil {
+			return err
+		}
+		mismatchedEdges, err := s.BlocksWithMismatchedEdges(databaseTransaction)
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(mismatchedEdges, []databasePackage.BlockReference{
+			{ID: 3, BlockHash: blockHash(3).String(), Height: 2},
+			{ID: 4, BlockHash: blockHash(4).String(), Height: 2},
+		}) {
+			t.Fatalf("Blocks with mismatched edges are %v, expected 3 and 4", mismatchedEdges)
+		}
+		err = s.RefreshEdgesOfBlock(databaseTransaction, 3)
+		if err != nil {
+			return err
+		}
+		err = s.RenumberHeightGroup(databaseTransaction, 1)
+		if err != nil {
+			return err
+		}
+		return s.RenumberHeightGroup(databaseTransaction, 2)
+	})
+	requireBlockAt(t, s, 3, 2, 1)
+	edge := s.tables.edges[4][3]
+	if edge.ToHeight != 2 || edge.ToHeightGroupIndex != 1 {
+		t.Fatalf("Edge from 4 to 3 goes to height %d and index %d, expected height 2 and index 1",
+			edge.ToHeight, edge.ToHeightGroupIndex)
+	}
+	if s.tables.heightGroups[1].Size != 1 || s.tables.heightGroups[2].Size != 2 {
+		t.Fatalf("Height groups are %+v and %+v, expected sizes 1 and 2", s.tables.heightGroups[1], s.tables.heightGroups[2])
+	}
+	// The block 3 is no longer right above its parent, nor the block 4 above all its parents
+	run(t, s, func(databaseTransaction databasePackage.Transaction) error {
+		wrongHeights, err := s.BlocksWithWrongHeight(databaseTransaction)
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(referenceIDs(wrongHeights), []uint64{3, 4}) {
+			t.Fatalf("Blocks with a wrong height are %v, expected 3 and 4", wrongHeights)
+		}
+		return nil
+	})
+}
+
+func TestRenumberHeightGroup(t *testing.T) {
+	s := newDiamond(t)
+
+	// The block 2 leaves the height 1, leaving the block 3 alone at index 1
+	run(t, s, func(databaseTransaction databasePackage.Transaction) error {
+		return s.UpdateBlockHeight(databaseTransaction, 2, blockHash(2), 5, 0)
+	})
+	run(t, s, func(databaseTransaction databasePackage.Transaction) error {
+		return s.RenumberHeightGroup(databaseTransaction, 1)
+	})
+	requireBlockAt(t, s, 3, 1, 0)
+	if s.tables.heightGroups[1].Size != 1 {
+		t.Fatalf("Height group 1 has size %d, expected 1", s.tables.heightGroups[1].Size)
+	}
+	for _, edge := range []*model.Edge{s.tables.edges[3][1], s.tables.edges[4][3]} {
+		fromBlock, toBlock := s.tables.blocks[edge.FromBlockID], s.tables.blocks[edge.ToBlockID]
+		if edge.FromHeightGroupIndex != fromBlock.HeightGroupIndex || edge.ToHeightGroupIndex != toBlock.HeightGroupIndex {
+			t.Fatalf("Edge %+v was not renumbered", edge)
+		}
+	}
+
+	// The height group is deleted once left empty
+	run(t, s, func(databaseTransaction databasePackage.Transaction) error {
+		err := s.UpdateBlockHeight(databaseTransaction, 3, blockHash(3), 5, 1)
+		if err != nil {
+			return err
+		}
+		return s.RenumberHeightGroup(databaseTransaction, 1)
+	})
+	if _, ok := s.tables.heightGroups[1]; ok {
+		t.Fatalf("Height group 1 was not deleted")
+	}
+}
+
+func TestRebaseHeights(t *testing.T) {
+	s := New()
+	storeBlocks(t, s,
+		newBlock(1, 5, 0),
+		newBlock(2, 6, 0, 1),
+		newBlock(3, 6, 1, 1),
+		newBlock(4, 7, 0, 2, 3))
+	requireConsistent(t, s)
+
+	var lowest uint64
+	run(t, s, func(databaseTransaction databasePackage.Transaction) error {
+		var err error
+		lowest, err = s.RebaseHeights(databaseTransaction)
+		return err
+	})
+	if lowest != 5 {
+		t.Fatalf("RebaseHeights returned %d, expected 5", lowest)
+	}
+	requireBlockAt(t, s, 1, 0, 0)
+	requireBlockAt(t, s, 2, 1, 0)
+	requireBlockAt(t, s, 3, 1, 1)
+	requireBlockAt(t, s, 4, 2, 0)
+	expectedHeightGroups := map[uint64]*model.HeightGroup{
+		0: {Height: 0, Size: 1},
+		1: {Height: 1, Size: 2},
+		2: {Height: 2, Size: 1},
+	}
+	if !reflect.DeepEqual(s.tables.heightGroups, expectedHeightGroups) {
+		t.Fatalf("Height groups are %v, expected %v", s.tables.heightGroups, expectedHeightGroups)
+	}
+	requireConsistent(t, s)
+
+	// Heights already starting at 0 are left as is
+	expected := takeSnapshot(s)
+	run(t, s, func(databaseTransaction databasePackage.Transaction) error {
+		var err error
+		lowest, err = s.RebaseHeights(databaseTransaction)
+		return err
+	})
+	if lowest != 0 {
+		t.Fatalf("RebaseHeights returned %d, expected 0", lowest)
+	}
+	requireUnchanged(t, s, expected)
+}
+
+func TestMaxBlockHeight(t *testing.T) {
+	s := New()
+	run(t, s, func(databaseTransaction databasePackage.Transaction) error {
+		_, found, err := s.MaxBlockHeight(databaseTransaction)
+		if err != nil {
+			return err
+		}
+		if found {
+			t.Fatalf("MaxBlockHeight found a height in an empty storage")
+		}
+		return nil
+	})
+
+	s = newDiamond(t)
+	run(t, s, func(databaseTransaction databasePackage.Transaction) error {
+		maxHeight, found, err := s.MaxBlockHeight(databaseTransaction)
+		if err != nil {
+			return err
+		}
+		if !found || maxHeight != 2 {
+			t.Fatalf("MaxBlockHeight returned %d and %t, expected 2", maxHeight, found)
+		}
+		highest, err := s.HighestBlockHeight(databaseTransaction, []uint64{2, 3, 42})
+		if err != nil {
+			return err
+		}
+		if highest != 1 {
+			t.Fatalf("HighestBlockHeight returned %d, expected 1", highest)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,256 @@
+package memorystorage
+
+import (
+	"context"
+	"sync"
+
+	databasePackage "github.com/kaspa-live/kaspa-graph-inspector/processing/database"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/database/model"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/tracing"
+	"github.com/pkg/errors"
+)
+
+// Storage is a databasePackage.Storage holding its data in memory, for the processing to be
+// run without a database by tests and tooling. Its transactions run one at a time and the
+// changes of a failed transaction are rolled back.
+type Storage struct {
+	tables        *tables
+	lastBlockID   uint64
+	lastSegmentID uint64
+	sync.Mutex
+}
+
+// tables holds the rows of the tables of the database schema, along with the indexes
+// the queries of the processing need
+type tables struct {
+	blocks           map[uint64]*model.Block
+	blockIDsByHash   map[string]uint64
+	blockIDsByHeight map[uint64]map[uint64]struct{}
+	// edges maps the from block ID and then the to block ID to the edges
+	edges        map[uint64]map[uint64]*model.Edge
+	edgesTo      map[uint64]map[uint64]struct{}
+	heightGroups map[uint64]*model.HeightGroup
+	segments     []*model.Segment
+	appConfig    *model.AppConfig
+}
+
+func newTables() *tables {
+	return &tables{
+		blocks:           make(map[uint64]*model.Block),
+		blockIDsByHash:   make(map[string]uint64),
+		blockIDsByHeight: make(map[uint64]map[uint64]struct{}),
+		edges:            make(map[uint64]map[uint64]*model.Edge),
+		edgesTo:          make(map[uint64]map[uint64]struct{}),
+		heightGroups:     make(map[uint64]*model.HeightGroup),
+	}
+}
+
+// New creates an empty storage
+func New() *Storage {
+	return &Storage{tables: newTables()}
+}
+
+// transaction records how to undo the changes it makes, so they can be rolled back.
+// The stored rows are never modified in place but replaced by modified copies.
+type transaction struct {
+	ctx     context.Context
+	storage *Storage
+	undo    []func()
+}
+
+func (t *transaction) Context() context.Context {
+	return t.ctx
+}
+
+func asTransaction(databaseTransaction databasePackage.Transaction) *transaction {
+	return databaseTransaction.(*transaction)
+}
+
+// RunInTransaction runs `transactionFunction` in a transaction bound to `ctx`, rolling its
+// changes back if it fails. The transactions run one at a time.
+func (s *Storage) RunInTransaction(ctx context.Context, transactionFunction func(databasePackage.Transaction) error) error {
+	s.Lock()
+	defer s.Unlock()
+
+	ctx, span := tracing.Start(ctx, "MemoryStorage.RunInTransaction")
+	t := &transaction{ctx: ctx, storage: s}
+	err := transactionFunction(t)
+	if err != nil {
+		for i := len(t.undo) - 1; i >= 0; i-- {
+			t.undo[i]()
+		}
+	}
+	tracing.End(span, err)
+	return err
+}
+
+// Ping always succeeds
+func (s *Storage) Ping() error {
+	return nil
+}
+
+// LoadCache does nothing since every block is held in memory
+func (s *Storage) LoadCache(databaseTransaction databasePackage.Transaction, minHeight uint64) error {
+	return nil
+}
+
+func (s *Storage) Clear(databaseTransaction databasePackage.Transaction) error {
+	t := asTransaction(databaseTransaction)
+	cleared := s.tables
+	s.tables = newTables()
+	t.undo = append(t.undo, func() { s.tables = cleared })
+	return nil
+}
+
+// replaceBlock replaces the block `id` by `block`, deleting it if `block` is nil
+func (t *transaction) replaceBlock(id uint64, block *model.Block) {
+	tables := t.storage.tables
+	replaced := tables.replaceBlock(id, block)
+	t.undo = append(t.undo, func() { tables.replaceBlock(id, replaced) })
+}
+
+// updateBlock replaces the block `id` by a copy modified by `update`.
+// Nothing is updated if the block does not exist.
+func (t *transaction) updateBlock(id uint64, update func(block *model.Block)) {
+	block, ok := t.storage.tables.blocks[id]
+	if !ok {
+		return
+	}
+	block = cloneBlock(block)
+	update(block)
+	t.replaceBlock(id, block)
+}
+
+// replaceEdge replaces the edge from `fromBlockID` to `toBlockID` by `edge`, deleting it if `edge` is nil
+func (t *transaction) replaceEdge(fromBlockID uint64, toBlockID uint64, edge *model.Edge) {
+	tables := t.storage.tables
+	replaced := tables.replaceEdge(fromBlockID, toBlockID, edge)
+	t.undo = append(t.undo, func() { tables.replaceEdge(fromBlockID, toBlockID, replaced) })
+}
+
+// updateEdge replaces `edge` by a copy modified by `update`
+func (t *transaction) updateEdge(edge *model.Edge, update func(edge *model.Edge)) {
+	updated := *edge
+	update(&updated)
+	t.replaceEdge(edge.FromBlockID, edge.ToBlockID, &updated)
+}
+
+// replaceHeightGroup replaces the height group at `height` by `heightGroup`, deleting it if `heightGroup` is nil
+func (t *transaction) replaceHeightGroup(height uint64, heightGroup *model.HeightGroup) {
+	tables := t.storage.tables
+	replaced := tables.replaceHeightGroup(height, heightGroup)
+	t.undo = append(t.undo, func() { tables.replaceHeightGroup(height, replaced) })
+}
+
+func (tables *tables) replaceBlock(id uint64, block *model.Block) *model.Block {
+	replaced, ok := tables.blocks[id]
+	if ok {
+		delete(tables.blocks, id)
+		delete(tables.blockIDsByHash, replaced.BlockHash)
+		deleteFromSet(tables.blockIDsByHeight, replaced.Height, id)
+	}
+	if block != nil {
+		tables.blocks[id] = block
+		tables.blockIDsByHash[block.BlockHash] = id
+		addToSet(tables.blockIDsByHeight, block.Height, id)
+	}
+	return replaced
+}
+
+func (tables *tables) replaceEdge(fromBlockID uint64, toBlockID uint64, edge *model.Edge) *model.Edge {
+	replaced := tables.edges[fromBlockID][toBlockID]
+	if replaced != nil {
+		delete(tables.edges[fromBlockID], toBlockID)
+		if len(tables.edges[fromBlockID]) == 0 {
+			delete(tables.edges, fromBlockID)
+		}
+		deleteFromSet(tables.edgesTo, toBlockID, fromBlockID)
+	}
+	if edge != nil {
+		if _, ok := tables.edges[fromBlockID]; !ok {
+			tables.edges[fromBlockID] = make(map[uint64]*model.Edge)
+		}
+		tables.edges[fromBlockID][toBlockID] = edge
+		addToSet(tables.edgesTo, toBlockID, fromBlockID)
+	}
+	return replaced
+}
+
+func (tables *tables) replaceHeightGroup(height uint64, heightGroup *model.HeightGroup) *model.HeightGroup {
+	replaced := tables.heightGroups[height]
+	if heightGroup == nil {
+		delete(tables.heightGroups, height)
+	} else {
+		tables.heightGroups[height] = heightGroup
+	}
+	return replaced
+}
+
+func addToSet(sets map[uint64]map[uint64]struct{}, key uint64, value uint64) {
+	set, ok := sets[key]
+	if !ok {
+		set = make(map[uint64]struct{})
+		sets[key] = set
+	}
+	set[value] = struct{}{}
+}
+
+func deleteFromSet(sets map[uint64]map[uint64]struct{}, key uint64, value uint64) {
+	delete(sets[key], value)
+	if len(sets[key]) == 0 {
+		delete(sets, key)
+	}
+}
+
+// SetUpPartitioning accepts only the absence of partitioning
+func (s *Storage) SetUpPartitioning(databaseTransaction databasePackage.Transaction, options *databasePackage.PartitioningOptions) error {
+	if options.Mode != databasePackage.PartitioningNone {
+		return errors.Errorf("The in-memory storage does not support %s partitioning", options.Mode)
+	}
+	return nil
+}
+
+// MaintainPartitions does nothing since the in-memory storage is not partitioned
+func (s *Storage) MaintainPartitions(databaseTransaction databasePackage.Transaction,
+	options *databasePackage.PartitioningOptions) (int, int, error) {
+
+	return 0, 0, nil
+}
+
+func (s *Storage) InsertSegment(databaseTransaction databasePackage.Transaction, segment *model.Segment) error {
+	t := asTransaction(databaseTransaction)
+	if segment.ID == 0 {
+		s.lastSegmentID++
+		segment.ID = s.lastSegmentID
+	} else if segment.ID > s.lastSegmentID {
+		s.lastSegmentID = segment.ID
+	}
+	stored := *segment
+	tables := s.tables
+	tables.segments = append(tables.segments, &stored)
+	segmentCount := len(tables.segments) - 1
+	t.undo = append(t.undo, func() { tables.segments = tables.segments[:segmentCount] })
+	return nil
+}
+
+// GetAppConfig returns the stored app config.
+// Returns an error if no app config is stored.
+func (s *Storage) GetAppConfig(databaseTransaction databasePackage.Transaction) (*model.AppConfig, error) {
+	if s.tables.appConfig == nil {
+		return nil, errors.Errorf("No app config is stored")
+	}
+	appConfig := *s.tables.appConfig
+	return &appConfig, nil
+}
+
+// StoreAppConfig stores `appConfig`, replacing the stored one if any
+func (s *Storage) StoreAppConfig(databaseTransaction databasePackage.Transaction, appConfig *model.AppConfig) error {
+	t := asTransaction(databaseTransaction)
+	appConfig.ID = true
+	stored := *appConfig
+	tables := s.tables
+	replaced := tables.appConfig
+	tables.appConfig = &stored
+	t.undo = append(t.undo, func() { tables.appConfig = replaced })
+	return nil
+}
@@ -0,0 +1,296 @@
+package memorystorage
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	databasePackage "github.com/kaspa-live/kaspa-graph-inspector/processing/database"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/database/model"
+	"github.com/kaspanet/kaspad/domain/consensus/model/externalapi"
+	"github.com/pkg/errors"
+)
+
+var errTest = errors.New("test failure")
+
+// blockHash returns the hash of the test block `id`
+func blockHash(id uint64) *externalapi.DomainHash {
+	var hash [externalapi.DomainHashSize]byte
+	hash[0] = byte(id)
+	hash[1] = byte(id >> 8)
+	return externalapi.NewDomainHashFromByteArray(&hash)
+}
+
+// newBlock returns the test block `id` at `height` and `heightGroupIndex`, its first parent being its selected parent
+func newBlock(id uint64, height uint64, heightGroupIndex uint32, parentIDs ...uint64) *model.Block {
+	block := &model.Block{
+		ID:               id,
+		BlockHash:        blockHash(id).String(),
+		Timestamp:        int64(id),
+		ParentIDs:        parentIDs,
+		DAAScore:         id,
+		Height:           height,
+		HeightGroupIndex: heightGroupIndex,
+		Color:            model.ColorGray,
+		MergeSetRedIDs:   []uint64{},
+		MergeSetBlueIDs:  append([]uint64(nil), parentIDs...),
+	}
+	if len(parentIDs) > 0 {
+		block.SelectedParentID = &parentIDs[0]
+	}
+	return block
+}
+
+// run runs `transactionFunction` in a transaction of `s`, failing the test if it fails
+func run(t *testing.T, s *Storage, transactionFunction func(databaseTransaction databasePackage.Transaction) error) {
+	t.Helper()
+
+	err := s.RunInTransaction(context.Background(), transactionFunction)
+	if err != nil {
+		t.Fatalf("RunInTransaction: %s", err)
+	}
+}
+
+// storeBlocks stores `blocks` along with the edges to their parents, stored earlier, and their height groups
+func storeBlocks(t *testing.T, s *Storage, blocks ...*model.Block) {
+	t.Helper()
+
+	run(t, s, func(databaseTransaction databasePackage.Transaction) error {
+		for _, block := range blocks {
+			err := s.InsertBlock(databaseTransaction, blockHash(block.ID), block)
+			if err != nil {
+				return err
+			}
+			for _, parentID := range block.ParentIDs {
+				parent, err := s.GetBlock(databaseTransaction, parentID)
+				if err != nil {
+					return err
+				}
+				err = s.InsertEdge(databaseTransaction, &model.Edge{
+					FromBlockID:          block.ID,
+					ToBlockID:            parentID,
+					FromHeight:           block.Height,
+					ToHeight:             parent.Height,
+					FromHeightGroupIndex: block.HeightGroupIndex,
+					ToHeightGroupIndex:   parent.HeightGroupIndex,
+				})
+				if err != nil {
+					return err
+				}
+			}
+			size, err := s.HeightGroupSize(databaseTransaction, block.Height)
+			if err != nil {
+				return err
+			}
+			err = s.InsertOrUpdateHeightGroup(databaseTransaction, &model.HeightGroup{Height: block.Height, Size: size + 1})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// newDiamond returns a storage holding the block 1, its children 2 and 3, and the block 4 merging them
+func newDiamond(t *testing.T) *Storage {
+	s := New()
+	storeBlocks(t, s,
+		newBlock(1, 0, 0),
+		newBlock(2, 1, 0, 1),
+		newBlock(3, 1, 1, 1),
+		newBlock(4, 2, 0, 2, 3))
+	return s
+}
+
+// snapshot is a copy of the rows of a storage, comparable with reflect.DeepEqual
+type snapshot struct {
+	blocks           map[uint64]*model.Block
+	blockIDsByHash   map[string]uint64
+	blockIDsByHeight map[uint64]map[uint64]struct{}
+	edges            map[uint64]map[uint64]*model.Edge
+	edgesTo          map[uint64]map[uint64]struct{}
+	heightGroups     map[uint64]*model.HeightGroup
+	segments         []*model.Segment
+	appConfig        *model.AppConfig
+}
+
+func takeSnapshot(s *Storage) *snapshot {
+	tables := s.tables
+	snapshot := &snapshot{
+		blocks:           make(map[uint64]*model.Block),
+		blockIDsByHash:   make(map[string]uint64),
+		blockIDsByHeight: make(map[uint64]map[uint64]struct{}),
+		edges:            make(map[uint64]map[uint64]*model.Edge),
+		edgesTo:          make(map[uint64]map[uint64]struct{}),
+		heightGroups:     make(map[uint64]*model.HeightGroup),
+		segments:         append([]*model.Segment(nil), tables.segments...),
+		appConfig:        tables.appConfig,
+	}
+	for id, block := range tables.blocks {
+		snapshot.blocks[id] = cloneBlock(block)
+	}
+	for hash, id := range tables.blockIDsByHash {
+		snapshot.blockIDsByHash[hash] = id
+	}
+	for height, ids := range tables.blockIDsByHeight {
+		for id := range ids {
+			addToSet(snapshot.blockIDsByHeight, height, id)
+		}
+	}
+	for fromBlockID, edgesFromBlock := range tables.edges {
+		snapshot.edges[fromBlockID] = make(map[uint64]*model.Edge)
+		for toBlockID, edge := range edgesFromBlock {
+			snapshot.edges[fromBlockID][toBlockID] = edge
+		}
+	}
+	for toBlockID, fromBlockIDs := range tables.edgesTo {
+		for fromBlockID := range fromBlockIDs {
+			addToSet(snapshot.edgesTo, toBlockID, fromBlockID)
+		}
+	}
+	for height, heightGroup := range tables.heightGroups {
+		snapshot.heightGroups[height] = heightGroup
+	}
+	return snapshot
+}
+
+// requireUnchanged fails the test if the rows of `s` differ from `expected`
+func requireUnchanged(t *testing.T, s *Storage, expected *snapshot) {
+	t.Helper()
+
+	actual := takeSnapshot(s)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("The storage holds %+v, expected %+v", actual, expected)
+	}
+}
+
+func TestFailedTransactionRollsBack(t *testing.T) {
+	s := newDiamond(t)
+	run(t, s, func(databaseTransaction databasePackage.Transaction) error {
+		err := s.InsertSegment(databaseTransaction, &model.Segment{PruningPointID: 1})
+		if err != nil {
+			return err
+		}
+		return s.StoreAppConfig(databaseTransaction, &model.AppConfig{Network: "kaspa-simnet"})
+	})
+	expected := takeSnapshot(s)
+
+	err := s.RunInTransaction(context.Background(), func(databaseTransaction databasePackage.Transaction) error {
+		steps := []func() error{
+			func() error { return s.InsertBlock(databaseTransaction, blockHash(5), newBlock(5, 3, 0, 4)) },
+			func() error {
+				return s.InsertEdge(databaseTransaction, &model.Edge{FromBlockID: 5, ToBlockID: 4, FromHeight: 3, ToHeight: 2})
+			},
+			func() error { return s.UpdateBlockParents(databaseTransaction, 4, blockHash(4), []uint64{2}, 2, 0) },
+			func() error { return s.DeleteEdgesFromBlock(databaseTransaction, 4) },
+			func() error { return s.UpdateBlockHeight(databaseTransaction, 3, blockHash(3), 4, 0) },
+			func() error { return s.RefreshEdgesOfBlock(databaseTransaction, 3) },
+			func() error { return s.RenumberHeightGroup(databaseTransaction, 1) },
+			func() error { return s.UpdateBlockColors(databaseTransaction, map[uint64]string{2: model.ColorBlue}) },
+			func() error {
+				return s.UpdateBlockIsInVirtualSelectedParentChain(databaseTransaction, map[uint64]bool{1: true, 2: true})
+			},
+			func() error { return s.UpdateBlockDAAScores(databaseTransaction, map[uint64]uint64{2: 20}) },
+			func() error { return s.UpdateBlockMergeSet(databaseTransaction, 4, []uint64{3}, []uint64{2}) },
+			func() error { return s.AddBlockParent(databaseTransaction, 5, 2) },
+			func() error {
+				_, err := s.PruneBlocksOutsideWindow(databaseTransaction, &databasePackage.RetentionWindow{Height: 1}, 100, 10)
+				return err
+			},
+			func() error {
+				_, err := s.RebaseHeights(databaseTransaction)
+				return err
+			},
+			func() error { return s.InsertSegment(databaseTransaction, &model.Segment{PruningPointID: 2}) },
+			func() error { return s.StoreAppConfig(databaseTransaction, &model.AppConfig{Network: "kaspa-devnet"}) },
+		}
+		for _, step := range steps {
+			err := step()
+			if err != nil {
+				return err
+			}
+		}
+		if reflect.DeepEqual(takeSnapshot(s), expected) {
+			t.Fatalf("The transaction changed nothing")
+		}
+		return errTest
+	})
+	if !errors.Is(err, errTest) {
+		t.Fatalf("RunInTransaction returned %v, expected %s", err, errTest)
+	}
+	requireUnchanged(t, s, expected)
+}
+
+func TestFailedTransactionRollsBackClear(t *testing.T) {
+	s := newDiamond(t)
+	expected := takeSnapshot(s)
+
+	// The changes made before and after the clearing are undone on the tables they were made on
+	err := s.RunInTransaction(context.Background(), func(databaseTransaction databasePackage.Transaction) error {
+		err := s.UpdateBlockColors(databaseTransaction, map[uint64]string{2: model.ColorBlue})
+		if err != nil {
+			return err
+		}
+		err = s.Clear(databaseTransaction)
+		if err != nil {
+			return err
+		}
+		err = s.InsertBlock(databaseTransaction, blockHash(2), newBlock(2, 0, 0))
+		if err != nil {
+			return err
+		}
+		err = s.InsertOrUpdateHeightGroup(databaseTransaction, &model.HeightGroup{Height: 0, Size: 1})
+		if err != nil {
+			return err
+		}
+		err = s.StoreAppConfig(databaseTransaction, &model.AppConfig{Network: "kaspa-devnet"})
+		if err != nil {
+			return err
+		}
+		return errTest
+	})
+	if !errors.Is(err, errTest) {
+		t.Fatalf("RunInTransaction returned %v, expected %s", err, errTest)
+	}
+	requireUnchanged(t, s, expected)
+
+	// A committed clearing is not undone by a later failing transaction
+	run(t, s, s.Clear)
+	cleared := takeSnapshot(s)
+	if len(cleared.blocks) != 0 || len(cleared.edges) != 0 || len(cleared.heightGroups) != 0 {
+		t.Fatalf("The storage holds %+v after being cleared", cleared)
+	}
+	err = s.RunInTransaction(context.Background(), func(databaseTransaction databasePackage.Transaction) error {
+		err := s.InsertBlock(databaseTransaction, blockHash(1), newBlock(1, 0, 0))
+		if err != nil {
+			return err
+		}
+		return errTest
+	})
+	if !errors.Is(err, errTest) {
+		t.Fatalf("RunInTransaction returned %v, expected %s", err, errTest)
+	}
+	requireUnchanged(t, s, cleared)
+}
+
+func TestInsertBlockRejectsDuplicates(t *testing.T) {
+	s := newDiamond(t)
+	expected := takeSnapshot(s)
+
+	for _, block := range []*model.Block{newBlock(5, 0, 1), newBlock(0, 0, 1)} {
+		block.BlockHash = blockHash(1).String()
+		err := s.RunInTransaction(context.Background(), func(databaseTransaction databasePackage.Transaction) error {
+			return s.InsertBlock(databaseTransaction, blockHash(1), block)
+		})
+		if err == nil {
+			t.Fatalf("Storing a block hash twice did not fail")
+		}
+	}
+	err := s.RunInTransaction(context.Background(), func(databaseTransaction databasePackage.Transaction) error {
+		return s.InsertBlock(databaseTransaction, blockHash(5), newBlock(1, 0, 1))
+	})
+	if err == nil {
+		t.Fatalf("Storing a block ID twice did not fail")
+	}
+	requireUnchanged(t, s, expected)
+}
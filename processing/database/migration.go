@@ -1,10 +1,14 @@
 package database
 
 import (
+	"embed"
+	"os"
+	"path/filepath"
+
 	migratePackage "github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
 	"github.com/pkg/errors"
-	"os"
 
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
@@ -19,7 +23,8 @@ func isCurrent(migrator *migratePackage.Migrate, driver source.Driver) (bool, ui
 		return false, 0, errors.WithStack(err)
 	}
 	if isDirty {
-		return false, 0, errors.Errorf("Database is dirty")
+		return false, 0, errors.Errorf("Database is dirty (version %d), "+
+			"fix the schema manually then run `migrate force` to recover", version)
 	}
 
 	// The database is current if Next returns ErrNotExist
@@ -33,8 +38,29 @@ func isCurrent(migrator *migratePackage.Migrate, driver source.Driver) (bool, ui
 	return false, version, err
 }
 
+// embeddedMigrations holds the SQL migrations built into the binary
+//
+//go:embed migrations/*.sql
+var embeddedMigrations embed.FS
+
+// migrationsDirectory overrides the embedded migrations when not empty
+var migrationsDirectory string
+
+// UseMigrationsDirectory makes all subsequent migrations load from `directory` instead of
+// the migrations embedded in the binary. An empty `directory` restores the embedded migrations.
+func UseMigrationsDirectory(directory string) {
+	migrationsDirectory = directory
+}
+
+func openMigrationSource() (source.Driver, error) {
+	if migrationsDirectory != "" {
+		return source.Open("file://" + filepath.ToSlash(migrationsDirectory))
+	}
+	return iofs.New(embeddedMigrations, "migrations")
+}
+
 func openMigrator(connectionString string) (*migratePackage.Migrate, source.Driver, error) {
-	driver, err := source.Open("file://database/migrations")
+	driver, err := openMigrationSource()
 	if err != nil {
 		return nil, nil, err
 	}
@@ -71,3 +97,194 @@ func migrate(connectionString string) error {
 	log.Infof("Migrated database to the latest version (version %d)", version)
 	return nil
 }
+
+// MigrationStatus describes the migration version of a database
+type MigrationStatus struct {
+	// Version is the version of the latest migration applied, 0 if none
+	Version uint
+	// IsDirty is true if the latest migration failed and the schema has to be fixed manually
+	IsDirty         bool
+	LatestVersion   uint
+	PendingVersions []uint
+}
+
+// Migrate migrates the database to the latest version.
+// Does nothing if the database is already up to date.
+func Migrate(connectionString string) error {
+	migrator, driver, err := openMigrator(connectionString)
+	if err != nil {
+		return err
+	}
+	isCurrent, version, err := isCurrent(migrator, driver)
+	if err != nil {
+		return errors.Wrapf(err, "error checking whether the database is current")
+	}
+	if isCurrent {
+		log.Infof("Database is already up to date (version %d)", version)
+		return nil
+	}
+	return migrate(connectionString)
+}
+
+// MigrateDown rolls the database back by `steps` migrations
+func MigrateDown(connectionString string, steps uint) error {
+	migrator, _, err := openMigrator(connectionString)
+	if err != nil {
+		return err
+	}
+	err = migrator.Steps(-int(steps))
+	if err != nil {
+		return err
+	}
+	return logRolledBackVersion(migrator)
+}
+
+// MigrateDownTo rolls the database back to `version`, 0 rolling back all the migrations
+func MigrateDownTo(connectionString string, version uint) error {
+	migrator, driver, err := openMigrator(connectionString)
+	if err != nil {
+		return err
+	}
+	currentVersion, isDirty, err := migrator.Version()
+	if errors.Is(err, migratePackage.ErrNilVersion) {
+		return errors.Errorf("could not roll back a database having no migration applied")
+	}
+	if err != nil {
+		return err
+	}
+	if isDirty {
+		return errors.Errorf("could not roll back a dirty database (version %d)", currentVersion)
+	}
+	if version >= currentVersion {
+		return errors.Errorf("could not roll back to version %d from version %d", version, currentVersion)
+	}
+
+	if version == 0 {
+		err = migrator.Down()
+	} else {
+		err = sourceVersionExists(driver, version)
+		if err != nil {
+			return err
+		}
+		err = migrator.Migrate(version)
+	}
+	if err != nil {
+		return err
+	}
+	return logRolledBackVersion(migrator)
+}
+
+func logRolledBackVersion(migrator *migratePackage.Migrate) error {
+	version, isDirty, err := migrator.Version()
+	if errors.Is(err, migratePackage.ErrNilVersion) {
+		log.Infof("Rolled the database back to an empty schema")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if isDirty {
+		return errors.Errorf("error rolling back database: database is dirty")
+	}
+	log.Infof("Rolled the database back to version %d", version)
+	return nil
+}
+
+// ForceMigrationVersion records `version` as the version of a dirty database, without running
+// any migration. Once the failed migration got fixed manually, `version` is expected to be either
+// the dirty version, if the migration got completed, or the version before, if it got reverted.
+// 0 records that no migration is applied.
+func ForceMigrationVersion(connectionString string, version uint) error {
+	migrator, driver, err := openMigrator(connectionString)
+	if err != nil {
+		return err
+	}
+	dirtyVersion, isDirty, err := migrator.Version()
+	if err != nil && !errors.Is(err, migratePackage.ErrNilVersion) {
+		return err
+	}
+	if !isDirty {
+		return errors.Errorf("could not force the version of a database that is not dirty (version %d)", dirtyVersion)
+	}
+
+	previousVersion, err := driver.Prev(dirtyVersion)
+	if errors.Is(err, os.ErrNotExist) {
+		previousVersion = 0
+	} else if err != nil {
+		return err
+	}
+	if version != dirtyVersion && version != previousVersion {
+		return errors.Errorf("could not force version %d on a database dirty at version %d, "+
+			"expecting either %d or %d", version, dirtyVersion, dirtyVersion, previousVersion)
+	}
+
+	if version == 0 {
+		// Version -1 stands for no migration applied
+		err = migrator.Force(-1)
+	} else {
+		err = migrator.Force(int(version))
+	}
+	if err != nil {
+		return err
+	}
+	log.Infof("Forced the database version to %d", version)
+	return nil
+}
+
+// GetMigrationStatus returns the migration version of the database along with the
+// versions of the migrations not applied yet
+func GetMigrationStatus(connectionString string) (*MigrationStatus, error) {
+	migrator, driver, err := openMigrator(connectionString)
+	if err != nil {
+		return nil, err
+	}
+	status := &MigrationStatus{PendingVersions: []uint{}}
+	status.Version, status.IsDirty, err = migrator.Version()
+	if err != nil && !errors.Is(err, migratePackage.ErrNilVersion) {
+		return nil, err
+	}
+
+	versions, err := sourceVersions(driver)
+	if err != nil {
+		return nil, err
+	}
+	for _, version := range versions {
+		if version > status.Version {
+			status.PendingVersions = append(status.PendingVersions, version)
+		}
+	}
+	status.LatestVersion = versions[len(versions)-1]
+	return status, nil
+}
+
+// sourceVersions returns the versions of all the available migrations in increasing order
+func sourceVersions(driver source.Driver) ([]uint, error) {
+	version, err := driver.First()
+	if err != nil {
+		return nil, err
+	}
+	versions := []uint{version}
+	for {
+		version, err = driver.Next(version)
+		if errors.Is(err, os.ErrNotExist) {
+			return versions, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+}
+
+func sourceVersionExists(driver source.Driver, version uint) error {
+	versions, err := sourceVersions(driver)
+	if err != nil {
+		return err
+	}
+	for _, sourceVersion := range versions {
+		if sourceVersion == version {
+			return nil
+		}
+	}
+	return errors.Errorf("no migration has version %d", version)
+}
@@ -0,0 +1,3 @@
+ALTER TABLE blocks
+    DROP COLUMN merge_set_red_ids,
+    DROP COLUMN merge_set_blue_ids;
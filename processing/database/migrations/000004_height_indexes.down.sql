@@ -0,0 +1,3 @@
+DROP INDEX edges_from_height_idx;
+DROP INDEX edges_to_height_idx;
+DROP INDEX blocks_height_idx;
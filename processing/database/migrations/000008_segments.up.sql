@@ -0,0 +1,9 @@
+CREATE TABLE segments
+(
+    id               BIGSERIAL,
+    pruning_point_id BIGINT  NOT NULL,
+    start_height     BIGINT  NOT NULL,
+    is_connected     BOOLEAN NOT NULL,
+    created_at       BIGINT  NOT NULL,
+    PRIMARY KEY (id)
+);
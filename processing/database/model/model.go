@@ -35,6 +35,16 @@ type HeightGroup struct {
 	Size   uint32 `pg:"size,use_zero"`
 }
 
+// Segment records the pruning point of the node starting a new part of the stored history,
+// either connected to the blocks stored before it or following a gap
+type Segment struct {
+	ID             uint64 `pg:"id,pk"`
+	PruningPointID uint64 `pg:"pruning_point_id,use_zero"`
+	StartHeight    uint64 `pg:"start_height,use_zero"`
+	IsConnected    bool   `pg:"is_connected,use_zero"`
+	CreatedAt      int64  `pg:"created_at,use_zero"`
+}
+
 type AppConfig struct {
 	//lint:ignore U1000 This field is used by gp-pg reflexively
 	tableName struct{} `pg:"app_config,alias:app_config"`
@@ -0,0 +1,475 @@
+package database
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+// Partitioning modes of the blocks and edges tables
+const (
+	// PartitioningNone keeps the blocks and edges tables as plain tables
+	PartitioningNone = "none"
+	// PartitioningNative partitions the tables by height range with PostgreSQL declarative partitioning
+	PartitioningNative = "native"
+	// PartitioningTimescaleDB turns the tables into TimescaleDB hypertables chunked by height range
+	PartitioningTimescaleDB = "timescaledb"
+)
+
+// PartitioningOptions describes how the blocks and edges tables are partitioned
+type PartitioningOptions struct {
+	Mode string
+	// PartitionSize is the number of heights covered by each new partition
+	PartitionSize uint64
+	// PartitionsAhead is the number of empty partitions kept above the highest block
+	PartitionsAhead int
+	// RetainedPartitions is the number of partitions kept below the partition holding the
+	// highest block. The older partitions are detached. Use 0 to keep all the partitions.
+	RetainedPartitions int
+}
+
+// partitionedTable is a table partitioned by the range of `column`
+type partitionedTable struct {
+	name   string
+	column string
+}
+
+var partitionedTables = []partitionedTable{
+	{name: "blocks", column: "height"},
+	{name: "edges", column: "from_height"},
+}
+
+// heightRange is the range of heights covered by a partition, `to` excluded
+type heightRange struct {
+	name string
+	from uint64
+	to   uint64
+}
+
+// PartitioningMode returns the partitioning mode the blocks table is currently in
+func (db *Database) PartitioningMode(databaseTransaction *pg.Tx) (string, error) {
+	var result struct {
+		Partitioned bool
+		Timescale   bool
+	}
+	_, err := databaseTransaction.QueryOne(&result, `
+		SELECT EXISTS (SELECT 1 FROM pg_partitioned_table WHERE partrelid = 'blocks'::REGCLASS) AS partitioned,
+			EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'timescaledb') AS timescale`)
+	if err != nil {
+		return "", err
+	}
+	if result.Partitioned {
+		return PartitioningNative, nil
+	}
+	if !result.Timescale {
+		return PartitioningNone, nil
+	}
+
+	var hypertable struct {
+		Hypertable bool
+	}
+	_, err = databaseTransaction.QueryOne(&hypertable, `
+		SELECT EXISTS (SELECT 1 FROM timescaledb_information.hypertables WHERE hypertable_name = 'blocks') AS hypertable`)
+	if err != nil {
+		return "", err
+	}
+	if hypertable.Hypertable {
+		return PartitioningTimescaleDB, nil
+	}
+	return PartitioningNone, nil
+}
+
+// SetUpPartitioning converts the blocks and edges tables to the partitioning mode of `options`.
+// Tables already in that mode are left as is. Partitioned tables are never converted back.
+func (db *Database) SetUpPartitioning(databaseTransaction *pg.Tx, options *PartitioningOptions) error {
+	mode, err := db.PartitioningMode(databaseTransaction)
+	if err != nil {
+		return err
+	}
+	if mode == options.Mode {
+		db.partitioning = mode
+		return nil
+	}
+	if mode != PartitioningNone {
+		return errors.Errorf("The blocks and edges tables are partitioned in %s mode, "+
+			"they cannot be converted to %s mode", mode, options.Mode)
+	}
+
+	log.Infof("Converting the blocks and edges tables to %s partitioning", options.Mode)
+	defer log.Infof("Finished converting the blocks and edges tables to %s partitioning", options.Mode)
+
+	_, err = databaseTransaction.Exec("LOCK TABLE blocks, edges IN ACCESS EXCLUSIVE MODE")
+	if err != nil {
+		return err
+	}
+	switch options.Mode {
+	case PartitioningNative:
+		err = db.convertToNativePartitioning(databaseTransaction, options)
+	case PartitioningTimescaleDB:
+		err = db.convertToHypertables(databaseTransaction, options)
+	default:
+		return errors.Errorf("Unknown partitioning mode %s", options.Mode)
+	}
+	if err != nil {
+		return err
+	}
+	db.partitioning = options.Mode
+	return nil
+}
+
+// convertToNativePartitioning replaces the blocks and edges tables by tables partitioned by height
+// range, holding the same columns, data and indexes. Since the primary key and the unique constraints
+// of a partitioned table must include its partition column, the height is added to the primary keys
+// and to the unique index of the block hashes. That index no longer makes the block hashes unique:
+// InsertBlock is the only guard against a block stored again at another height.
+func (db *Database) convertToNativePartitioning(databaseTransaction *pg.Tx, options *PartitioningOptions) error {
+	var sequence struct {
+		Name string
+	}
+	_, err := databaseTransaction.QueryOne(&sequence, "SELECT pg_get_serial_sequence('blocks', 'id') AS name")
+	if err != nil {
+		return err
+	}
+	// The sequence would otherwise be dropped along with the unpartitioned table
+	_, err = databaseTransaction.Exec("ALTER SEQUENCE " + sequence.Name + " OWNED BY NONE")
+	if err != nil {
+		return err
+	}
+
+	highestHeight, err := highestPartitionedHeight(databaseTransaction)
+	if err != nil {
+		return err
+	}
+	for _, table := range partitionedTables {
+		statements := []string{
+			fmt.Sprintf("ALTER TABLE %[1]s RENAME TO %[1]s_unpartitioned", table.name),
+			fmt.Sprintf("CREATE TABLE %[1]s (LIKE %[1]s_unpartitioned INCLUDING DEFAULTS INCLUDING CONSTRAINTS) "+
+				"PARTITION BY RANGE (%[2]s)", table.name, table.column),
+			fmt.Sprintf("CREATE TABLE %[1]s_default PARTITION OF %[1]s DEFAULT", table.name),
+		}
+		for _, statement := range statements {
+			_, err = databaseTransaction.Exec(statement)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = createPartitions(databaseTransaction, table, highestHeight, options)
+		if err != nil {
+			return err
+		}
+		result, err := databaseTransaction.Exec(fmt.Sprintf("INSERT INTO %[1]s SELECT * FROM %[1]s_unpartitioned", table.name))
+		if err != nil {
+			return err
+		}
+		_, err = databaseTransaction.Exec(fmt.Sprintf("DROP TABLE %s_unpartitioned", table.name))
+		if err != nil {
+			return err
+		}
+		log.Infof("Moved %d rows to the partitioned %s table", result.RowsAffected(), table.name)
+	}
+
+	statements := []string{
+		"ALTER TABLE blocks ADD CONSTRAINT blocks_pkey PRIMARY KEY (id, height)",
+		"CREATE UNIQUE INDEX blocks_block_hash_height_key ON blocks (block_hash, height)",
+		"CREATE INDEX blocks_height_idx ON blocks (height DESC)",
+		"ALTER SEQUENCE " + sequence.Name + " OWNED BY blocks.id",
+		"ALTER TABLE edges ADD CONSTRAINT edges_pkey PRIMARY KEY (from_block_id, to_block_id, from_height)",
+		"CREATE INDEX edges_to_height_idx ON edges (to_height DESC)",
+		"CREATE INDEX edges_from_height_idx ON edges (from_height DESC)",
+	}
+	for _, statement := range statements {
+		_, err = databaseTransaction.Exec(statement)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// convertToHypertables turns the blocks and edges tables into TimescaleDB hypertables chunked by height range.
+// As with native partitioning, the height is added to the primary keys and to the unique index of the block hashes.
+func (db *Database) convertToHypertables(databaseTransaction *pg.Tx, options *PartitioningOptions) error {
+	statements := []string{
+		"CREATE EXTENSION IF NOT EXISTS timescaledb",
+		"ALTER TABLE blocks DROP CONSTRAINT blocks_block_hash_key",
+		"CREATE UNIQUE INDEX blocks_block_hash_height_key ON blocks (block_hash, height)",
+		"ALTER TABLE blocks DROP CONSTRAINT blocks_pkey, ADD CONSTRAINT blocks_pkey PRIMARY KEY (id, height)",
+		"ALTER TABLE edges DROP CONSTRAINT edges_pkey, ADD CONSTRAINT edges_pkey PRIMARY KEY (from_block_id, to_block_id, from_height)",
+	}
+	for _, statement := range statements {
+		_, err := databaseTransaction.Exec(statement)
+		if err != nil {
+			return err
+		}
+	}
+	for _, table := range partitionedTables {
+		_, err := databaseTransaction.Exec("SELECT create_hypertable(?::REGCLASS, ?::NAME, chunk_time_interval => ?::BIGINT, migrate_data => TRUE)",
+			table.name, table.column, options.PartitionSize)
+		if err != nil {
+			// enhanced error description
+			return errors.Wrapf(err, "Could not convert table %s to a hypertable", table.name)
+		}
+	}
+	return nil
+}
+
+// MaintainPartitions creates the partitions ahead of the highest block and detaches the partitions
+// older than the retained ones. In TimescaleDB mode, the chunks are created on demand and the old
+// chunks are dropped since they cannot be detached.
+// The references of the remaining blocks to the detached blocks are removed as when pruning.
+// Returns the number of created and detached partitions.
+func (db *Database) MaintainPartitions(databaseTransaction *pg.Tx, options *PartitioningOptions) (int, int, error) {
+	highestHeight, err := highestPartitionedHeight(databaseTransaction)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	createdCount := 0
+	detachedCount := 0
+	switch options.Mode {
+	case PartitioningNative:
+		for _, table := range partitionedTables {
+			created, err := createPartitions(databaseTransaction, table, highestHeight, options)
+			if err != nil {
+				// enhanced error description
+				return 0, 0, errors.Wrapf(err, "Could not create the partitions of table %s", table.name)
+			}
+			createdCount += created
+		}
+		detachedCount, err = db.detachPartitions(databaseTransaction, highestHeight, options)
+		if err != nil {
+			return 0, 0, err
+		}
+	case PartitioningTimescaleDB:
+		for _, table := range partitionedTables {
+			_, err = databaseTransaction.Exec("SELECT set_chunk_time_interval(?::REGCLASS, ?::BIGINT)", table.name, options.PartitionSize)
+			if err != nil {
+				return 0, 0, err
+			}
+		}
+		detachedCount, err = db.dropChunks(databaseTransaction, highestHeight, options)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return createdCount, detachedCount, nil
+}
+
+func highestPartitionedHeight(databaseTransaction *pg.Tx) (uint64, error) {
+	var result struct {
+		Height uint64
+	}
+	_, err := databaseTransaction.QueryOne(&result, "SELECT COALESCE(MAX(height), 0) AS height FROM blocks")
+	if err != nil {
+		return 0, err
+	}
+	return result.Height, nil
+}
+
+// createPartitions creates the partitions of `table` following the highest one, until
+// `options.PartitionsAhead` partitions lie above `highestHeight`.
+// The rows of the default partition falling into a new partition are moved to it.
+func createPartitions(databaseTransaction *pg.Tx, table partitionedTable, highestHeight uint64,
+	options *PartitioningOptions) (int, error) {
+
+	partitions, err := attachedPartitions(databaseTransaction, table)
+	if err != nil {
+		return 0, err
+	}
+	from := uint64(0)
+	if len(partitions) > 0 {
+		from = partitions[len(partitions)-1].to
+	}
+	limit := (highestHeight/options.PartitionSize + 1 + uint64(options.PartitionsAhead)) * options.PartitionSize
+
+	created := 0
+	for ; from < limit; from += options.PartitionSize {
+		to := from + options.PartitionSize
+		partition := fmt.Sprintf("%s_%d_%d", table.name, from, to)
+		statements := []string{
+			fmt.Sprintf("CREATE TABLE %s (LIKE %s INCLUDING DEFAULTS INCLUDING CONSTRAINTS)", partition, table.name),
+			fmt.Sprintf("WITH moved AS (DELETE FROM %[2]s_default WHERE %[3]s >= %[4]d AND %[3]s < %[5]d RETURNING *) "+
+				"INSERT INTO %[1]s SELECT * FROM moved", partition, table.name, table.column, from, to),
+			fmt.Sprintf("ALTER TABLE %s ATTACH PARTITION %s FOR VALUES FROM (%d) TO (%d)", table.name, partition, from, to),
+		}
+		for _, statement := range statements {
+			_, err = databaseTransaction.Exec(statement)
+			if err != nil {
+				return 0, err
+			}
+		}
+		log.Infof("Created partition %s", partition)
+		created++
+	}
+	return created, nil
+}
+
+var partitionBoundPattern = regexp.MustCompile(`FROM \('?(\d+)'?\) TO \('?(\d+)'?\)`)
+
+// attachedPartitions returns the range partitions of `table`, default partition excluded, ordered by height
+func attachedPartitions(databaseTransaction *pg.Tx, table partitionedTable) ([]heightRange, error) {
+	var results []struct {
+		Name  string
+		Bound string
+	}
+	_, err := databaseTransaction.Query(&results, `
+		SELECT c.relname AS name, pg_get_expr(c.relpartbound, c.oid) AS bound
+		FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = ?::REGCLASS`, table.name)
+	if err != nil {
+		return nil, err
+	}
+
+	partitions := make([]heightRange, 0, len(results))
+	for _, result := range results {
+		match := partitionBoundPattern.FindStringSubmatch(result.Bound)
+		if match == nil {
+			continue
+		}
+		from, err := strconv.ParseUint(match[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		to, err := strconv.ParseUint(match[2], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		partitions = append(partitions, heightRange{name: result.Name, from: from, to: to})
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i].from < partitions[j].from })
+	return partitions, nil
+}
+
+// retentionBoundary returns the height below which the partitions among `partitions`
+// are no longer retained, or false if all of them are retained
+func retentionBoundary(partitions []heightRange, highestHeight uint64, options *PartitioningOptions) (uint64, bool) {
+	if options.RetainedPartitions == 0 {
+		return 0, false
+	}
+	highestIndex := len(partitions) - 1
+	for highestIndex > 0 && partitions[highestIndex].from > highestHeight {
+		highestIndex--
+	}
+	oldestRetainedIndex := highestIndex - options.RetainedPartitions
+	if oldestRetainedIndex <= 0 {
+		return 0, false
+	}
+	return partitions[oldestRetainedIndex].from, true
+}
+
+// detachPartitions detaches the partitions of the blocks and edges tables lying below the retained ones.
+// The detached partitions are renamed after their detachment time so they can be archived or dropped.
+func (db *Database) detachPartitions(databaseTransaction *pg.Tx, highestHeight uint64, options *PartitioningOptions) (int, error) {
+	blockPartitions, err := attachedPartitions(databaseTransaction, partitionedTables[0])
+	if err != nil {
+		return 0, err
+	}
+	boundary, ok := retentionBoundary(blockPartitions, highestHeight, options)
+	if !ok {
+		return 0, nil
+	}
+
+	// The rows of the default partition are not detached
+	_, err = databaseTransaction.Exec(`
+		CREATE TEMPORARY TABLE pruned_blocks ON COMMIT DROP AS
+		SELECT id, height FROM blocks WHERE height < ? AND tableoid <> 'blocks_default'::REGCLASS`, boundary)
+	if err != nil {
+		return 0, err
+	}
+
+	suffix := time.Now().UTC().Format("20060102150405")
+	detached := 0
+	for _, table := range partitionedTables {
+		partitions, err := attachedPartitions(databaseTransaction, table)
+		if err != nil {
+			return 0, err
+		}
+		for _, partition := range partitions {
+			if partition.to > boundary {
+				break
+			}
+			statements := []string{
+				fmt.Sprintf("ALTER TABLE %s DETACH PARTITION %s", table.name, partition.name),
+				fmt.Sprintf("ALTER TABLE %[1]s RENAME TO %[1]s_detached_%[2]s", partition.name, suffix),
+			}
+			for _, statement := range statements {
+				_, err = databaseTransaction.Exec(statement)
+				if err != nil {
+					// enhanced error description
+					return 0, errors.Wrapf(err, "Could not detach partition %s", partition.name)
+				}
+			}
+			log.Infof("Detached partition %s as %s_detached_%s", partition.name, partition.name, suffix)
+			detached++
+		}
+	}
+	_, err = databaseTransaction.Exec("DELETE FROM edges WHERE from_block_id IN (SELECT id FROM pruned_blocks)")
+	if err != nil {
+		return 0, err
+	}
+
+	db.clearCache()
+	err = db.removeReferencesToPrunedBlocks(databaseTransaction)
+	if err != nil {
+		return 0, err
+	}
+	return detached, nil
+}
+
+// dropChunks drops the chunks of the blocks and edges hypertables lying below the retained ones
+func (db *Database) dropChunks(databaseTransaction *pg.Tx, highestHeight uint64, options *PartitioningOptions) (int, error) {
+	var results []struct {
+		ChunkName         string
+		RangeStartInteger uint64
+		RangeEndInteger   uint64
+	}
+	_, err := databaseTransaction.Query(&results, `
+		SELECT chunk_name, range_start_integer, range_end_integer
+		FROM timescaledb_information.chunks WHERE hypertable_name = 'blocks' ORDER BY range_start_integer`)
+	if err != nil {
+		return 0, err
+	}
+	chunks := make([]heightRange, len(results))
+	for i, result := range results {
+		chunks[i] = heightRange{name: result.ChunkName, from: result.RangeStartInteger, to: result.RangeEndInteger}
+	}
+	boundary, ok := retentionBoundary(chunks, highestHeight, options)
+	if !ok {
+		return 0, nil
+	}
+
+	_, err = databaseTransaction.Exec(`
+		CREATE TEMPORARY TABLE pruned_blocks ON COMMIT DROP AS
+		SELECT id, height FROM blocks WHERE height < ?`, boundary)
+	if err != nil {
+		return 0, err
+	}
+	dropped := 0
+	for _, table := range partitionedTables {
+		var droppedChunks []struct {
+			Name string
+		}
+		_, err = databaseTransaction.Query(&droppedChunks, "SELECT drop_chunks(?::REGCLASS, older_than => ?::BIGINT) AS name",
+			table.name, boundary)
+		if err != nil {
+			// enhanced error description
+			return 0, errors.Wrapf(err, "Could not drop the chunks of table %s", table.name)
+		}
+		for _, chunk := range droppedChunks {
+			log.Infof("Dropped chunk %s", chunk.Name)
+		}
+		dropped += len(droppedChunks)
+	}
+
+	db.clearCache()
+	err = db.removeReferencesToPrunedBlocks(databaseTransaction)
+	if err != nil {
+		return 0, err
+	}
+	return dropped, nil
+}
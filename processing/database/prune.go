@@ -0,0 +1,169 @@
+package database
+
+import (
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/tracing"
+)
+
+// PruneBlocksBelowDAAScore deletes the blocks having a DAA score lower than `daaScore` along with
+// their edges, and removes the references to them from the remaining blocks.
+// The height groups the deleted blocks belonged to are renumbered.
+// Returns the number of deleted blocks.
+func (db *Database) PruneBlocksBelowDAAScore(databaseTransaction *pg.Tx, daaScore uint64) (int, error) {
+	_, err := databaseTransaction.Exec(`
+		CREATE TEMPORARY TABLE pruned_blocks ON COMMIT DROP AS
+		SELECT id, height FROM blocks WHERE daa_score < ?`, daaScore)
+	if err != nil {
+		return 0, err
+	}
+	return db.deletePrunedBlocks(databaseTransaction)
+}
+
+// RetentionWindow defines the blocks kept by the retention policy. Only one of its fields is expected to be set.
+type RetentionWindow struct {
+	// Height keeps the blocks at most this many heights below the highest block
+	Height uint64
+	// DAAScore keeps the blocks at most this far in DAA score below the highest DAA score
+	DAAScore uint64
+	// Age keeps the blocks having a timestamp at most this old
+	Age time.Duration
+}
+
+// PruneBlocksOutsideWindow deletes at most `batchSize` of the lowest blocks lying outside `window`
+// along with their edges, as PruneBlocksBelowDAAScore does. The blocks having a DAA score of at least
+// `keptDAAScore` are always kept.
+// Returns the number of deleted blocks.
+func (db *Database) PruneBlocksOutsideWindow(databaseTransaction *pg.Tx, window *RetentionWindow,
+	keptDAAScore uint64, batchSize int) (int, error) {
+
+	defer tracing.CallerSpan(databaseTransaction.Context(), tracing.Count(batchSize)).End()
+
+	var condition string
+	var threshold interface{}
+	switch {
+	case window.Height > 0:
+		condition = "height + ? < (SELECT MAX(height) FROM blocks)"
+		threshold = window.Height
+	case window.DAAScore > 0:
+		condition = "daa_score + ? < (SELECT MAX(daa_score) FROM blocks)"
+		threshold = window.DAAScore
+	case window.Age > 0:
+		condition = "timestamp < ?"
+		threshold = time.Now().Add(-window.Age).UnixMilli()
+	default:
+		return 0, nil
+	}
+
+	_, err := databaseTransaction.Exec(`
+		CREATE TEMPORARY TABLE pruned_blocks ON COMMIT DROP AS
+		SELECT id, height FROM blocks WHERE `+condition+` AND daa_score < ?
+		ORDER BY height, id LIMIT ?`, threshold, keptDAAScore, batchSize)
+	if err != nil {
+		return 0, err
+	}
+	return db.deletePrunedBlocks(databaseTransaction)
+}
+
+// RebaseHeights shifts the heights of all the blocks, edges and height groups down
+// so the lowest height is 0. Returns the height that became 0.
+func (db *Database) RebaseHeights(databaseTransaction *pg.Tx) (uint64, error) {
+	var result struct {
+		Height uint64
+	}
+	_, err := databaseTransaction.QueryOne(&result, "SELECT COALESCE(MIN(height), 0) AS height FROM blocks")
+	if err != nil {
+		return 0, err
+	}
+	if result.Height == 0 {
+		return 0, nil
+	}
+
+	statements := []string{
+		"UPDATE blocks SET height = height - ?0",
+		"UPDATE edges SET from_height = from_height - ?0, to_height = to_height - ?0",
+		// Shifting the heights in place could transiently break the uniqueness of the heights
+		`WITH shifted AS (DELETE FROM height_groups RETURNING height, size)
+		INSERT INTO height_groups (height, size) SELECT height - ?0, size FROM shifted WHERE height >= ?0`,
+	}
+	for _, statement := range statements {
+		_, err = databaseTransaction.Exec(statement, result.Height)
+		if err != nil {
+			return 0, err
+		}
+	}
+	db.clearCache()
+	return result.Height, nil
+}
+
+// deletePrunedBlocks deletes the blocks listed in the temporary table pruned_blocks along with their
+// edges, and removes the references to them from the remaining blocks.
+// Returns the number of deleted blocks.
+func (db *Database) deletePrunedBlocks(databaseTransaction *pg.Tx) (int, error) {
+	_, err := databaseTransaction.Exec("DELETE FROM edges WHERE from_block_id IN (SELECT id FROM pruned_blocks)")
+	if err != nil {
+		return 0, err
+	}
+	result, err := databaseTransaction.Exec("DELETE FROM blocks WHERE id IN (SELECT id FROM pruned_blocks)")
+	if err != nil {
+		return 0, err
+	}
+	prunedBlockCount := result.RowsAffected()
+	db.clearCache()
+
+	err = db.removeReferencesToPrunedBlocks(databaseTransaction)
+	if err != nil {
+		return 0, err
+	}
+	return prunedBlockCount, nil
+}
+
+// removeReferencesToPrunedBlocks removes the edges to the blocks listed in the temporary table
+// pruned_blocks along with the references to them from the remaining blocks.
+// The height groups the pruned blocks belonged to are renumbered, or deleted when left empty.
+func (db *Database) removeReferencesToPrunedBlocks(databaseTransaction *pg.Tx) error {
+	_, err := databaseTransaction.Exec("DELETE FROM edges WHERE to_block_id IN (SELECT id FROM pruned_blocks)")
+	if err != nil {
+		return err
+	}
+	for _, column := range []string{"parent_ids", "merge_set_red_ids", "merge_set_blue_ids"} {
+		_, err = databaseTransaction.Exec(`
+			UPDATE blocks SET ?0 = COALESCE((
+				SELECT jsonb_agg(r.id::BIGINT) FROM jsonb_array_elements_text(blocks.?0) r(id)
+				WHERE r.id::BIGINT NOT IN (SELECT id FROM pruned_blocks)), '[]'::JSONB)
+			WHERE EXISTS (
+				SELECT 1 FROM jsonb_array_elements_text(blocks.?0) r(id) JOIN pruned_blocks pb ON pb.id = r.id::BIGINT)`,
+			pg.Ident(column))
+		if err != nil {
+			return err
+		}
+	}
+	_, err = databaseTransaction.Exec("UPDATE blocks SET selected_parent_id = NULL WHERE selected_parent_id IN (SELECT id FROM pruned_blocks)")
+	if err != nil {
+		return err
+	}
+
+	_, err = databaseTransaction.Exec(`
+		DELETE FROM height_groups
+		WHERE height IN (SELECT height FROM pruned_blocks) AND NOT EXISTS (SELECT 1 FROM blocks WHERE blocks.height = height_groups.height)`)
+	if err != nil {
+		return err
+	}
+	var partiallyPrunedHeights []struct {
+		Height uint64
+	}
+	_, err = databaseTransaction.Query(&partiallyPrunedHeights, `
+		SELECT DISTINCT height FROM pruned_blocks WHERE height IN (SELECT height FROM height_groups)`)
+	if err != nil {
+		return err
+	}
+	for _, partiallyPrunedHeight := range partiallyPrunedHeights {
+		err = db.RenumberHeightGroup(databaseTransaction, partiallyPrunedHeight.Height)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
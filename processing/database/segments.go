@@ -0,0 +1,28 @@
+package database
+
+import (
+	"github.com/go-pg/pg/v10"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/database/model"
+)
+
+// InsertSegment records the start of a new segment of the stored history
+func (db *Database) InsertSegment(databaseTransaction *pg.Tx, segment *model.Segment) error {
+	_, err := databaseTransaction.Model(segment).Insert()
+	return err
+}
+
+// MaxBlockHeight returns the height of the highest stored block.
+// Returns false if no block is stored.
+func (db *Database) MaxBlockHeight(databaseTransaction *pg.Tx) (uint64, bool, error) {
+	var result struct {
+		Height *uint64
+	}
+	_, err := databaseTransaction.QueryOne(&result, "SELECT MAX(height) AS height FROM blocks")
+	if err != nil {
+		return 0, false, err
+	}
+	if result.Height == nil {
+		return 0, false, nil
+	}
+	return *result.Height, true, nil
+}
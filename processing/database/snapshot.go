@@ -0,0 +1,274 @@
+package database
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/tracing"
+	"github.com/pkg/errors"
+)
+
+// snapshotTables lists the tables stored in a snapshot, in import order
+var snapshotTables = []string{"app_config", "height_groups", "blocks", "edges", "segments"}
+
+const snapshotEntrySuffix = ".copy"
+
+// snapshotHeaderEntry is the name of the first entry of a snapshot, holding its SnapshotHeader
+const snapshotHeaderEntry = "snapshot.json"
+
+// SnapshotFormatVersion is the version of the snapshot format written by Export.
+// Import reads no other version.
+const SnapshotFormatVersion = 1
+
+// SnapshotHeader describes the content of a snapshot
+type SnapshotHeader struct {
+	FormatVersion uint32 `json:"formatVersion"`
+	// Network is the network of the exported database, as stored in its app config
+	Network string `json:"network"`
+	// SchemaVersion is the migration version of the exported database. The rows of
+	// a snapshot can only be imported into a database having the same schema.
+	SchemaVersion uint  `json:"schemaVersion"`
+	CreatedAt     int64 `json:"createdAt"`
+}
+
+// Export writes the content of the tables as a gzipped tar archive to `writer`.
+// The archive starts with a SnapshotHeader entry, followed by one entry per table
+// in the PostgreSQL COPY text format.
+// Export must be the first call in `databaseTransaction`.
+func (db *Database) Export(databaseTransaction *pg.Tx, writer io.Writer) (*SnapshotHeader, error) {
+	defer tracing.CallerSpan(databaseTransaction.Context()).End()
+
+	// All the tables are read from the same database snapshot
+	_, err := databaseTransaction.Exec("SET TRANSACTION ISOLATION LEVEL REPEATABLE READ, READ ONLY")
+	if err != nil {
+		return nil, err
+	}
+
+	appConfig, err := db.GetAppConfig(databaseTransaction)
+	if err != nil {
+		// enhanced error description
+		return nil, errors.Wrapf(err, "Could not get the network of the database")
+	}
+	schemaVersion, err := getSchemaVersion(databaseTransaction)
+	if err != nil {
+		return nil, err
+	}
+	header := &SnapshotHeader{
+		FormatVersion: SnapshotFormatVersion,
+		Network:       appConfig.Network,
+		SchemaVersion: schemaVersion,
+		CreatedAt:     time.Now().UnixMilli(),
+	}
+
+	gzipWriter := gzip.NewWriter(writer)
+	tarWriter := tar.NewWriter(gzipWriter)
+	err = writeSnapshotHeader(tarWriter, header)
+	if err != nil {
+		return nil, err
+	}
+	for _, table := range snapshotTables {
+		err := exportTable(databaseTransaction, tarWriter, table)
+		if err != nil {
+			// enhanced error description
+			return nil, errors.Wrapf(err, "Could not export table %s", table)
+		}
+	}
+	err = tarWriter.Close()
+	if err != nil {
+		return nil, err
+	}
+	return header, gzipWriter.Close()
+}
+
+// getSchemaVersion returns the migration version of the database, as recorded by the migrator
+func getSchemaVersion(databaseTransaction *pg.Tx) (uint, error) {
+	var migration struct {
+		Version uint
+		Dirty   bool
+	}
+	_, err := databaseTransaction.QueryOne(&migration, "SELECT version, dirty FROM schema_migrations")
+	if err != nil {
+		// enhanced error description
+		return 0, errors.Wrapf(err, "Could not get the schema version of the database")
+	}
+	if migration.Dirty {
+		return 0, errors.Errorf("Database is dirty (version %d)", migration.Version)
+	}
+	return migration.Version, nil
+}
+
+func writeSnapshotHeader(tarWriter *tar.Writer, header *SnapshotHeader) error {
+	content, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	err = tarWriter.WriteHeader(&tar.Header{
+		Name: snapshotHeaderEntry,
+		Mode: 0600,
+		Size: int64(len(content)),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tarWriter.Write(content)
+	return err
+}
+
+// readSnapshotHeader reads the SnapshotHeader entry starting the snapshot read by `tarReader`
+func readSnapshotHeader(tarReader *tar.Reader) (*SnapshotHeader, error) {
+	entry, err := tarReader.Next()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not read the snapshot")
+	}
+	if entry.Name != snapshotHeaderEntry {
+		return nil, errors.Errorf("The snapshot has no header, it was either written by a former version or is not a snapshot")
+	}
+	header := new(SnapshotHeader)
+	err = json.NewDecoder(tarReader).Decode(header)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not read the snapshot header")
+	}
+	return header, nil
+}
+
+// validate checks that the snapshot described by `header` can be imported into a database
+// of `network` having the schema version `schemaVersion`
+func (header *SnapshotHeader) validate(network string, schemaVersion uint) error {
+	if header.FormatVersion != SnapshotFormatVersion {
+		return errors.Errorf("Unsupported snapshot format version %d, expecting %d",
+			header.FormatVersion, SnapshotFormatVersion)
+	}
+	if header.Network != network {
+		return errors.Errorf("The snapshot holds the network %s, expecting %s", header.Network, network)
+	}
+	if header.SchemaVersion != schemaVersion {
+		return errors.Errorf("The snapshot has the schema version %d while the database has version %d, "+
+			"migrate the database to version %d first", header.SchemaVersion, schemaVersion, header.SchemaVersion)
+	}
+	return nil
+}
+
+// exportTable copies `table` to a temporary file first since the size of a tar entry
+// must be known before writing it
+func exportTable(databaseTransaction *pg.Tx, tarWriter *tar.Writer, table string) error {
+	file, err := os.CreateTemp("", "kgi-export-"+table)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	// Copying from a query also reads the partitions of a partitioned table
+	_, err = databaseTransaction.CopyTo(file, "COPY (SELECT * FROM "+table+") TO STDOUT")
+	if err != nil {
+		return err
+	}
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	err = tarWriter.WriteHeader(&tar.Header{
+		Name: table + snapshotEntrySuffix,
+		Mode: 0600,
+		Size: size,
+	})
+	if err != nil {
+		return err
+	}
+	copied, err := io.Copy(tarWriter, file)
+	if err != nil {
+		return err
+	}
+	log.Infof("Exported table %s (%d bytes)", table, copied)
+	return nil
+}
+
+// Import replaces the content of the tables with the snapshot read from `reader`,
+// as written by Export. The snapshot must be of `network` and have the schema version
+// of the database.
+func (db *Database) Import(databaseTransaction *pg.Tx, reader io.Reader, network string) (*SnapshotHeader, error) {
+	defer tracing.CallerSpan(databaseTransaction.Context()).End()
+
+	gzipReader, err := gzip.NewReader(reader)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not read the snapshot")
+	}
+	defer gzipReader.Close()
+
+	tarReader := tar.NewReader(gzipReader)
+	header, err := readSnapshotHeader(tarReader)
+	if err != nil {
+		return nil, err
+	}
+	schemaVersion, err := getSchemaVersion(databaseTransaction)
+	if err != nil {
+		return nil, err
+	}
+	err = header.validate(network, schemaVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	db.clearCache()
+	_, err = databaseTransaction.Exec("TRUNCATE TABLE " + strings.Join(snapshotTables, ", "))
+	if err != nil {
+		return nil, err
+	}
+
+	importedTables := make(map[string]struct{})
+	for {
+		entry, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not read the snapshot")
+		}
+		table := strings.TrimSuffix(entry.Name, snapshotEntrySuffix)
+		if !isSnapshotTable(table) || table == entry.Name {
+			return nil, errors.Errorf("Unexpected snapshot entry %s", entry.Name)
+		}
+		_, err = databaseTransaction.CopyFrom(tarReader, "COPY "+table+" FROM STDIN")
+		if err != nil {
+			// enhanced error description
+			return nil, errors.Wrapf(err, "Could not import table %s", table)
+		}
+		importedTables[table] = struct{}{}
+		log.Infof("Imported table %s", table)
+	}
+	for _, table := range snapshotTables {
+		if _, ok := importedTables[table]; !ok {
+			return nil, errors.Errorf("The snapshot misses table %s", table)
+		}
+	}
+
+	// Rows inserted after the import must not reuse the imported IDs
+	for _, table := range []string{"blocks", "segments"} {
+		_, err = databaseTransaction.Exec("SELECT setval(pg_get_serial_sequence(?0, 'id'), COALESCE(MAX(id), 0) + 1, false) FROM ?1",
+			table, pg.Ident(table))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return header, nil
+}
+
+func isSnapshotTable(table string) bool {
+	for _, snapshotTable := range snapshotTables {
+		if table == snapshotTable {
+			return true
+		}
+	}
+	return false
+}
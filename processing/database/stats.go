@@ -0,0 +1,46 @@
+package database
+
+import (
+	"github.com/go-pg/pg/v10"
+)
+
+// Stats summarizes the content of the database
+type Stats struct {
+	BlockCount       uint64
+	ChainBlockCount  uint64
+	BlueBlockCount   uint64
+	RedBlockCount    uint64
+	EdgeCount        uint64
+	HeightGroupCount uint64
+	SegmentCount     uint64
+	GapCount         uint64
+	MinHeight        uint64
+	MaxHeight        uint64
+	MinDAAScore      uint64
+	MaxDAAScore      uint64
+	DatabaseSize     string
+}
+
+// GetStats returns a summary of the content of the database
+func (db *Database) GetStats(databaseTransaction *pg.Tx) (*Stats, error) {
+	stats := &Stats{}
+	_, err := databaseTransaction.QueryOne(stats, `
+		SELECT COUNT(*) AS block_count,
+			COUNT(*) FILTER (WHERE is_in_virtual_selected_parent_chain) AS chain_block_count,
+			COUNT(*) FILTER (WHERE color = 'blue') AS blue_block_count,
+			COUNT(*) FILTER (WHERE color = 'red') AS red_block_count,
+			COALESCE(MIN(height), 0) AS min_height,
+			COALESCE(MAX(height), 0) AS max_height,
+			COALESCE(MIN(daa_score), 0) AS min_daa_score,
+			COALESCE(MAX(daa_score), 0) AS max_daa_score,
+			(SELECT COUNT(*) FROM edges) AS edge_count,
+			(SELECT COUNT(*) FROM height_groups) AS height_group_count,
+			(SELECT COUNT(*) FROM segments) AS segment_count,
+			(SELECT COUNT(*) FROM segments WHERE NOT is_connected) AS gap_count,
+			pg_size_pretty(pg_database_size(current_database())) AS database_size
+		FROM blocks`)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
@@ -0,0 +1,312 @@
+package database
+
+import (
+	"context"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/database/model"
+	"github.com/kaspanet/kaspad/domain/consensus/model/externalapi"
+)
+
+// Transaction is a transaction of a Storage. It is opaque to the callers, which only hand it
+// back to the methods of the Storage that began it.
+type Transaction interface {
+	// Context returns the context the transaction is bound to
+	Context() context.Context
+}
+
+// Storage stores the blocks, edges, height groups, segments and app config the processing
+// maintains. Every method but RunInTransaction and Ping runs within a transaction began
+// by RunInTransaction, and has the semantics of the Database method of the same name.
+type Storage interface {
+	RunInTransaction(ctx context.Context, transactionFunction func(Transaction) error) error
+	Ping() error
+	LoadCache(databaseTransaction Transaction, minHeight uint64) error
+	Clear(databaseTransaction Transaction) error
+
+	InsertBlock(databaseTransaction Transaction, blockHash *externalapi.DomainHash, block *model.Block) error
+	GetBlock(databaseTransaction Transaction, id uint64) (*model.Block, error)
+	DoesBlockExist(databaseTransaction Transaction, blockHash *externalapi.DomainHash) (bool, error)
+	ExistingBlockIDs(databaseTransaction Transaction, blockIDs []uint64) ([]uint64, error)
+	BlockIDByHash(databaseTransaction Transaction, blockHash *externalapi.DomainHash) (uint64, error)
+	BlockIDsByHashes(databaseTransaction Transaction, blockHashes []*externalapi.DomainHash) ([]uint64, error)
+	BlockIDsAndHeightsByHashes(databaseTransaction Transaction, blockHashes []*externalapi.DomainHash) ([]uint64, []uint64, error)
+	FindLatestStoredBlockIndex(databaseTransaction Transaction, blockHashes []*externalapi.DomainHash) (int, error)
+	BlockIDByDAAScore(databaseTransaction Transaction, blockDAAScore uint64) (uint64, error)
+	BlockCountAtDAAScore(databaseTransaction Transaction, blockDAAScore uint64) (uint32, error)
+	BlockColors(databaseTransaction Transaction, blockIDs []uint64) (map[uint64]string, error)
+	HighestBlockInVirtualSelectedParentChain(databaseTransaction Transaction) (*model.Block, error)
+	BlockHashesInVirtualSelectedParentChain(databaseTransaction Transaction, minDAAScore uint64) ([]string, error)
+	ChildBlocks(databaseTransaction Transaction, blockID uint64) ([]BlockReference, error)
+	ChildrenMissingParent(databaseTransaction Transaction, parentID uint64, childrenHashes []string) ([]BlockReference, error)
+
+	UpdateBlockSelectedParent(databaseTransaction Transaction, blockID uint64, selectedParentID uint64) error
+	UpdateBlockMergeSet(databaseTransaction Transaction, blockID uint64, mergeSetRedIDs []uint64, mergeSetBlueIDs []uint64) error
+	UpdateBlockIsInVirtualSelectedParentChain(databaseTransaction Transaction, blockIDsToIsInVirtualSelectedParentChain map[uint64]bool) error
+	UpdateBlockColors(databaseTransaction Transaction, blockIDsToColors map[uint64]string) error
+	UpdateBlockDAAScores(databaseTransaction Transaction, blockIDsToDAAScores map[uint64]uint64) error
+	UpdateBlockParents(databaseTransaction Transaction, blockID uint64, blockHash *externalapi.DomainHash,
+		parentIDs []uint64, height uint64, heightGroupIndex uint32) error
+	AddBlockParent(databaseTransaction Transaction, blockID uint64, parentID uint64) error
+
+	BlockHeight(databaseTransaction Transaction, blockID uint64) (uint64, error)
+	BlockHeightByHash(databaseTransaction Transaction, blockHash *externalapi.DomainHash) (uint64, error)
+	BlockHeightGroupIndex(databaseTransaction Transaction, blockID uint64) (uint32, error)
+	HighestBlockHeight(databaseTransaction Transaction, blockIDs []uint64) (uint64, error)
+	MaxBlockHeight(databaseTransaction Transaction) (uint64, bool, error)
+	UpdateBlockHeight(databaseTransaction Transaction, blockID uint64, blockHash *externalapi.DomainHash,
+		height uint64, heightGroupIndex uint32) error
+	HeightGroupSize(databaseTransaction Transaction, height uint64) (uint32, error)
+	InsertOrUpdateHeightGroup(databaseTransaction Transaction, heightGroup *model.HeightGroup) error
+	RenumberHeightGroup(databaseTransaction Transaction, height uint64) error
+	RebaseHeights(databaseTransaction Transaction) (uint64, error)
+
+	InsertEdge(databaseTransaction Transaction, edge *model.Edge) error
+	DeleteEdgesFromBlock(databaseTransaction Transaction, blockID uint64) error
+	RefreshEdgesOfBlock(databaseTransaction Transaction, blockID uint64) error
+
+	BlocksWithMismatchedEdges(databaseTransaction Transaction) ([]BlockReference, error)
+	BlocksWithUnresolvedParents(databaseTransaction Transaction) ([]BlockReference, error)
+	BlocksWithWrongHeight(databaseTransaction Transaction) ([]BlockReference, error)
+	BlocksWithoutSelectedParent(databaseTransaction Transaction, pruningPointID uint64) ([]BlockReference, error)
+	BlocksWithUnresolvedMergeSet(databaseTransaction Transaction) ([]BlockReference, error)
+	HeightGroupsWithWrongSize(databaseTransaction Transaction) ([]HeightGroupViolation, error)
+
+	PruneBlocksOutsideWindow(databaseTransaction Transaction, window *RetentionWindow, keptDAAScore uint64, batchSize int) (int, error)
+	SetUpPartitioning(databaseTransaction Transaction, options *PartitioningOptions) error
+	MaintainPartitions(databaseTransaction Transaction, options *PartitioningOptions) (int, int, error)
+	InsertSegment(databaseTransaction Transaction, segment *model.Segment) error
+
+	GetAppConfig(databaseTransaction Transaction) (*model.AppConfig, error)
+	StoreAppConfig(databaseTransaction Transaction, appConfig *model.AppConfig) error
+}
+
+// Storage returns the database as a Storage, whose transactions are go-pg transactions
+func (db *Database) Storage() Storage {
+	return &postgresStorage{database: db}
+}
+
+// postgresStorage forwards the methods of Storage to a Database
+type postgresStorage struct {
+	database *Database
+}
+
+func (s *postgresStorage) RunInTransaction(ctx context.Context, transactionFunction func(Transaction) error) error {
+	return s.database.RunInTransaction(ctx, func(databaseTransaction *pg.Tx) error {
+		return transactionFunction(databaseTransaction)
+	})
+}
+
+func (s *postgresStorage) Ping() error {
+	return s.database.Ping()
+}
+
+func (s *postgresStorage) LoadCache(databaseTransaction Transaction, minHeight uint64) error {
+	return s.database.LoadCache(databaseTransaction.(*pg.Tx), minHeight)
+}
+
+func (s *postgresStorage) Clear(databaseTransaction Transaction) error {
+	return s.database.Clear(databaseTransaction.(*pg.Tx))
+}
+
+func (s *postgresStorage) InsertBlock(databaseTransaction Transaction, blockHash *externalapi.DomainHash, block *model.Block) error {
+	return s.database.InsertBlock(databaseTransaction.(*pg.Tx), blockHash, block)
+}
+
+func (s *postgresStorage) GetBlock(databaseTransaction Transaction, id uint64) (*model.Block, error) {
+	return s.database.GetBlock(databaseTransaction.(*pg.Tx), id)
+}
+
+func (s *postgresStorage) DoesBlockExist(databaseTransaction Transaction, blockHash *externalapi.DomainHash) (bool, error) {
+	return s.database.DoesBlockExist(databaseTransaction.(*pg.Tx), blockHash)
+}
+
+func (s *postgresStorage) ExistingBlockIDs(databaseTransaction Transaction, blockIDs []uint64) ([]uint64, error) {
+	return s.database.ExistingBlockIDs(databaseTransaction.(*pg.Tx), blockIDs)
+}
+
+func (s *postgresStorage) BlockIDByHash(databaseTransaction Transaction, blockHash *externalapi.DomainHash) (uint64, error) {
+	return s.database.BlockIDByHash(databaseTransaction.(*pg.Tx), blockHash)
+}
+
+func (s *postgresStorage) BlockIDsByHashes(databaseTransaction Transaction, blockHashes []*externalapi.DomainHash) ([]uint64, error) {
+	return s.database.BlockIDsByHashes(databaseTransaction.(*pg.Tx), blockHashes)
+}
+
+func (s *postgresStorage) BlockIDsAndHeightsByHashes(databaseTransaction Transaction,
+	blockHashes []*externalapi.DomainHash) ([]uint64, []uint64, error) {
+
+	return s.database.BlockIDsAndHeightsByHashes(databaseTransaction.(*pg.Tx), blockHashes)
+}
+
+func (s *postgresStorage) FindLatestStoredBlockIndex(databaseTransaction Transaction, blockHashes []*externalapi.DomainHash) (int, error) {
+	return s.database.FindLatestStoredBlockIndex(databaseTransaction.(*pg.Tx), blockHashes)
+}
+
+func (s *postgresStorage) BlockIDByDAAScore(databaseTransaction Transaction, blockDAAScore uint64) (uint64, error) {
+	return s.database.BlockIDByDAAScore(databaseTransaction.(*pg.Tx), blockDAAScore)
+}
+
+func (s *postgresStorage) BlockCountAtDAAScore(databaseTransaction Transaction, blockDAAScore uint64) (uint32, error) {
+	return s.database.BlockCountAtDAAScore(databaseTransaction.(*pg.Tx), blockDAAScore)
+}
+
+func (s *postgresStorage) BlockColors(databaseTransaction Transaction, blockIDs []uint64) (map[uint64]string, error) {
+	return s.database.BlockColors(databaseTransaction.(*pg.Tx), blockIDs)
+}
+
+func (s *postgresStorage) HighestBlockInVirtualSelectedParentChain(databaseTransaction Transaction) (*model.Block, error) {
+	return s.database.HighestBlockInVirtualSelectedParentChain(databaseTransaction.(*pg.Tx))
+}
+
+func (s *postgresStorage) BlockHashesInVirtualSelectedParentChain(databaseTransaction Transaction, minDAAScore uint64) ([]string, error) {
+	return s.database.BlockHashesInVirtualSelectedParentChain(databaseTransaction.(*pg.Tx), minDAAScore)
+}
+
+func (s *postgresStorage) ChildBlocks(databaseTransaction Transaction, blockID uint64) ([]BlockReference, error) {
+	return s.database.ChildBlocks(databaseTransaction.(*pg.Tx), blockID)
+}
+
+func (s *postgresStorage) ChildrenMissingParent(databaseTransaction Transaction, parentID uint64,
+	childrenHashes []string) ([]BlockReference, error) {
+
+	return s.database.ChildrenMissingParent(databaseTransaction.(*pg.Tx), parentID, childrenHashes)
+}
+
+func (s *postgresStorage) UpdateBlockSelectedParent(databaseTransaction Transaction, blockID uint64, selectedParentID uint64) error {
+	return s.database.UpdateBlockSelectedParent(databaseTransaction.(*pg.Tx), blockID, selectedParentID)
+}
+
+func (s *postgresStorage) UpdateBlockMergeSet(databaseTransaction Transaction, blockID uint64,
+	mergeSetRedIDs []uint64, mergeSetBlueIDs []uint64) error {
+
+	return s.database.UpdateBlockMergeSet(databaseTransaction.(*pg.Tx), blockID, mergeSetRedIDs, mergeSetBlueIDs)
+}
+
+func (s *postgresStorage) UpdateBlockIsInVirtualSelectedParentChain(databaseTransaction Transaction,
+	blockIDsToIsInVirtualSelectedParentChain map[uint64]bool) error {
+
+	return s.database.UpdateBlockIsInVirtualSelectedParentChain(databaseTransaction.(*pg.Tx), blockIDsToIsInVirtualSelectedParentChain)
+}
+
+func (s *postgresStorage) UpdateBlockColors(databaseTransaction Transaction, blockIDsToColors map[uint64]string) error {
+	return s.database.UpdateBlockColors(databaseTransaction.(*pg.Tx), blockIDsToColors)
+}
+
+func (s *postgresStorage) UpdateBlockDAAScores(databaseTransaction Transaction, blockIDsToDAAScores map[uint64]uint64) error {
+	return s.database.UpdateBlockDAAScores(databaseTransaction.(*pg.Tx), blockIDsToDAAScores)
+}
+
+func (s *postgresStorage) UpdateBlockParents(databaseTransaction Transaction, blockID uint64, blockHash *externalapi.DomainHash,
+	parentIDs []uint64, height uint64, heightGroupIndex uint32) error {
+
+	return s.database.UpdateBlockParents(databaseTransaction.(*pg.Tx), blockID, blockHash, parentIDs, height, heightGroupIndex)
+}
+
+func (s *postgresStorage) AddBlockParent(databaseTransaction Transaction, blockID uint64, parentID uint64) error {
+	return s.database.AddBlockParent(databaseTransaction.(*pg.Tx), blockID, parentID)
+}
+
+func (s *postgresStorage) BlockHeight(databaseTransaction Transaction, blockID uint64) (uint64, error) {
+	return s.database.BlockHeight(databaseTransaction.(*pg.Tx), blockID)
+}
+
+func (s *postgresStorage) BlockHeightByHash(databaseTransaction Transaction, blockHash *externalapi.DomainHash) (uint64, error) {
+	return s.database.BlockHeightByHash(databaseTransaction.(*pg.Tx), blockHash)
+}
+
+func (s *postgresStorage) BlockHeightGroupIndex(databaseTransaction Transaction, blockID uint64) (uint32, error) {
+	return s.database.BlockHeightGroupIndex(databaseTransaction.(*pg.Tx), blockID)
+}
+
+func (s *postgresStorage) HighestBlockHeight(databaseTransaction Transaction, blockIDs []uint64) (uint64, error) {
+	return s.database.HighestBlockHeight(databaseTransaction.(*pg.Tx), blockIDs)
+}
+
+func (s *postgresStorage) MaxBlockHeight(databaseTransaction Transaction) (uint64, bool, error) {
+	return s.database.MaxBlockHeight(databaseTransaction.(*pg.Tx))
+}
+
+func (s *postgresStorage) UpdateBlockHeight(databaseTransaction Transaction, blockID uint64, blockHash *externalapi.DomainHash,
+	height uint64, heightGroupIndex uint32) error {
+
+	return s.database.UpdateBlockHeight(databaseTransaction.(*pg.Tx), blockID, blockHash, height, heightGroupIndex)
+}
+
+func (s *postgresStorage) HeightGroupSize(databaseTransaction Transaction, height uint64) (uint32, error) {
+	return s.database.HeightGroupSize(databaseTransaction.(*pg.Tx), height)
+}
+
+func (s *postgresStorage) InsertOrUpdateHeightGroup(databaseTransaction Transaction, heightGroup *model.HeightGroup) error {
+	return s.database.InsertOrUpdateHeightGroup(databaseTransaction.(*pg.Tx), heightGroup)
+}
+
+func (s *postgresStorage) RenumberHeightGroup(databaseTransaction Transaction, height uint64) error {
+	return s.database.RenumberHeightGroup(databaseTransaction.(*pg.Tx), height)
+}
+
+func (s *postgresStorage) RebaseHeights(databaseTransaction Transaction) (uint64, error) {
+	return s.database.RebaseHeights(databaseTransaction.(*pg.Tx))
+}
+
+func (s *postgresStorage) InsertEdge(databaseTransaction Transaction, edge *model.Edge) error {
+	return s.database.InsertEdge(databaseTransaction.(*pg.Tx), edge)
+}
+
+func (s *postgresStorage) DeleteEdgesFromBlock(databaseTransaction Transaction, blockID uint64) error {
+	return s.database.DeleteEdgesFromBlock(databaseTransaction.(*pg.Tx), blockID)
+}
+
+func (s *postgresStorage) RefreshEdgesOfBlock(databaseTransaction Transaction, blockID uint64) error {
+	return s.database.RefreshEdgesOfBlock(databaseTransaction.(*pg.Tx), blockID)
+}
+
+func (s *postgresStorage) BlocksWithMismatchedEdges(databaseTransaction Transaction) ([]BlockReference, error) {
+	return s.database.BlocksWithMismatchedEdges(databaseTransaction.(*pg.Tx))
+}
+
+func (s *postgresStorage) BlocksWithUnresolvedParents(databaseTransaction Transaction) ([]BlockReference, error) {
+	return s.database.BlocksWithUnresolvedParents(databaseTransaction.(*pg.Tx))
+}
+
+func (s *postgresStorage) BlocksWithWrongHeight(databaseTransaction Transaction) ([]BlockReference, error) {
+	return s.database.BlocksWithWrongHeight(databaseTransaction.(*pg.Tx))
+}
+
+func (s *postgresStorage) BlocksWithoutSelectedParent(databaseTransaction Transaction, pruningPointID uint64) ([]BlockReference, error) {
+	return s.database.BlocksWithoutSelectedParent(databaseTransaction.(*pg.Tx), pruningPointID)
+}
+
+func (s *postgresStorage) BlocksWithUnresolvedMergeSet(databaseTransaction Transaction) ([]BlockReference, error) {
+	return s.database.BlocksWithUnresolvedMergeSet(databaseTransaction.(*pg.Tx))
+}
+
+func (s *postgresStorage) HeightGroupsWithWrongSize(databaseTransaction Transaction) ([]HeightGroupViolation, error) {
+	return s.database.HeightGroupsWithWrongSize(databaseTransaction.(*pg.Tx))
+}
+
+func (s *postgresStorage) PruneBlocksOutsideWindow(databaseTransaction Transaction, window *RetentionWindow,
+	keptDAAScore uint64, batchSize int) (int, error) {
+
+	return s.database.PruneBlocksOutsideWindow(databaseTransaction.(*pg.Tx), window, keptDAAScore, batchSize)
+}
+
+func (s *postgresStorage) SetUpPartitioning(databaseTransaction Transaction, options *PartitioningOptions) error {
+	return s.database.SetUpPartitioning(databaseTransaction.(*pg.Tx), options)
+}
+
+func (s *postgresStorage) MaintainPartitions(databaseTransaction Transaction, options *PartitioningOptions) (int, int, error) {
+	return s.database.MaintainPartitions(databaseTransaction.(*pg.Tx), options)
+}
+
+func (s *postgresStorage) InsertSegment(databaseTransaction Transaction, segment *model.Segment) error {
+	return s.database.InsertSegment(databaseTransaction.(*pg.Tx), segment)
+}
+
+func (s *postgresStorage) GetAppConfig(databaseTransaction Transaction) (*model.AppConfig, error) {
+	return s.database.GetAppConfig(databaseTransaction.(*pg.Tx))
+}
+
+func (s *postgresStorage) StoreAppConfig(databaseTransaction Transaction, appConfig *model.AppConfig) error {
+	return s.database.StoreAppConfig(databaseTransaction.(*pg.Tx), appConfig)
+}
@@ -0,0 +1,322 @@
+package graphexport
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/database/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	FormatDOT     = "dot"
+	FormatGEXF    = "gexf"
+	FormatGraphML = "graphml"
+)
+
+// Formats lists the supported export formats
+var Formats = []string{FormatDOT, FormatGEXF, FormatGraphML}
+
+// IsFormat returns whether `format` is one of Formats
+func IsFormat(format string) bool {
+	for _, supportedFormat := range Formats {
+		if format == supportedFormat {
+			return true
+		}
+	}
+	return false
+}
+
+// ContentType returns the MIME type of `format`
+func ContentType(format string) string {
+	switch format {
+	case FormatDOT:
+		return "text/vnd.graphviz"
+	default:
+		return "application/xml"
+	}
+}
+
+// Write writes `graph` to `writer` in `format`
+func Write(writer io.Writer, format string, graph *Graph) error {
+	switch format {
+	case FormatDOT:
+		return writeDOT(writer, graph)
+	case FormatGEXF:
+		return writeGEXF(writer, graph)
+	case FormatGraphML:
+		return writeGraphML(writer, graph)
+	default:
+		return errors.Errorf("Unknown graph format %s, expecting one of %s", format, strings.Join(Formats, ", "))
+	}
+}
+
+// displayColors maps the block colors to the colors the web UI displays
+var displayColors = map[string]struct{ r, g, b uint8 }{
+	model.ColorGray: {0x99, 0x99, 0x99},
+	model.ColorRed:  {0xb3, 0x4d, 0x50},
+	model.ColorBlue: {0x55, 0x81, 0xaa},
+}
+
+func displayColor(color string) (uint8, uint8, uint8) {
+	displayColor, ok := displayColors[color]
+	if !ok {
+		displayColor = displayColors[model.ColorGray]
+	}
+	return displayColor.r, displayColor.g, displayColor.b
+}
+
+// writeDOT writes `graph` as a GraphViz digraph. The parents are drawn below their children,
+// the chain blocks with a thicker outline and the selected-parent edges in bold.
+func writeDOT(writer io.Writer, graph *Graph) error {
+	bufferedWriter := bufio.NewWriter(writer)
+	fmt.Fprintln(bufferedWriter, "digraph DAG {")
+	fmt.Fprintln(bufferedWriter, "\trankdir=BT;")
+	fmt.Fprintln(bufferedWriter, "\tnode [shape=box, style=filled, fontcolor=white];")
+	for _, node := range graph.Nodes {
+		r, g, b := displayColor(node.Color)
+		fmt.Fprintf(bufferedWriter, "\t%s [label=%s, fillcolor=\"#%02x%02x%02x\"", dotID(node.Hash), dotID(shortHash(node.Hash)), r, g, b)
+		if node.IsInVirtualSelectedParentChain {
+			fmt.Fprint(bufferedWriter, ", penwidth=3")
+		}
+		for i, value := range node.attributeValues() {
+			fmt.Fprintf(bufferedWriter, ", %s=%s", dotAttributeName(nodeAttributes[i].name), dotID(value))
+		}
+		fmt.Fprintln(bufferedWriter, "];")
+	}
+	for _, edge := range graph.Edges {
+		fmt.Fprintf(bufferedWriter, "\t%s -> %s [type=%s", dotID(edge.From), dotID(edge.To), dotID(edge.Type))
+		if edge.Type == EdgeTypeSelectedParent {
+			fmt.Fprint(bufferedWriter, ", style=bold, color=\"#2b8a3e\"")
+		}
+		fmt.Fprintln(bufferedWriter, "];")
+	}
+	fmt.Fprintln(bufferedWriter, "}")
+	return bufferedWriter.Flush()
+}
+
+// dotAttributeNames renames the node attributes clashing with the GraphViz ones
+var dotAttributeNames = map[string]string{
+	"height": "blockHeight",
+	"color":  "blockColor",
+}
+
+func dotAttributeName(name string) string {
+	if dotAttributeName, ok := dotAttributeNames[name]; ok {
+		return dotAttributeName
+	}
+	return name
+}
+
+func dotID(value string) string {
+	return strconv.Quote(value)
+}
+
+func shortHash(hash string) string {
+	const shortHashLength = 8
+	if len(hash) <= shortHashLength {
+		return hash
+	}
+	return hash[:shortHashLength]
+}
+
+type gexfDocument struct {
+	XMLName xml.Name  `xml:"gexf"`
+	XMLNS   string    `xml:"xmlns,attr"`
+	VizNS   string    `xml:"xmlns:viz,attr"`
+	Version string    `xml:"version,attr"`
+	Graph   gexfGraph `xml:"graph"`
+}
+
+type gexfGraph struct {
+	DefaultEdgeType string           `xml:"defaultedgetype,attr"`
+	Attributes      []gexfAttributes `xml:"attributes"`
+	Nodes           []gexfNode       `xml:"nodes>node"`
+	Edges           []gexfEdge       `xml:"edges>edge"`
+}
+
+type gexfAttributes struct {
+	Class      string          `xml:"class,attr"`
+	Attributes []gexfAttribute `xml:"attribute"`
+}
+
+type gexfAttribute struct {
+	ID    string `xml:"id,attr"`
+	Title string `xml:"title,attr"`
+	Type  string `xml:"type,attr"`
+}
+
+type gexfNode struct {
+	ID        string           `xml:"id,attr"`
+	Label     string           `xml:"label,attr"`
+	AttValues []gexfAttValue   `xml:"attvalues>attvalue"`
+	Color     gexfColor        `xml:"viz:color"`
+	Size      *gexfValueHolder `xml:"viz:size,omitempty"`
+}
+
+type gexfEdge struct {
+	ID        string         `xml:"id,attr"`
+	Source    string         `xml:"source,attr"`
+	Target    string         `xml:"target,attr"`
+	AttValues []gexfAttValue `xml:"attvalues>attvalue"`
+}
+
+type gexfAttValue struct {
+	For   string `xml:"for,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type gexfColor struct {
+	R uint8 `xml:"r,attr"`
+	G uint8 `xml:"g,attr"`
+	B uint8 `xml:"b,attr"`
+}
+
+type gexfValueHolder struct {
+	Value float64 `xml:"value,attr"`
+}
+
+// writeGEXF writes `graph` as a GEXF 1.3 document, the node colors being those of the web UI
+func writeGEXF(writer io.Writer, graph *Graph) error {
+	nodeAttributeDefinitions := gexfAttributes{Class: "node"}
+	for _, nodeAttribute := range nodeAttributes {
+		nodeAttributeDefinitions.Attributes = append(nodeAttributeDefinitions.Attributes, gexfAttribute{
+			ID:    nodeAttribute.name,
+			Title: nodeAttribute.name,
+			Type:  nodeAttribute.valueType,
+		})
+	}
+	edgeAttributeDefinitions := gexfAttributes{
+		Class:      "edge",
+		Attributes: []gexfAttribute{{ID: "type", Title: "type", Type: "string"}},
+	}
+	document := &gexfDocument{
+		XMLNS:   "http://gexf.net/1.3",
+		VizNS:   "http://gexf.net/1.3/viz",
+		Version: "1.3",
+		Graph: gexfGraph{
+			DefaultEdgeType: "directed",
+			Attributes:      []gexfAttributes{nodeAttributeDefinitions, edgeAttributeDefinitions},
+			Nodes:           make([]gexfNode, len(graph.Nodes)),
+			Edges:           make([]gexfEdge, len(graph.Edges)),
+		},
+	}
+	for i, node := range graph.Nodes {
+		r, g, b := displayColor(node.Color)
+		gexfNode := gexfNode{
+			ID:    node.Hash,
+			Label: shortHash(node.Hash),
+			Color: gexfColor{R: r, G: g, B: b},
+		}
+		for j, value := range node.attributeValues() {
+			gexfNode.AttValues = append(gexfNode.AttValues, gexfAttValue{For: nodeAttributes[j].name, Value: value})
+		}
+		if node.IsInVirtualSelectedParentChain {
+			gexfNode.Size = &gexfValueHolder{Value: 2}
+		}
+		document.Graph.Nodes[i] = gexfNode
+	}
+	for i, edge := range graph.Edges {
+		document.Graph.Edges[i] = gexfEdge{
+			ID:        strconv.Itoa(i),
+			Source:    edge.From,
+			Target:    edge.To,
+			AttValues: []gexfAttValue{{For: "type", Value: edge.Type}},
+		}
+	}
+	return writeXML(writer, document)
+}
+
+type graphMLDocument struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID       string `xml:"id,attr"`
+	For      string `xml:"for,attr"`
+	AttrName string `xml:"attr.name,attr"`
+	AttrType string `xml:"attr.type,attr"`
+}
+
+type graphMLGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// writeGraphML writes `graph` as a GraphML document
+func writeGraphML(writer io.Writer, graph *Graph) error {
+	document := &graphMLDocument{
+		XMLNS: "http://graphml.graphdrawing.org/xmlns",
+		Graph: graphMLGraph{
+			ID:          "DAG",
+			EdgeDefault: "directed",
+			Nodes:       make([]graphMLNode, len(graph.Nodes)),
+			Edges:       make([]graphMLEdge, len(graph.Edges)),
+		},
+	}
+	for _, nodeAttribute := range nodeAttributes {
+		document.Keys = append(document.Keys, graphMLKey{
+			ID:       nodeAttribute.name,
+			For:      "node",
+			AttrName: nodeAttribute.name,
+			AttrType: nodeAttribute.valueType,
+		})
+	}
+	document.Keys = append(document.Keys, graphMLKey{ID: "type", For: "edge", AttrName: "type", AttrType: "string"})
+
+	for i, node := range graph.Nodes {
+		graphMLNode := graphMLNode{ID: node.Hash}
+		for j, value := range node.attributeValues() {
+			graphMLNode.Data = append(graphMLNode.Data, graphMLData{Key: nodeAttributes[j].name, Value: value})
+		}
+		document.Graph.Nodes[i] = graphMLNode
+	}
+	for i, edge := range graph.Edges {
+		document.Graph.Edges[i] = graphMLEdge{
+			Source: edge.From,
+			Target: edge.To,
+			Data:   []graphMLData{{Key: "type", Value: edge.Type}},
+		}
+	}
+	return writeXML(writer, document)
+}
+
+func writeXML(writer io.Writer, document interface{}) error {
+	_, err := io.WriteString(writer, xml.Header)
+	if err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(writer)
+	encoder.Indent("", "  ")
+	err = encoder.Encode(document)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(writer, "\n")
+	return err
+}
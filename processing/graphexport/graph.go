@@ -0,0 +1,191 @@
+package graphexport
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/go-pg/pg/v10"
+	databasePackage "github.com/kaspa-live/kaspa-graph-inspector/processing/database"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/database/model"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/tracing"
+)
+
+const (
+	// EdgeTypeParent links a block to each of its parents
+	EdgeTypeParent = "parent"
+	// EdgeTypeSelectedParent links a block to its selected parent, in addition to its parent edge
+	EdgeTypeSelectedParent = "selected_parent"
+)
+
+// Options selects the part of the DAG to export
+type Options struct {
+	Range *databasePackage.BlockRange
+	// SelectedParentEdges adds an edge of type EdgeTypeSelectedParent from each block to its selected parent
+	SelectedParentEdges bool
+	// MaxBlocks limits the number of exported blocks, 0 meaning no limit
+	MaxBlocks int
+}
+
+// Graph is a part of the DAG, its nodes being identified by the block hashes
+type Graph struct {
+	Nodes []*Node
+	Edges []*Edge
+}
+
+// Node is a block of the DAG
+type Node struct {
+	Hash                           string
+	Height                         uint64
+	DAAScore                       uint64
+	Timestamp                      int64
+	Color                          string
+	IsInVirtualSelectedParentChain bool
+	// SelectedParent is the hash of the selected parent, empty if it is unknown
+	SelectedParent string
+	// MergeSetBlues and MergeSetReds hold the hashes of the blocks in the merge set,
+	// those not stored anymore being left out
+	MergeSetBlues []string
+	MergeSetReds  []string
+}
+
+// Edge links a block to one of its parents
+type Edge struct {
+	From string
+	To   string
+	// Type is either EdgeTypeParent or EdgeTypeSelectedParent
+	Type string
+}
+
+// attribute describes a node attribute along with its GEXF and GraphML type
+type attribute struct {
+	name      string
+	valueType string
+}
+
+// nodeAttributes lists the node attributes in the order of Node.attributeValues
+var nodeAttributes = []attribute{
+	{"height", "long"},
+	{"daaScore", "long"},
+	{"timestamp", "long"},
+	{"color", "string"},
+	{"isInVirtualSelectedParentChain", "boolean"},
+	{"selectedParent", "string"},
+	{"mergeSetBlues", "string"},
+	{"mergeSetReds", "string"},
+}
+
+func (node *Node) attributeValues() []string {
+	return []string{
+		strconv.FormatUint(node.Height, 10),
+		strconv.FormatUint(node.DAAScore, 10),
+		strconv.FormatInt(node.Timestamp, 10),
+		node.Color,
+		strconv.FormatBool(node.IsInVirtualSelectedParentChain),
+		node.SelectedParent,
+		strings.Join(node.MergeSetBlues, " "),
+		strings.Join(node.MergeSetReds, " "),
+	}
+}
+
+// Load reads the part of the DAG selected by `options` from the database.
+// Only the edges between blocks of the range are part of the graph.
+func Load(databaseTransaction *pg.Tx, database *databasePackage.Database, options *Options) (*Graph, error) {
+	defer tracing.Span(databaseTransaction.Context(), "graphexport.Load").End()
+
+	blocks, err := database.BlocksInRange(databaseTransaction, options.Range, options.MaxBlocks)
+	if err != nil {
+		return nil, err
+	}
+	blockIDs := make([]uint64, len(blocks))
+	for i, block := range blocks {
+		blockIDs[i] = block.ID
+	}
+	edges, err := database.EdgesBetweenBlocks(databaseTransaction, blockIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Selected parents and merge sets may lie outside the range
+	blockIDsToHashes, err := database.BlockHashesByIDs(databaseTransaction, referencedBlockIDs(blocks))
+	if err != nil {
+		return nil, err
+	}
+
+	graph := &Graph{
+		Nodes: make([]*Node, len(blocks)),
+		Edges: make([]*Edge, 0, len(edges)),
+	}
+	blocksInRange := make(map[uint64]struct{}, len(blocks))
+	for i, block := range blocks {
+		blocksInRange[block.ID] = struct{}{}
+		node := &Node{
+			Hash:                           block.BlockHash,
+			Height:                         block.Height,
+			DAAScore:                       block.DAAScore,
+			Timestamp:                      block.Timestamp,
+			Color:                          block.Color,
+			IsInVirtualSelectedParentChain: block.IsInVirtualSelectedParentChain,
+			MergeSetBlues:                  blockHashes(block.MergeSetBlueIDs, blockIDsToHashes),
+			MergeSetReds:                   blockHashes(block.MergeSetRedIDs, blockIDsToHashes),
+		}
+		if block.SelectedParentID != nil {
+			node.SelectedParent = blockIDsToHashes[*block.SelectedParentID]
+		}
+		graph.Nodes[i] = node
+	}
+	for _, edge := range edges {
+		graph.Edges = append(graph.Edges, &Edge{
+			From: blockIDsToHashes[edge.FromBlockID],
+			To:   blockIDsToHashes[edge.ToBlockID],
+			Type: EdgeTypeParent,
+		})
+	}
+	if options.SelectedParentEdges {
+		for _, block := range blocks {
+			if block.SelectedParentID == nil {
+				continue
+			}
+			if _, ok := blocksInRange[*block.SelectedParentID]; !ok {
+				continue
+			}
+			graph.Edges = append(graph.Edges, &Edge{
+				From: block.BlockHash,
+				To:   blockIDsToHashes[*block.SelectedParentID],
+				Type: EdgeTypeSelectedParent,
+			})
+		}
+	}
+	return graph, nil
+}
+
+// referencedBlockIDs returns the IDs of `blocks` along with the IDs of their selected parents and merge sets
+func referencedBlockIDs(blocks []*model.Block) []uint64 {
+	referencedIDs := make(map[uint64]struct{})
+	for _, block := range blocks {
+		referencedIDs[block.ID] = struct{}{}
+		if block.SelectedParentID != nil {
+			referencedIDs[*block.SelectedParentID] = struct{}{}
+		}
+		for _, id := range block.MergeSetBlueIDs {
+			referencedIDs[id] = struct{}{}
+		}
+		for _, id := range block.MergeSetRedIDs {
+			referencedIDs[id] = struct{}{}
+		}
+	}
+	blockIDs := make([]uint64, 0, len(referencedIDs))
+	for id := range referencedIDs {
+		blockIDs = append(blockIDs, id)
+	}
+	return blockIDs
+}
+
+func blockHashes(blockIDs []uint64, blockIDsToHashes map[uint64]string) []string {
+	hashes := make([]string, 0, len(blockIDs))
+	for _, id := range blockIDs {
+		if hash, ok := blockIDsToHashes[id]; ok {
+			hashes = append(hashes, hash)
+		}
+	}
+	return hashes
+}
@@ -0,0 +1,91 @@
+package graphexport
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-pg/pg/v10"
+	databasePackage "github.com/kaspa-live/kaspa-graph-inspector/processing/database"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/logging"
+	"github.com/pkg/errors"
+)
+
+var log = logging.Logger()
+
+// Handler serves the part of the DAG selected by the query parameters:
+//   - format: one of Formats, FormatDOT by default
+//   - fromHeight and toHeight, or fromDAAScore and toDAAScore: the inclusive range of blocks
+//   - selectedParentEdges: true to add the selected-parent edges
+//
+// A range holding more than `maxBlocks` blocks is rejected.
+func Handler(database *databasePackage.Database, maxBlocks int) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodGet {
+			writer.Header().Set("Allow", http.MethodGet)
+			http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		format, options, err := parseQuery(request)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		options.MaxBlocks = maxBlocks
+
+		var graph *Graph
+		err = database.RunInTransaction(request.Context(), func(databaseTransaction *pg.Tx) error {
+			graph, err = Load(databaseTransaction, database, options)
+			return err
+		})
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writer.Header().Set("Content-Type", ContentType(format))
+		err = Write(writer, format, graph)
+		if err != nil {
+			log.Warnf("Could not write the graph: %s", err)
+		}
+	}
+}
+
+func parseQuery(request *http.Request) (string, *Options, error) {
+	query := request.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = FormatDOT
+	}
+	if !IsFormat(format) {
+		return "", nil, errors.Errorf("format must be one of %s", strings.Join(Formats, ", "))
+	}
+
+	blockRange := &databasePackage.BlockRange{By: databasePackage.RangeByHeight}
+	fromParameter, toParameter := "fromHeight", "toHeight"
+	if query.Has("fromDAAScore") || query.Has("toDAAScore") {
+		blockRange.By = databasePackage.RangeByDAAScore
+		fromParameter, toParameter = "fromDAAScore", "toDAAScore"
+	}
+	var err error
+	blockRange.From, err = strconv.ParseUint(query.Get(fromParameter), 10, 64)
+	if err != nil {
+		return "", nil, errors.Errorf("%s must be an unsigned integer", fromParameter)
+	}
+	blockRange.To, err = strconv.ParseUint(query.Get(toParameter), 10, 64)
+	if err != nil {
+		return "", nil, errors.Errorf("%s must be an unsigned integer", toParameter)
+	}
+	if blockRange.From > blockRange.To {
+		return "", nil, errors.Errorf("%s must not be greater than %s", fromParameter, toParameter)
+	}
+
+	options := &Options{Range: blockRange}
+	if query.Has("selectedParentEdges") {
+		options.SelectedParentEdges, err = strconv.ParseBool(query.Get("selectedParentEdges"))
+		if err != nil {
+			return "", nil, errors.Errorf("selectedParentEdges must be a boolean")
+		}
+	}
+	return format, options, nil
+}
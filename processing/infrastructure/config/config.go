@@ -2,9 +2,11 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/jessevdk/go-flags"
 	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/logging"
@@ -22,32 +24,207 @@ const (
 	defaultLogLevel       = "info"
 	defaultLogFilename    = "kgi-processing.log"
 	defaultErrLogFilename = "kgi-processing_err.log"
+	defaultConfigFilename = "kgi-processing.conf"
+	environmentPrefix     = "KGI_"
+
+	defaultHealthMaxIdle        = 10 * time.Minute
+	defaultReadyMaxDAAScoreLag  = 1000
+	defaultTracingSampleRatio   = 1.0
+	defaultShutdownTimeout      = 30 * time.Second
+	defaultMaxFailures          = 5
+	defaultFailureBackoff       = 2 * time.Second
+	defaultEventQueueCapacity   = 10000
+	defaultDatabaseIdleTimeout  = 5 * time.Minute
+	defaultDatabaseTxRetries    = 3
+	defaultPartitioning         = "none"
+	defaultBlockSource          = BlockSourceRPC
+	defaultPartitionSize        = 100000
+	defaultPartitionsAhead      = 2
+	defaultPartitionMaintenance = time.Hour
+	defaultRetentionInterval    = 10 * time.Minute
+	defaultRetentionBatchSize   = 1000
+	defaultGraphMaxBlocks       = 10000
+	defaultParquetPartitionSize = 1000000
+	defaultParquetMinDepth      = 1000
+	defaultParquetBatchSize     = 100000
+	defaultLoadTestBlocks       = 10000
+	defaultLoadTestBPS          = 10
+	defaultLoadTestWidth        = 10
+	defaultLoadTestMaxParents   = 10
+	defaultLoadTestReorgDepth   = 3
 )
 
 var (
 	// DefaultAppDir is the default home directory for kaspad.
 	DefaultAppDir  = util.AppDir(appDataDirectory, false)
 	defaultDataDir = filepath.Join(DefaultAppDir)
+	// defaultConfigFile is loaded if it exists and no other configuration file is given
+	defaultConfigFile = filepath.Join(DefaultAppDir, defaultConfigFilename)
 )
 
 type Flags struct {
-	ShowVersion              bool     `short:"V" long:"version" description:"Display version information and exit"`
-	AppDir                   string   `short:"b" long:"appdir" description:"Directory to store data"`
-	LogDir                   string   `long:"logdir" description:"Directory to log output."`
-	DatabaseConnectionString string   `long:"connection-string" description:"Connection string for PostgrSQL database to connect to. Should be of the form: postgres://<username>:<password>@<host>:<port>/<database name>"`
-	ConnectPeers             []string `long:"connect" description:"Connect only to the specified peers at startup"`
-	DNSSeed                  string   `long:"dnsseed" description:"Override DNS seeds with specified hostname (Only 1 hostname allowed)"`
-	GRPCSeed                 string   `long:"grpcseed" description:"Hostname of gRPC server for seeding peers"`
-	Resync                   bool     `long:"resync" description:"Force to resync all available node blocks with the PostgrSQL database -- Use if some recently added blocks have missing parents"`
-	ClearDB                  bool     `long:"clear-db" description:"Clear the PostgrSQL database and sync from scratch"`
-	LogLevel                 string   `short:"d" long:"loglevel" description:"Logging level for all subsystems {trace, debug, info, warn, error, critical} -- You may also specify <subsystem>=<level>,<subsystem2>=<level>,... to set the log level for individual subsystems -- Use show to list available subsystems"`
-	RPCServer                string   `short:"s" long:"rpcserver" description:"RPC server to connect to"`
-	NetSuffix                int   	 `long:"netsuffix" description:"Testnet network suffix number"`
+	ShowVersion              bool          `short:"V" long:"version" description:"Display version information and exit"`
+	ConfigFile               string        `short:"C" long:"configfile" description:"Path to an INI configuration file, its options being overridden by the environment and the command line"`
+	AppDir                   string        `short:"b" long:"appdir" description:"Directory to store data"`
+	LogDir                   string        `long:"logdir" description:"Directory to log output."`
+	DatabaseConnectionString string        `long:"connection-string" description:"Connection string for PostgrSQL database to connect to. Should be of the form: postgres://<username>:<password>@<host>:<port>/<database name>"`
+	ConnectionStringFile     string        `long:"connection-string-file" description:"File holding the connection string, instead of --connection-string"`
+	DatabasePasswordFile     string        `long:"database-password-file" description:"File holding the database password to insert into the connection string"`
+	MigrationsDir            string        `long:"migrations-dir" description:"Directory to load the SQL migrations from instead of the migrations embedded in the binary"`
+	DatabasePoolSize         int           `long:"database-pool-size" description:"Maximum number of connections to the database -- Use 0 for 10 connections per CPU"`
+	DatabaseIdleTimeout      time.Duration `long:"database-idle-timeout" description:"Close the database connections idle for this duration"`
+	DatabaseReadTimeout      time.Duration `long:"database-read-timeout" description:"Timeout of reading a query response from the database -- Use 0 to disable"`
+	DatabaseWriteTimeout     time.Duration `long:"database-write-timeout" description:"Timeout of sending a query to the database -- Use 0 to disable"`
+	DatabaseStatementTimeout time.Duration `long:"database-statement-timeout" description:"Abort the database statements lasting longer than this duration -- Use 0 to keep the timeout of the server"`
+	DatabaseTxTimeout        time.Duration `long:"database-transaction-timeout" description:"Roll back the database transactions lasting longer than this duration, including a full resync -- Use 0 to disable"`
+	DatabaseTxRetries        int           `long:"database-transaction-retries" description:"Number of times a database transaction failing with a transient error, such as a lost connection, is run again"`
+	DatabaseTLSCA            string        `long:"database-tls-ca" description:"File holding the certificate authorities to verify the certificate of the database server against"`
+	DatabaseTLSCert          string        `long:"database-tls-cert" description:"File holding the client certificate to authenticate to the database with"`
+	DatabaseTLSKey           string        `long:"database-tls-key" description:"File holding the private key of the client certificate"`
+	Partitioning             string        `long:"partitioning" choice:"none" choice:"native" choice:"timescaledb" description:"Partition the blocks and edges tables by height range, with PostgreSQL declarative partitioning or TimescaleDB hypertables -- The tables are converted on startup and cannot be converted back"`
+	PartitionSize            uint64        `long:"partition-size" description:"Number of heights covered by each partition"`
+	PartitionsAhead          int           `long:"partitions-ahead" description:"Number of empty partitions created in advance above the highest block"`
+	RetainedPartitions       int           `long:"retained-partitions" description:"Number of partitions kept below the partition of the highest block, the older ones being detached (dropped with TimescaleDB) -- Use 0 to keep all the partitions"`
+	RetentionHeight          uint64        `long:"retention-height" description:"Delete the blocks lying more than this many heights below the highest block, keeping the pruning point of the node and the blocks following it -- Use 0 to disable"`
+	RetentionDAAScore        uint64        `long:"retention-daa-score" description:"Delete the blocks lying more than this far in DAA score below the highest DAA score -- Use 0 to disable"`
+	RetentionAge             time.Duration `long:"retention-age" description:"Delete the blocks having a timestamp older than this duration -- Use 0 to disable"`
+	RetentionInterval        time.Duration `long:"retention-interval" description:"Interval between the enforcements of the retention window"`
+	RetentionBatchSize       int           `long:"retention-batch-size" description:"Number of blocks deleted per database transaction when enforcing the retention window"`
+	RetentionRebase          bool          `long:"retention-rebase" description:"Shift the heights down after enforcing the retention window so the lowest stored height is 0 -- Cannot be combined with --partitioning"`
+	PartitionMaintenance     time.Duration `long:"partition-maintenance-interval" description:"Interval between the creations of the partitions ahead and the detachments of the old partitions"`
+	ConnectPeers             []string      `long:"connect" description:"Connect only to the specified peers at startup"`
+	DNSSeed                  string        `long:"dnsseed" description:"Override DNS seeds with specified hostname (Only 1 hostname allowed)"`
+	GRPCSeed                 string        `long:"grpcseed" description:"Hostname of gRPC server for seeding peers"`
+	Resync                   bool          `long:"resync" description:"Force to resync all available node blocks with the PostgrSQL database -- Use if some recently added blocks have missing parents"`
+	ClearDB                  bool          `long:"clear-db" description:"Clear the PostgrSQL database and sync from scratch"`
+	Archival                 bool          `long:"archival" description:"Keep the stored history when the pruning point of the node is missing in the database instead of clearing it, the pruning point starting a new segment -- Cannot be combined with a retention window"`
+	LogLevel                 string        `short:"d" long:"loglevel" description:"Logging level for all subsystems {trace, debug, info, warn, error, critical} -- You may also specify <subsystem>=<level>,<subsystem2>=<level>,... to set the log level for individual subsystems -- Use show to list available subsystems"`
+	BlockSource              string        `long:"block-source" choice:"rpc" choice:"embedded" description:"Node to get the blocks from: the RPC server, or a node embedded in the process storing its own database in the app directory and connecting to the network with the peers of --connect, --dnsseed and --grpcseed -- The embedded node cannot be combined with --record or --replay"`
+	RPCServer                string        `short:"s" long:"rpcserver" description:"RPC server to connect to"`
+	Record                   string        `long:"record" description:"Append the responses and the notifications of the RPC server to the specified file for a later replay"`
+	Replay                   string        `long:"replay" description:"Replay the responses and the notifications recorded in the specified file instead of connecting to an RPC server, then exit"`
+	NetSuffix                int           `long:"netsuffix" description:"Testnet network suffix number"`
+	HTTPListen               string        `long:"http-listen" description:"Address on which to serve the /healthz, /readyz, /metrics and /graph endpoints, e.g. 0.0.0.0:8082 -- Leave empty to disable"`
+	AdminListen              string        `long:"admin-listen" description:"Address on which to serve the /reconcile endpoint repairing the database, e.g. 127.0.0.1:8083 -- Keep it out of reach of untrusted clients, since it is not authenticated -- Leave empty to disable"`
+	GraphMaxBlocks           int           `long:"graph-max-blocks" description:"Maximum number of blocks of a graph served by the /graph endpoint"`
+	HealthMaxIdle            time.Duration `long:"health-max-idle" description:"Report the instance as unhealthy on /healthz when no block got processed during this duration -- Use 0 to disable"`
+	ReadyMaxDAAScoreLag      uint64        `long:"ready-max-daa-score-lag" description:"Report the instance as not ready on /readyz when lagging more than this DAA score behind the node"`
+	TracingEndpoint          string        `long:"tracing-endpoint" description:"OTLP/gRPC collector to export trace spans to, e.g. localhost:4317 -- Leave empty to disable tracing"`
+	TracingInsecure          bool          `long:"tracing-insecure" description:"Connect to the OTLP/gRPC collector without TLS"`
+	TracingSampleRatio       float64       `long:"tracing-sample-ratio" description:"Ratio of the traces to sample, from 0 to 1"`
+	ShutdownTimeout          time.Duration `long:"shutdown-timeout" description:"Maximum duration of the shutdown following a SIGINT or SIGTERM before the process exits with an error"`
+	MaxFailures              int           `long:"max-failures" description:"Number of consecutive processing failures, each followed by a retry or a resync, after which the process exits"`
+	FailureBackoff           time.Duration `long:"failure-backoff" description:"Delay before retrying after a processing failure, doubled after each consecutive failure up to one minute"`
+	EventQueueCapacity       int           `long:"event-queue-capacity" description:"Maximum number of node events waiting to be processed before they get discarded in favor of a database resync"`
+	CoalesceChainChanges     bool          `long:"coalesce-chain-changes" description:"Merge consecutive virtual selected parent chain changes waiting to be processed into a single one"`
 	kaspaConfigPackage.NetworkFlags
 }
 
+// Names of the commands
+const (
+	RunCommand      = "run"
+	MigrateCommand  = "migrate"
+	CheckCommand    = "check"
+	ExportCommand   = "export"
+	ImportCommand   = "import"
+	PruneCommand    = "prune"
+	StatsCommand    = "stats"
+	GraphCommand    = "graph"
+	ParquetCommand  = "parquet"
+	LoadTestCommand = "loadtest"
+
+	MigrateUpCommand     = "up"
+	MigrateDownCommand   = "down"
+	MigrateStatusCommand = "status"
+	MigrateForceCommand  = "force"
+)
+
+// Names of the block sources
+const (
+	BlockSourceRPC      = "rpc"
+	BlockSourceEmbedded = "embedded"
+)
+
+// CheckFlags holds the options of the check command
+type CheckFlags struct {
+	Repair bool `long:"repair" description:"Repair the violations found, using the data of the node for the blocks it provides"`
+}
+
+// MigrateDownFlags holds the options of the migrate down command
+type MigrateDownFlags struct {
+	Steps     uint `long:"steps" description:"Number of migrations to roll back"`
+	ToVersion int  `long:"to-version" description:"Version to roll back to, overriding --steps -- Use 0 to roll back all the migrations"`
+}
+
+// MigrateForceFlags holds the options of the migrate force command
+type MigrateForceFlags struct {
+	ToVersion uint `long:"to-version" description:"Version to record, either the dirty version if its migration got completed manually or the version before if it got reverted" required:"true"`
+}
+
+// ExportFlags holds the options of the export command
+type ExportFlags struct {
+	Output string `short:"o" long:"output" description:"Snapshot file to write" required:"true"`
+}
+
+// ImportFlags holds the options of the import command
+type ImportFlags struct {
+	Input string `short:"i" long:"input" description:"Snapshot file to read" required:"true"`
+	Run   bool   `long:"run" description:"Sync the database with the node once the snapshot is imported"`
+}
+
+// GraphFlags holds the options of the graph command
+type GraphFlags struct {
+	Output              string `short:"o" long:"output" description:"File to write the graph to" required:"true"`
+	Format              string `long:"format" choice:"dot" choice:"gexf" choice:"graphml" description:"Format of the graph"`
+	By                  string `long:"by" choice:"height" choice:"daa-score" description:"Block field the range applies to"`
+	From                uint64 `long:"from" description:"Lowest height or DAA score of the range" required:"true"`
+	To                  uint64 `long:"to" description:"Highest height or DAA score of the range" required:"true"`
+	SelectedParentEdges bool   `long:"selected-parent-edges" description:"Add an edge of a distinct type from each block to its selected parent"`
+}
+
+// ParquetFlags holds the options of the parquet command
+type ParquetFlags struct {
+	OutputDir     string `short:"o" long:"output-dir" description:"Directory to write the Parquet files and the export state to" required:"true"`
+	PartitionSize uint64 `long:"daa-score-partition-size" description:"DAA score range covered by each partition directory"`
+	MinDepth      uint64 `long:"min-depth" description:"Leave the blocks having a DAA score less than this depth below the highest one for a later export, their GHOSTDAG data being still likely to change"`
+	BatchSize     int    `long:"batch-size" description:"Maximum number of blocks exported per database transaction"`
+	Full          bool   `long:"full" description:"Discard the previous exports and export all the blocks again"`
+}
+
+// LoadTestFlags holds the options of the loadtest command
+type LoadTestFlags struct {
+	Blocks     int     `long:"blocks" description:"Number of blocks to generate"`
+	BPS        float64 `long:"bps" description:"Number of blocks generated per second -- Use 0 to generate them as fast as they get processed"`
+	K          uint64  `long:"k" description:"GHOSTDAG parameter the generated blocks get colored with -- Defaults to the K of the network"`
+	Width      int     `long:"width" description:"Number of blocks created concurrently, in the anticone of each other"`
+	MaxParents int     `long:"max-parents" description:"Maximum number of parents of a block"`
+	RedRate    float64 `long:"red-rate" description:"Probability for a block to be created on a view of the DAG lagging behind enough to make it red, from 0 to 1"`
+	ReorgRate  float64 `long:"reorg-rate" description:"Probability for each round of concurrent blocks to start a network split reorganizing the virtual selected parent chain, from 0 to 1"`
+	ReorgDepth int     `long:"reorg-depth" description:"Number of rounds of concurrent blocks a network split lasts"`
+	Seed       int64   `long:"seed" description:"Seed of the random choices, the same seed generating the same DAG"`
+	InMemory   bool    `long:"in-memory" description:"Store the processed blocks in memory instead of the database, which is then not connected to"`
+}
+
+// PruneFlags holds the options of the prune command
+type PruneFlags struct {
+	BelowDAAScore uint64 `long:"below-daa-score" description:"Delete the blocks having a lower DAA score -- Defaults to the DAA score of the pruning point of the node"`
+}
+
 type Config struct {
-	NetName	string
+	NetName string
+	// Command is the name of the command to run, RunCommand if none is given
+	Command string
+	// MigrateCommand is the name of the migrate subcommand to run
+	MigrateCommand string
+	Check          *CheckFlags
+	MigrateDown    *MigrateDownFlags
+	MigrateForce   *MigrateForceFlags
+	Export         *ExportFlags
+	Import         *ImportFlags
+	Prune          *PruneFlags
+	Graph          *GraphFlags
+	Parquet        *ParquetFlags
+	LoadTest       *LoadTestFlags
 	*Flags
 }
 
@@ -83,10 +260,213 @@ func cleanAndExpandPath(path string) string {
 
 func defaultFlags() *Flags {
 	return &Flags{
-		AppDir:    defaultDataDir,
-		LogLevel:  defaultLogLevel,
-		RPCServer: "localhost",
+		AppDir:               defaultDataDir,
+		LogLevel:             defaultLogLevel,
+		BlockSource:          defaultBlockSource,
+		RPCServer:            "localhost",
+		HealthMaxIdle:        defaultHealthMaxIdle,
+		ReadyMaxDAAScoreLag:  defaultReadyMaxDAAScoreLag,
+		TracingSampleRatio:   defaultTracingSampleRatio,
+		ShutdownTimeout:      defaultShutdownTimeout,
+		MaxFailures:          defaultMaxFailures,
+		FailureBackoff:       defaultFailureBackoff,
+		EventQueueCapacity:   defaultEventQueueCapacity,
+		DatabaseIdleTimeout:  defaultDatabaseIdleTimeout,
+		DatabaseTxRetries:    defaultDatabaseTxRetries,
+		Partitioning:         defaultPartitioning,
+		PartitionSize:        defaultPartitionSize,
+		PartitionsAhead:      defaultPartitionsAhead,
+		PartitionMaintenance: defaultPartitionMaintenance,
+		RetentionInterval:    defaultRetentionInterval,
+		RetentionBatchSize:   defaultRetentionBatchSize,
+		GraphMaxBlocks:       defaultGraphMaxBlocks,
+	}
+}
+
+// newParser returns a parser storing the options and the commands in `cfg`
+func newParser(cfg *Config, options flags.Options) (*flags.Parser, error) {
+	parser := flags.NewParser(cfg.Flags, options)
+	// Running the processing stays the default so existing deployments keep working
+	parser.SubcommandsOptional = true
+	err := addCommands(parser, cfg)
+	if err != nil {
+		return nil, err
 	}
+	return parser, nil
+}
+
+// findConfigFile returns the configuration file given on the command line or in the environment.
+// Defaults to the configuration file of the default app directory if it exists.
+func findConfigFile() (string, error) {
+	preCfg := &Flags{}
+	preParser := flags.NewParser(preCfg, flags.IgnoreUnknown)
+	bindEnvironment(preParser.Command)
+	// Errors are reported by the complete parsing following
+	_, _ = preParser.Parse()
+	if preCfg.ConfigFile != "" {
+		return cleanAndExpandPath(preCfg.ConfigFile), nil
+	}
+
+	_, err := os.Stat(defaultConfigFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return defaultConfigFile, nil
+}
+
+// bindEnvironment makes each option of `command`, of its groups and of its subcommands default to
+// the environment variable named after it, e.g. KGI_CONNECTION_STRING for --connection-string.
+// The options sharing a name, such as the --output of the export and graph commands, share their variable.
+func bindEnvironment(command *flags.Command) {
+	bindGroupEnvironment(command.Group)
+	for _, subcommand := range command.Commands() {
+		bindEnvironment(subcommand)
+	}
+}
+
+func bindGroupEnvironment(group *flags.Group) {
+	for _, option := range group.Options() {
+		if option.LongName == "" || option.EnvDefaultKey != "" || option.LongName == "version" {
+			continue
+		}
+		option.EnvDefaultKey = environmentPrefix + strings.ToUpper(strings.ReplaceAll(option.LongName, "-", "_"))
+	}
+	for _, subgroup := range group.Groups() {
+		bindGroupEnvironment(subgroup)
+	}
+}
+
+// readSecretFiles reads the secrets stored in files, so they appear neither
+// in the process list nor in the environment
+func (cfg *Config) readSecretFiles() error {
+	if cfg.ConnectionStringFile != "" {
+		if cfg.DatabaseConnectionString != "" {
+			return errors.Errorf("--connection-string and --connection-string-file cannot be used together.")
+		}
+		connectionString, err := readSecretFile(cfg.ConnectionStringFile)
+		if err != nil {
+			return err
+		}
+		cfg.DatabaseConnectionString = connectionString
+	}
+
+	if cfg.DatabasePasswordFile != "" {
+		password, err := readSecretFile(cfg.DatabasePasswordFile)
+		if err != nil {
+			return err
+		}
+		connectionURL, err := url.Parse(cfg.DatabaseConnectionString)
+		if err != nil || connectionURL.User == nil {
+			return errors.Errorf("--database-password-file requires a connection string of the form postgres://<username>@<host>:<port>/<database name>")
+		}
+		connectionURL.User = url.UserPassword(connectionURL.User.Username(), password)
+		cfg.DatabaseConnectionString = connectionURL.String()
+	}
+	return nil
+}
+
+func readSecretFile(path string) (string, error) {
+	content, err := os.ReadFile(cleanAndExpandPath(path))
+	if err != nil {
+		return "", errors.Wrapf(err, "Error reading secret file %s", path)
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// RedactedConnectionString returns the database connection string with its password hidden
+func (cfg *Config) RedactedConnectionString() string {
+	connectionURL, err := url.Parse(cfg.DatabaseConnectionString)
+	if err != nil {
+		return "<invalid connection string>"
+	}
+	return connectionURL.Redacted()
+}
+
+// addCommands adds the commands to `parser`, storing their options in `cfg`.
+// The options of `parser` are shared by all the commands.
+func addCommands(parser *flags.Parser, cfg *Config) error {
+	_, err := parser.AddCommand(RunCommand, "Sync the database with the node (default)",
+		"Sync the database with the node and keep processing the node events until interrupted",
+		&struct{}{})
+	if err != nil {
+		return err
+	}
+
+	migrate, err := parser.AddCommand(MigrateCommand, "Manage the database schema",
+		"Migrate the database schema up or down, or report its version", &struct{}{})
+	if err != nil {
+		return err
+	}
+	_, err = migrate.AddCommand(MigrateUpCommand, "Migrate the database to the latest version",
+		"Apply all the migrations not applied yet", &struct{}{})
+	if err != nil {
+		return err
+	}
+	_, err = migrate.AddCommand(MigrateDownCommand, "Roll the database back",
+		"Roll back the latest applied migrations, or all the migrations after a given version", cfg.MigrateDown)
+	if err != nil {
+		return err
+	}
+	_, err = migrate.AddCommand(MigrateStatusCommand, "Report the version of the database",
+		"Report the current version of the database, whether it is dirty and the migrations not applied yet", &struct{}{})
+	if err != nil {
+		return err
+	}
+	_, err = migrate.AddCommand(MigrateForceCommand, "Recover a dirty database",
+		"Record the version of a database left dirty by a failed migration, once its schema got fixed manually",
+		cfg.MigrateForce)
+	if err != nil {
+		return err
+	}
+
+	_, err = parser.AddCommand(CheckCommand, "Check the consistency of the database",
+		"Verify that the edges match the parents of the blocks, that the heights and the height groups are consistent "+
+			"and that the selected parents and merge sets reference stored blocks, then exit with code 1 if any violation is left",
+		cfg.Check)
+	if err != nil {
+		return err
+	}
+	_, err = parser.AddCommand(ExportCommand, "Export the database to a snapshot file",
+		"Write the blocks, edges, height groups and app config to a gzipped tar snapshot file", cfg.Export)
+	if err != nil {
+		return err
+	}
+	_, err = parser.AddCommand(ImportCommand, "Import a snapshot file into the database",
+		"Replace the content of the database with a snapshot file written by the export command, "+
+			"the snapshot having to be of the same network and schema version as the database", cfg.Import)
+	if err != nil {
+		return err
+	}
+	_, err = parser.AddCommand(PruneCommand, "Delete the oldest blocks",
+		"Delete the blocks below a DAA score, by default the blocks the node pruned", cfg.Prune)
+	if err != nil {
+		return err
+	}
+	_, err = parser.AddCommand(StatsCommand, "Report statistics about the database",
+		"Report the number of blocks, edges and height groups along with the height and DAA score ranges", &struct{}{})
+	if err != nil {
+		return err
+	}
+	_, err = parser.AddCommand(GraphCommand, "Export a range of the DAG as a graph",
+		"Write the blocks within a height or DAA score range and the edges between them as a GraphViz DOT, "+
+			"GEXF or GraphML graph", cfg.Graph)
+	if err != nil {
+		return err
+	}
+	_, err = parser.AddCommand(ParquetCommand, "Export the blocks and edges to Parquet files",
+		"Write the blocks stored since the last export, with their GHOSTDAG data, and their edges "+
+			"to Parquet files partitioned by DAA score range", cfg.Parquet)
+	if err != nil {
+		return err
+	}
+	_, err = parser.AddCommand(LoadTestCommand, "Process a synthetic DAG at a given block rate",
+		"Generate a GHOSTDAG-consistent synthetic DAG and serve it to the processing by a fake node within the process, "+
+			"then report the processing rate -- Use a dedicated database since it gets resynced with the synthetic DAG",
+		cfg.LoadTest)
+	return err
 }
 
 func LoadConfig() (*Config, error) {
@@ -96,8 +476,52 @@ func LoadConfig() (*Config, error) {
 	usageMessage := fmt.Sprintf("Use %s -h to show usage", appName)
 
 	cfgFlags := defaultFlags()
-	parser := flags.NewParser(cfgFlags, flags.HelpFlag)
-	_, err := parser.Parse()
+	cfg := &Config{
+		Flags:        cfgFlags,
+		Check:        &CheckFlags{},
+		MigrateDown:  &MigrateDownFlags{Steps: 1, ToVersion: -1},
+		MigrateForce: &MigrateForceFlags{},
+		Export:       &ExportFlags{},
+		Import:       &ImportFlags{},
+		Prune:        &PruneFlags{},
+		Graph:        &GraphFlags{Format: "dot", By: "height"},
+		Parquet: &ParquetFlags{
+			PartitionSize: defaultParquetPartitionSize,
+			MinDepth:      defaultParquetMinDepth,
+			BatchSize:     defaultParquetBatchSize,
+		},
+		LoadTest: &LoadTestFlags{
+			Blocks:     defaultLoadTestBlocks,
+			BPS:        defaultLoadTestBPS,
+			Width:      defaultLoadTestWidth,
+			MaxParents: defaultLoadTestMaxParents,
+			ReorgDepth: defaultLoadTestReorgDepth,
+		},
+	}
+
+	// Options get their value from the defaults first, then from the configuration file,
+	// then from the environment and finally from the command line
+	configFile, err := findConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	if configFile != "" {
+		fileParser, err := newParser(cfg, flags.None)
+		if err != nil {
+			return nil, err
+		}
+		err = flags.NewIniParser(fileParser).ParseFile(configFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error parsing configuration file %s", configFile)
+		}
+	}
+
+	parser, err := newParser(cfg, flags.HelpFlag)
+	if err != nil {
+		return nil, err
+	}
+	bindEnvironment(parser.Command)
+	_, err = parser.Parse()
 	if err != nil {
 		var flagsErr *flags.Error
 		if ok := errors.As(err, &flagsErr); !ok || flagsErr.Type != flags.ErrHelp {
@@ -105,8 +529,12 @@ func LoadConfig() (*Config, error) {
 		}
 		return nil, err
 	}
-	cfg := &Config{
-		Flags: cfgFlags,
+	cfg.Command = RunCommand
+	if parser.Active != nil {
+		cfg.Command = parser.Active.Name
+		if parser.Active.Active != nil {
+			cfg.MigrateCommand = parser.Active.Active.Name
+		}
 	}
 
 	// Show the version and exit if the version flag was specified.
@@ -116,15 +544,170 @@ func LoadConfig() (*Config, error) {
 		os.Exit(0)
 	}
 
-	if cfg.DatabaseConnectionString == "" {
+	err = cfg.readSecretFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	isInMemory := cfg.Command == LoadTestCommand && cfg.LoadTest.InMemory
+	if cfg.DatabaseConnectionString == "" && !isInMemory {
 		return nil, errors.Errorf("--connection-string is required.")
 	}
 
+	if cfg.MigrateDown.Steps < 1 {
+		return nil, errors.Errorf("--steps must be at least 1.")
+	}
+
+	if cfg.MigrateDown.ToVersion < -1 {
+		return nil, errors.Errorf("--to-version must not be negative.")
+	}
+
+	if cfg.MaxFailures < 1 {
+		return nil, errors.Errorf("--max-failures must be at least 1.")
+	}
+
+	if cfg.FailureBackoff <= 0 {
+		return nil, errors.Errorf("--failure-backoff must be positive.")
+	}
+
+	if cfg.EventQueueCapacity < 1 {
+		return nil, errors.Errorf("--event-queue-capacity must be at least 1.")
+	}
+
+	if cfg.DatabasePoolSize < 0 {
+		return nil, errors.Errorf("--database-pool-size must not be negative.")
+	}
+
+	if cfg.DatabaseIdleTimeout < 0 || cfg.DatabaseReadTimeout < 0 || cfg.DatabaseWriteTimeout < 0 ||
+		cfg.DatabaseStatementTimeout < 0 || cfg.DatabaseTxTimeout < 0 {
+		return nil, errors.Errorf("The database timeouts must not be negative.")
+	}
+
+	if cfg.DatabaseTxRetries < 0 {
+		return nil, errors.Errorf("--database-transaction-retries must not be negative.")
+	}
+
+	if (cfg.DatabaseTLSCert == "") != (cfg.DatabaseTLSKey == "") {
+		return nil, errors.Errorf("--database-tls-cert and --database-tls-key must be used together.")
+	}
+
+	if cfg.PartitionSize < 1 {
+		return nil, errors.Errorf("--partition-size must be at least 1.")
+	}
+
+	if cfg.PartitionsAhead < 0 {
+		return nil, errors.Errorf("--partitions-ahead must not be negative.")
+	}
+
+	if cfg.RetainedPartitions < 0 {
+		return nil, errors.Errorf("--retained-partitions must not be negative.")
+	}
+
+	if cfg.PartitionMaintenance <= 0 {
+		return nil, errors.Errorf("--partition-maintenance-interval must be positive.")
+	}
+
+	retentionWindows := 0
+	for _, isSet := range []bool{cfg.RetentionHeight > 0, cfg.RetentionDAAScore > 0, cfg.RetentionAge > 0} {
+		if isSet {
+			retentionWindows++
+		}
+	}
+	if retentionWindows > 1 {
+		return nil, errors.Errorf("Only one of --retention-height, --retention-daa-score and --retention-age can be used.")
+	}
+
+	if cfg.RetentionAge < 0 {
+		return nil, errors.Errorf("--retention-age must not be negative.")
+	}
+
+	if cfg.RetentionInterval <= 0 {
+		return nil, errors.Errorf("--retention-interval must be positive.")
+	}
+
+	if cfg.RetentionBatchSize < 1 {
+		return nil, errors.Errorf("--retention-batch-size must be at least 1.")
+	}
+
+	if cfg.Archival && retentionWindows > 0 {
+		return nil, errors.Errorf("--archival cannot be used with a retention window.")
+	}
+
+	if cfg.Record != "" && cfg.Replay != "" {
+		return nil, errors.Errorf("--record cannot be used with --replay.")
+	}
+
+	// Only the responses of an RPC server get recorded and replayed
+	if cfg.BlockSource != BlockSourceRPC && (cfg.Record != "" || cfg.Replay != "") {
+		return nil, errors.Errorf("--record and --replay cannot be used with --block-source=%s.", cfg.BlockSource)
+	}
+
+	// Rebasing would move all the rows between the partitions
+	if cfg.RetentionRebase && cfg.Partitioning != defaultPartitioning {
+		return nil, errors.Errorf("--retention-rebase cannot be used with --partitioning.")
+	}
+
+	if cfg.AdminListen != "" && cfg.AdminListen == cfg.HTTPListen {
+		return nil, errors.Errorf("--admin-listen must differ from --http-listen.")
+	}
+
+	if cfg.GraphMaxBlocks < 1 {
+		return nil, errors.Errorf("--graph-max-blocks must be at least 1.")
+	}
+
+	if cfg.Graph.From > cfg.Graph.To {
+		return nil, errors.Errorf("--from must not be greater than --to.")
+	}
+
+	if cfg.Parquet.PartitionSize < 1 {
+		return nil, errors.Errorf("--daa-score-partition-size must be at least 1.")
+	}
+
+	if cfg.Parquet.BatchSize < 1 {
+		return nil, errors.Errorf("--batch-size must be at least 1.")
+	}
+
+	if cfg.LoadTest.Blocks < 1 {
+		return nil, errors.Errorf("--blocks must be at least 1.")
+	}
+
+	if cfg.LoadTest.BPS < 0 {
+		return nil, errors.Errorf("--bps must not be negative.")
+	}
+
+	if cfg.LoadTest.Width < 1 {
+		return nil, errors.Errorf("--width must be at least 1.")
+	}
+
+	if cfg.LoadTest.MaxParents < 1 {
+		return nil, errors.Errorf("--max-parents must be at least 1.")
+	}
+
+	if cfg.LoadTest.RedRate < 0 || cfg.LoadTest.RedRate > 1 {
+		return nil, errors.Errorf("--red-rate must be between 0 and 1.")
+	}
+
+	if cfg.LoadTest.ReorgRate < 0 || cfg.LoadTest.ReorgRate > 1 {
+		return nil, errors.Errorf("--reorg-rate must be between 0 and 1.")
+	}
+
+	if cfg.LoadTest.ReorgDepth < 1 {
+		return nil, errors.Errorf("--reorg-depth must be at least 1.")
+	}
+
+	if cfg.TracingSampleRatio < 0 || cfg.TracingSampleRatio > 1 {
+		return nil, errors.Errorf("--tracing-sample-ratio must be between 0 and 1.")
+	}
+
 	err = cfg.ResolveNetwork(parser)
 	if err != nil {
 		return nil, err
 	}
 
+	if cfg.LoadTest.K == 0 {
+		cfg.LoadTest.K = uint64(cfg.NetParams().K)
+	}
+
 	cfg.AppDir = cleanAndExpandPath(cfg.AppDir)
 	// Append the network type to the app directory so it is "namespaced"
 	// per network.
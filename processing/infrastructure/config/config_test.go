@@ -0,0 +1,78 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/jessevdk/go-flags"
+)
+
+// parseCommandLine parses `args` as the command line of the processing, the options defaulting to the environment
+func parseCommandLine(t *testing.T, args ...string) *Config {
+	t.Helper()
+
+	cfg := &Config{
+		Check:        &CheckFlags{},
+		MigrateDown:  &MigrateDownFlags{},
+		MigrateForce: &MigrateForceFlags{},
+		Export:       &ExportFlags{},
+		Import:       &ImportFlags{},
+		Prune:        &PruneFlags{},
+		Graph:        &GraphFlags{},
+		Parquet:      &ParquetFlags{},
+		LoadTest:     &LoadTestFlags{},
+		Flags:        defaultFlags(),
+	}
+	parser, err := newParser(cfg, flags.None)
+	if err != nil {
+		t.Fatalf("newParser: %s", err)
+	}
+	bindEnvironment(parser.Command)
+	_, err = parser.ParseArgs(args)
+	if err != nil {
+		t.Fatalf("ParseArgs(%v): %s", args, err)
+	}
+	return cfg
+}
+
+func TestEnvironmentSetsCommandOptions(t *testing.T) {
+	t.Setenv("KGI_RPCSERVER", "localhost:16210")
+	t.Setenv("KGI_OUTPUT", "dag.gexf")
+	t.Setenv("KGI_FORMAT", "gexf")
+	t.Setenv("KGI_FROM", "10")
+	t.Setenv("KGI_TO", "20")
+	t.Setenv("KGI_REPAIR", "true")
+	t.Setenv("KGI_TO_VERSION", "7")
+	t.Setenv("KGI_BLOCKS", "42")
+
+	// The required options of the commands are satisfied by the environment
+	cfg := parseCommandLine(t, "graph")
+	if cfg.RPCServer != "localhost:16210" {
+		t.Fatalf("--rpcserver is %s, expected localhost:16210", cfg.RPCServer)
+	}
+	if cfg.Graph.Output != "dag.gexf" || cfg.Graph.Format != "gexf" || cfg.Graph.From != 10 || cfg.Graph.To != 20 {
+		t.Fatalf("The graph options are %+v, expected them from the environment", cfg.Graph)
+	}
+
+	cfg = parseCommandLine(t, "export")
+	if cfg.Export.Output != "dag.gexf" {
+		t.Fatalf("--output of export is %s, expected dag.gexf", cfg.Export.Output)
+	}
+	cfg = parseCommandLine(t, "check")
+	if !cfg.Check.Repair {
+		t.Fatalf("--repair is not set from the environment")
+	}
+	cfg = parseCommandLine(t, "loadtest")
+	if cfg.LoadTest.Blocks != 42 {
+		t.Fatalf("--blocks is %d, expected 42", cfg.LoadTest.Blocks)
+	}
+
+	// The nested commands are bound as well, the command line overriding the environment
+	cfg = parseCommandLine(t, "migrate", "force")
+	if cfg.MigrateForce.ToVersion != 7 {
+		t.Fatalf("--to-version of migrate force is %d, expected 7", cfg.MigrateForce.ToVersion)
+	}
+	cfg = parseCommandLine(t, "migrate", "down", "--to-version=3")
+	if cfg.MigrateDown.ToVersion != 3 {
+		t.Fatalf("--to-version of migrate down is %d, expected 3", cfg.MigrateDown.ToVersion)
+	}
+}
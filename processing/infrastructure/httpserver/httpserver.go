@@ -0,0 +1,66 @@
+package httpserver
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/logging"
+	"github.com/pkg/errors"
+)
+
+var log = logging.Logger()
+
+const readHeaderTimeout = 10 * time.Second
+
+// Server is a minimal HTTP server exposing the operational endpoints
+// of the processing tier (health checks and the like)
+type Server struct {
+	server *http.Server
+	mux    *http.ServeMux
+}
+
+// New creates a new Server listening on `address` once started
+func New(address string) *Server {
+	mux := http.NewServeMux()
+	return &Server{
+		server: &http.Server{
+			Addr:              address,
+			Handler:           mux,
+			ReadHeaderTimeout: readHeaderTimeout,
+		},
+		mux: mux,
+	}
+}
+
+// Handle registers `handler` for the given `pattern`
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// HandleFunc registers the handler function `handler` for the given `pattern`
+func (s *Server) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	s.mux.HandleFunc(pattern, handler)
+}
+
+// Start binds the server address and serves requests in the background
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		return errors.Wrapf(err, "could not listen on %s", s.server.Addr)
+	}
+	log.Infof("HTTP server listening on %s", listener.Addr())
+	go func() {
+		err := s.server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Errorf("HTTP server stopped unexpectedly: %s", err)
+		}
+	}()
+	return nil
+}
+
+// Stop gracefully shuts the server down
+func (s *Server) Stop(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
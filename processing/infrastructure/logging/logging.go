@@ -47,7 +47,13 @@ func LogErrorAndExit(errorLog string, logParameters ...interface{}) {
 	}
 
 	log.Errorf(errorLog, logParameters...)
+	CloseLog()
 
+	os.Exit(1)
+}
+
+// CloseLog flushes and closes the log backend, waiting for at most one second
+func CloseLog() {
 	exitHandlerDone := make(chan struct{})
 	go func() {
 		log.Backend().Close()
@@ -57,6 +63,4 @@ func LogErrorAndExit(errorLog string, logParameters ...interface{}) {
 	case <-time.After(1 * time.Second):
 	case <-exitHandlerDone:
 	}
-
-	os.Exit(1)
-}
\ No newline at end of file
+}
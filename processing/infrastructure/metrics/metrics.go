@@ -0,0 +1,228 @@
+package metrics
+
+import (
+	"math"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "kgi"
+
+var (
+	blocksProcessed = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "processing",
+		Name:      "blocks_processed_total",
+		Help:      "Number of blocks processed",
+	})
+	missingDependencies = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "processing",
+		Name:      "missing_dependencies_total",
+		Help:      "Number of missing block dependencies found while collecting the dependencies of a block",
+	})
+	processBlockDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "processing",
+		Name:      "process_block_duration_seconds",
+		Help:      "Latency of processing a single block",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	})
+	eventQueueOverflows = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "processing",
+		Name:      "event_queue_overflows_total",
+		Help:      "Number of times the event queue overflowed and the database had to be resynced",
+	})
+	processVirtualChangeDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "processing",
+		Name:      "process_virtual_change_duration_seconds",
+		Help:      "Latency of processing a virtual selected parent chain change",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	})
+
+	rpcRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "rpc",
+		Name:      "requests_total",
+		Help:      "Number of RPC requests sent to the node, per command",
+	}, []string{"command"})
+	rpcRequestErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "rpc",
+		Name:      "request_errors_total",
+		Help:      "Number of failed RPC requests, per command",
+	}, []string{"command"})
+	rpcRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "rpc",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of RPC requests, per command",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 16),
+	}, []string{"command"})
+	rpcReconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "rpc",
+		Name:      "reconnects_total",
+		Help:      "Number of successful reconnections to the node",
+	})
+
+	databaseTransactionDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "database",
+		Name:      "transaction_duration_seconds",
+		Help:      "Duration of database transactions, commit included",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 12),
+	})
+	databaseTransactionRetries = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "database",
+		Name:      "transaction_retries_total",
+		Help:      "Number of database transactions run again after a transient error",
+	})
+	blockCacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "database",
+		Name:      "block_cache_requests_total",
+		Help:      "Number of block base cache lookups, by result (hit or miss)",
+	}, []string{"result"})
+	blockCacheHits   = blockCacheRequests.WithLabelValues("hit")
+	blockCacheMisses = blockCacheRequests.WithLabelValues("miss")
+
+	// Running totals used to expose the cache hit ratio without requiring a PromQL expression
+	blockCacheHitCount  uint64
+	blockCacheMissCount uint64
+	_                   = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "database",
+		Name:      "block_cache_hit_ratio",
+		Help:      "Ratio of block base cache lookups that were hits since startup",
+	}, blockCacheHitRatio)
+)
+
+// Handler returns the HTTP handler serving the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// BlockProcessed records a processed block along with the time it took to process it
+func BlockProcessed(duration time.Duration) {
+	blocksProcessed.Inc()
+	processBlockDuration.Observe(duration.Seconds())
+}
+
+// VirtualChangeProcessed records the time it took to process a virtual change
+func VirtualChangeProcessed(duration time.Duration) {
+	processVirtualChangeDuration.Observe(duration.Seconds())
+}
+
+// MissingDependencyFound records a block dependency missing in the database
+func MissingDependencyFound() {
+	missingDependencies.Inc()
+}
+
+// RPCRequestDone records an RPC request of type `command`
+func RPCRequestDone(command string, duration time.Duration, failed bool) {
+	rpcRequests.WithLabelValues(command).Inc()
+	rpcRequestDuration.WithLabelValues(command).Observe(duration.Seconds())
+	if failed {
+		rpcRequestErrors.WithLabelValues(command).Inc()
+	}
+}
+
+// RPCReconnected records a successful reconnection to the node
+func RPCReconnected() {
+	rpcReconnects.Inc()
+}
+
+// DatabaseTransactionDone records the duration of a database transaction
+func DatabaseTransactionDone(duration time.Duration) {
+	databaseTransactionDuration.Observe(duration.Seconds())
+}
+
+// DatabaseTransactionRetried records a database transaction run again after a transient error
+func DatabaseTransactionRetried() {
+	databaseTransactionRetries.Inc()
+}
+
+// BlockCacheHit records a block base cache lookup that found its entry
+func BlockCacheHit() {
+	blockCacheHits.Inc()
+	atomic.AddUint64(&blockCacheHitCount, 1)
+}
+
+// BlockCacheMiss records a block base cache lookup that had to fall back to the database
+func BlockCacheMiss() {
+	blockCacheMisses.Inc()
+	atomic.AddUint64(&blockCacheMissCount, 1)
+}
+
+func blockCacheHitRatio() float64 {
+	hits := atomic.LoadUint64(&blockCacheHitCount)
+	misses := atomic.LoadUint64(&blockCacheMissCount)
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
+// EventQueueOverflowed records an overflow of the event queue
+func EventQueueOverflowed() {
+	eventQueueOverflows.Inc()
+}
+
+// gaugeProvider holds the function providing the value of a gauge registered once for all,
+// so the provider can be replaced, for instance by each processing created within a process
+type gaugeProvider struct {
+	provider atomic.Value
+}
+
+func (g *gaugeProvider) set(provider func() float64) {
+	g.provider.Store(provider)
+}
+
+// value returns the value given by the provider, NaN if none was set
+func (g *gaugeProvider) value() float64 {
+	provider, ok := g.provider.Load().(func() float64)
+	if !ok {
+		return math.NaN()
+	}
+	return provider()
+}
+
+var (
+	eventQueueLength = &gaugeProvider{}
+	_                = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "processing",
+		Name:      "event_queue_length",
+		Help:      "Number of node events waiting to be processed",
+	}, eventQueueLength.value)
+
+	daaScoreLag = &gaugeProvider{}
+	_           = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "processing",
+		Name:      "daa_score_lag",
+		Help:      "DAA score difference between the node virtual and the latest processed block",
+	}, daaScoreLag.value)
+)
+
+// SetEventQueueLength sets `lengthFunction` as the provider of the
+// number of node events waiting to be processed
+func SetEventQueueLength(lengthFunction func() float64) {
+	eventQueueLength.set(lengthFunction)
+}
+
+// SetDAAScoreLag sets `lagFunction` as the provider of the current
+// sync lag, in DAA score, between the database and the node.
+// It gets called on every scrape so it must not query the node.
+func SetDAAScoreLag(lagFunction func() float64) {
+	daaScoreLag.set(lagFunction)
+}
@@ -0,0 +1,36 @@
+package blocksource
+
+import (
+	"context"
+
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/network/rpcclient"
+	"github.com/kaspanet/kaspad/app/appmessage"
+)
+
+// BlockSource is the node the processing fetches the blocks from and gets notified by.
+// It answers with the messages kaspad sends over RPC, whether the node is reached
+// through an RPC client or embedded in the process.
+type BlockSource interface {
+	GetInfo(ctx context.Context) (*appmessage.GetInfoResponseMessage, error)
+	GetBlockDAGInfo(ctx context.Context) (*appmessage.GetBlockDAGInfoResponseMessage, error)
+	GetBlock(ctx context.Context, hash string, includeTransactions bool) (*appmessage.GetBlockResponseMessage, error)
+	GetBlocks(ctx context.Context, lowHash string, includeBlocks bool,
+		includeTransactions bool) (*appmessage.GetBlocksResponseMessage, error)
+	GetSelectedTipHash(ctx context.Context) (*appmessage.GetSelectedTipHashResponseMessage, error)
+	GetVirtualSelectedParentChainFromBlock(ctx context.Context, startHash string, includeAcceptedTransactionIDs bool) (
+		*appmessage.GetVirtualSelectedParentChainFromBlockResponseMessage, error)
+
+	RegisterForBlockAddedNotifications(ctx context.Context,
+		onBlockAdded func(notification *appmessage.BlockAddedNotificationMessage)) error
+	RegisterForVirtualSelectedParentChainChangedNotifications(ctx context.Context, includeAcceptedTransactionIDs bool,
+		onChainChanged func(notification *appmessage.VirtualSelectedParentChainChangedNotificationMessage)) error
+
+	// SetOnReconnectedHandler sets the handler called once the source got back to a state
+	// requiring a resync, such as after a reconnection to the node
+	SetOnReconnectedHandler(onReconnectedHandler rpcclient.OnReconnectedHandler)
+	IsConnected() bool
+	Close() error
+}
+
+// The RPC client is the default block source
+var _ BlockSource = (*rpcclient.RPCClient)(nil)
@@ -0,0 +1,414 @@
+package fakekaspad
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/syntheticdag"
+	"github.com/pkg/errors"
+)
+
+const (
+	// GenesisName is the name of the genesis block of the DAGs created by NewDAG
+	GenesisName = "genesis"
+
+	genesisTimestamp = 1_700_000_000_000
+	blockInterval    = 1000
+)
+
+// Block is a block of a synthetic DAG along with its GHOSTDAG data
+type Block = syntheticdag.Block
+
+// ChainChange is a change of the virtual selected parent chain
+type ChainChange struct {
+	// RemovedChainBlockHashes is ordered from the highest block down
+	RemovedChainBlockHashes []string
+	// AddedChainBlockHashes is ordered from the lowest block up
+	AddedChainBlockHashes []string
+}
+
+// DAG is a scriptable synthetic block DAG, whose blocks can be named for the scripts to refer to them.
+// It is safe for concurrent use.
+type DAG struct {
+	blocks   map[string]*Block
+	names    map[string]string
+	children map[string][]string
+	tips     map[string]struct{}
+	// chain is the virtual selected parent chain, from the genesis up to the selected tip
+	chain        []string
+	chainIndexes map[string]int
+	pruningPoint string
+	sync.RWMutex
+}
+
+// NewDAG creates a DAG holding only a genesis block named GenesisName
+func NewDAG() *DAG {
+	genesis := &Block{
+		Timestamp: genesisTimestamp,
+		Nonce:     nonceOf(GenesisName),
+	}
+	hash, err := syntheticdag.HeaderHash(genesis)
+	if err != nil {
+		// The header of a block without parents holds no hash to parse
+		panic(err)
+	}
+	genesis.Hash = hash
+	return &DAG{
+		blocks:       map[string]*Block{genesis.Hash: genesis},
+		names:        map[string]string{GenesisName: genesis.Hash},
+		children:     make(map[string][]string),
+		tips:         map[string]struct{}{genesis.Hash: {}},
+		chain:        []string{genesis.Hash},
+		chainIndexes: map[string]int{genesis.Hash: 0},
+		pruningPoint: genesis.Hash,
+	}
+}
+
+// HashOf returns the hash of the block named `name`, or an empty string if the DAG holds no such block
+func (d *DAG) HashOf(name string) string {
+	d.RLock()
+	defer d.RUnlock()
+
+	return d.names[name]
+}
+
+// nonceOf returns the nonce of the block named `name`, so blocks sharing their parents get different hashes
+func nonceOf(name string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(name))
+	return hash.Sum64()
+}
+
+// AddBlock adds a block named `name` on top of the blocks named `parentNames`, and returns it
+// along with the resulting change of the virtual selected parent chain.
+//
+// The GHOSTDAG data of the block are computed with no bound on the size of the anticone of the
+// blue blocks: its selected parent is the parent with the highest blue work, and all the blocks
+// of its merge set are blue. AddGHOSTDAGBlock adds blocks with red blocks in their merge sets.
+func (d *DAG) AddBlock(name string, parentNames ...string) (*Block, *ChainChange, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	if _, ok := d.names[name]; ok {
+		return nil, nil, errors.Errorf("Block %s already exists", name)
+	}
+	if len(parentNames) == 0 {
+		return nil, nil, errors.Errorf("Block %s has no parents", name)
+	}
+	parents := make([]string, len(parentNames))
+	for i, parentName := range parentNames {
+		parent, ok := d.names[parentName]
+		if !ok {
+			return nil, nil, errors.Errorf("Parent %s of block %s is unknown", parentName, name)
+		}
+		parents[i] = parent
+	}
+
+	block := &Block{
+		Parents:        parents,
+		SelectedParent: d.highestBlueWork(parents),
+		Nonce:          nonceOf(name),
+	}
+	selectedParent := d.blocks[block.SelectedParent]
+	block.MergeSetBlues = d.mergeSet(block.SelectedParent, parents)
+	block.BlueScore = selectedParent.BlueScore + uint64(len(block.MergeSetBlues))
+	block.BlueWork = selectedParent.BlueWork + uint64(len(block.MergeSetBlues))
+	block.DAAScore = selectedParent.DAAScore + uint64(len(block.MergeSetBlues))
+	d.setTimestamp(block)
+	hash, err := syntheticdag.HeaderHash(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	block.Hash = hash
+
+	chainChange, err := d.add(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	d.names[name] = block.Hash
+	return block, chainChange, nil
+}
+
+// AddGHOSTDAGBlock adds `block` with the GHOSTDAG data and the hash it holds, such as the blocks
+// generated by a syntheticdag.Generator, and returns the resulting change of the virtual
+// selected parent chain
+func (d *DAG) AddGHOSTDAGBlock(block *Block) (*ChainChange, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	for _, parent := range block.Parents {
+		if _, ok := d.blocks[parent]; !ok {
+			return nil, errors.Errorf("Parent %s of block %s is unknown", parent, block.Hash)
+		}
+	}
+	if _, ok := d.blocks[block.SelectedParent]; !ok {
+		return nil, errors.Errorf("Selected parent %s of block %s is unknown", block.SelectedParent, block.Hash)
+	}
+	return d.add(block)
+}
+
+func (d *DAG) add(block *Block) (*ChainChange, error) {
+	if _, ok := d.blocks[block.Hash]; ok {
+		return nil, errors.Errorf("Block %s already exists", block.Hash)
+	}
+
+	d.blocks[block.Hash] = block
+	for _, parent := range block.Parents {
+		d.children[parent] = append(d.children[parent], block.Hash)
+		delete(d.tips, parent)
+	}
+	d.tips[block.Hash] = struct{}{}
+	return d.updateChain(), nil
+}
+
+// setTimestamp sets the timestamp of `block` after the ones of its parents
+func (d *DAG) setTimestamp(block *Block) {
+	for _, parent := range block.Parents {
+		if d.blocks[parent].Timestamp+blockInterval > block.Timestamp {
+			block.Timestamp = d.blocks[parent].Timestamp + blockInterval
+		}
+	}
+}
+
+// highestBlueWork returns the block of `hashes` with the highest blue work, the highest hash breaking ties
+func (d *DAG) highestBlueWork(hashes []string) string {
+	highest := hashes[0]
+	for _, hash := range hashes[1:] {
+		block, highestBlock := d.blocks[hash], d.blocks[highest]
+		if block.BlueWork > highestBlock.BlueWork || (block.BlueWork == highestBlock.BlueWork && hash > highest) {
+			highest = hash
+		}
+	}
+	return highest
+}
+
+// mergeSet returns the blocks in the past of `parents` but not in the past of `selectedParent`,
+// the selected parent first and the others ordered by blue work
+func (d *DAG) mergeSet(selectedParent string, parents []string) []string {
+	selectedParentPast := d.past(selectedParent)
+	selectedParentPast[selectedParent] = struct{}{}
+
+	var mergeSet []string
+	visited := make(map[string]struct{})
+	queue := make([]string, 0, len(parents))
+	for _, parent := range parents {
+		if parent != selectedParent {
+			queue = append(queue, parent)
+		}
+	}
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		if _, ok := visited[hash]; ok {
+			continue
+		}
+		visited[hash] = struct{}{}
+		if _, ok := selectedParentPast[hash]; ok {
+			continue
+		}
+		mergeSet = append(mergeSet, hash)
+		queue = append(queue, d.blocks[hash].Parents...)
+	}
+	sort.Slice(mergeSet, func(i, j int) bool {
+		blockI, blockJ := d.blocks[mergeSet[i]], d.blocks[mergeSet[j]]
+		if blockI.BlueWork != blockJ.BlueWork {
+			return blockI.BlueWork < blockJ.BlueWork
+		}
+		return mergeSet[i] < mergeSet[j]
+	})
+	return append([]string{selectedParent}, mergeSet...)
+}
+
+// past returns the hashes of the blocks in the past of the block `hash`
+func (d *DAG) past(hash string) map[string]struct{} {
+	past := make(map[string]struct{})
+	queue := append([]string{}, d.blocks[hash].Parents...)
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if _, ok := past[current]; ok {
+			continue
+		}
+		past[current] = struct{}{}
+		queue = append(queue, d.blocks[current].Parents...)
+	}
+	return past
+}
+
+// updateChain moves the virtual selected parent chain to the tip with the highest blue work
+func (d *DAG) updateChain() *ChainChange {
+	tips := make([]string, 0, len(d.tips))
+	for tip := range d.tips {
+		tips = append(tips, tip)
+	}
+	selectedTip := d.highestBlueWork(tips)
+
+	chainChange := &ChainChange{}
+	var added []string
+	hash := selectedTip
+	for {
+		if _, ok := d.chainIndexes[hash]; ok {
+			break
+		}
+		added = append(added, hash)
+		hash = d.blocks[hash].SelectedParent
+	}
+	forkIndex := d.chainIndexes[hash]
+	for i := len(d.chain) - 1; i > forkIndex; i-- {
+		chainChange.RemovedChainBlockHashes = append(chainChange.RemovedChainBlockHashes, d.chain[i])
+		delete(d.chainIndexes, d.chain[i])
+	}
+	d.chain = d.chain[:forkIndex+1]
+	for i := len(added) - 1; i >= 0; i-- {
+		chainChange.AddedChainBlockHashes = append(chainChange.AddedChainBlockHashes, added[i])
+		d.chainIndexes[added[i]] = len(d.chain)
+		d.chain = append(d.chain, added[i])
+	}
+	return chainChange
+}
+
+// SetPruningPoint makes the chain block named `name` the pruning point of the DAG
+func (d *DAG) SetPruningPoint(name string) error {
+	d.Lock()
+	defer d.Unlock()
+
+	hash := d.names[name]
+	if _, ok := d.chainIndexes[hash]; !ok {
+		return errors.Errorf("Block %s is not in the virtual selected parent chain", name)
+	}
+	d.pruningPoint = hash
+	return nil
+}
+
+// Block returns the block `hash`, or nil if the DAG does not hold it
+func (d *DAG) Block(hash string) *Block {
+	d.RLock()
+	defer d.RUnlock()
+
+	return d.blocks[hash]
+}
+
+// BlockCount returns the number of blocks of the DAG
+func (d *DAG) BlockCount() int {
+	d.RLock()
+	defer d.RUnlock()
+
+	return len(d.blocks)
+}
+
+// SelectedTip returns the hash of the tip of the virtual selected parent chain
+func (d *DAG) SelectedTip() string {
+	d.RLock()
+	defer d.RUnlock()
+
+	return d.chain[len(d.chain)-1]
+}
+
+// PruningPoint returns the hash of the pruning point of the DAG
+func (d *DAG) PruningPoint() string {
+	d.RLock()
+	defer d.RUnlock()
+
+	return d.pruningPoint
+}
+
+// IsChainBlock returns true if the block `hash` is in the virtual selected parent chain
+func (d *DAG) IsChainBlock(hash string) bool {
+	d.RLock()
+	defer d.RUnlock()
+
+	_, ok := d.chainIndexes[hash]
+	return ok
+}
+
+// Tips returns the hashes of the tips of the DAG, ordered by hash
+func (d *DAG) Tips() []string {
+	d.RLock()
+	defer d.RUnlock()
+
+	tips := make([]string, 0, len(d.tips))
+	for tip := range d.tips {
+		tips = append(tips, tip)
+	}
+	sort.Strings(tips)
+	return tips
+}
+
+// VirtualDAAScore returns the DAA score of the virtual block, the one following the highest DAA score
+func (d *DAG) VirtualDAAScore() uint64 {
+	d.RLock()
+	defer d.RUnlock()
+
+	var virtualDAAScore uint64
+	for tip := range d.tips {
+		if d.blocks[tip].DAAScore+1 > virtualDAAScore {
+			virtualDAAScore = d.blocks[tip].DAAScore + 1
+		}
+	}
+	return virtualDAAScore
+}
+
+// Children returns the hashes of the children of the block `hash`
+func (d *DAG) Children(hash string) []string {
+	d.RLock()
+	defer d.RUnlock()
+
+	return append([]string{}, d.children[hash]...)
+}
+
+// BlocksFrom returns the hash of the block `lowHash` followed by the hashes of the blocks in the past of
+// the selected tip but not in the past of `lowHash`, ordered by blue work, up to `limit` hashes
+func (d *DAG) BlocksFrom(lowHash string, limit int) ([]string, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	if _, ok := d.blocks[lowHash]; !ok {
+		return nil, errors.Errorf("Block %s is unknown", lowHash)
+	}
+	selectedTip := d.chain[len(d.chain)-1]
+	selectedTipPast := d.past(selectedTip)
+	selectedTipPast[selectedTip] = struct{}{}
+	lowPast := d.past(lowHash)
+
+	var hashes []string
+	for hash := range selectedTipPast {
+		if _, ok := lowPast[hash]; !ok && hash != lowHash {
+			hashes = append(hashes, hash)
+		}
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		blockI, blockJ := d.blocks[hashes[i]], d.blocks[hashes[j]]
+		if blockI.BlueWork != blockJ.BlueWork {
+			return blockI.BlueWork < blockJ.BlueWork
+		}
+		return hashes[i] < hashes[j]
+	})
+	hashes = append([]string{lowHash}, hashes...)
+	if len(hashes) > limit {
+		hashes = hashes[:limit]
+	}
+	return hashes, nil
+}
+
+// ChainFromBlock returns the change of the virtual selected parent chain from the chain
+// that had the block `startHash` as its selected tip to the current one
+func (d *DAG) ChainFromBlock(startHash string) (*ChainChange, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	if _, ok := d.blocks[startHash]; !ok {
+		return nil, errors.Errorf("Block %s is unknown", startHash)
+	}
+	chainChange := &ChainChange{}
+	hash := startHash
+	for {
+		if _, ok := d.chainIndexes[hash]; ok {
+			break
+		}
+		chainChange.RemovedChainBlockHashes = append(chainChange.RemovedChainBlockHashes, hash)
+		hash = d.blocks[hash].SelectedParent
+	}
+	chainChange.AddedChainBlockHashes = append([]string{}, d.chain[d.chainIndexes[hash]+1:]...)
+	return chainChange, nil
+}
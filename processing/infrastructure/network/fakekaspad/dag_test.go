@@ -0,0 +1,162 @@
+package fakekaspad
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kaspanet/kaspad/app/appmessage"
+	"github.com/kaspanet/kaspad/domain/consensus/utils/consensushashing"
+)
+
+// addBlocks adds `blocks` to `dag` in order, each one given by its name followed by the names of its parents,
+// and returns the chain change resulting from the last one
+func addBlocks(t *testing.T, dag *DAG, blocks ...[]string) *ChainChange {
+	t.Helper()
+
+	var chainChange *ChainChange
+	for _, names := range blocks {
+		var err error
+		_, chainChange, err = dag.AddBlock(names[0], names[1:]...)
+		if err != nil {
+			t.Fatalf("AddBlock(%s): %s", names[0], err)
+		}
+	}
+	return chainChange
+}
+
+// hashesOf returns the hashes of the blocks named `names`
+func hashesOf(dag *DAG, names ...string) []string {
+	hashes := make([]string, len(names))
+	for i, name := range names {
+		hashes[i] = dag.HashOf(name)
+	}
+	return hashes
+}
+
+func TestAddBlockExtendsChain(t *testing.T) {
+	dag := NewDAG()
+
+	chainChange := addBlocks(t, dag, []string{"a", GenesisName})
+	if len(chainChange.RemovedChainBlockHashes) != 0 {
+		t.Fatalf("Removed chain blocks %v, expected none", chainChange.RemovedChainBlockHashes)
+	}
+	if !reflect.DeepEqual(chainChange.AddedChainBlockHashes, hashesOf(dag, "a")) {
+		t.Fatalf("Added chain blocks %v, expected a", chainChange.AddedChainBlockHashes)
+	}
+
+	// A block with less blue work than the selected tip leaves the chain as is
+	chainChange = addBlocks(t, dag, []string{"b", "a"}, []string{"c", GenesisName})
+	if len(chainChange.RemovedChainBlockHashes) != 0 || len(chainChange.AddedChainBlockHashes) != 0 {
+		t.Fatalf("Chain changed to %+v, expected no change", chainChange)
+	}
+	if dag.SelectedTip() != dag.HashOf("b") {
+		t.Fatalf("Selected tip is %s, expected b", dag.SelectedTip())
+	}
+	if dag.IsChainBlock(dag.HashOf("c")) {
+		t.Fatalf("Block c is in the chain")
+	}
+}
+
+func TestAddBlockReorganizesChain(t *testing.T) {
+	dag := NewDAG()
+	addBlocks(t, dag,
+		[]string{"a1", GenesisName}, []string{"a2", "a1"}, []string{"a3", "a2"},
+		[]string{"b1", GenesisName}, []string{"b2", "b1"})
+
+	// The selected parent of c is b2, and a1 in its merge set gives it more blue work than a3
+	chainChange := addBlocks(t, dag, []string{"c", "b2", "a1"})
+	if !reflect.DeepEqual(chainChange.RemovedChainBlockHashes, hashesOf(dag, "a3", "a2", "a1")) {
+		t.Fatalf("Removed chain blocks %v, expected a3, a2 and a1", chainChange.RemovedChainBlockHashes)
+	}
+	if !reflect.DeepEqual(chainChange.AddedChainBlockHashes, hashesOf(dag, "b1", "b2", "c")) {
+		t.Fatalf("Added chain blocks %v, expected b1, b2 and c", chainChange.AddedChainBlockHashes)
+	}
+
+	c := dag.Block(dag.HashOf("c"))
+	if c.SelectedParent != dag.HashOf("b2") {
+		t.Fatalf("Selected parent of c is %s, expected b2", c.SelectedParent)
+	}
+	if !reflect.DeepEqual(c.MergeSetBlues, hashesOf(dag, "b2", "a1")) {
+		t.Fatalf("Merge set blues of c are %v, expected b2 and a1", c.MergeSetBlues)
+	}
+	for _, name := range []string{"a1", "a2", "a3"} {
+		if dag.IsChainBlock(dag.HashOf(name)) {
+			t.Fatalf("Block %s is still in the chain", name)
+		}
+	}
+
+	// The node reports the same change to a client whose selected tip was a3
+	chainFromA3, err := dag.ChainFromBlock(dag.HashOf("a3"))
+	if err != nil {
+		t.Fatalf("ChainFromBlock: %s", err)
+	}
+	if !reflect.DeepEqual(chainFromA3, chainChange) {
+		t.Fatalf("Chain from a3 is %+v, expected %+v", chainFromA3, chainChange)
+	}
+}
+
+func TestAddBlockErrors(t *testing.T) {
+	dag := NewDAG()
+	addBlocks(t, dag, []string{"a", GenesisName})
+
+	_, _, err := dag.AddBlock("a", GenesisName)
+	if err == nil {
+		t.Fatalf("Adding block a twice did not fail")
+	}
+	_, _, err = dag.AddBlock("b", "unknown")
+	if err == nil {
+		t.Fatalf("Adding a block on an unknown parent did not fail")
+	}
+	_, _, err = dag.AddBlock("c")
+	if err == nil {
+		t.Fatalf("Adding a block without parents did not fail")
+	}
+	if dag.BlockCount() != 2 {
+		t.Fatalf("DAG holds %d blocks, expected 2", dag.BlockCount())
+	}
+}
+
+func TestSetPruningPoint(t *testing.T) {
+	dag := NewDAG()
+	addBlocks(t, dag, []string{"a", GenesisName}, []string{"b", "a"}, []string{"c", GenesisName})
+
+	err := dag.SetPruningPoint("c")
+	if err == nil {
+		t.Fatalf("Setting a block out of the chain as the pruning point did not fail")
+	}
+	err = dag.SetPruningPoint("a")
+	if err != nil {
+		t.Fatalf("SetPruningPoint: %s", err)
+	}
+	if dag.PruningPoint() != dag.HashOf("a") {
+		t.Fatalf("Pruning point is %s, expected a", dag.PruningPoint())
+	}
+}
+
+func TestRPCBlockHash(t *testing.T) {
+	dag := NewDAG()
+	addBlocks(t, dag, []string{"a", GenesisName}, []string{"b", GenesisName}, []string{"c", "a", "b"})
+
+	for _, name := range []string{GenesisName, "a", "b", "c"} {
+		block := dag.Block(dag.HashOf(name))
+		rpcBlock, err := dag.rpcBlock(block)
+		if err != nil {
+			t.Fatalf("rpcBlock(%s): %s", name, err)
+		}
+		// The processing identifies the blocks by the hash of the header it receives
+		domainBlock, err := appmessage.RPCBlockToDomainBlock(rpcBlock)
+		if err != nil {
+			t.Fatalf("RPCBlockToDomainBlock(%s): %s", name, err)
+		}
+		hash := consensushashing.BlockHash(domainBlock).String()
+		if hash != block.Hash {
+			t.Fatalf("Block %s received with hash %s, expected %s", name, hash, block.Hash)
+		}
+		if rpcBlock.VerboseData.Hash != block.Hash {
+			t.Fatalf("Block %s reported with hash %s, expected %s", name, rpcBlock.VerboseData.Hash, block.Hash)
+		}
+	}
+	if dag.HashOf("a") == dag.HashOf("b") {
+		t.Fatalf("Blocks a and b sharing their parents got the same hash")
+	}
+}
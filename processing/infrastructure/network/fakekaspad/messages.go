@@ -0,0 +1,54 @@
+package fakekaspad
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/syntheticdag"
+	"github.com/kaspanet/kaspad/app/appmessage"
+	"github.com/kaspanet/kaspad/domain/consensus/model/externalapi"
+	"github.com/pkg/errors"
+)
+
+// rpcBlock converts `block` to the block kaspad sends over RPC, with its header and no transactions
+func (d *DAG) rpcBlock(block *Block) (*appmessage.RPCBlock, error) {
+	header, err := syntheticdag.Header(block)
+	if err != nil {
+		return nil, err
+	}
+	rpcBlock := appmessage.DomainBlockToRPCBlock(&externalapi.DomainBlock{Header: header})
+	rpcBlock.VerboseData = &appmessage.RPCBlockVerboseData{
+		Hash:                block.Hash,
+		SelectedParentHash:  block.SelectedParent,
+		BlueScore:           block.BlueScore,
+		ChildrenHashes:      d.Children(block.Hash),
+		MergeSetBluesHashes: block.MergeSetBlues,
+		MergeSetRedsHashes:  block.MergeSetReds,
+		IsChainBlock:        d.IsChainBlock(block.Hash),
+	}
+	return rpcBlock, nil
+}
+
+// rpcError returns an RPC error with the message formatted from `format` and `args`
+func rpcError(format string, args ...interface{}) *appmessage.RPCError {
+	return &appmessage.RPCError{Message: fmt.Sprintf(format, args...)}
+}
+
+// stringArgument returns the string field `name` of `request`.
+// The request messages hold their arguments in fields named after them.
+func stringArgument(request appmessage.Message, name string) (string, error) {
+	field := reflect.ValueOf(request).Elem().FieldByName(name)
+	if !field.IsValid() || field.Kind() != reflect.String {
+		return "", errors.Errorf("%s has no %s argument", request.Command(), name)
+	}
+	return field.String(), nil
+}
+
+// boolArgument returns the boolean field `name` of `request`
+func boolArgument(request appmessage.Message, name string) (bool, error) {
+	field := reflect.ValueOf(request).Elem().FieldByName(name)
+	if !field.IsValid() || field.Kind() != reflect.Bool {
+		return false, errors.Errorf("%s has no %s argument", request.Command(), name)
+	}
+	return field.Bool(), nil
+}
@@ -0,0 +1,330 @@
+package fakekaspad
+
+import (
+	"context"
+	"sync"
+
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/logging"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/network/rpcclient"
+	"github.com/kaspanet/kaspad/app/appmessage"
+	"github.com/kaspanet/kaspad/version"
+	"github.com/pkg/errors"
+)
+
+var log = logging.Logger()
+
+const (
+	// Address is the address the clients of a Server report in their logs
+	Address = "fakekaspad"
+
+	defaultNetworkName = "kaspa-simnet"
+	maxGetBlocksHashes = 1000
+)
+
+// subscriptions maps the notifications to the requests subscribing to them
+var subscriptions = map[appmessage.MessageCommand]appmessage.MessageCommand{
+	appmessage.CmdBlockAddedNotificationMessage:                        appmessage.CmdNotifyBlockAddedRequestMessage,
+	appmessage.CmdVirtualSelectedParentChainChangedNotificationMessage: appmessage.CmdNotifyVirtualSelectedParentChainChangedRequestMessage,
+}
+
+// event is either a notification or, when notification is nil, a reconnection
+type event struct {
+	notification appmessage.Message
+}
+
+// Server is a fake kaspad RPC server serving a DAG to an RPC client within the process,
+// for the processing to be run without a node. The DAG is scripted through the server,
+// which notifies its client of the added blocks and of the changes of the virtual
+// selected parent chain, the way kaspad does.
+//
+// A server serves a single client at a time.
+type Server struct {
+	dag         *DAG
+	networkName string
+	isSynced    bool
+	hidden      map[string]struct{}
+
+	subscribed map[appmessage.MessageCommand]struct{}
+	pending    []*event
+	delivering bool
+	wake       chan struct{}
+	delivered  chan struct{}
+	sync.Mutex
+}
+
+// NewServer creates a server serving `dag`
+func NewServer(dag *DAG) *Server {
+	return &Server{
+		dag:         dag,
+		networkName: defaultNetworkName,
+		isSynced:    true,
+		hidden:      make(map[string]struct{}),
+		subscribed:  make(map[appmessage.MessageCommand]struct{}),
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// NewRPCClient creates an RPC client served by the server
+func (s *Server) NewRPCClient(routeCapacity int) (*rpcclient.RPCClient, error) {
+	return rpcclient.NewInProcessRPCClient(s, Address, routeCapacity)
+}
+
+// DAG returns the DAG served by the server
+func (s *Server) DAG() *DAG {
+	return s.dag
+}
+
+// SetNetworkName sets the network name the server reports
+func (s *Server) SetNetworkName(networkName string) {
+	s.Lock()
+	defer s.Unlock()
+	s.networkName = networkName
+}
+
+// SetSynced sets whether the server reports being synced, the processing waiting for it to be
+func (s *Server) SetSynced(isSynced bool) {
+	s.Lock()
+	defer s.Unlock()
+	s.isSynced = isSynced
+}
+
+// SetHidden sets whether the server answers the requests for the block named `name` as if it did not know it,
+// the way a node does for the blocks it did not sync
+func (s *Server) SetHidden(name string, isHidden bool) {
+	s.Lock()
+	defer s.Unlock()
+	if isHidden {
+		s.hidden[s.dag.HashOf(name)] = struct{}{}
+	} else {
+		delete(s.hidden, s.dag.HashOf(name))
+	}
+}
+
+// AddBlock adds a block named `name` on top of the blocks named `parentNames` to the DAG, see DAG.AddBlock,
+// and notifies the client of it
+func (s *Server) AddBlock(name string, parentNames ...string) (*Block, error) {
+	block, chainChange, err := s.dag.AddBlock(name, parentNames...)
+	if err != nil {
+		return nil, err
+	}
+	err = s.notifyBlockAdded(block, chainChange)
+	if err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// AddGHOSTDAGBlock adds `block` to the DAG, see DAG.AddGHOSTDAGBlock, and notifies the client of it
+func (s *Server) AddGHOSTDAGBlock(block *Block) error {
+	chainChange, err := s.dag.AddGHOSTDAGBlock(block)
+	if err != nil {
+		return err
+	}
+	return s.notifyBlockAdded(block, chainChange)
+}
+
+func (s *Server) notifyBlockAdded(block *Block, chainChange *ChainChange) error {
+	rpcBlock, err := s.dag.rpcBlock(block)
+	if err != nil {
+		return err
+	}
+	s.push(&event{notification: &appmessage.BlockAddedNotificationMessage{Block: rpcBlock}})
+	if len(chainChange.RemovedChainBlockHashes) > 0 || len(chainChange.AddedChainBlockHashes) > 0 {
+		s.push(&event{notification: &appmessage.VirtualSelectedParentChainChangedNotificationMessage{
+			RemovedChainBlockHashes: chainChange.RemovedChainBlockHashes,
+			AddedChainBlockHashes:   chainChange.AddedChainBlockHashes,
+		}})
+	}
+	return nil
+}
+
+// Reconnect makes the client lose its connection and reconnect, once the pending notifications got delivered.
+// The subscriptions of the client are lost along with its connection.
+func (s *Server) Reconnect() {
+	s.push(&event{})
+}
+
+func (s *Server) push(e *event) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.pending = append(s.pending, e)
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// WaitDelivered waits for the pending notifications and reconnections to be delivered to the client,
+// each notification being delivered once the handler of the client returned
+func (s *Server) WaitDelivered(ctx context.Context) error {
+	s.Lock()
+	if len(s.pending) == 0 && !s.delivering {
+		s.Unlock()
+		return nil
+	}
+	if s.delivered == nil {
+		s.delivered = make(chan struct{})
+	}
+	delivered := s.delivered
+	s.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-delivered:
+		return nil
+	}
+}
+
+// Serve delivers the pending notifications and reconnections, one at a time. The notifications
+// the client did not subscribe to are dropped, as kaspad does not send them.
+func (s *Server) Serve(ctx context.Context, connection *rpcclient.InProcessConnection) {
+	for {
+		s.Lock()
+		if len(s.pending) == 0 {
+			s.Unlock()
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.wake:
+			}
+			continue
+		}
+		e := s.pending[0]
+		s.pending = s.pending[1:]
+		s.delivering = true
+		isSubscribed := true
+		if e.notification != nil {
+			_, isSubscribed = s.subscribed[subscriptions[e.notification.Command()]]
+		} else {
+			s.subscribed = make(map[appmessage.MessageCommand]struct{})
+		}
+		s.Unlock()
+
+		var err error
+		if e.notification == nil {
+			err = connection.Reconnect()
+		} else if isSubscribed {
+			err = connection.Notify(ctx, e.notification)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Warnf("Could not deliver an event to the client: %s", err)
+		}
+
+		s.Lock()
+		s.delivering = false
+		if len(s.pending) == 0 && s.delivered != nil {
+			close(s.delivered)
+			s.delivered = nil
+		}
+		s.Unlock()
+	}
+}
+
+// HandleRequest answers the requests the processing sends to kaspad
+func (s *Server) HandleRequest(request appmessage.Message) (appmessage.Message, error) {
+	switch request.Command() {
+	case appmessage.CmdGetInfoRequestMessage:
+		s.Lock()
+		defer s.Unlock()
+		return &appmessage.GetInfoResponseMessage{
+			P2PID:         Address,
+			ServerVersion: version.Version(),
+			IsSynced:      s.isSynced,
+		}, nil
+
+	case appmessage.CmdGetBlockDAGInfoRequestMessage:
+		s.Lock()
+		networkName := s.networkName
+		s.Unlock()
+		blockCount := uint64(s.dag.BlockCount())
+		return &appmessage.GetBlockDAGInfoResponseMessage{
+			NetworkName:         networkName,
+			BlockCount:          blockCount,
+			HeaderCount:         blockCount,
+			TipHashes:           s.dag.Tips(),
+			VirtualParentHashes: s.dag.Tips(),
+			PruningPointHash:    s.dag.PruningPoint(),
+			VirtualDAAScore:     s.dag.VirtualDAAScore(),
+		}, nil
+
+	case appmessage.CmdGetBlockRequestMessage:
+		hash, err := stringArgument(request, "Hash")
+		if err != nil {
+			return nil, err
+		}
+		s.Lock()
+		_, isHidden := s.hidden[hash]
+		s.Unlock()
+		block := s.dag.Block(hash)
+		if block == nil || isHidden {
+			return &appmessage.GetBlockResponseMessage{Error: rpcError("Block %s not found", hash)}, nil
+		}
+		rpcBlock, err := s.dag.rpcBlock(block)
+		if err != nil {
+			return nil, err
+		}
+		return &appmessage.GetBlockResponseMessage{Block: rpcBlock}, nil
+
+	case appmessage.CmdGetBlocksRequestMessage:
+		lowHash, err := stringArgument(request, "LowHash")
+		if err != nil {
+			return nil, err
+		}
+		includeBlocks, err := boolArgument(request, "IncludeBlocks")
+		if err != nil {
+			return nil, err
+		}
+		hashes, err := s.dag.BlocksFrom(lowHash, maxGetBlocksHashes)
+		if err != nil {
+			return &appmessage.GetBlocksResponseMessage{Error: rpcError("%s", err)}, nil
+		}
+		response := &appmessage.GetBlocksResponseMessage{BlockHashes: hashes}
+		if includeBlocks {
+			for _, hash := range hashes {
+				rpcBlock, err := s.dag.rpcBlock(s.dag.Block(hash))
+				if err != nil {
+					return nil, err
+				}
+				response.Blocks = append(response.Blocks, rpcBlock)
+			}
+		}
+		return response, nil
+
+	case appmessage.CmdGetSelectedTipHashRequestMessage:
+		return &appmessage.GetSelectedTipHashResponseMessage{SelectedTipHash: s.dag.SelectedTip()}, nil
+
+	case appmessage.CmdGetVirtualSelectedParentChainFromBlockRequestMessage:
+		startHash, err := stringArgument(request, "StartHash")
+		if err != nil {
+			return nil, err
+		}
+		chainChange, err := s.dag.ChainFromBlock(startHash)
+		if err != nil {
+			return &appmessage.GetVirtualSelectedParentChainFromBlockResponseMessage{Error: rpcError("%s", err)}, nil
+		}
+		return &appmessage.GetVirtualSelectedParentChainFromBlockResponseMessage{
+			RemovedChainBlockHashes: chainChange.RemovedChainBlockHashes,
+			AddedChainBlockHashes:   chainChange.AddedChainBlockHashes,
+		}, nil
+
+	case appmessage.CmdNotifyBlockAddedRequestMessage:
+		s.subscribe(request.Command())
+		return &appmessage.NotifyBlockAddedResponseMessage{}, nil
+
+	case appmessage.CmdNotifyVirtualSelectedParentChainChangedRequestMessage:
+		s.subscribe(request.Command())
+		return &appmessage.NotifyVirtualSelectedParentChainChangedResponseMessage{}, nil
+	}
+	return nil, errors.Errorf("%s is not implemented", request.Command())
+}
+
+func (s *Server) subscribe(command appmessage.MessageCommand) {
+	s.Lock()
+	defer s.Unlock()
+	s.subscribed[command] = struct{}{}
+}
@@ -0,0 +1,147 @@
+package rpcclient
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/metrics"
+	"github.com/kaspanet/kaspad/app/appmessage"
+	"github.com/pkg/errors"
+)
+
+// InProcessServer plays the part of the RPC server for a client created by NewInProcessRPCClient
+type InProcessServer interface {
+	// HandleRequest returns the response to `request`, or nil to let the request time out
+	HandleRequest(request appmessage.Message) (appmessage.Message, error)
+
+	// Serve sends the notifications and the reconnections of the server through `connection`
+	// until `ctx` is done, which happens when the client closes
+	Serve(ctx context.Context, connection *InProcessConnection)
+}
+
+// InProcessConnection lets an InProcessServer send notifications to its client
+type InProcessConnection struct {
+	client  *RPCClient
+	handled chan struct{}
+}
+
+// NewInProcessRPCClient creates an RPC client served by `server` within the process instead of
+// connecting to an RPC server. `address` names the server in the logs.
+func NewInProcessRPCClient(server InProcessServer, address string, routeCapacity int) (*RPCClient, error) {
+	if routeCapacity == 0 {
+		routeCapacity = defaultRouteCapacity
+	}
+
+	rpcClient := &RPCClient{
+		rpcAddress:     address,
+		timeout:        defaultTimeout,
+		reconnectDelay: defaultReconnectDelay,
+		routeCapacity:  routeCapacity,
+		server:         server,
+	}
+	err := rpcClient.connectInProcess()
+	if err != nil {
+		return nil, err
+	}
+
+	var ctx context.Context
+	ctx, rpcClient.stopServer = context.WithCancel(context.Background())
+	rpcClient.connection = &InProcessConnection{
+		client:  rpcClient,
+		handled: make(chan struct{}, 1),
+	}
+	spawn("NewInProcessRPCClient-Serve", func() {
+		server.Serve(ctx, rpcClient.connection)
+	})
+
+	log.Infof("Connected to %s", address)
+	return rpcClient, nil
+}
+
+// connectInProcess attaches a new RPC router to the in-process server
+func (c *RPCClient) connectInProcess() error {
+	rpcRouter, err := buildRPCRouter(c.routeCapacity)
+	if err != nil {
+		return errors.Wrapf(err, "error creating the RPC router")
+	}
+	c.setRouter(rpcRouter)
+	atomic.StoreUint32(&c.isConnected, 1)
+	spawn("connectInProcess-serveInProcessRequests", func() {
+		c.serveInProcessRequests(rpcRouter)
+	})
+	return nil
+}
+
+// serveInProcessRequests answers the requests sent through `rpcRouter` until it closes
+func (c *RPCClient) serveInProcessRequests(rpcRouter *rpcRouter) {
+	for {
+		request, err := rpcRouter.outgoingRoute().Dequeue()
+		if err != nil {
+			return
+		}
+		response, err := c.server.HandleRequest(request)
+		if err != nil {
+			log.Warnf("Could not handle %s: %s", request.Command(), err)
+			continue
+		}
+		if response == nil {
+			log.Warnf("No response to %s, the request will time out", request.Command())
+			continue
+		}
+		err = rpcRouter.routes[response.Command()].Enqueue(response)
+		if err != nil {
+			log.Warnf("Could not respond to %s: %s", request.Command(), err)
+		}
+	}
+}
+
+// notificationHandled reports to the in-process server that the last notification got handled
+func (c *RPCClient) notificationHandled() {
+	if c.connection == nil {
+		return
+	}
+	select {
+	case c.connection.handled <- struct{}{}:
+	default:
+	}
+}
+
+// Notify sends `notification` to the client and waits for its handler to return.
+// The client must have subscribed to the notification, otherwise Notify waits until `ctx` is done.
+func (c *InProcessConnection) Notify(ctx context.Context, notification appmessage.Message) error {
+	err := c.client.route(notification.Command()).Enqueue(notification)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.handled:
+		return nil
+	}
+}
+
+// Reconnect simulates a loss of the connection to the server followed by a reconnection:
+// the pending requests fail, the notification listeners stop, and the handler set by
+// SetOnReconnectedHandler gets called once a new connection is ready
+func (c *InProcessConnection) Reconnect() error {
+	client := c.client
+	if atomic.LoadUint32(&client.isClosed) == 1 {
+		return errors.Errorf("Cannot reconnect from a closed client")
+	}
+	log.Warnf("Attempting to reconnect to %s", client.rpcAddress)
+	atomic.StoreUint32(&client.isConnected, 0)
+	client.router().router.Close()
+	err := client.connectInProcess()
+	if err != nil {
+		return err
+	}
+	metrics.RPCReconnected()
+	log.Infof("Connected to %s", client.rpcAddress)
+
+	client.recordReconnection()
+	if client.onReconnectedHandler != nil {
+		client.onReconnectedHandler()
+	}
+	return nil
+}
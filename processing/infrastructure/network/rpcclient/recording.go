@@ -0,0 +1,151 @@
+package rpcclient
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kaspanet/kaspad/app/appmessage"
+	"github.com/pkg/errors"
+)
+
+// reconnectedCommand is the pseudo command of the records of the reconnections to the RPC server
+const reconnectedCommand = "Reconnected"
+
+// record is a line of a recording. It holds either a response along with its request,
+// a notification, or a reconnection to the RPC server.
+type record struct {
+	// Time is the Unix time in milliseconds at which the message was received
+	Time    int64           `json:"t"`
+	Command string          `json:"c"`
+	Message json.RawMessage `json:"m,omitempty"`
+	// RequestCommand and Request are only set for the responses
+	RequestCommand string          `json:"rc,omitempty"`
+	Request        json.RawMessage `json:"r,omitempty"`
+}
+
+// recordedMessages lists the messages a recording can be replayed with, those the processing uses
+var recordedMessages = map[appmessage.MessageCommand]func() appmessage.Message{
+	appmessage.CmdGetInfoResponseMessage:         func() appmessage.Message { return &appmessage.GetInfoResponseMessage{} },
+	appmessage.CmdGetBlockDAGInfoResponseMessage: func() appmessage.Message { return &appmessage.GetBlockDAGInfoResponseMessage{} },
+	appmessage.CmdGetBlockResponseMessage:        func() appmessage.Message { return &appmessage.GetBlockResponseMessage{} },
+	appmessage.CmdGetBlocksResponseMessage:       func() appmessage.Message { return &appmessage.GetBlocksResponseMessage{} },
+	appmessage.CmdGetSelectedTipHashResponseMessage: func() appmessage.Message {
+		return &appmessage.GetSelectedTipHashResponseMessage{}
+	},
+	appmessage.CmdGetVirtualSelectedParentChainFromBlockResponseMessage: func() appmessage.Message {
+		return &appmessage.GetVirtualSelectedParentChainFromBlockResponseMessage{}
+	},
+	appmessage.CmdNotifyBlockAddedResponseMessage: func() appmessage.Message {
+		return &appmessage.NotifyBlockAddedResponseMessage{}
+	},
+	appmessage.CmdNotifyVirtualSelectedParentChainChangedResponseMessage: func() appmessage.Message {
+		return &appmessage.NotifyVirtualSelectedParentChainChangedResponseMessage{}
+	},
+	appmessage.CmdBlockAddedNotificationMessage: func() appmessage.Message {
+		return &appmessage.BlockAddedNotificationMessage{}
+	},
+	appmessage.CmdVirtualSelectedParentChainChangedNotificationMessage: func() appmessage.Message {
+		return &appmessage.VirtualSelectedParentChainChangedNotificationMessage{}
+	},
+}
+
+// recorder appends the messages received from the RPC server to a recording file,
+// one JSON record per line
+type recorder struct {
+	file *os.File
+	sync.Mutex
+}
+
+func openRecorder(path string) (*recorder, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &recorder{file: file}, nil
+}
+
+func (r *recorder) write(command string, message appmessage.Message, request appmessage.Message) error {
+	rec := &record{
+		Time:    time.Now().UnixMilli(),
+		Command: command,
+	}
+	var err error
+	if message != nil {
+		rec.Message, err = json.Marshal(message)
+		if err != nil {
+			return err
+		}
+	}
+	if request != nil {
+		rec.RequestCommand = request.Command().String()
+		rec.Request, err = json.Marshal(request)
+		if err != nil {
+			return err
+		}
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	// A single write per record keeps the records whole when several goroutines record
+	_, err = r.file.Write(append(line, '\n'))
+	return err
+}
+
+func (r *recorder) close() error {
+	r.Lock()
+	defer r.Unlock()
+	return r.file.Close()
+}
+
+// Record appends every response received from the RPC server, along with its request, and every
+// notification to the recording file at `path`, for the recording to be replayed later on by a client
+// created with NewReplayRPCClient
+func (c *RPCClient) Record(path string) error {
+	if c.recorder != nil {
+		return errors.Errorf("The client is already recording")
+	}
+	recorder, err := openRecorder(path)
+	if err != nil {
+		// enhanced error description
+		return errors.Wrapf(err, "Could not open the recording %s", path)
+	}
+	c.recorder = recorder
+	log.Infof("Recording to %s", path)
+	return nil
+}
+
+func (c *RPCClient) recordResponse(request appmessage.Message, response appmessage.Message) {
+	if c.recorder == nil {
+		return
+	}
+	err := c.recorder.write(response.Command().String(), response, request)
+	if err != nil {
+		log.Warnf("Could not record the response to %s: %s", request.Command(), err)
+	}
+}
+
+func (c *RPCClient) recordNotification(notification appmessage.Message) {
+	if c.recorder == nil {
+		return
+	}
+	err := c.recorder.write(notification.Command().String(), notification, nil)
+	if err != nil {
+		log.Warnf("Could not record %s: %s", notification.Command(), err)
+	}
+}
+
+func (c *RPCClient) recordReconnection() {
+	if c.recorder == nil {
+		return
+	}
+	err := c.recorder.write(reconnectedCommand, nil, nil)
+	if err != nil {
+		log.Warnf("Could not record the reconnection: %s", err)
+	}
+}
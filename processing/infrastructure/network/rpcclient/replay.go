@@ -0,0 +1,254 @@
+package rpcclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"reflect"
+	"sync"
+
+	"github.com/kaspanet/kaspad/app/appmessage"
+	"github.com/pkg/errors"
+)
+
+// notificationSubscriptions maps the notifications to the requests subscribing to them
+var notificationSubscriptions = map[appmessage.MessageCommand]appmessage.MessageCommand{
+	appmessage.CmdBlockAddedNotificationMessage:                        appmessage.CmdNotifyBlockAddedRequestMessage,
+	appmessage.CmdVirtualSelectedParentChainChangedNotificationMessage: appmessage.CmdNotifyVirtualSelectedParentChainChangedRequestMessage,
+}
+
+// replayedEvent is either a notification or, when notification is nil, a reconnection to the RPC server
+type replayedEvent struct {
+	notification appmessage.Message
+}
+
+// replayer is the InProcessServer of a client replaying a recording
+type replayer struct {
+	path string
+	// responses holds the recorded responses of each request, in the order they got received
+	responses map[string][]appmessage.Message
+	// responseCommands maps the commands of the recorded requests to the commands of their responses
+	responseCommands map[string]appmessage.MessageCommand
+	events           []*replayedEvent
+
+	// missingSubscriptions lists the subscriptions the notifications wait for to be replayed
+	missingSubscriptions map[appmessage.MessageCommand]struct{}
+	subscribed           chan struct{}
+	barrier              func(ctx context.Context) error
+	done                 chan struct{}
+	sync.Mutex
+}
+
+// NewReplayRPCClient creates an RPC client replaying the recording at `path`, written by Record,
+// instead of connecting to an RPC server.
+//
+// Requests get the responses recorded for the same requests, in the recorded order, the last one
+// being repeated once they are exhausted. A request never recorded gets an RPC error response.
+// The notifications and the reconnections get replayed in the recorded order once the client
+// subscribed to all the recorded notifications, each waiting for the previous one to be handled
+// and for the barrier set by SetReplayBarrier.
+func NewReplayRPCClient(path string, routeCapacity int) (*RPCClient, error) {
+	replayer, err := loadReplayer(path)
+	if err != nil {
+		// enhanced error description
+		return nil, errors.Wrapf(err, "Could not load the recording %s", path)
+	}
+	log.Infof("Replaying %s (%d notifications and reconnections)", path, len(replayer.events))
+	return NewInProcessRPCClient(replayer, "replay of "+path, routeCapacity)
+}
+
+func loadReplayer(path string) (*replayer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	commands := make(map[string]appmessage.MessageCommand, len(appmessage.RPCMessageCommandToString))
+	for command, name := range appmessage.RPCMessageCommandToString {
+		commands[name] = command
+	}
+
+	r := &replayer{
+		path:                 path,
+		responses:            make(map[string][]appmessage.Message),
+		responseCommands:     make(map[string]appmessage.MessageCommand),
+		missingSubscriptions: make(map[appmessage.MessageCommand]struct{}),
+		subscribed:           make(chan struct{}),
+		done:                 make(chan struct{}),
+	}
+
+	const maxRecordSize = 64 * 1024 * 1024
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 1024*1024), maxRecordSize)
+	lineNumber := 0
+	var lastLineError error
+	for scanner.Scan() {
+		lineNumber++
+		if lastLineError != nil {
+			return nil, lastLineError
+		}
+		// The last line may be incomplete if the recording process got killed
+		lastLineError = r.load(scanner.Bytes(), commands)
+		if lastLineError != nil {
+			lastLineError = errors.Wrapf(lastLineError, "line %d", lineNumber)
+		}
+	}
+	if lastLineError != nil {
+		log.Warnf("Ignoring the last line of %s: %s", path, lastLineError)
+	}
+	err = scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(r.missingSubscriptions) == 0 {
+		close(r.subscribed)
+	}
+	return r, nil
+}
+
+// load adds the record held by `line` to the replayer
+func (r *replayer) load(line []byte, commands map[string]appmessage.MessageCommand) error {
+	rec := &record{}
+	err := json.Unmarshal(line, rec)
+	if err != nil {
+		return err
+	}
+	if rec.Command == reconnectedCommand {
+		r.events = append(r.events, &replayedEvent{})
+		return nil
+	}
+
+	command, ok := commands[rec.Command]
+	if !ok {
+		return errors.Errorf("unknown command %s", rec.Command)
+	}
+	newMessage, ok := recordedMessages[command]
+	if !ok {
+		// The processing does not use this message
+		return nil
+	}
+	message := newMessage()
+	err = json.Unmarshal(rec.Message, message)
+	if err != nil {
+		return err
+	}
+
+	if rec.RequestCommand == "" {
+		r.events = append(r.events, &replayedEvent{notification: message})
+		subscription, ok := notificationSubscriptions[command]
+		if ok {
+			r.missingSubscriptions[subscription] = struct{}{}
+		}
+		return nil
+	}
+	key := requestKey(rec.RequestCommand, rec.Request)
+	r.responses[key] = append(r.responses[key], message)
+	r.responseCommands[rec.RequestCommand] = command
+	return nil
+}
+
+// requestKey identifies the requests with the same command and content
+func requestKey(command string, request []byte) string {
+	return command + string(request)
+}
+
+// HandleRequest returns the recorded response to `request`, nil if its command was never recorded
+func (r *replayer) HandleRequest(request appmessage.Message) (appmessage.Message, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	command := request.Command()
+	if _, ok := r.missingSubscriptions[command]; ok {
+		delete(r.missingSubscriptions, command)
+		if len(r.missingSubscriptions) == 0 {
+			close(r.subscribed)
+		}
+	}
+
+	content, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	key := requestKey(command.String(), content)
+	responses := r.responses[key]
+	if len(responses) > 0 {
+		response := responses[0]
+		if len(responses) > 1 {
+			r.responses[key] = responses[1:]
+		}
+		return response, nil
+	}
+
+	responseCommand, ok := r.responseCommands[command.String()]
+	if !ok {
+		return nil, nil
+	}
+	log.Warnf("%s %s was not recorded", command, content)
+	response := recordedMessages[responseCommand]()
+	errorField := reflect.ValueOf(response).Elem().FieldByName("Error")
+	errorField.Set(reflect.ValueOf(&appmessage.RPCError{Message: "request not recorded"}))
+	return response, nil
+}
+
+// Serve replays the recorded notifications and reconnections, one at a time
+func (r *replayer) Serve(ctx context.Context, connection *InProcessConnection) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-r.subscribed:
+	}
+
+	for _, event := range r.events {
+		if event.notification == nil {
+			err := connection.Reconnect()
+			if err != nil {
+				log.Warnf("Could not replay a reconnection: %s", err)
+				return
+			}
+		} else {
+			err := connection.Notify(ctx, event.notification)
+			if err != nil {
+				log.Warnf("Could not replay %s: %s", event.notification.Command(), err)
+				return
+			}
+		}
+
+		r.Lock()
+		barrier := r.barrier
+		r.Unlock()
+		if barrier != nil {
+			err := barrier(ctx)
+			if err != nil {
+				return
+			}
+		}
+	}
+	log.Infof("Replayed the %d notifications and reconnections of %s", len(r.events), r.path)
+	close(r.done)
+}
+
+// SetReplayBarrier makes a replay client wait for `barrier` to return after each replayed
+// notification or reconnection before replaying the next one. Does nothing if the client
+// does not replay a recording.
+func (c *RPCClient) SetReplayBarrier(barrier func(ctx context.Context) error) {
+	replayer, ok := c.server.(*replayer)
+	if !ok {
+		return
+	}
+	replayer.Lock()
+	defer replayer.Unlock()
+	replayer.barrier = barrier
+}
+
+// ReplayDone returns a channel that is closed once a replay client replayed all the notifications
+// and the reconnections of its recording. Returns nil if the client does not replay a recording.
+func (c *RPCClient) ReplayDone() <-chan struct{} {
+	replayer, ok := c.server.(*replayer)
+	if !ok {
+		return nil
+	}
+	return replayer.done
+}
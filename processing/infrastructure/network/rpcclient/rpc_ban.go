@@ -1,14 +1,14 @@
 package rpcclient
 
-import "github.com/kaspanet/kaspad/app/appmessage"
+import (
+	"context"
+
+	"github.com/kaspanet/kaspad/app/appmessage"
+)
 
 // Ban sends an RPC request respective to the function's name and returns the RPC server's response
-func (c *RPCClient) Ban(ip string) (*appmessage.BanResponseMessage, error) {
-	err := c.rpcRouter.outgoingRoute().Enqueue(appmessage.NewBanRequestMessage(ip))
-	if err != nil {
-		return nil, err
-	}
-	response, err := c.route(appmessage.CmdBanRequestMessage).DequeueWithTimeout(c.timeout)
+func (c *RPCClient) Ban(ctx context.Context, ip string) (*appmessage.BanResponseMessage, error) {
+	response, err := c.call(ctx, appmessage.NewBanRequestMessage(ip), appmessage.CmdBanRequestMessage)
 	if err != nil {
 		return nil, err
 	}
@@ -1,14 +1,16 @@
 package rpcclient
 
-import "github.com/kaspanet/kaspad/app/appmessage"
+import (
+	"context"
+
+	"github.com/kaspanet/kaspad/app/appmessage"
+)
 
 // AddPeer sends an RPC request respective to the function's name and returns the RPC server's response
-func (c *RPCClient) AddPeer(address string, isPermanent bool) error {
-	err := c.rpcRouter.outgoingRoute().Enqueue(appmessage.NewAddPeerRequestMessage(address, isPermanent))
-	if err != nil {
-		return err
-	}
-	response, err := c.route(appmessage.CmdAddPeerResponseMessage).DequeueWithTimeout(c.timeout)
+func (c *RPCClient) AddPeer(ctx context.Context, address string, isPermanent bool) error {
+	response, err := c.call(ctx,
+		appmessage.NewAddPeerRequestMessage(address, isPermanent),
+		appmessage.CmdAddPeerResponseMessage)
 	if err != nil {
 		return err
 	}
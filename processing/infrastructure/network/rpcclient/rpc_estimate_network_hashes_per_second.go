@@ -1,14 +1,16 @@
 package rpcclient
 
-import "github.com/kaspanet/kaspad/app/appmessage"
+import (
+	"context"
+
+	"github.com/kaspanet/kaspad/app/appmessage"
+)
 
 // EstimateNetworkHashesPerSecond sends an RPC request respective to the function's name and returns the RPC server's response
-func (c *RPCClient) EstimateNetworkHashesPerSecond(startHash string, windowSize uint32) (*appmessage.EstimateNetworkHashesPerSecondResponseMessage, error) {
-	err := c.rpcRouter.outgoingRoute().Enqueue(appmessage.NewEstimateNetworkHashesPerSecondRequestMessage(startHash, windowSize))
-	if err != nil {
-		return nil, err
-	}
-	response, err := c.route(appmessage.CmdEstimateNetworkHashesPerSecondResponseMessage).DequeueWithTimeout(c.timeout)
+func (c *RPCClient) EstimateNetworkHashesPerSecond(ctx context.Context, startHash string, windowSize uint32) (*appmessage.EstimateNetworkHashesPerSecondResponseMessage, error) {
+	response, err := c.call(ctx,
+		appmessage.NewEstimateNetworkHashesPerSecondRequestMessage(startHash, windowSize),
+		appmessage.CmdEstimateNetworkHashesPerSecondResponseMessage)
 	if err != nil {
 		return nil, err
 	}
@@ -1,14 +1,16 @@
 package rpcclient
 
-import "github.com/kaspanet/kaspad/app/appmessage"
+import (
+	"context"
+
+	"github.com/kaspanet/kaspad/app/appmessage"
+)
 
 // GetBalanceByAddress sends an RPC request respective to the function's name and returns the RPC server's response
-func (c *RPCClient) GetBalanceByAddress(address string) (*appmessage.GetBalanceByAddressResponseMessage, error) {
-	err := c.rpcRouter.outgoingRoute().Enqueue(appmessage.NewGetBalanceByAddressRequest(address))
-	if err != nil {
-		return nil, err
-	}
-	response, err := c.route(appmessage.CmdGetBalanceByAddressResponseMessage).DequeueWithTimeout(c.timeout)
+func (c *RPCClient) GetBalanceByAddress(ctx context.Context, address string) (*appmessage.GetBalanceByAddressResponseMessage, error) {
+	response, err := c.call(ctx,
+		appmessage.NewGetBalanceByAddressRequest(address),
+		appmessage.CmdGetBalanceByAddressResponseMessage)
 	if err != nil {
 		return nil, err
 	}
@@ -1,14 +1,16 @@
 package rpcclient
 
-import "github.com/kaspanet/kaspad/app/appmessage"
+import (
+	"context"
+
+	"github.com/kaspanet/kaspad/app/appmessage"
+)
 
 // GetBalancesByAddresses sends an RPC request respective to the function's name and returns the RPC server's response
-func (c *RPCClient) GetBalancesByAddresses(addresses []string) (*appmessage.GetBalancesByAddressesResponseMessage, error) {
-	err := c.rpcRouter.outgoingRoute().Enqueue(appmessage.NewGetBalancesByAddressesRequest(addresses))
-	if err != nil {
-		return nil, err
-	}
-	response, err := c.route(appmessage.CmdGetBalancesByAddressesResponseMessage).DequeueWithTimeout(c.timeout)
+func (c *RPCClient) GetBalancesByAddresses(ctx context.Context, addresses []string) (*appmessage.GetBalancesByAddressesResponseMessage, error) {
+	response, err := c.call(ctx,
+		appmessage.NewGetBalancesByAddressesRequest(addresses),
+		appmessage.CmdGetBalancesByAddressesResponseMessage)
 	if err != nil {
 		return nil, err
 	}
@@ -1,17 +1,20 @@
 package rpcclient
 
-import "github.com/kaspanet/kaspad/app/appmessage"
+import (
+	"context"
+
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/tracing"
+	"github.com/kaspanet/kaspad/app/appmessage"
+)
 
 // GetBlock sends an RPC request respective to the function's name and returns the RPC server's response
-func (c *RPCClient) GetBlock(hash string, includeTransactions bool) (
+func (c *RPCClient) GetBlock(ctx context.Context, hash string, includeTransactions bool) (
 	*appmessage.GetBlockResponseMessage, error) {
 
-	err := c.rpcRouter.outgoingRoute().Enqueue(
-		appmessage.NewGetBlockRequestMessage(hash, includeTransactions))
-	if err != nil {
-		return nil, err
-	}
-	response, err := c.route(appmessage.CmdGetBlockResponseMessage).DequeueWithTimeout(c.timeout)
+	response, err := c.call(ctx,
+		appmessage.NewGetBlockRequestMessage(hash, includeTransactions),
+		appmessage.CmdGetBlockResponseMessage,
+		tracing.BlockHashString(hash))
 	if err != nil {
 		return nil, err
 	}
@@ -1,14 +1,14 @@
 package rpcclient
 
-import "github.com/kaspanet/kaspad/app/appmessage"
+import (
+	"context"
+
+	"github.com/kaspanet/kaspad/app/appmessage"
+)
 
 // GetBlockCount sends an RPC request respective to the function's name and returns the RPC server's response
-func (c *RPCClient) GetBlockCount() (*appmessage.GetBlockCountResponseMessage, error) {
-	err := c.rpcRouter.outgoingRoute().Enqueue(appmessage.NewGetBlockCountRequestMessage())
-	if err != nil {
-		return nil, err
-	}
-	response, err := c.route(appmessage.CmdGetBlockCountResponseMessage).DequeueWithTimeout(c.timeout)
+func (c *RPCClient) GetBlockCount(ctx context.Context) (*appmessage.GetBlockCountResponseMessage, error) {
+	response, err := c.call(ctx, appmessage.NewGetBlockCountRequestMessage(), appmessage.CmdGetBlockCountResponseMessage)
 	if err != nil {
 		return nil, err
 	}
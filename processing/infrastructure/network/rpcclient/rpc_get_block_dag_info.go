@@ -1,14 +1,14 @@
 package rpcclient
 
-import "github.com/kaspanet/kaspad/app/appmessage"
+import (
+	"context"
+
+	"github.com/kaspanet/kaspad/app/appmessage"
+)
 
 // GetBlockDAGInfo sends an RPC request respective to the function's name and returns the RPC server's response
-func (c *RPCClient) GetBlockDAGInfo() (*appmessage.GetBlockDAGInfoResponseMessage, error) {
-	err := c.rpcRouter.outgoingRoute().Enqueue(appmessage.NewGetBlockDAGInfoRequestMessage())
-	if err != nil {
-		return nil, err
-	}
-	response, err := c.route(appmessage.CmdGetBlockDAGInfoResponseMessage).DequeueWithTimeout(c.timeout)
+func (c *RPCClient) GetBlockDAGInfo(ctx context.Context) (*appmessage.GetBlockDAGInfoResponseMessage, error) {
+	response, err := c.call(ctx, appmessage.NewGetBlockDAGInfoRequestMessage(), appmessage.CmdGetBlockDAGInfoResponseMessage)
 	if err != nil {
 		return nil, err
 	}
@@ -1,14 +1,16 @@
 package rpcclient
 
-import "github.com/kaspanet/kaspad/app/appmessage"
+import (
+	"context"
+
+	"github.com/kaspanet/kaspad/app/appmessage"
+)
 
 // GetBlockTemplate sends an RPC request respective to the function's name and returns the RPC server's response
-func (c *RPCClient) GetBlockTemplate(miningAddress, extraData string) (*appmessage.GetBlockTemplateResponseMessage, error) {
-	err := c.rpcRouter.outgoingRoute().Enqueue(appmessage.NewGetBlockTemplateRequestMessage(miningAddress, extraData))
-	if err != nil {
-		return nil, err
-	}
-	response, err := c.route(appmessage.CmdGetBlockTemplateResponseMessage).DequeueWithTimeout(c.timeout)
+func (c *RPCClient) GetBlockTemplate(ctx context.Context, miningAddress, extraData string) (*appmessage.GetBlockTemplateResponseMessage, error) {
+	response, err := c.call(ctx,
+		appmessage.NewGetBlockTemplateRequestMessage(miningAddress, extraData),
+		appmessage.CmdGetBlockTemplateResponseMessage)
 	if err != nil {
 		return nil, err
 	}
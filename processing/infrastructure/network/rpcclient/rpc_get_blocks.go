@@ -1,17 +1,18 @@
 package rpcclient
 
-import "github.com/kaspanet/kaspad/app/appmessage"
+import (
+	"context"
+
+	"github.com/kaspanet/kaspad/app/appmessage"
+)
 
 // GetBlocks sends an RPC request respective to the function's name and returns the RPC server's response
-func (c *RPCClient) GetBlocks(lowHash string, includeBlocks bool,
+func (c *RPCClient) GetBlocks(ctx context.Context, lowHash string, includeBlocks bool,
 	includeTransactions bool) (*appmessage.GetBlocksResponseMessage, error) {
 
-	err := c.rpcRouter.outgoingRoute().Enqueue(
-		appmessage.NewGetBlocksRequestMessage(lowHash, includeBlocks, includeTransactions))
-	if err != nil {
-		return nil, err
-	}
-	response, err := c.route(appmessage.CmdGetBlocksResponseMessage).DequeueWithTimeout(c.timeout)
+	response, err := c.call(ctx,
+		appmessage.NewGetBlocksRequestMessage(lowHash, includeBlocks, includeTransactions),
+		appmessage.CmdGetBlocksResponseMessage)
 	if err != nil {
 		return nil, err
 	}
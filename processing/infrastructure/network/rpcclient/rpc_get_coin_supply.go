@@ -1,14 +1,14 @@
 package rpcclient
 
-import "github.com/kaspanet/kaspad/app/appmessage"
+import (
+	"context"
+
+	"github.com/kaspanet/kaspad/app/appmessage"
+)
 
 // GetCoinSupply sends an RPC request respective to the function's name and returns the RPC server's response
-func (c *RPCClient) GetCoinSupply() (*appmessage.GetCoinSupplyResponseMessage, error) {
-	err := c.rpcRouter.outgoingRoute().Enqueue(appmessage.NewGetCoinSupplyRequestMessage())
-	if err != nil {
-		return nil, err
-	}
-	response, err := c.route(appmessage.CmdGetCoinSupplyResponseMessage).DequeueWithTimeout(c.timeout)
+func (c *RPCClient) GetCoinSupply(ctx context.Context) (*appmessage.GetCoinSupplyResponseMessage, error) {
+	response, err := c.call(ctx, appmessage.NewGetCoinSupplyRequestMessage(), appmessage.CmdGetCoinSupplyResponseMessage)
 	if err != nil {
 		return nil, err
 	}
@@ -1,14 +1,16 @@
 package rpcclient
 
-import "github.com/kaspanet/kaspad/app/appmessage"
+import (
+	"context"
+
+	"github.com/kaspanet/kaspad/app/appmessage"
+)
 
 // GetConnectedPeerInfo sends an RPC request respective to the function's name and returns the RPC server's response
-func (c *RPCClient) GetConnectedPeerInfo() (*appmessage.GetConnectedPeerInfoResponseMessage, error) {
-	err := c.rpcRouter.outgoingRoute().Enqueue(appmessage.NewGetConnectedPeerInfoRequestMessage())
-	if err != nil {
-		return nil, err
-	}
-	response, err := c.route(appmessage.CmdGetConnectedPeerInfoResponseMessage).DequeueWithTimeout(c.timeout)
+func (c *RPCClient) GetConnectedPeerInfo(ctx context.Context) (*appmessage.GetConnectedPeerInfoResponseMessage, error) {
+	response, err := c.call(ctx,
+		appmessage.NewGetConnectedPeerInfoRequestMessage(),
+		appmessage.CmdGetConnectedPeerInfoResponseMessage)
 	if err != nil {
 		return nil, err
 	}
@@ -1,14 +1,14 @@
 package rpcclient
 
-import "github.com/kaspanet/kaspad/app/appmessage"
+import (
+	"context"
+
+	"github.com/kaspanet/kaspad/app/appmessage"
+)
 
 // GetFeeEstimate sends an RPC request respective to the function's name and returns the RPC server's response
-func (c *RPCClient) GetFeeEstimate() (*appmessage.GetFeeEstimateResponseMessage, error) {
-	err := c.rpcRouter.outgoingRoute().Enqueue(appmessage.NewGetFeeEstimateRequestMessage())
-	if err != nil {
-		return nil, err
-	}
-	response, err := c.route(appmessage.CmdGetFeeEstimateResponseMessage).DequeueWithTimeout(c.timeout)
+func (c *RPCClient) GetFeeEstimate(ctx context.Context) (*appmessage.GetFeeEstimateResponseMessage, error) {
+	response, err := c.call(ctx, appmessage.NewGetFeeEstimateRequestMessage(), appmessage.CmdGetFeeEstimateResponseMessage)
 	if err != nil {
 		return nil, err
 	}
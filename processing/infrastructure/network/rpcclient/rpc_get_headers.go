@@ -1,14 +1,16 @@
 package rpcclient
 
-import "github.com/kaspanet/kaspad/app/appmessage"
+import (
+	"context"
+
+	"github.com/kaspanet/kaspad/app/appmessage"
+)
 
 // GetHeaders sends an RPC request respective to the function's name and returns the RPC server's response
-func (c *RPCClient) GetHeaders(startHash string, limit uint64, isAscending bool) (*appmessage.GetHeadersResponseMessage, error) {
-	err := c.rpcRouter.outgoingRoute().Enqueue(appmessage.NewGetHeadersRequestMessage(startHash, limit, isAscending))
-	if err != nil {
-		return nil, err
-	}
-	response, err := c.route(appmessage.CmdGetHeadersResponseMessage).DequeueWithTimeout(c.timeout)
+func (c *RPCClient) GetHeaders(ctx context.Context, startHash string, limit uint64, isAscending bool) (*appmessage.GetHeadersResponseMessage, error) {
+	response, err := c.call(ctx,
+		appmessage.NewGetHeadersRequestMessage(startHash, limit, isAscending),
+		appmessage.CmdGetHeadersResponseMessage)
 	if err != nil {
 		return nil, err
 	}
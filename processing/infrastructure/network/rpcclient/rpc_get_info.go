@@ -1,14 +1,14 @@
 package rpcclient
 
-import "github.com/kaspanet/kaspad/app/appmessage"
+import (
+	"context"
+
+	"github.com/kaspanet/kaspad/app/appmessage"
+)
 
 // GetInfo sends an RPC request respective to the function's name and returns the RPC server's response
-func (c *RPCClient) GetInfo() (*appmessage.GetInfoResponseMessage, error) {
-	err := c.rpcRouter.outgoingRoute().Enqueue(appmessage.NewGetInfoRequestMessage())
-	if err != nil {
-		return nil, err
-	}
-	response, err := c.route(appmessage.CmdGetInfoResponseMessage).DequeueWithTimeout(c.timeout)
+func (c *RPCClient) GetInfo(ctx context.Context) (*appmessage.GetInfoResponseMessage, error) {
+	response, err := c.call(ctx, appmessage.NewGetInfoRequestMessage(), appmessage.CmdGetInfoResponseMessage)
 	if err != nil {
 		return nil, err
 	}
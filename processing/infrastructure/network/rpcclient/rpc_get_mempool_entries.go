@@ -1,14 +1,16 @@
 package rpcclient
 
-import "github.com/kaspanet/kaspad/app/appmessage"
+import (
+	"context"
+
+	"github.com/kaspanet/kaspad/app/appmessage"
+)
 
 // GetMempoolEntries sends an RPC request respective to the function's name and returns the RPC server's response
-func (c *RPCClient) GetMempoolEntries(includeOrphanPool bool, filterTransactionPool bool) (*appmessage.GetMempoolEntriesResponseMessage, error) {
-	err := c.rpcRouter.outgoingRoute().Enqueue(appmessage.NewGetMempoolEntriesRequestMessage(includeOrphanPool, filterTransactionPool))
-	if err != nil {
-		return nil, err
-	}
-	response, err := c.route(appmessage.CmdGetMempoolEntriesResponseMessage).DequeueWithTimeout(c.timeout)
+func (c *RPCClient) GetMempoolEntries(ctx context.Context, includeOrphanPool bool, filterTransactionPool bool) (*appmessage.GetMempoolEntriesResponseMessage, error) {
+	response, err := c.call(ctx,
+		appmessage.NewGetMempoolEntriesRequestMessage(includeOrphanPool, filterTransactionPool),
+		appmessage.CmdGetMempoolEntriesResponseMessage)
 	if err != nil {
 		return nil, err
 	}
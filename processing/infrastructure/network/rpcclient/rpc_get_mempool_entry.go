@@ -1,14 +1,16 @@
 package rpcclient
 
-import "github.com/kaspanet/kaspad/app/appmessage"
+import (
+	"context"
+
+	"github.com/kaspanet/kaspad/app/appmessage"
+)
 
 // GetMempoolEntry sends an RPC request respective to the function's name and returns the RPC server's response
-func (c *RPCClient) GetMempoolEntry(txID string, includeOrphanPool bool, filterTransactionPool bool) (*appmessage.GetMempoolEntryResponseMessage, error) {
-	err := c.rpcRouter.outgoingRoute().Enqueue(appmessage.NewGetMempoolEntryRequestMessage(txID, includeOrphanPool, filterTransactionPool))
-	if err != nil {
-		return nil, err
-	}
-	response, err := c.route(appmessage.CmdGetMempoolEntryResponseMessage).DequeueWithTimeout(c.timeout)
+func (c *RPCClient) GetMempoolEntry(ctx context.Context, txID string, includeOrphanPool bool, filterTransactionPool bool) (*appmessage.GetMempoolEntryResponseMessage, error) {
+	response, err := c.call(ctx,
+		appmessage.NewGetMempoolEntryRequestMessage(txID, includeOrphanPool, filterTransactionPool),
+		appmessage.CmdGetMempoolEntryResponseMessage)
 	if err != nil {
 		return nil, err
 	}
@@ -1,14 +1,16 @@
 package rpcclient
 
-import "github.com/kaspanet/kaspad/app/appmessage"
+import (
+	"context"
+
+	"github.com/kaspanet/kaspad/app/appmessage"
+)
 
 // GetPeerAddresses sends an RPC request respective to the function's name and returns the RPC server's response
-func (c *RPCClient) GetPeerAddresses() (*appmessage.GetPeerAddressesResponseMessage, error) {
-	err := c.rpcRouter.outgoingRoute().Enqueue(appmessage.NewGetPeerAddressesRequestMessage())
-	if err != nil {
-		return nil, err
-	}
-	response, err := c.route(appmessage.CmdGetPeerAddressesResponseMessage).DequeueWithTimeout(c.timeout)
+func (c *RPCClient) GetPeerAddresses(ctx context.Context) (*appmessage.GetPeerAddressesResponseMessage, error) {
+	response, err := c.call(ctx,
+		appmessage.NewGetPeerAddressesRequestMessage(),
+		appmessage.CmdGetPeerAddressesResponseMessage)
 	if err != nil {
 		return nil, err
 	}
@@ -1,14 +1,16 @@
 package rpcclient
 
-import "github.com/kaspanet/kaspad/app/appmessage"
+import (
+	"context"
+
+	"github.com/kaspanet/kaspad/app/appmessage"
+)
 
 // GetSelectedTipHash sends an RPC request respective to the function's name and returns the RPC server's response
-func (c *RPCClient) GetSelectedTipHash() (*appmessage.GetSelectedTipHashResponseMessage, error) {
-	err := c.rpcRouter.outgoingRoute().Enqueue(appmessage.NewGetSelectedTipHashRequestMessage())
-	if err != nil {
-		return nil, err
-	}
-	response, err := c.route(appmessage.CmdGetSelectedTipHashResponseMessage).DequeueWithTimeout(c.timeout)
+func (c *RPCClient) GetSelectedTipHash(ctx context.Context) (*appmessage.GetSelectedTipHashResponseMessage, error) {
+	response, err := c.call(ctx,
+		appmessage.NewGetSelectedTipHashRequestMessage(),
+		appmessage.CmdGetSelectedTipHashResponseMessage)
 	if err != nil {
 		return nil, err
 	}
@@ -1,14 +1,16 @@
 package rpcclient
 
-import "github.com/kaspanet/kaspad/app/appmessage"
+import (
+	"context"
+
+	"github.com/kaspanet/kaspad/app/appmessage"
+)
 
 // GetSubnetwork sends an RPC request respective to the function's name and returns the RPC server's response
-func (c *RPCClient) GetSubnetwork(subnetworkID string) (*appmessage.GetSubnetworkResponseMessage, error) {
-	err := c.rpcRouter.outgoingRoute().Enqueue(appmessage.NewGetSubnetworkRequestMessage(subnetworkID))
-	if err != nil {
-		return nil, err
-	}
-	response, err := c.route(appmessage.CmdGetSubnetworkResponseMessage).DequeueWithTimeout(c.timeout)
+func (c *RPCClient) GetSubnetwork(ctx context.Context, subnetworkID string) (*appmessage.GetSubnetworkResponseMessage, error) {
+	response, err := c.call(ctx,
+		appmessage.NewGetSubnetworkRequestMessage(subnetworkID),
+		appmessage.CmdGetSubnetworkResponseMessage)
 	if err != nil {
 		return nil, err
 	}
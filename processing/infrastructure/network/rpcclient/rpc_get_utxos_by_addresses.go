@@ -1,14 +1,16 @@
 package rpcclient
 
-import "github.com/kaspanet/kaspad/app/appmessage"
+import (
+	"context"
+
+	"github.com/kaspanet/kaspad/app/appmessage"
+)
 
 // GetUTXOsByAddresses sends an RPC request respective to the function's name and returns the RPC server's response
-func (c *RPCClient) GetUTXOsByAddresses(addresses []string) (*appmessage.GetUTXOsByAddressesResponseMessage, error) {
-	err := c.rpcRouter.outgoingRoute().Enqueue(appmessage.NewGetUTXOsByAddressesRequestMessage(addresses))
-	if err != nil {
-		return nil, err
-	}
-	response, err := c.route(appmessage.CmdGetUTXOsByAddressesResponseMessage).DequeueWithTimeout(c.timeout)
+func (c *RPCClient) GetUTXOsByAddresses(ctx context.Context, addresses []string) (*appmessage.GetUTXOsByAddressesResponseMessage, error) {
+	response, err := c.call(ctx,
+		appmessage.NewGetUTXOsByAddressesRequestMessage(addresses),
+		appmessage.CmdGetUTXOsByAddressesResponseMessage)
 	if err != nil {
 		return nil, err
 	}
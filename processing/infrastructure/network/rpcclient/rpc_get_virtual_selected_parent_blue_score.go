@@ -1,14 +1,16 @@
 package rpcclient
 
-import "github.com/kaspanet/kaspad/app/appmessage"
+import (
+	"context"
+
+	"github.com/kaspanet/kaspad/app/appmessage"
+)
 
 // GetVirtualSelectedParentBlueScore sends an RPC request respective to the function's name and returns the RPC server's response
-func (c *RPCClient) GetVirtualSelectedParentBlueScore() (*appmessage.GetVirtualSelectedParentBlueScoreResponseMessage, error) {
-	err := c.rpcRouter.outgoingRoute().Enqueue(appmessage.NewGetVirtualSelectedParentBlueScoreRequestMessage())
-	if err != nil {
-		return nil, err
-	}
-	response, err := c.route(appmessage.CmdGetVirtualSelectedParentBlueScoreResponseMessage).DequeueWithTimeout(c.timeout)
+func (c *RPCClient) GetVirtualSelectedParentBlueScore(ctx context.Context) (*appmessage.GetVirtualSelectedParentBlueScoreResponseMessage, error) {
+	response, err := c.call(ctx,
+		appmessage.NewGetVirtualSelectedParentBlueScoreRequestMessage(),
+		appmessage.CmdGetVirtualSelectedParentBlueScoreResponseMessage)
 	if err != nil {
 		return nil, err
 	}
@@ -1,6 +1,8 @@
 package rpcclient
 
 import (
+	"context"
+
 	"github.com/kaspanet/kaspad/app/appmessage"
 	routerpkg "github.com/kaspanet/kaspad/infrastructure/network/netadapter/router"
 	"github.com/pkg/errors"
@@ -8,12 +10,10 @@ import (
 
 // RegisterForBlockAddedNotifications sends an RPC request respective to the function's name and returns the RPC server's response.
 // Additionally, it starts listening for the appropriate notification using the given handler function
-func (c *RPCClient) RegisterForBlockAddedNotifications(onBlockAdded func(notification *appmessage.BlockAddedNotificationMessage)) error {
-	err := c.rpcRouter.outgoingRoute().Enqueue(appmessage.NewNotifyBlockAddedRequestMessage())
-	if err != nil {
-		return err
-	}
-	response, err := c.route(appmessage.CmdNotifyBlockAddedResponseMessage).DequeueWithTimeout(c.timeout)
+func (c *RPCClient) RegisterForBlockAddedNotifications(ctx context.Context, onBlockAdded func(notification *appmessage.BlockAddedNotificationMessage)) error {
+	response, err := c.call(ctx,
+		appmessage.NewNotifyBlockAddedRequestMessage(),
+		appmessage.CmdNotifyBlockAddedResponseMessage)
 	if err != nil {
 		return err
 	}
@@ -21,17 +21,19 @@ func (c *RPCClient) RegisterForBlockAddedNotifications(onBlockAdded func(notific
 	if notifyBlockAddedResponse.Error != nil {
 		return c.convertRPCError(notifyBlockAddedResponse.Error)
 	}
-	spawn("RegisterForBlockAddedNotifications", func() {
+	c.spawnNotificationListener("RegisterForBlockAddedNotifications", func() {
 		for {
 			notification, err := c.route(appmessage.CmdBlockAddedNotificationMessage).Dequeue()
 			if err != nil {
-				if errors.Is(err, routerpkg.ErrRouteClosed) {
-					break
+				if !errors.Is(err, routerpkg.ErrRouteClosed) {
+					c.handleListenerError("RegisterForBlockAddedNotifications", err)
 				}
-				panic(err)
+				return
 			}
 			blockAddedNotification := notification.(*appmessage.BlockAddedNotificationMessage)
+			c.recordNotification(blockAddedNotification)
 			onBlockAdded(blockAddedNotification)
+			c.notificationHandled()
 		}
 	})
 	return nil
@@ -1,6 +1,8 @@
 package rpcclient
 
 import (
+	"context"
+
 	"github.com/kaspanet/kaspad/app/appmessage"
 	routerpkg "github.com/kaspanet/kaspad/infrastructure/network/netadapter/router"
 	"github.com/pkg/errors"
@@ -8,15 +10,12 @@ import (
 
 // RegisterForVirtualSelectedParentChainChangedNotifications sends an RPC request respective to the function's name and returns the RPC server's response.
 // Additionally, it starts listening for the appropriate notification using the given handler function
-func (c *RPCClient) RegisterForVirtualSelectedParentChainChangedNotifications(includeAcceptedTransactionIDs bool,
+func (c *RPCClient) RegisterForVirtualSelectedParentChainChangedNotifications(ctx context.Context, includeAcceptedTransactionIDs bool,
 	onChainChanged func(notification *appmessage.VirtualSelectedParentChainChangedNotificationMessage)) error {
 
-	err := c.rpcRouter.outgoingRoute().Enqueue(
-		appmessage.NewNotifyVirtualSelectedParentChainChangedRequestMessage(includeAcceptedTransactionIDs))
-	if err != nil {
-		return err
-	}
-	response, err := c.route(appmessage.CmdNotifyVirtualSelectedParentChainChangedResponseMessage).DequeueWithTimeout(c.timeout)
+	response, err := c.call(ctx,
+		appmessage.NewNotifyVirtualSelectedParentChainChangedRequestMessage(includeAcceptedTransactionIDs),
+		appmessage.CmdNotifyVirtualSelectedParentChainChangedResponseMessage)
 	if err != nil {
 		return err
 	}
@@ -24,17 +23,19 @@ func (c *RPCClient) RegisterForVirtualSelectedParentChainChangedNotifications(in
 	if notifyChainChangedResponse.Error != nil {
 		return c.convertRPCError(notifyChainChangedResponse.Error)
 	}
-	spawn("RegisterForVirtualSelectedParentChainChangedNotifications", func() {
+	c.spawnNotificationListener("RegisterForVirtualSelectedParentChainChangedNotifications", func() {
 		for {
 			notification, err := c.route(appmessage.CmdVirtualSelectedParentChainChangedNotificationMessage).Dequeue()
 			if err != nil {
-				if errors.Is(err, routerpkg.ErrRouteClosed) {
-					break
+				if !errors.Is(err, routerpkg.ErrRouteClosed) {
+					c.handleListenerError("RegisterForVirtualSelectedParentChainChangedNotifications", err)
 				}
-				panic(err)
+				return
 			}
 			ChainChangedNotification := notification.(*appmessage.VirtualSelectedParentChainChangedNotificationMessage)
+			c.recordNotification(ChainChangedNotification)
 			onChainChanged(ChainChangedNotification)
+			c.notificationHandled()
 		}
 	})
 	return nil
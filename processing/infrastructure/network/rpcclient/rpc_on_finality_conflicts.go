@@ -1,6 +1,8 @@
 package rpcclient
 
 import (
+	"context"
+
 	"github.com/kaspanet/kaspad/app/appmessage"
 	routerpkg "github.com/kaspanet/kaspad/infrastructure/network/netadapter/router"
 	"github.com/pkg/errors"
@@ -8,15 +10,13 @@ import (
 
 // RegisterForFinalityConflictsNotifications sends an RPC request respective to the function's name and returns the RPC server's response.
 // Additionally, it starts listening for the appropriate notification using the given handler function
-func (c *RPCClient) RegisterForFinalityConflictsNotifications(
+func (c *RPCClient) RegisterForFinalityConflictsNotifications(ctx context.Context,
 	onFinalityConflict func(notification *appmessage.FinalityConflictNotificationMessage),
 	onFinalityConflictResolved func(notification *appmessage.FinalityConflictResolvedNotificationMessage)) error {
 
-	err := c.rpcRouter.outgoingRoute().Enqueue(appmessage.NewNotifyFinalityConflictsRequestMessage())
-	if err != nil {
-		return err
-	}
-	response, err := c.route(appmessage.CmdNotifyFinalityConflictsResponseMessage).DequeueWithTimeout(c.timeout)
+	response, err := c.call(ctx,
+		appmessage.NewNotifyFinalityConflictsRequestMessage(),
+		appmessage.CmdNotifyFinalityConflictsResponseMessage)
 	if err != nil {
 		return err
 	}
@@ -24,27 +24,27 @@ func (c *RPCClient) RegisterForFinalityConflictsNotifications(
 	if notifyFinalityConflictsResponse.Error != nil {
 		return c.convertRPCError(notifyFinalityConflictsResponse.Error)
 	}
-	spawn("RegisterForFinalityConflictsNotifications-finalityConflict", func() {
+	c.spawnNotificationListener("RegisterForFinalityConflictsNotifications-finalityConflict", func() {
 		for {
 			notification, err := c.route(appmessage.CmdFinalityConflictNotificationMessage).Dequeue()
 			if err != nil {
-				if errors.Is(err, routerpkg.ErrRouteClosed) {
-					break
+				if !errors.Is(err, routerpkg.ErrRouteClosed) {
+					c.handleListenerError("RegisterForFinalityConflictsNotifications-finalityConflict", err)
 				}
-				panic(err)
+				return
 			}
 			finalityConflictNotification := notification.(*appmessage.FinalityConflictNotificationMessage)
 			onFinalityConflict(finalityConflictNotification)
 		}
 	})
-	spawn("RegisterForFinalityConflictsNotifications-finalityConflictResolved", func() {
+	c.spawnNotificationListener("RegisterForFinalityConflictsNotifications-finalityConflictResolved", func() {
 		for {
 			notification, err := c.route(appmessage.CmdFinalityConflictResolvedNotificationMessage).Dequeue()
 			if err != nil {
-				if errors.Is(err, routerpkg.ErrRouteClosed) {
-					break
+				if !errors.Is(err, routerpkg.ErrRouteClosed) {
+					c.handleListenerError("RegisterForFinalityConflictsNotifications-finalityConflictResolved", err)
 				}
-				panic(err)
+				return
 			}
 			finalityConflictResolvedNotification := notification.(*appmessage.FinalityConflictResolvedNotificationMessage)
 			onFinalityConflictResolved(finalityConflictResolvedNotification)
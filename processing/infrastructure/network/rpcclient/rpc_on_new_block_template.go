@@ -1,6 +1,8 @@
 package rpcclient
 
 import (
+	"context"
+
 	"github.com/kaspanet/kaspad/app/appmessage"
 	routerpkg "github.com/kaspanet/kaspad/infrastructure/network/netadapter/router"
 	"github.com/pkg/errors"
@@ -8,12 +10,10 @@ import (
 
 // RegisterForNewBlockTemplateNotifications sends an RPC request respective to the function's name and returns the RPC server's response.
 // Additionally, it starts listening for the appropriate notification using the given handler function
-func (c *RPCClient) RegisterForNewBlockTemplateNotifications(onNewBlockTemplate func(notification *appmessage.NewBlockTemplateNotificationMessage)) error {
-	err := c.rpcRouter.outgoingRoute().Enqueue(appmessage.NewNotifyNewBlockTemplateRequestMessage())
-	if err != nil {
-		return err
-	}
-	response, err := c.route(appmessage.CmdNotifyNewBlockTemplateResponseMessage).DequeueWithTimeout(c.timeout)
+func (c *RPCClient) RegisterForNewBlockTemplateNotifications(ctx context.Context, onNewBlockTemplate func(notification *appmessage.NewBlockTemplateNotificationMessage)) error {
+	response, err := c.call(ctx,
+		appmessage.NewNotifyNewBlockTemplateRequestMessage(),
+		appmessage.CmdNotifyNewBlockTemplateResponseMessage)
 	if err != nil {
 		return err
 	}
@@ -21,14 +21,14 @@ func (c *RPCClient) RegisterForNewBlockTemplateNotifications(onNewBlockTemplate
 	if notifyNewBlockTemplateResponse.Error != nil {
 		return c.convertRPCError(notifyNewBlockTemplateResponse.Error)
 	}
-	spawn("RegisterForNewBlockTemplateNotifications", func() {
+	c.spawnNotificationListener("RegisterForNewBlockTemplateNotifications", func() {
 		for {
 			notification, err := c.route(appmessage.CmdNewBlockTemplateNotificationMessage).Dequeue()
 			if err != nil {
-				if errors.Is(err, routerpkg.ErrRouteClosed) {
-					break
+				if !errors.Is(err, routerpkg.ErrRouteClosed) {
+					c.handleListenerError("RegisterForNewBlockTemplateNotifications", err)
 				}
-				panic(err)
+				return
 			}
 			NewBlockTemplateNotification := notification.(*appmessage.NewBlockTemplateNotificationMessage)
 			onNewBlockTemplate(NewBlockTemplateNotification)
@@ -1,6 +1,8 @@
 package rpcclient
 
 import (
+	"context"
+
 	"github.com/kaspanet/kaspad/app/appmessage"
 	routerpkg "github.com/kaspanet/kaspad/infrastructure/network/netadapter/router"
 	"github.com/pkg/errors"
@@ -8,13 +10,11 @@ import (
 
 // RegisterPruningPointUTXOSetNotifications sends an RPC request respective to the function's name and returns the RPC server's response.
 // Additionally, it starts listening for the appropriate notification using the given handler function
-func (c *RPCClient) RegisterPruningPointUTXOSetNotifications(onPruningPointUTXOSetNotifications func()) error {
+func (c *RPCClient) RegisterPruningPointUTXOSetNotifications(ctx context.Context, onPruningPointUTXOSetNotifications func()) error {
 
-	err := c.rpcRouter.outgoingRoute().Enqueue(appmessage.NewNotifyPruningPointUTXOSetOverrideRequestMessage())
-	if err != nil {
-		return err
-	}
-	response, err := c.route(appmessage.CmdNotifyPruningPointUTXOSetOverrideResponseMessage).DequeueWithTimeout(c.timeout)
+	response, err := c.call(ctx,
+		appmessage.NewNotifyPruningPointUTXOSetOverrideRequestMessage(),
+		appmessage.CmdNotifyPruningPointUTXOSetOverrideResponseMessage)
 	if err != nil {
 		return err
 	}
@@ -22,14 +22,14 @@ func (c *RPCClient) RegisterPruningPointUTXOSetNotifications(onPruningPointUTXOS
 	if notifyPruningPointUTXOSetOverrideResponse.Error != nil {
 		return c.convertRPCError(notifyPruningPointUTXOSetOverrideResponse.Error)
 	}
-	spawn("RegisterPruningPointUTXOSetNotifications", func() {
+	c.spawnNotificationListener("RegisterPruningPointUTXOSetNotifications", func() {
 		for {
 			notification, err := c.route(appmessage.CmdPruningPointUTXOSetOverrideNotificationMessage).Dequeue()
 			if err != nil {
-				if errors.Is(err, routerpkg.ErrRouteClosed) {
-					break
+				if !errors.Is(err, routerpkg.ErrRouteClosed) {
+					c.handleListenerError("RegisterPruningPointUTXOSetNotifications", err)
 				}
-				panic(err)
+				return
 			}
 			_ = notification.(*appmessage.PruningPointUTXOSetOverrideNotificationMessage) // Sanity check the type
 			onPruningPointUTXOSetNotifications()
@@ -40,13 +40,11 @@ func (c *RPCClient) RegisterPruningPointUTXOSetNotifications(onPruningPointUTXOS
 
 // UnregisterPruningPointUTXOSetNotifications sends an RPC request respective to the function's name and returns the RPC server's response.
 // Additionally, it stops listening for the appropriate notification using the given handler function
-func (c *RPCClient) UnregisterPruningPointUTXOSetNotifications() error {
+func (c *RPCClient) UnregisterPruningPointUTXOSetNotifications(ctx context.Context) error {
 
-	err := c.rpcRouter.outgoingRoute().Enqueue(appmessage.NewStopNotifyingPruningPointUTXOSetOverrideRequestMessage())
-	if err != nil {
-		return err
-	}
-	response, err := c.route(appmessage.CmdStopNotifyingPruningPointUTXOSetOverrideResponseMessage).DequeueWithTimeout(c.timeout)
+	response, err := c.call(ctx,
+		appmessage.NewStopNotifyingPruningPointUTXOSetOverrideRequestMessage(),
+		appmessage.CmdStopNotifyingPruningPointUTXOSetOverrideResponseMessage)
 	if err != nil {
 		return err
 	}
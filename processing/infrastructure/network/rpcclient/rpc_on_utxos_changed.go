@@ -1,6 +1,8 @@
 package rpcclient
 
 import (
+	"context"
+
 	"github.com/kaspanet/kaspad/app/appmessage"
 	routerpkg "github.com/kaspanet/kaspad/infrastructure/network/netadapter/router"
 	"github.com/pkg/errors"
@@ -8,14 +10,12 @@ import (
 
 // RegisterForUTXOsChangedNotifications sends an RPC request respective to the function's name and returns the RPC server's response.
 // Additionally, it starts listening for the appropriate notification using the given handler function
-func (c *RPCClient) RegisterForUTXOsChangedNotifications(addresses []string,
+func (c *RPCClient) RegisterForUTXOsChangedNotifications(ctx context.Context, addresses []string,
 	onUTXOsChanged func(notification *appmessage.UTXOsChangedNotificationMessage)) error {
 
-	err := c.rpcRouter.outgoingRoute().Enqueue(appmessage.NewNotifyUTXOsChangedRequestMessage(addresses))
-	if err != nil {
-		return err
-	}
-	response, err := c.route(appmessage.CmdNotifyUTXOsChangedResponseMessage).DequeueWithTimeout(c.timeout)
+	response, err := c.call(ctx,
+		appmessage.NewNotifyUTXOsChangedRequestMessage(addresses),
+		appmessage.CmdNotifyUTXOsChangedResponseMessage)
 	if err != nil {
 		return err
 	}
@@ -23,14 +23,14 @@ func (c *RPCClient) RegisterForUTXOsChangedNotifications(addresses []string,
 	if notifyUTXOsChangedResponse.Error != nil {
 		return c.convertRPCError(notifyUTXOsChangedResponse.Error)
 	}
-	spawn("RegisterForUTXOsChangedNotifications", func() {
+	c.spawnNotificationListener("RegisterForUTXOsChangedNotifications", func() {
 		for {
 			notification, err := c.route(appmessage.CmdUTXOsChangedNotificationMessage).Dequeue()
 			if err != nil {
-				if errors.Is(err, routerpkg.ErrRouteClosed) {
-					break
+				if !errors.Is(err, routerpkg.ErrRouteClosed) {
+					c.handleListenerError("RegisterForUTXOsChangedNotifications", err)
 				}
-				panic(err)
+				return
 			}
 			UTXOsChangedNotification := notification.(*appmessage.UTXOsChangedNotificationMessage)
 			onUTXOsChanged(UTXOsChangedNotification)
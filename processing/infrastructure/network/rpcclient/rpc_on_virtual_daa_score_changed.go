@@ -1,6 +1,8 @@
 package rpcclient
 
 import (
+	"context"
+
 	"github.com/kaspanet/kaspad/app/appmessage"
 	routerpkg "github.com/kaspanet/kaspad/infrastructure/network/netadapter/router"
 	"github.com/pkg/errors"
@@ -9,14 +11,12 @@ import (
 // RegisterForVirtualDaaScoreChangedNotifications sends an RPC request respective to the function's
 // name and returns the RPC server's response. Additionally, it starts listening for the appropriate notification
 // using the given handler function
-func (c *RPCClient) RegisterForVirtualDaaScoreChangedNotifications(
+func (c *RPCClient) RegisterForVirtualDaaScoreChangedNotifications(ctx context.Context,
 	onVirtualDaaScoreChanged func(notification *appmessage.VirtualDaaScoreChangedNotificationMessage)) error {
 
-	err := c.rpcRouter.outgoingRoute().Enqueue(appmessage.NewNotifyVirtualDaaScoreChangedRequestMessage())
-	if err != nil {
-		return err
-	}
-	response, err := c.route(appmessage.CmdNotifyVirtualDaaScoreChangedResponseMessage).DequeueWithTimeout(c.timeout)
+	response, err := c.call(ctx,
+		appmessage.NewNotifyVirtualDaaScoreChangedRequestMessage(),
+		appmessage.CmdNotifyVirtualDaaScoreChangedResponseMessage)
 	if err != nil {
 		return err
 	}
@@ -24,14 +24,14 @@ func (c *RPCClient) RegisterForVirtualDaaScoreChangedNotifications(
 	if notifyVirtualDaaScoreChangedResponse.Error != nil {
 		return c.convertRPCError(notifyVirtualDaaScoreChangedResponse.Error)
 	}
-	spawn("RegisterForVirtualDaaScoreChangedNotifications", func() {
+	c.spawnNotificationListener("RegisterForVirtualDaaScoreChangedNotifications", func() {
 		for {
 			notification, err := c.route(appmessage.CmdVirtualDaaScoreChangedNotificationMessage).Dequeue()
 			if err != nil {
-				if errors.Is(err, routerpkg.ErrRouteClosed) {
-					break
+				if !errors.Is(err, routerpkg.ErrRouteClosed) {
+					c.handleListenerError("RegisterForVirtualDaaScoreChangedNotifications", err)
 				}
-				panic(err)
+				return
 			}
 			VirtualDaaScoreChangedNotification := notification.(*appmessage.VirtualDaaScoreChangedNotificationMessage)
 			onVirtualDaaScoreChanged(VirtualDaaScoreChangedNotification)
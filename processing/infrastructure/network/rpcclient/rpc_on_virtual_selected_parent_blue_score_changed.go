@@ -1,6 +1,8 @@
 package rpcclient
 
 import (
+	"context"
+
 	"github.com/kaspanet/kaspad/app/appmessage"
 	routerpkg "github.com/kaspanet/kaspad/infrastructure/network/netadapter/router"
 	"github.com/pkg/errors"
@@ -9,14 +11,12 @@ import (
 // RegisterForVirtualSelectedParentBlueScoreChangedNotifications sends an RPC request respective to the function's
 // name and returns the RPC server's response. Additionally, it starts listening for the appropriate notification
 // using the given handler function
-func (c *RPCClient) RegisterForVirtualSelectedParentBlueScoreChangedNotifications(
+func (c *RPCClient) RegisterForVirtualSelectedParentBlueScoreChangedNotifications(ctx context.Context,
 	onVirtualSelectedParentBlueScoreChanged func(notification *appmessage.VirtualSelectedParentBlueScoreChangedNotificationMessage)) error {
 
-	err := c.rpcRouter.outgoingRoute().Enqueue(appmessage.NewNotifyVirtualSelectedParentBlueScoreChangedRequestMessage())
-	if err != nil {
-		return err
-	}
-	response, err := c.route(appmessage.CmdNotifyVirtualSelectedParentBlueScoreChangedResponseMessage).DequeueWithTimeout(c.timeout)
+	response, err := c.call(ctx,
+		appmessage.NewNotifyVirtualSelectedParentBlueScoreChangedRequestMessage(),
+		appmessage.CmdNotifyVirtualSelectedParentBlueScoreChangedResponseMessage)
 	if err != nil {
 		return err
 	}
@@ -24,14 +24,14 @@ func (c *RPCClient) RegisterForVirtualSelectedParentBlueScoreChangedNotification
 	if notifyVirtualSelectedParentBlueScoreChangedResponse.Error != nil {
 		return c.convertRPCError(notifyVirtualSelectedParentBlueScoreChangedResponse.Error)
 	}
-	spawn("RegisterForVirtualSelectedParentBlueScoreChangedNotifications", func() {
+	c.spawnNotificationListener("RegisterForVirtualSelectedParentBlueScoreChangedNotifications", func() {
 		for {
 			notification, err := c.route(appmessage.CmdVirtualSelectedParentBlueScoreChangedNotificationMessage).Dequeue()
 			if err != nil {
-				if errors.Is(err, routerpkg.ErrRouteClosed) {
-					break
+				if !errors.Is(err, routerpkg.ErrRouteClosed) {
+					c.handleListenerError("RegisterForVirtualSelectedParentBlueScoreChangedNotifications", err)
 				}
-				panic(err)
+				return
 			}
 			VirtualSelectedParentBlueScoreChangedNotification := notification.(*appmessage.VirtualSelectedParentBlueScoreChangedNotificationMessage)
 			onVirtualSelectedParentBlueScoreChanged(VirtualSelectedParentBlueScoreChangedNotification)
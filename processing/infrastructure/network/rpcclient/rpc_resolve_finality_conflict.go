@@ -1,14 +1,16 @@
 package rpcclient
 
-import "github.com/kaspanet/kaspad/app/appmessage"
+import (
+	"context"
+
+	"github.com/kaspanet/kaspad/app/appmessage"
+)
 
 // ResolveFinalityConflict sends an RPC request respective to the function's name and returns the RPC server's response
-func (c *RPCClient) ResolveFinalityConflict(finalityBlockHash string) (*appmessage.ResolveFinalityConflictResponseMessage, error) {
-	err := c.rpcRouter.outgoingRoute().Enqueue(appmessage.NewResolveFinalityConflictRequestMessage(finalityBlockHash))
-	if err != nil {
-		return nil, err
-	}
-	response, err := c.route(appmessage.CmdResolveFinalityConflictResponseMessage).DequeueWithTimeout(c.timeout)
+func (c *RPCClient) ResolveFinalityConflict(ctx context.Context, finalityBlockHash string) (*appmessage.ResolveFinalityConflictResponseMessage, error) {
+	response, err := c.call(ctx,
+		appmessage.NewResolveFinalityConflictRequestMessage(finalityBlockHash),
+		appmessage.CmdResolveFinalityConflictResponseMessage)
 	if err != nil {
 		return nil, err
 	}
@@ -1,17 +1,16 @@
 package rpcclient
 
 import (
+	"context"
+
 	"github.com/kaspanet/kaspad/app/appmessage"
 	"github.com/kaspanet/kaspad/domain/consensus/model/externalapi"
 )
 
-func (c *RPCClient) submitBlock(block *externalapi.DomainBlock, allowNonDAABlocks bool) (appmessage.RejectReason, error) {
-	err := c.rpcRouter.outgoingRoute().Enqueue(
-		appmessage.NewSubmitBlockRequestMessage(appmessage.DomainBlockToRPCBlock(block), allowNonDAABlocks))
-	if err != nil {
-		return appmessage.RejectReasonNone, err
-	}
-	response, err := c.route(appmessage.CmdSubmitBlockResponseMessage).DequeueWithTimeout(c.timeout)
+func (c *RPCClient) submitBlock(ctx context.Context, block *externalapi.DomainBlock, allowNonDAABlocks bool) (appmessage.RejectReason, error) {
+	response, err := c.call(ctx,
+		appmessage.NewSubmitBlockRequestMessage(appmessage.DomainBlockToRPCBlock(block), allowNonDAABlocks),
+		appmessage.CmdSubmitBlockResponseMessage)
 	if err != nil {
 		return appmessage.RejectReasonNone, err
 	}
@@ -23,11 +22,11 @@ func (c *RPCClient) submitBlock(block *externalapi.DomainBlock, allowNonDAABlock
 }
 
 // SubmitBlock sends an RPC request respective to the function's name and returns the RPC server's response
-func (c *RPCClient) SubmitBlock(block *externalapi.DomainBlock) (appmessage.RejectReason, error) {
-	return c.submitBlock(block, false)
+func (c *RPCClient) SubmitBlock(ctx context.Context, block *externalapi.DomainBlock) (appmessage.RejectReason, error) {
+	return c.submitBlock(ctx, block, false)
 }
 
 // SubmitBlockAlsoIfNonDAA operates the same as SubmitBlock with the exception that `allowNonDAABlocks` is set to true
-func (c *RPCClient) SubmitBlockAlsoIfNonDAA(block *externalapi.DomainBlock) (appmessage.RejectReason, error) {
-	return c.submitBlock(block, true)
+func (c *RPCClient) SubmitBlockAlsoIfNonDAA(ctx context.Context, block *externalapi.DomainBlock) (appmessage.RejectReason, error) {
+	return c.submitBlock(ctx, block, true)
 }
@@ -1,14 +1,14 @@
 package rpcclient
 
-import "github.com/kaspanet/kaspad/app/appmessage"
+import (
+	"context"
+
+	"github.com/kaspanet/kaspad/app/appmessage"
+)
 
 // Unban sends an RPC request respective to the function's name and returns the RPC server's response
-func (c *RPCClient) Unban(ip string) (*appmessage.UnbanResponseMessage, error) {
-	err := c.rpcRouter.outgoingRoute().Enqueue(appmessage.NewUnbanRequestMessage(ip))
-	if err != nil {
-		return nil, err
-	}
-	response, err := c.route(appmessage.CmdUnbanRequestMessage).DequeueWithTimeout(c.timeout)
+func (c *RPCClient) Unban(ctx context.Context, ip string) (*appmessage.UnbanResponseMessage, error) {
+	response, err := c.call(ctx, appmessage.NewUnbanRequestMessage(ip), appmessage.CmdUnbanRequestMessage)
 	if err != nil {
 		return nil, err
 	}
@@ -1,9 +1,14 @@
 package rpcclient
 
 import (
+	"context"
+	"reflect"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/metrics"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/tracing"
 	"github.com/kaspanet/kaspad/app/appmessage"
 	"github.com/kaspanet/kaspad/infrastructure/logger"
 	routerpkg "github.com/kaspanet/kaspad/infrastructure/network/netadapter/router"
@@ -11,6 +16,8 @@ import (
 	"github.com/kaspanet/kaspad/util/panics"
 	"github.com/kaspanet/kaspad/version"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
 const defaultTimeout = 30 * time.Second
@@ -31,10 +38,22 @@ type RPCClient struct {
 	isReconnecting       uint32
 	lastDisconnectedTime time.Time
 
+	notificationListeners sync.WaitGroup
+
+	// routerLock guards rpcRouter, which gets replaced on reconnection while the listeners use it
+	routerLock sync.RWMutex
+
 	timeout              time.Duration
 	routeCapacity        int
 	reconnectDelay       time.Duration
 	onReconnectedHandler OnReconnectedHandler
+
+	recorder *recorder
+
+	// server, connection and stopServer are only set for the clients served within the process
+	server     InProcessServer
+	connection *InProcessConnection
+	stopServer context.CancelFunc
 }
 
 // NewRPCClient сreates a new RPC client with a default call timeout value
@@ -73,11 +92,11 @@ func (c *RPCClient) connect() error {
 	rpcClient.AttachRouter(rpcRouter.router)
 
 	c.GRPCClient = rpcClient
-	c.rpcRouter = rpcRouter
+	c.setRouter(rpcRouter)
 
 	log.Infof("Connected to %s", c.rpcAddress)
 
-	getInfoResponse, err := c.GetInfo()
+	getInfoResponse, err := c.GetInfo(context.Background())
 	if err != nil {
 		return errors.Wrapf(err, "error making GetInfo request")
 	}
@@ -126,12 +145,16 @@ func (c *RPCClient) Reconnect() error {
 		}
 	}
 
-	// Attempt to connect until we succeed
+	// Attempt to connect until we succeed or the client gets closed
 	for {
+		if atomic.LoadUint32(&c.isClosed) == 1 {
+			return errors.Errorf("Client closed while reconnecting")
+		}
 		const retryDelay = 10 * time.Second
 		if time.Since(c.lastDisconnectedTime) > retryDelay {
 			err := c.connect()
 			if err == nil {
+				metrics.RPCReconnected()
 				return nil
 			}
 			log.Warnf("Could not automatically reconnect to %s: %s", c.rpcAddress, err)
@@ -141,18 +164,26 @@ func (c *RPCClient) Reconnect() error {
 	}
 }
 
+// handleClientDisconnected reconnects to the RPC server. Its failures are only logged: the client then
+// stays disconnected, so the requests fail and the processing recovers from them or gives up.
 func (c *RPCClient) handleClientDisconnected() {
 	atomic.StoreUint32(&c.isConnected, 0)
 	if atomic.LoadUint32(&c.isClosed) == 0 {
 		err := c.disconnect()
 		if err != nil {
-			panic(err)
+			// The connection is lost anyway
+			log.Warnf("Could not disconnect from %s: %s", c.rpcAddress, err)
 		}
 		c.lastDisconnectedTime = time.Now()
 		err = c.Reconnect()
 		if err != nil {
-			panic(err)
+			if atomic.LoadUint32(&c.isClosed) == 1 {
+				return
+			}
+			log.Errorf("Could not reconnect to %s: %s", c.rpcAddress, err)
+			return
 		}
+		c.recordReconnection()
 		if c.onReconnectedHandler != nil {
 			c.onReconnectedHandler()
 		}
@@ -182,14 +213,37 @@ func (c *RPCClient) SetReconnectDelay(reconnectDelay time.Duration) {
 	c.reconnectDelay = reconnectDelay
 }
 
-// Close closes the RPC client
+// Close closes the RPC client.
+// Pending and future requests fail, and Close waits for the notification listeners
+// to finish handling the notifications they already received.
+// It must therefore not be called from within a notification handler.
 func (c *RPCClient) Close() error {
 	swapped := atomic.CompareAndSwapUint32(&c.isClosed, 0, 1)
 	if !swapped {
 		return errors.Errorf("Cannot close a client that had already been closed")
 	}
-	c.rpcRouter.router.Close()
-	return c.GRPCClient.Close()
+	if c.stopServer != nil {
+		c.stopServer()
+	}
+	c.router().router.Close()
+	c.notificationListeners.Wait()
+	if c.recorder != nil {
+		err := c.recorder.close()
+		if err != nil {
+			return err
+		}
+	}
+	// The clients served within the process have no gRPC connection
+	if c.GRPCClient == nil {
+		log.Infof("Closed connection to %s", c.rpcAddress)
+		return nil
+	}
+	err := c.GRPCClient.Close()
+	if err != nil {
+		return err
+	}
+	log.Infof("Closed connection to %s", c.rpcAddress)
+	return nil
 }
 
 // Address returns the address the RPC client connected to
@@ -197,8 +251,108 @@ func (c *RPCClient) Address() string {
 	return c.rpcAddress
 }
 
+// IsConnected returns true if the client is currently connected to the RPC server
+func (c *RPCClient) IsConnected() bool {
+	return atomic.LoadUint32(&c.isConnected) == 1
+}
+
+// spawnNotificationListener runs `listener` in a goroutine that Close waits for
+func (c *RPCClient) spawnNotificationListener(name string, listener func()) {
+	c.notificationListeners.Add(1)
+	spawn(name, func() {
+		defer c.notificationListeners.Done()
+		listener()
+	})
+}
+
+// handleListenerError handles the failure of the notification listener `name`, which stops listening.
+// The connection is handled as lost, so the notifications get subscribed to again once reconnected.
+func (c *RPCClient) handleListenerError(name string, err error) {
+	if atomic.LoadUint32(&c.isClosed) == 1 {
+		return
+	}
+	log.Errorf("Notification listener %s failed: %s", name, err)
+	// The reconnection runs apart for the listener to return right away
+	spawn("handleListenerError-reconnect", func() {
+		if c.connection != nil {
+			err := c.connection.Reconnect()
+			if err != nil {
+				log.Errorf("Could not reconnect to %s: %s", c.rpcAddress, err)
+			}
+			return
+		}
+		c.handleClientDisconnected()
+	})
+}
+
 func (c *RPCClient) route(command appmessage.MessageCommand) *routerpkg.Route {
-	return c.rpcRouter.routes[command]
+	return c.router().routes[command]
+}
+
+// router returns the RPC router of the current connection
+func (c *RPCClient) router() *rpcRouter {
+	c.routerLock.RLock()
+	defer c.routerLock.RUnlock()
+	return c.rpcRouter
+}
+
+// setRouter makes `rpcRouter` the RPC router of the current connection
+func (c *RPCClient) setRouter(rpcRouter *rpcRouter) {
+	c.routerLock.Lock()
+	defer c.routerLock.Unlock()
+	c.rpcRouter = rpcRouter
+}
+
+// call sends `request` to the RPC server and waits for its response on the route of `responseCommand`.
+// Every request is accounted for in the RPC metrics and traced as a child span of `ctx`.
+//
+// No request is sent once `ctx` is done. A request already sent is not abandoned on cancellation
+// since its response would later be mistaken for the response of the next request on the same
+// route; closing the client is what interrupts it.
+func (c *RPCClient) call(ctx context.Context, request appmessage.Message, responseCommand appmessage.MessageCommand,
+	attributes ...attribute.KeyValue) (appmessage.Message, error) {
+
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	command := request.Command().String()
+	_, span := tracing.Start(ctx, "RPC."+command, append(attributes, attribute.String("rpc.command", command))...)
+	start := time.Now()
+	response, err := c.send(request, responseCommand)
+	rpcFailed := err == nil && hasRPCError(response)
+	metrics.RPCRequestDone(command, time.Since(start), err != nil || rpcFailed)
+	if rpcFailed {
+		span.SetStatus(codes.Error, "RPC error response")
+	}
+	tracing.End(span, err)
+	return response, err
+}
+
+func (c *RPCClient) send(request appmessage.Message, responseCommand appmessage.MessageCommand) (appmessage.Message, error) {
+	rpcRouter := c.router()
+	err := rpcRouter.outgoingRoute().Enqueue(request)
+	if err != nil {
+		return nil, err
+	}
+	response, err := rpcRouter.routes[responseCommand].DequeueWithTimeout(c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	c.recordResponse(request, response)
+	return response, nil
+}
+
+// hasRPCError returns true if `response` carries an RPC error.
+// All RPC response messages hold their error in an `Error *appmessage.RPCError` field.
+func hasRPCError(response appmessage.Message) bool {
+	value := reflect.ValueOf(response)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return false
+	}
+	errorField := value.Elem().FieldByName("Error")
+	return errorField.IsValid() && errorField.Kind() == reflect.Ptr && !errorField.IsNil()
 }
 
 // ErrRPC is an error in the RPC protocol
@@ -0,0 +1,128 @@
+package tracing
+
+import (
+	"context"
+	"runtime"
+	"strings"
+
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/logging"
+	versionPackage "github.com/kaspa-live/kaspa-graph-inspector/processing/version"
+	"github.com/kaspanet/kaspad/domain/consensus/model/externalapi"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	serviceName    = "kgi-processing"
+	instrumentName = "github.com/kaspa-live/kaspa-graph-inspector/processing"
+)
+
+var log = logging.Logger()
+
+// tracer is a no-op tracer until Init is called so that instrumented code
+// costs next to nothing when tracing is disabled
+var tracer = otel.Tracer(instrumentName)
+
+// Init starts exporting spans over OTLP/gRPC to `endpoint`.
+// It returns a function flushing and stopping the exporter.
+func Init(endpoint string, insecure bool, sampleRatio float64) (func(context.Context) error, error) {
+	options := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(endpoint)}
+	if insecure {
+		options = append(options, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(context.Background(), options...)
+	if err != nil {
+		return nil, err
+	}
+
+	traceProvider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			semconv.ServiceName(serviceName),
+			semconv.ServiceVersion(versionPackage.Version()),
+		)),
+	)
+	otel.SetTracerProvider(traceProvider)
+	tracer = traceProvider.Tracer(instrumentName)
+
+	log.Infof("Exporting traces to %s", endpoint)
+	return traceProvider.Shutdown, nil
+}
+
+// Start starts a span named `name` as a child of the span held by `ctx`, if any
+func Start(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// Span starts a span that will have no children of its own.
+// It is meant to be used as `defer tracing.Span(ctx, name).End()`
+func Span(ctx context.Context, name string, attributes ...attribute.KeyValue) trace.Span {
+	_, span := Start(ctx, name, attributes...)
+	return span
+}
+
+// CallerSpan starts a span named after the function calling it, such as `Database.InsertBlock`,
+// that will have no children of its own. It is meant to be used as `defer tracing.CallerSpan(ctx).End()`
+// to trace a few chosen statements, the span name following the function when it gets renamed.
+func CallerSpan(ctx context.Context, attributes ...attribute.KeyValue) trace.Span {
+	// Looking up the caller is only worth it for a span that gets recorded
+	if !trace.SpanFromContext(ctx).IsRecording() {
+		return trace.SpanFromContext(context.Background())
+	}
+	name := "unknown"
+	programCounter, _, _, ok := runtime.Caller(1)
+	if ok {
+		name = functionName(runtime.FuncForPC(programCounter).Name())
+	}
+	return Span(ctx, name, attributes...)
+}
+
+// functionName shortens a fully qualified function name, such as
+// `github.com/kaspa-live/kaspa-graph-inspector/processing/database.(*Database).InsertBlock`,
+// to its type and function names, such as `Database.InsertBlock`
+func functionName(qualifiedName string) string {
+	name := qualifiedName[strings.LastIndex(qualifiedName, "/")+1:]
+	name = name[strings.Index(name, ".")+1:]
+	return strings.NewReplacer("(*", "", "(", "", ")", "").Replace(name)
+}
+
+// End records `err` in `span`, if any, and ends the span
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// BlockHash is the span attribute of a block hash
+func BlockHash(hash *externalapi.DomainHash) attribute.KeyValue {
+	return BlockHashString(hash.String())
+}
+
+// BlockHashString is the span attribute of a block hash given as a string
+func BlockHashString(hash string) attribute.KeyValue {
+	return attribute.String("kgi.block.hash", hash)
+}
+
+// BlockHeight is the span attribute of a block height
+func BlockHeight(height uint64) attribute.KeyValue {
+	return attribute.Int64("kgi.block.height", int64(height))
+}
+
+// BlockID is the span attribute of a block database id
+func BlockID(id uint64) attribute.KeyValue {
+	return attribute.Int64("kgi.block.id", int64(id))
+}
+
+// Count is the span attribute of the number of items handled by an operation
+func Count(count int) attribute.KeyValue {
+	return attribute.Int("kgi.count", count)
+}
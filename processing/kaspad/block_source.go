@@ -0,0 +1,357 @@
+package kaspad
+
+import (
+	"context"
+	"sync"
+
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/logging"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/network/blocksource"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/network/rpcclient"
+	"github.com/kaspanet/kaspad/app/appmessage"
+	"github.com/kaspanet/kaspad/domain/consensus/model/externalapi"
+	"github.com/kaspanet/kaspad/domain/consensus/utils/consensushashing"
+	"github.com/kaspanet/kaspad/version"
+	"github.com/pkg/errors"
+)
+
+var log = logging.Logger()
+
+// maxGetBlocksBlueScoreDifference bounds the blocks answered to GetBlocks the way kaspad does
+const maxGetBlocksBlueScoreDifference = 1000
+
+// BlockSource is a block source backed by the node embedded in the process, for the processing
+// to be run without a separate node. It answers with the messages kaspad sends over RPC, built
+// from the consensus of the node, and turns the consensus events into the matching notifications.
+type BlockSource struct {
+	kaspad  *Kaspad
+	started bool
+
+	onBlockAdded         func(notification *appmessage.BlockAddedNotificationMessage)
+	onChainChanged       func(notification *appmessage.VirtualSelectedParentChainChangedNotificationMessage)
+	onReconnectedHandler rpcclient.OnReconnectedHandler
+	quit                 chan struct{}
+	done                 chan struct{}
+	sync.Mutex
+}
+
+var _ blocksource.BlockSource = (*BlockSource)(nil)
+
+// NewBlockSource creates a block source backed by `kaspad`
+func NewBlockSource(kaspad *Kaspad) *BlockSource {
+	blockSource := &BlockSource{
+		kaspad: kaspad,
+		quit:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	// A consensus reset replaces all the blocks of the node, just like reconnecting to another node
+	kaspad.SetOnConsensusResetListener(func() {
+		blockSource.Lock()
+		onReconnectedHandler := blockSource.onReconnectedHandler
+		blockSource.Unlock()
+		if onReconnectedHandler != nil {
+			go onReconnectedHandler()
+		}
+	})
+	return blockSource
+}
+
+// Start starts the node and the delivery of its consensus events as notifications
+func (s *BlockSource) Start() error {
+	err := s.kaspad.Start()
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	s.started = true
+	s.Unlock()
+	go s.deliverConsensusEvents()
+	return nil
+}
+
+// deliverConsensusEvents notifies the registered handlers of the consensus events until the source gets closed.
+// The events coming before a handler got registered are dropped, the processing syncing from the node first.
+func (s *BlockSource) deliverConsensusEvents() {
+	defer close(s.done)
+
+	consensusEvents := s.kaspad.Domain().ConsensusEventsChannel()
+	for {
+		select {
+		case <-s.quit:
+			return
+		case event := <-consensusEvents:
+			s.Lock()
+			onBlockAdded, onChainChanged := s.onBlockAdded, s.onChainChanged
+			s.Unlock()
+
+			switch event := event.(type) {
+			case *externalapi.BlockAdded:
+				if onBlockAdded == nil {
+					continue
+				}
+				rpcBlock, err := s.rpcBlock(event.Block)
+				if err != nil {
+					log.Warnf("Could not notify the addition of block %s: %s", consensushashing.BlockHash(event.Block), err)
+					continue
+				}
+				onBlockAdded(&appmessage.BlockAddedNotificationMessage{Block: rpcBlock})
+
+			case *externalapi.VirtualChangeSet:
+				chainChanges := event.VirtualSelectedParentChainChanges
+				if onChainChanged == nil || chainChanges == nil ||
+					(len(chainChanges.Added) == 0 && len(chainChanges.Removed) == 0) {
+					continue
+				}
+				onChainChanged(&appmessage.VirtualSelectedParentChainChangedNotificationMessage{
+					RemovedChainBlockHashes: hashStrings(chainChanges.Removed),
+					AddedChainBlockHashes:   hashStrings(chainChanges.Added),
+				})
+			}
+		}
+	}
+}
+
+func (s *BlockSource) consensus() externalapi.Consensus {
+	return s.kaspad.Domain().Consensus()
+}
+
+// rpcBlock converts `block` to the block kaspad sends over RPC, along with its verbose data
+func (s *BlockSource) rpcBlock(block *externalapi.DomainBlock) (*appmessage.RPCBlock, error) {
+	consensus := s.consensus()
+	blockHash := consensushashing.BlockHash(block)
+	blockInfo, err := consensus.GetBlockInfo(blockHash)
+	if err != nil {
+		return nil, err
+	}
+	_, children, err := consensus.GetBlockRelations(blockHash)
+	if err != nil {
+		return nil, err
+	}
+	isChainBlock, err := consensus.IsChainBlock(blockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	rpcBlock := appmessage.DomainBlockToRPCBlock(block)
+	rpcBlock.VerboseData = &appmessage.RPCBlockVerboseData{
+		Hash:                blockHash.String(),
+		SelectedParentHash:  hashString(blockInfo.SelectedParent),
+		IsHeaderOnly:        blockInfo.BlockStatus == externalapi.StatusHeaderOnly,
+		BlueScore:           blockInfo.BlueScore,
+		ChildrenHashes:      hashStrings(children),
+		MergeSetBluesHashes: hashStrings(blockInfo.MergeSetBlues),
+		MergeSetRedsHashes:  hashStrings(blockInfo.MergeSetReds),
+		IsChainBlock:        isChainBlock,
+	}
+	return rpcBlock, nil
+}
+
+func (s *BlockSource) GetInfo(ctx context.Context) (*appmessage.GetInfoResponseMessage, error) {
+	isSynced, err := s.consensus().IsNearlySynced()
+	if err != nil {
+		return nil, err
+	}
+	return &appmessage.GetInfoResponseMessage{
+		ServerVersion: version.Version(),
+		IsSynced:      isSynced,
+	}, nil
+}
+
+func (s *BlockSource) GetBlockDAGInfo(ctx context.Context) (*appmessage.GetBlockDAGInfoResponseMessage, error) {
+	consensus := s.consensus()
+	tips, err := consensus.Tips()
+	if err != nil {
+		return nil, err
+	}
+	virtualInfo, err := consensus.GetVirtualInfo()
+	if err != nil {
+		return nil, err
+	}
+	pruningPoint, err := consensus.PruningPoint()
+	if err != nil {
+		return nil, err
+	}
+	return &appmessage.GetBlockDAGInfoResponseMessage{
+		NetworkName:         s.kaspad.config.NetName,
+		TipHashes:           hashStrings(tips),
+		VirtualParentHashes: hashStrings(virtualInfo.ParentHashes),
+		PastMedianTime:      virtualInfo.PastMedianTime,
+		PruningPointHash:    pruningPoint.String(),
+		VirtualDAAScore:     virtualInfo.DAAScore,
+	}, nil
+}
+
+func (s *BlockSource) GetBlock(ctx context.Context, hash string, includeTransactions bool) (*appmessage.GetBlockResponseMessage, error) {
+	blockHash, err := externalapi.NewDomainHashFromString(hash)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not parse hash %s", hash)
+	}
+	block, err := s.consensus().GetBlockEvenIfHeaderOnly(blockHash)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Block %s not found", hash)
+	}
+	rpcBlock, err := s.rpcBlock(block)
+	if err != nil {
+		return nil, err
+	}
+	if !includeTransactions {
+		rpcBlock.Transactions = nil
+	}
+	return &appmessage.GetBlockResponseMessage{Block: rpcBlock}, nil
+}
+
+// GetBlocks returns the hashes of the blocks from `lowHash` to the virtual selected parent, along
+// with its anticone when reached, the way kaspad does. Lacking `lowHash`, the blocks are returned
+// from the pruning point since the ones below it may be pruned.
+func (s *BlockSource) GetBlocks(ctx context.Context, lowHash string, includeBlocks bool,
+	includeTransactions bool) (*appmessage.GetBlocksResponseMessage, error) {
+
+	if includeTransactions && !includeBlocks {
+		return nil, errors.Errorf("If includeTransactions is set, then includeBlocks must be set as well")
+	}
+
+	consensus := s.consensus()
+	var lowBlockHash *externalapi.DomainHash
+	var err error
+	if lowHash == "" {
+		lowBlockHash, err = consensus.PruningPoint()
+	} else {
+		lowBlockHash, err = externalapi.NewDomainHashFromString(lowHash)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not get the low hash %s", lowHash)
+	}
+	virtualSelectedParent, err := consensus.GetVirtualSelectedParent()
+	if err != nil {
+		return nil, err
+	}
+
+	blockHashes, highHash, err := consensus.GetHashesBetween(lowBlockHash, virtualSelectedParent, maxGetBlocksBlueScoreDifference)
+	if err != nil {
+		return nil, err
+	}
+	// GetHashesBetween excludes the low hash
+	blockHashes = append([]*externalapi.DomainHash{lowBlockHash}, blockHashes...)
+	if highHash.Equal(virtualSelectedParent) {
+		anticone, err := consensus.Anticone(virtualSelectedParent)
+		if err != nil {
+			return nil, err
+		}
+		blockHashes = append(blockHashes, anticone...)
+	}
+
+	response := &appmessage.GetBlocksResponseMessage{BlockHashes: hashStrings(blockHashes)}
+	if includeBlocks {
+		for _, blockHash := range blockHashes {
+			block, err := consensus.GetBlockEvenIfHeaderOnly(blockHash)
+			if err != nil {
+				return nil, err
+			}
+			rpcBlock, err := s.rpcBlock(block)
+			if err != nil {
+				return nil, err
+			}
+			if !includeTransactions {
+				rpcBlock.Transactions = nil
+			}
+			response.Blocks = append(response.Blocks, rpcBlock)
+		}
+	}
+	return response, nil
+}
+
+func (s *BlockSource) GetSelectedTipHash(ctx context.Context) (*appmessage.GetSelectedTipHashResponseMessage, error) {
+	selectedTip, err := s.consensus().GetVirtualSelectedParent()
+	if err != nil {
+		return nil, err
+	}
+	return &appmessage.GetSelectedTipHashResponseMessage{SelectedTipHash: selectedTip.String()}, nil
+}
+
+// GetVirtualSelectedParentChainFromBlock returns the changes of the virtual selected parent chain since `startHash`.
+// The accepted transaction IDs are not available since the embedded node keeps no UTXO index.
+func (s *BlockSource) GetVirtualSelectedParentChainFromBlock(ctx context.Context, startHash string, includeAcceptedTransactionIDs bool) (
+	*appmessage.GetVirtualSelectedParentChainFromBlockResponseMessage, error) {
+
+	if includeAcceptedTransactionIDs {
+		return nil, errors.Errorf("The embedded node does not provide the accepted transaction IDs")
+	}
+	startBlockHash, err := externalapi.NewDomainHashFromString(startHash)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not parse hash %s", startHash)
+	}
+	chainPath, err := s.consensus().GetVirtualSelectedParentChainFromBlock(startBlockHash)
+	if err != nil {
+		return nil, err
+	}
+	return &appmessage.GetVirtualSelectedParentChainFromBlockResponseMessage{
+		RemovedChainBlockHashes: hashStrings(chainPath.Removed),
+		AddedChainBlockHashes:   hashStrings(chainPath.Added),
+	}, nil
+}
+
+func (s *BlockSource) RegisterForBlockAddedNotifications(ctx context.Context,
+	onBlockAdded func(notification *appmessage.BlockAddedNotificationMessage)) error {
+
+	s.Lock()
+	defer s.Unlock()
+	s.onBlockAdded = onBlockAdded
+	return nil
+}
+
+func (s *BlockSource) RegisterForVirtualSelectedParentChainChangedNotifications(ctx context.Context, includeAcceptedTransactionIDs bool,
+	onChainChanged func(notification *appmessage.VirtualSelectedParentChainChangedNotificationMessage)) error {
+
+	if includeAcceptedTransactionIDs {
+		return errors.Errorf("The embedded node does not provide the accepted transaction IDs")
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.onChainChanged = onChainChanged
+	return nil
+}
+
+// SetOnReconnectedHandler sets the handler called once the consensus of the node got reset
+func (s *BlockSource) SetOnReconnectedHandler(onReconnectedHandler rpcclient.OnReconnectedHandler) {
+	s.Lock()
+	defer s.Unlock()
+	s.onReconnectedHandler = onReconnectedHandler
+}
+
+// IsConnected always returns true since the node runs in the process
+func (s *BlockSource) IsConnected() bool {
+	return true
+}
+
+// Close stops the delivery of the notifications, waiting for the handler being called
+// to return, and then stops the node
+func (s *BlockSource) Close() error {
+	select {
+	case <-s.quit:
+		return nil
+	default:
+	}
+	close(s.quit)
+	s.Lock()
+	started := s.started
+	s.Unlock()
+	if !started {
+		return nil
+	}
+	<-s.done
+	return s.kaspad.Stop()
+}
+
+func hashString(hash *externalapi.DomainHash) string {
+	if hash == nil {
+		return ""
+	}
+	return hash.String()
+}
+
+func hashStrings(hashes []*externalapi.DomainHash) []string {
+	strings := make([]string, len(hashes))
+	for i, hash := range hashes {
+		strings[i] = hash.String()
+	}
+	return strings
+}
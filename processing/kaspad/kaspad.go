@@ -9,6 +9,7 @@ import (
 	"github.com/kaspanet/kaspad/app/protocol"
 	"github.com/kaspanet/kaspad/domain/consensus/model/externalapi"
 	kaspadConfigPackage "github.com/kaspanet/kaspad/infrastructure/config"
+	kaspadDatabasePackage "github.com/kaspanet/kaspad/infrastructure/db/database"
 	"github.com/kaspanet/kaspad/infrastructure/network/addressmanager"
 	"github.com/kaspanet/kaspad/infrastructure/network/connmanager"
 	"github.com/kaspanet/kaspad/infrastructure/network/netadapter"
@@ -18,6 +19,7 @@ import (
 
 type Kaspad struct {
 	config            *configPackage.Config
+	databaseContext   kaspadDatabasePackage.Database
 	domain            *domainPackage.Domain
 	netAdapter        *netadapter.NetAdapter
 	addressManager    *addressmanager.AddressManager
@@ -62,6 +64,7 @@ func New(config *configPackage.Config) (*Kaspad, error) {
 	}
 	return &Kaspad{
 		config:            config,
+		databaseContext:   databaseContext,
 		domain:            domain,
 		netAdapter:        netAdapter,
 		addressManager:    addressManager,
@@ -95,6 +98,16 @@ func (k *Kaspad) Start() error {
 	return nil
 }
 
+// Stop disconnects from the peers and closes the database of the node
+func (k *Kaspad) Stop() error {
+	k.connectionManager.Stop()
+	err := k.netAdapter.Stop()
+	if err != nil {
+		return err
+	}
+	return k.databaseContext.Close()
+}
+
 func (k *Kaspad) Domain() *domainPackage.Domain {
 	return k.domain
 }
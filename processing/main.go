@@ -1,12 +1,24 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	databasePackage "github.com/kaspa-live/kaspa-graph-inspector/processing/database"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/database/memorystorage"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/graphexport"
 	configPackage "github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/config"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/httpserver"
 	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/logging"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/metrics"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/network/blocksource"
 	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/network/rpcclient"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/tracing"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/kaspad"
 	processingPackage "github.com/kaspa-live/kaspa-graph-inspector/processing/processing"
 	versionPackage "github.com/kaspa-live/kaspa-graph-inspector/processing/version"
 	"github.com/kaspanet/kaspad/version"
@@ -21,60 +33,227 @@ func main() {
 	if err != nil {
 		logging.LogErrorAndExit("Could not parse command line arguments.\n%s", err)
 	}
+	defer logging.CloseLog()
 
 	logging.Logger().Infof("Application version %s", versionPackage.Version())
 	logging.Logger().Infof("Embedded kaspad version %s", version.Version())
 	logging.Logger().Infof("Network %s", config.NetName)
 
-	database, err := databasePackage.Connect(config.DatabaseConnectionString)
+	// ctx gets done on SIGINT or SIGTERM, which starts the shutdown
+	ctx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	if config.TracingEndpoint != "" {
+		shutdownTracing, err := tracing.Init(config.TracingEndpoint, config.TracingInsecure, config.TracingSampleRatio)
+		if err != nil {
+			logging.LogErrorAndExit("Could not initialize tracing: %s", err)
+		}
+		defer shutdownTracing(context.Background())
+	}
+
+	if config.MigrationsDir != "" {
+		databasePackage.UseMigrationsDirectory(config.MigrationsDir)
+	}
+
+	if config.Command == configPackage.MigrateCommand {
+		migrateDatabase(config)
+		return
+	}
+
+	if config.Command == configPackage.LoadTestCommand && config.LoadTest.InMemory {
+		loadTest(ctx, stopSignals, config, memorystorage.New(), func() {})
+		return
+	}
+
+	database, err := databasePackage.Connect(config.DatabaseConnectionString, databaseConnectionOptions(config))
 	if err != nil {
-		logging.LogErrorAndExit("Could not connect to database %s: %s", config.DatabaseConnectionString, err)
+		logging.LogErrorAndExit("Could not connect to database %s: %s", config.RedactedConnectionString(), err)
 	}
 	defer database.Close()
 
+	switch config.Command {
+	case configPackage.CheckCommand:
+		check(ctx, config, database)
+	case configPackage.ExportCommand:
+		exportDatabase(ctx, config, database)
+	case configPackage.ImportCommand:
+		importDatabase(ctx, config, database)
+		if config.Import.Run {
+			// The resync on startup continues from the imported blocks
+			run(ctx, stopSignals, config, database)
+		}
+	case configPackage.PruneCommand:
+		prune(ctx, config, database)
+	case configPackage.StatsCommand:
+		stats(ctx, database)
+	case configPackage.GraphCommand:
+		exportGraph(ctx, config, database)
+	case configPackage.ParquetCommand:
+		exportParquet(ctx, config, database)
+	case configPackage.LoadTestCommand:
+		loadTest(ctx, stopSignals, config, database.Storage(), database.Close)
+	default:
+		run(ctx, stopSignals, config, database)
+	}
+}
+
+// databaseConnectionOptions returns the connection options of the database given by `config`
+func databaseConnectionOptions(config *configPackage.Config) *databasePackage.ConnectionOptions {
+	return &databasePackage.ConnectionOptions{
+		PoolSize:           config.DatabasePoolSize,
+		IdleTimeout:        config.DatabaseIdleTimeout,
+		ReadTimeout:        config.DatabaseReadTimeout,
+		WriteTimeout:       config.DatabaseWriteTimeout,
+		StatementTimeout:   config.DatabaseStatementTimeout,
+		TransactionTimeout: config.DatabaseTxTimeout,
+		TransactionRetries: config.DatabaseTxRetries,
+		TLSCAFile:          config.DatabaseTLSCA,
+		TLSCertFile:        config.DatabaseTLSCert,
+		TLSKeyFile:         config.DatabaseTLSKey,
+	}
+}
+
+// run syncs the database with the node and processes the node events until `ctx` is done
+// or the processing gives up on failures
+func run(ctx context.Context, stopSignals context.CancelFunc, config *configPackage.Config, database *databasePackage.Database) {
+	blockSource, rpcClient := newBlockSource(config)
+
+	processing, err := processingPackage.NewProcessing(config, database.Storage(), blockSource)
+	if err != nil {
+		logging.LogErrorAndExit("Could not initialize processing: %s", err)
+	}
+
+	// The HTTP server is started before processing so the health endpoints
+	// are available while the database is syncing
+	var httpServer *httpserver.Server
+	if config.HTTPListen != "" {
+		httpServer = httpserver.New(config.HTTPListen)
+		httpServer.HandleFunc("/healthz", processing.HandleHealthz)
+		httpServer.HandleFunc("/readyz", processing.HandleReadyz)
+		httpServer.Handle("/metrics", metrics.Handler())
+		httpServer.HandleFunc("/graph", graphexport.Handler(database, config.GraphMaxBlocks))
+		err = httpServer.Start()
+		if err != nil {
+			logging.LogErrorAndExit("Could not start the HTTP server: %s", err)
+		}
+	}
+	// The endpoints modifying the database are served apart from the public ones
+	var adminServer *httpserver.Server
+	if config.AdminListen != "" {
+		adminServer = httpserver.New(config.AdminListen)
+		adminServer.HandleFunc("/reconcile", processing.HandleReconcile)
+		err = adminServer.Start()
+		if err != nil {
+			logging.LogErrorAndExit("Could not start the admin HTTP server: %s", err)
+		}
+	}
+
+	// A replay waits for the processing of each notification before replaying the next one
+	var replayDone <-chan struct{}
+	if rpcClient != nil {
+		rpcClient.SetReplayBarrier(processing.WaitForIdleEvents)
+		replayDone = rpcClient.ReplayDone()
+	}
+	err = processing.Start(ctx)
+	if err != nil && ctx.Err() == nil {
+		logging.LogErrorAndExit("Could not initialize processing: %s", err)
+	}
+
+	// Processing is done on SIGINT or SIGTERM, once it gave up on failures,
+	// or once a replay got fully processed
+	select {
+	case <-processing.Done():
+	case <-replayDone:
+	}
+	// Restore the default signal behavior so a second signal kills the process right away
+	stopSignals()
+
+	logging.Logger().Infof("Shutting down...")
+	shutdownDone := make(chan struct{})
+	go func() {
+		shutdown(processing, blockSource, []*httpserver.Server{httpServer, adminServer}, config.ShutdownTimeout)
+		close(shutdownDone)
+	}()
+	select {
+	case <-shutdownDone:
+	case <-time.After(config.ShutdownTimeout):
+		logging.LogErrorAndExit("Could not shut down within %s", config.ShutdownTimeout)
+	}
+	logging.Logger().Infof("Shutdown complete")
+
+	err = processing.Err()
+	if err != nil {
+		database.Close()
+		logging.LogErrorAndExit("Processing stopped: %s", err)
+	}
+}
+
+// newBlockSource returns the block source selected by `config`, along with its RPC client
+// unless the node is embedded
+func newBlockSource(config *configPackage.Config) (blocksource.BlockSource, *rpcclient.RPCClient) {
+	if config.BlockSource == configPackage.BlockSourceEmbedded {
+		node, err := kaspad.New(config)
+		if err != nil {
+			logging.LogErrorAndExit("Could not create the embedded node: %s", err)
+		}
+		blockSource := kaspad.NewBlockSource(node)
+		err = blockSource.Start()
+		if err != nil {
+			logging.LogErrorAndExit("Could not start the embedded node: %s", err)
+		}
+		return blockSource, nil
+	}
+	rpcClient := newRPCClient(config)
+	return rpcClient, rpcClient
+}
+
+func newRPCClient(config *configPackage.Config) *rpcclient.RPCClient {
+	if config.Replay != "" {
+		rpcClient, err := rpcclient.NewReplayRPCClient(config.Replay, processingPackage.RpcRouteCapacity)
+		if err != nil {
+			logging.LogErrorAndExit("Could not replay: %s", err)
+		}
+		return rpcClient
+	}
 	rpcAddress, err := config.NetParams().NormalizeRPCServerAddress(config.RPCServer)
 	if err != nil {
-		panic(err)
+		logging.LogErrorAndExit("Invalid RPC server address %s: %s", config.RPCServer, err)
 	}
 	rpcClient, err := rpcclient.NewRPCClient(rpcAddress, processingPackage.RpcRouteCapacity)
 	if err != nil {
-		panic(err)
+		logging.LogErrorAndExit("Could not connect to the node at %s: %s", rpcAddress, err)
 	}
+	if config.Record != "" {
+		err = rpcClient.Record(config.Record)
+		if err != nil {
+			logging.LogErrorAndExit("Could not record: %s", err)
+		}
+	}
+	return rpcClient
+}
+
+// shutdown stops all the activities of the processing tier. The database gets closed
+// afterwards, once no transaction can be running anymore.
+func shutdown(processing *processingPackage.Processing, blockSource blocksource.BlockSource,
+	httpServers []*httpserver.Server, timeout time.Duration) {
 
-	_, err = processingPackage.NewProcessing(config, database, rpcClient)
+	// Closing the block source interrupts the pending requests and waits for
+	// the notification listeners to return
+	err := blockSource.Close()
 	if err != nil {
-		logging.LogErrorAndExit("Could not initialize processing: %s", err)
+		logging.Logger().Warnf("Could not close the block source: %s", err)
 	}
+	processing.Wait()
 
-	// This is no longer useful since kaspad v0.12.2
-	// that introduce a consensus event channel.
-	// See processing.initConsensusEventsHandler.
-
-	// kaspad.SetOnBlockAddedListener(func(block *externalapi.DomainBlock) {
-	// 	blockHash := consensushashing.BlockHash(block)
-	// 	blockInfo, err := kaspad.Domain().Consensus().GetBlockInfo(blockHash)
-	// 	if err != nil {
-	// 		logging.LogErrorAndExit("Consensus ValidateAndInsertBlock listener could not get block info for block %s: %s", blockHash, err)
-	// 	}
-	// 	logging.Logger().Debugf("Consensus ValidateAndInsertBlock listener gets block %s with status %s", blockHash, blockInfo.BlockStatus.String())
-	// })
-
-	//kaspad.SetOnVirtualResolvedListener(func() {
-	//	err := processing.ResyncVirtualSelectedParentChain()
-	//	if err != nil {
-	//		logging.LogErrorAndExit("Could not resync the virtual selected parent chain: %s", err)
-	//	}
-	//})
-	//kaspad.SetOnConsensusResetListener(func() {
-	//	err := processing.ResyncDatabase()
-	//	if err != nil {
-	//		logging.LogErrorAndExit("Could not resync database: %s", err)
-	//	}
-	//})
-	//err = kaspad.Start()
-	//if err != nil {
-	//	logging.LogErrorAndExit("Could not start kaspad: %s", err)
-	//}
-
-	<-make(chan struct{})
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, httpServer := range httpServers {
+		if httpServer == nil {
+			continue
+		}
+		err = httpServer.Stop(ctx)
+		if err != nil {
+			logging.Logger().Warnf("Could not stop the HTTP server: %s", err)
+		}
+	}
 }
@@ -0,0 +1,228 @@
+package parquetexport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/go-pg/pg/v10"
+	databasePackage "github.com/kaspa-live/kaspa-graph-inspector/processing/database"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/database/model"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/logging"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/tracing"
+	"github.com/parquet-go/parquet-go"
+	"github.com/pkg/errors"
+)
+
+var log = logging.Logger()
+
+const (
+	blocksDirectory = "blocks"
+	edgesDirectory  = "edges"
+	stateFile       = "state.json"
+)
+
+// Options configures an export
+type Options struct {
+	// Directory receives the Parquet files along with the export state
+	Directory string
+	// PartitionSize is the DAA score range covered by each partition directory
+	PartitionSize uint64
+	// MinDepth leaves the blocks having a DAA score less than MinDepth below the highest one
+	// for a later export, their GHOSTDAG data being still likely to change
+	MinDepth uint64
+	// BatchSize is the maximum number of blocks exported per database transaction
+	BatchSize int
+	// Full discards the previous exports and exports all the blocks again
+	Full bool
+}
+
+// Result summarizes an export
+type Result struct {
+	BlockCount  int
+	EdgeCount   int
+	LastBlockID uint64
+}
+
+// state records the progress of the exports in the export directory
+type state struct {
+	LastBlockID uint64 `json:"lastBlockID"`
+}
+
+// Export writes the blocks stored after the last exported block, and the edges completed by them,
+// to Parquet files partitioned by DAA score range. Each batch of blocks gets written to a new
+// file in each of the partitions it spans, named after the ID of its first block, so a batch
+// interrupted before its state got recorded gets overwritten by the next export.
+func Export(ctx context.Context, database *databasePackage.Database, options *Options) (result *Result, err error) {
+	ctx, span := tracing.Start(ctx, "parquetexport.Export")
+	defer func() { tracing.End(span, err) }()
+
+	if options.Full {
+		for _, directory := range []string{blocksDirectory, edgesDirectory, stateFile} {
+			err = os.RemoveAll(filepath.Join(options.Directory, directory))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	exportState, err := readState(options.Directory)
+	if err != nil {
+		return nil, err
+	}
+
+	var bound uint64
+	err = database.RunInTransaction(ctx, func(databaseTransaction *pg.Tx) error {
+		var err error
+		bound, err = database.SettledBlockIDBound(databaseTransaction, options.MinDepth)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result = &Result{LastBlockID: exportState.LastBlockID}
+	for result.LastBlockID < bound {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		toID := result.LastBlockID + uint64(options.BatchSize)
+		if toID > bound {
+			toID = bound
+		}
+		blockCount, edgeCount, err := exportBatch(ctx, database, options, result.LastBlockID, toID)
+		if err != nil {
+			return nil, err
+		}
+		result.LastBlockID = toID
+		err = writeState(options.Directory, &state{LastBlockID: toID})
+		if err != nil {
+			return nil, err
+		}
+		result.BlockCount += blockCount
+		result.EdgeCount += edgeCount
+		log.Infof("Exported %d blocks and %d edges up to block ID %d", blockCount, edgeCount, toID)
+	}
+	return result, nil
+}
+
+func exportBatch(ctx context.Context, database *databasePackage.Database, options *Options,
+	afterID uint64, toID uint64) (int, int, error) {
+
+	var blocks []*model.Block
+	var edges []*databasePackage.EdgeWithDAAScore
+	err := database.RunInTransaction(ctx, func(databaseTransaction *pg.Tx) error {
+		// The blocks and the edges are read from the same database snapshot
+		_, err := databaseTransaction.Exec("SET TRANSACTION ISOLATION LEVEL REPEATABLE READ, READ ONLY")
+		if err != nil {
+			return err
+		}
+		blocks, err = database.BlocksByIDRange(databaseTransaction, afterID, toID)
+		if err != nil {
+			return err
+		}
+		edges, err = database.EdgesByIDRange(databaseTransaction, afterID, toID)
+		return err
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	fileName := fmt.Sprintf("part-%020d.parquet", afterID+1)
+	blockPartitions := make(map[uint64][]*blockRow)
+	for _, block := range blocks {
+		partition := partitionStart(block.DAAScore, options.PartitionSize)
+		blockPartitions[partition] = append(blockPartitions[partition], newBlockRow(block))
+	}
+	for partition, rows := range blockPartitions {
+		err = writeFile(partitionPath(options.Directory, blocksDirectory, partition, fileName), rows)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	edgePartitions := make(map[uint64][]*edgeRow)
+	for _, edge := range edges {
+		partition := partitionStart(edge.FromDAAScore, options.PartitionSize)
+		edgePartitions[partition] = append(edgePartitions[partition], newEdgeRow(edge))
+	}
+	for partition, rows := range edgePartitions {
+		err = writeFile(partitionPath(options.Directory, edgesDirectory, partition, fileName), rows)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return len(blocks), len(edges), nil
+}
+
+func partitionStart(daaScore uint64, partitionSize uint64) uint64 {
+	return daaScore - daaScore%partitionSize
+}
+
+// partitionPath returns the path of a file of the partition starting at DAA score `partition`,
+// the partition directory being named the Hive way so the analytics tools read its DAA score range
+func partitionPath(directory string, table string, partition uint64, fileName string) string {
+	return filepath.Join(directory, table, fmt.Sprintf("daa_score_start=%d", partition), fileName)
+}
+
+// writeFile writes `rows` to the Parquet file at `path` through a temporary file,
+// so a file is never read partially written
+func writeFile[T any](path string, rows []T) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	temporaryPath := path + ".tmp"
+	file, err := os.Create(temporaryPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temporaryPath)
+	defer file.Close()
+
+	writer := parquet.NewGenericWriter[T](file, parquet.Compression(&parquet.Zstd))
+	_, err = writer.Write(rows)
+	if err != nil {
+		// enhanced error description
+		return errors.Wrapf(err, "Could not write %s", path)
+	}
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(temporaryPath, path)
+}
+
+func readState(directory string) (*state, error) {
+	content, err := os.ReadFile(filepath.Join(directory, stateFile))
+	if os.IsNotExist(err) {
+		return &state{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	exportState := &state{}
+	err = json.Unmarshal(content, exportState)
+	if err != nil {
+		// enhanced error description
+		return nil, errors.Wrapf(err, "Could not read the export state")
+	}
+	return exportState, nil
+}
+
+func writeState(directory string, exportState *state) error {
+	content, err := json.Marshal(exportState)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(directory, stateFile)
+	err = os.WriteFile(path+".tmp", content, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
@@ -0,0 +1,62 @@
+package parquetexport
+
+import (
+	databasePackage "github.com/kaspa-live/kaspa-graph-inspector/processing/database"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/database/model"
+)
+
+// blockRow is the Parquet schema of the blocks, along with the sizes of their parents and merge sets
+type blockRow struct {
+	ID                             uint64   `parquet:"id"`
+	BlockHash                      string   `parquet:"block_hash"`
+	Timestamp                      int64    `parquet:"timestamp,timestamp(millisecond)"`
+	DAAScore                       uint64   `parquet:"daa_score"`
+	Height                         uint64   `parquet:"height"`
+	ParentIDs                      []uint64 `parquet:"parent_ids,list"`
+	SelectedParentID               *uint64  `parquet:"selected_parent_id,optional"`
+	Color                          string   `parquet:"color,dict"`
+	IsInVirtualSelectedParentChain bool     `parquet:"is_in_virtual_selected_parent_chain"`
+	MergeSetBlueIDs                []uint64 `parquet:"merge_set_blue_ids,list"`
+	MergeSetRedIDs                 []uint64 `parquet:"merge_set_red_ids,list"`
+	ParentCount                    int32    `parquet:"parent_count"`
+	MergeSetBlueCount              int32    `parquet:"merge_set_blue_count"`
+	MergeSetRedCount               int32    `parquet:"merge_set_red_count"`
+}
+
+func newBlockRow(block *model.Block) *blockRow {
+	return &blockRow{
+		ID:                             block.ID,
+		BlockHash:                      block.BlockHash,
+		Timestamp:                      block.Timestamp,
+		DAAScore:                       block.DAAScore,
+		Height:                         block.Height,
+		ParentIDs:                      block.ParentIDs,
+		SelectedParentID:               block.SelectedParentID,
+		Color:                          block.Color,
+		IsInVirtualSelectedParentChain: block.IsInVirtualSelectedParentChain,
+		MergeSetBlueIDs:                block.MergeSetBlueIDs,
+		MergeSetRedIDs:                 block.MergeSetRedIDs,
+		ParentCount:                    int32(len(block.ParentIDs)),
+		MergeSetBlueCount:              int32(len(block.MergeSetBlueIDs)),
+		MergeSetRedCount:               int32(len(block.MergeSetRedIDs)),
+	}
+}
+
+// edgeRow is the Parquet schema of the edges, along with the DAA score of the block they start from
+type edgeRow struct {
+	FromBlockID  uint64 `parquet:"from_block_id"`
+	ToBlockID    uint64 `parquet:"to_block_id"`
+	FromHeight   uint64 `parquet:"from_height"`
+	ToHeight     uint64 `parquet:"to_height"`
+	FromDAAScore uint64 `parquet:"from_daa_score"`
+}
+
+func newEdgeRow(edge *databasePackage.EdgeWithDAAScore) *edgeRow {
+	return &edgeRow{
+		FromBlockID:  edge.FromBlockID,
+		ToBlockID:    edge.ToBlockID,
+		FromHeight:   edge.FromHeight,
+		ToHeight:     edge.ToHeight,
+		FromDAAScore: edge.FromDAAScore,
+	}
+}
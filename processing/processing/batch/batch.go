@@ -1,12 +1,13 @@
 package batch
 
 import (
-	"os"
+	"context"
 
-	"github.com/go-pg/pg/v10"
 	databasePackage "github.com/kaspa-live/kaspa-graph-inspector/processing/database"
 	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/logging"
-	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/network/rpcclient"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/metrics"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/network/blocksource"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/tracing"
 	"github.com/kaspanet/kaspad/app/appmessage"
 	"github.com/kaspanet/kaspad/domain/consensus/model/externalapi"
 	"github.com/pkg/errors"
@@ -14,11 +15,15 @@ import (
 
 const MaxSupportedMissingDependencies = 600
 
+// ErrTooManyMissingDependencies is returned when collecting the dependencies of a block
+// finds more than MaxSupportedMissingDependencies missing blocks
+var ErrTooManyMissingDependencies = errors.New("too many missing dependencies")
+
 var log = logging.Logger()
 
 type Batch struct {
-	database      *databasePackage.Database
-	rpcClient     *rpcclient.RPCClient
+	database      databasePackage.Storage
+	blockSource   blocksource.BlockSource
 	blocks        []*BlockAndHash
 	hashes        map[externalapi.DomainHash]*BlockAndHash
 	prunningBlock *externalapi.DomainBlock
@@ -31,10 +36,10 @@ type BlockAndHash struct {
 	hash *externalapi.DomainHash
 }
 
-func New(database *databasePackage.Database, rpcClient *rpcclient.RPCClient, prunningBlock *externalapi.DomainBlock) *Batch {
+func New(database databasePackage.Storage, blockSource blocksource.BlockSource, prunningBlock *externalapi.DomainBlock) *Batch {
 	batch := &Batch{
 		database:      database,
-		rpcClient:     rpcClient,
+		blockSource:   blockSource,
 		blocks:        make([]*BlockAndHash, 0),
 		hashes:        make(map[externalapi.DomainHash]*BlockAndHash),
 		prunningBlock: prunningBlock,
@@ -77,6 +82,11 @@ func (b *Batch) Get(hash *externalapi.DomainHash) (*externalapi.DomainBlock, boo
 	return value.DomainBlock, true
 }
 
+// Len returns the number of blocks in the batch
+func (b *Batch) Len() int {
+	return len(b.blocks)
+}
+
 func (b *Batch) Empty() bool {
 	return len(b.blocks) == 0
 }
@@ -103,28 +113,30 @@ func (b *Batch) Pop() (*externalapi.DomainHash, *externalapi.DomainBlock, bool)
 
 // CollectBlockAndDependencies adds `block` and all its missing direct and
 // indirect dependencies
-func (b *Batch) CollectBlockAndDependencies(databaseTransaction *pg.Tx, hash *externalapi.DomainHash, block *externalapi.DomainBlock) error {
+func (b *Batch) CollectBlockAndDependencies(ctx context.Context, databaseTransaction databasePackage.Transaction, hash *externalapi.DomainHash, block *externalapi.DomainBlock) error {
+	ctx, span := tracing.Start(ctx, "Batch.CollectBlockAndDependencies", tracing.BlockHash(hash))
+	defer span.End()
+
 	b.Add(hash, block)
 	for i := 0; i < len(b.blocks); i++ {
 		item := b.blocks[i]
-		err := b.CollectDirectDependencies(databaseTransaction, item.hash, item.DomainBlock)
+		err := b.CollectDirectDependencies(ctx, databaseTransaction, item.hash, item.DomainBlock)
 		if err != nil {
 			return err
 		}
 
-		// If too many missing dependencies are found, just terminate the process and
-		// let the service make a fresh restart.
+		// If too many missing dependencies are found, give up so the caller
+		// can resync the database from scratch.
 		if len(b.blocks) > MaxSupportedMissingDependencies {
-			log.Errorf("More then %d missing dependencies found! KGI is out of sync with the node.", MaxSupportedMissingDependencies)
-			log.Errorf("Terminating the process so it can restart from scratch.")
-			os.Exit(1)
+			return errors.Wrapf(ErrTooManyMissingDependencies, "more than %d missing dependencies found, KGI is out of sync with the node",
+				MaxSupportedMissingDependencies)
 		}
 	}
 	return nil
 }
 
 // CollectDirectDependencies adds the missing direct parents of `block`
-func (b *Batch) CollectDirectDependencies(databaseTransaction *pg.Tx, hash *externalapi.DomainHash, block *externalapi.DomainBlock) error {
+func (b *Batch) CollectDirectDependencies(ctx context.Context, databaseTransaction databasePackage.Transaction, hash *externalapi.DomainHash, block *externalapi.DomainBlock) error {
 	parentHashes := block.Header.DirectParents()
 	for _, parentHash := range parentHashes {
 		parentExists, err := b.database.DoesBlockExist(databaseTransaction, parentHash)
@@ -133,7 +145,8 @@ func (b *Batch) CollectDirectDependencies(databaseTransaction *pg.Tx, hash *exte
 			return errors.Wrapf(err, "Could not check if parent %s for block %s does exist in database", parentHash, hash)
 		}
 		if !parentExists {
-			rpcBlock, err := b.rpcClient.GetBlock(parentHash.String(), false)
+			metrics.MissingDependencyFound()
+			rpcBlock, err := b.blockSource.GetBlock(ctx, parentHash.String(), false)
 			if err != nil {
 				// We ignore the `block not found` kaspad error.
 				// In this case the parent is out the node scope so we have no way
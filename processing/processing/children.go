@@ -0,0 +1,182 @@
+package processing
+
+import (
+	"container/heap"
+	"context"
+
+	databasePackage "github.com/kaspa-live/kaspa-graph-inspector/processing/database"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/database/model"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/tracing"
+	"github.com/kaspanet/kaspad/domain/consensus/model/externalapi"
+	"github.com/pkg/errors"
+)
+
+// linkStoredChildren adds the block identified by `blockHash` and `blockID` to the parents of those
+// of `childrenHashes` that were stored before it as incomplete blocks, then moves these children
+// and their descendants to the height matching their parents
+func (p *Processing) linkStoredChildren(ctx context.Context, databaseTransaction databasePackage.Transaction,
+	blockHash *externalapi.DomainHash, blockID uint64, childrenHashes []string) (err error) {
+
+	children, err := p.database.ChildrenMissingParent(databaseTransaction, blockID, childrenHashes)
+	if err != nil {
+		// enhanced error description
+		return errors.Wrapf(err, "Could not find the stored children of block %s", blockHash)
+	}
+	if len(children) == 0 {
+		return nil
+	}
+
+	ctx, span := tracing.Start(ctx, "Processing.linkStoredChildren", tracing.BlockHash(blockHash), tracing.Count(len(children)))
+	defer func() { tracing.End(span, err) }()
+
+	blockHeight, err := p.database.BlockHeight(databaseTransaction, blockID)
+	if err != nil {
+		return err
+	}
+	blockHeightGroupIndex, err := p.database.BlockHeightGroupIndex(databaseTransaction, blockID)
+	if err != nil {
+		return err
+	}
+
+	for _, child := range children {
+		log.Infof("Linking block %s to its child %s stored before it", blockHash, child.BlockHash)
+		err = p.database.AddBlockParent(databaseTransaction, child.ID, blockID)
+		if err != nil {
+			// enhanced error description
+			return errors.Wrapf(err, "Could not add block %s to the parents of block %s", blockHash, child.BlockHash)
+		}
+		childHeightGroupIndex, err := p.database.BlockHeightGroupIndex(databaseTransaction, child.ID)
+		if err != nil {
+			return err
+		}
+		edge := &model.Edge{
+			FromBlockID:          child.ID,
+			ToBlockID:            blockID,
+			FromHeight:           child.Height,
+			ToHeight:             blockHeight,
+			FromHeightGroupIndex: childHeightGroupIndex,
+			ToHeightGroupIndex:   blockHeightGroupIndex,
+		}
+		err = p.database.InsertEdge(databaseTransaction, edge)
+		if err != nil {
+			// enhanced error description
+			return errors.Wrapf(err, "Could not insert edge from block %s to parent %s", child.BlockHash, blockHash)
+		}
+	}
+
+	return p.recomputeHeights(ctx, databaseTransaction, children)
+}
+
+// recomputeHeights moves the blocks of `blocks`, and then their descendants, to the highest height
+// of their stored parents plus one. The blocks are visited once each, in the order of their stored
+// heights, so all the moved parents of a block are at their final height by the time it moves.
+// Each block leaving a height group is appended to the group of its new height, and the height
+// groups it left are renumbered.
+func (p *Processing) recomputeHeights(ctx context.Context, databaseTransaction databasePackage.Transaction,
+	blocks []databasePackage.BlockReference) error {
+
+	leftHeights := make(map[uint64]struct{})
+	queued := make(map[uint64]struct{}, len(blocks))
+	pending := &blocksByHeight{}
+	for _, block := range blocks {
+		if _, ok := queued[block.ID]; !ok {
+			queued[block.ID] = struct{}{}
+			heap.Push(pending, block)
+		}
+	}
+	for pending.Len() > 0 {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		blockID := heap.Pop(pending).(databasePackage.BlockReference).ID
+
+		block, err := p.database.GetBlock(databaseTransaction, blockID)
+		if err != nil {
+			return err
+		}
+		parentIDs, err := p.database.ExistingBlockIDs(databaseTransaction, block.ParentIDs)
+		if err != nil {
+			return err
+		}
+		// Blocks without stored parents keep their height
+		height := block.Height
+		if len(parentIDs) > 0 {
+			highestParentHeight, err := p.database.HighestBlockHeight(databaseTransaction, parentIDs)
+			if err != nil {
+				return err
+			}
+			height = highestParentHeight + 1
+		}
+		if height == block.Height {
+			continue
+		}
+		blockHash, err := externalapi.NewDomainHashFromString(block.BlockHash)
+		if err != nil {
+			return err
+		}
+		log.Debugf("Moving block %s from height %d to height %d", blockHash, block.Height, height)
+		heightGroupSize, err := p.database.HeightGroupSize(databaseTransaction, height)
+		if err != nil {
+			// enhanced error description
+			return errors.Wrapf(err, "Could not resolve group size for height %d for block %s", height, blockHash)
+		}
+		err = p.database.InsertOrUpdateHeightGroup(databaseTransaction, &model.HeightGroup{
+			Height: height,
+			Size:   heightGroupSize + 1,
+		})
+		if err != nil {
+			// enhanced error description
+			return errors.Wrapf(err, "Could not insert or update height group %d for block %s", height, blockHash)
+		}
+		err = p.database.UpdateBlockHeight(databaseTransaction, blockID, blockHash, height, heightGroupSize)
+		if err != nil {
+			// enhanced error description
+			return errors.Wrapf(err, "Could not update the height of block %s", blockHash)
+		}
+		err = p.database.RefreshEdgesOfBlock(databaseTransaction, blockID)
+		if err != nil {
+			// enhanced error description
+			return errors.Wrapf(err, "Could not update the edges of block %s", blockHash)
+		}
+		leftHeights[block.Height] = struct{}{}
+
+		children, err := p.database.ChildBlocks(databaseTransaction, blockID)
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			if _, ok := queued[child.ID]; !ok {
+				queued[child.ID] = struct{}{}
+				heap.Push(pending, child)
+			}
+		}
+	}
+
+	for height := range leftHeights {
+		err := p.database.RenumberHeightGroup(databaseTransaction, height)
+		if err != nil {
+			// enhanced error description
+			return errors.Wrapf(err, "Could not renumber height group %d", height)
+		}
+	}
+	return nil
+}
+
+// blocksByHeight is a heap of blocks ordered by height. A block stored at a consistent height lies
+// above all its stored ancestors, so they are popped before it.
+type blocksByHeight []databasePackage.BlockReference
+
+func (blocks blocksByHeight) Len() int           { return len(blocks) }
+func (blocks blocksByHeight) Less(i, j int) bool { return blocks[i].Height < blocks[j].Height }
+func (blocks blocksByHeight) Swap(i, j int)      { blocks[i], blocks[j] = blocks[j], blocks[i] }
+
+func (blocks *blocksByHeight) Push(block interface{}) {
+	*blocks = append(*blocks, block.(databasePackage.BlockReference))
+}
+
+func (blocks *blocksByHeight) Pop() interface{} {
+	old := *blocks
+	block := old[len(old)-1]
+	*blocks = old[:len(old)-1]
+	return block
+}
@@ -0,0 +1,82 @@
+package processing_test
+
+import (
+	"context"
+	"testing"
+
+	databasePackage "github.com/kaspa-live/kaspa-graph-inspector/processing/database"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/network/fakekaspad"
+)
+
+// requireConsistent fails the test if the consistency checks report a violation in the stored blocks
+func (h *harness) requireConsistent() {
+	h.t.Helper()
+
+	err := h.storage.RunInTransaction(context.Background(), func(databaseTransaction databasePackage.Transaction) error {
+		mismatchedEdges, err := h.storage.BlocksWithMismatchedEdges(databaseTransaction)
+		if err != nil {
+			return err
+		}
+		wrongHeights, err := h.storage.BlocksWithWrongHeight(databaseTransaction)
+		if err != nil {
+			return err
+		}
+		wrongSizes, err := h.storage.HeightGroupsWithWrongSize(databaseTransaction)
+		if err != nil {
+			return err
+		}
+		if len(mismatchedEdges) != 0 || len(wrongHeights) != 0 || len(wrongSizes) != 0 {
+			h.t.Fatalf("Blocks with mismatched edges %v, with a wrong height %v, height groups with a wrong size %v",
+				mismatchedEdges, wrongHeights, wrongSizes)
+		}
+		return nil
+	})
+	if err != nil {
+		h.t.Fatalf("Could not check the consistency of the stored blocks: %s", err)
+	}
+}
+
+// requireHeights fails the test if the blocks named in `heights` are not stored at their height
+func (h *harness) requireHeights(heights map[string]uint64) {
+	h.t.Helper()
+
+	for name, height := range heights {
+		block := h.storedBlock(name)
+		if block.Height != height {
+			h.t.Fatalf("Block %s is stored at height %d, expected %d", name, block.Height, height)
+		}
+	}
+}
+
+func TestProcessingLinksChildrenStoredBeforeTheirParent(t *testing.T) {
+	h := newHarness(t)
+	h.start()
+	parentName := fakekaspad.GenesisName
+	for _, name := range []string{"a1", "a2", "a3", "a4", "a5", "a6"} {
+		h.addBlock(name, parentName)
+		parentName = name
+	}
+	h.waitProcessed()
+
+	// The node misses b1, so b2 gets stored without it at the lowest height, along with its descendants
+	h.addUnnotifiedBlock("b1", fakekaspad.GenesisName)
+	h.server.SetHidden("b1", true)
+	h.addBlock("b2", "b1")
+	h.addBlock("b3", "b2")
+	h.addBlock("b4", "b2", "b3")
+	h.waitProcessed()
+	h.requireHeights(map[string]uint64{"b2": 0, "b3": 1, "b4": 2})
+	h.requireConsistent()
+
+	// b1 is stored as a missing parent of d, moving b2 above it and b4 above both b2 and b3
+	h.server.SetHidden("b1", false)
+	h.addBlock("d", "a6", "b1")
+	h.waitProcessed()
+	h.requireHeights(map[string]uint64{"b1": 1, "b2": 2, "b3": 3, "b4": 4, "d": 7})
+	h.requireConsistent()
+	b1 := h.storedBlock("b1")
+	b2 := h.storedBlock("b2")
+	if len(b2.ParentIDs) != 1 || b2.ParentIDs[0] != b1.ID {
+		t.Fatalf("Block b2 is stored with parents %v, expected b1 %d", b2.ParentIDs, b1.ID)
+	}
+}
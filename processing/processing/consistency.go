@@ -0,0 +1,400 @@
+package processing
+
+import (
+	"context"
+
+	databasePackage "github.com/kaspa-live/kaspa-graph-inspector/processing/database"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/database/model"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/tracing"
+	"github.com/kaspanet/kaspad/app/appmessage"
+	"github.com/kaspanet/kaspad/domain/consensus/model/externalapi"
+	"github.com/pkg/errors"
+)
+
+// Invariants verified by CheckConsistency
+const (
+	// InvariantEdges requires the edges of a block to match its parent IDs
+	InvariantEdges = "edges"
+	// InvariantParents requires the parent IDs of a block to reference stored blocks
+	InvariantParents = "parents"
+	// InvariantHeight requires the height of a block to be the highest height of its parents plus one
+	InvariantHeight = "height"
+	// InvariantHeightGroup requires the size of a height group to match the blocks at its height
+	InvariantHeightGroup = "height-group"
+	// InvariantSelectedParent requires every block having parents, but the pruning point, to have a selected parent
+	InvariantSelectedParent = "selected-parent"
+	// InvariantMergeSet requires the merge set IDs of a block to reference stored blocks
+	InvariantMergeSet = "merge-set"
+)
+
+// Repairing a block may reveal violations of the blocks it gets linked to, fixed by the next pass
+const maxRepairPasses = 16
+
+// ConsistencyViolation describes a block or a height group breaking an invariant
+type ConsistencyViolation struct {
+	Invariant string `json:"invariant"`
+	BlockID   uint64 `json:"blockId,omitempty"`
+	BlockHash string `json:"blockHash,omitempty"`
+	Height    uint64 `json:"height"`
+}
+
+// ConsistencyReport describes the violations found by a consistency check and the repairs made
+type ConsistencyReport struct {
+	Violations   []*ConsistencyViolation `json:"violations"`
+	Repaired     int                     `json:"repaired"`
+	Unrepairable []string                `json:"unrepairable"`
+	Remaining    []*ConsistencyViolation `json:"remaining"`
+}
+
+// CheckConsistency verifies the invariants of the stored blocks, edges and height groups.
+// If `repair` is true, the violating blocks are rewritten from the data of the node and the
+// violating height groups are renumbered, until no violation is left or no progress is made.
+func (p *Processing) CheckConsistency(ctx context.Context, repair bool) (report *ConsistencyReport, err error) {
+	p.Lock()
+	defer p.Unlock()
+
+	ctx, span := tracing.Start(ctx, "Processing.CheckConsistency")
+	defer func() { tracing.End(span, err) }()
+
+	report = &ConsistencyReport{Unrepairable: []string{}}
+	err = p.runInTransaction(ctx, func(databaseTransaction databasePackage.Transaction) error {
+		var err error
+		pruningPointID := uint64(0)
+		if p.blockSource != nil {
+			pruningPointID, err = p.pruningPointID(ctx, databaseTransaction)
+			if err != nil {
+				return err
+			}
+		}
+
+		violations, err := p.findConsistencyViolations(databaseTransaction, pruningPointID)
+		if err != nil {
+			return err
+		}
+		report.Violations = violations
+		log.Infof("Found %d consistency violations", len(violations))
+		if !repair {
+			report.Remaining = violations
+			return nil
+		}
+
+		unrepairable := make(map[uint64]struct{})
+		for pass := 1; pass <= maxRepairPasses && len(violations) > 0; pass++ {
+			repaired, err := p.repairConsistencyViolations(ctx, databaseTransaction, violations, unrepairable, report)
+			if err != nil {
+				return err
+			}
+			report.Repaired += repaired
+			log.Infof("Repair pass %d: %d of %d violations repaired", pass, repaired, len(violations))
+			if repaired == 0 {
+				break
+			}
+
+			violations, err = p.findConsistencyViolations(databaseTransaction, pruningPointID)
+			if err != nil {
+				return err
+			}
+		}
+		report.Remaining = violations
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func (p *Processing) pruningPointID(ctx context.Context, databaseTransaction databasePackage.Transaction) (uint64, error) {
+	dagInfo, err := p.blockSource.GetBlockDAGInfo(ctx)
+	if err != nil {
+		return 0, err
+	}
+	pruningPointID, err := p.blockIDByHashString(databaseTransaction, dagInfo.PruningPointHash)
+	if err != nil {
+		log.Warnf("The pruning point %s is not stored in the database", dagInfo.PruningPointHash)
+		return 0, nil
+	}
+	return pruningPointID, nil
+}
+
+func (p *Processing) findConsistencyViolations(databaseTransaction databasePackage.Transaction, pruningPointID uint64) ([]*ConsistencyViolation, error) {
+	violations := make([]*ConsistencyViolation, 0)
+
+	for _, blockCheck := range []struct {
+		invariant string
+		find      func(databasePackage.Transaction) ([]databasePackage.BlockReference, error)
+	}{
+		{InvariantEdges, p.database.BlocksWithMismatchedEdges},
+		{InvariantParents, p.database.BlocksWithUnresolvedParents},
+		{InvariantHeight, p.database.BlocksWithWrongHeight},
+		{InvariantSelectedParent, func(databaseTransaction databasePackage.Transaction) ([]databasePackage.BlockReference, error) {
+			return p.database.BlocksWithoutSelectedParent(databaseTransaction, pruningPointID)
+		}},
+		{InvariantMergeSet, p.database.BlocksWithUnresolvedMergeSet},
+	} {
+		blocks, err := blockCheck.find(databaseTransaction)
+		if err != nil {
+			// enhanced error description
+			return nil, errors.Wrapf(err, "Could not check the %s invariant", blockCheck.invariant)
+		}
+		for _, block := range blocks {
+			violations = append(violations, &ConsistencyViolation{
+				Invariant: blockCheck.invariant,
+				BlockID:   block.ID,
+				BlockHash: block.BlockHash,
+				Height:    block.Height,
+			})
+		}
+	}
+
+	heightGroups, err := p.database.HeightGroupsWithWrongSize(databaseTransaction)
+	if err != nil {
+		// enhanced error description
+		return nil, errors.Wrapf(err, "Could not check the %s invariant", InvariantHeightGroup)
+	}
+	for _, heightGroup := range heightGroups {
+		log.Debugf("Height group %d has size %d for %d blocks", heightGroup.Height, heightGroup.Size, heightGroup.BlockCount)
+		violations = append(violations, &ConsistencyViolation{
+			Invariant: InvariantHeightGroup,
+			Height:    heightGroup.Height,
+		})
+	}
+
+	for _, violation := range violations {
+		if violation.BlockHash != "" {
+			log.Warnf("Block %s at height %d breaks the %s invariant", violation.BlockHash, violation.Height, violation.Invariant)
+		} else {
+			log.Warnf("Height group %d breaks the %s invariant", violation.Height, violation.Invariant)
+		}
+	}
+	return violations, nil
+}
+
+// repairConsistencyViolations repairs each violating block once, then renumbers the violating
+// height groups along with the groups the repaired blocks moved out of.
+// Returns the number of blocks and height groups repaired.
+func (p *Processing) repairConsistencyViolations(ctx context.Context, databaseTransaction databasePackage.Transaction,
+	violations []*ConsistencyViolation, unrepairable map[uint64]struct{}, report *ConsistencyReport) (int, error) {
+
+	repaired := 0
+	repairedBlocks := make(map[uint64]struct{})
+	heightGroups := make(map[uint64]struct{})
+	for _, violation := range violations {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		if violation.BlockHash == "" {
+			heightGroups[violation.Height] = struct{}{}
+			continue
+		}
+		if _, ok := repairedBlocks[violation.BlockID]; ok {
+			continue
+		}
+		if _, ok := unrepairable[violation.BlockID]; ok {
+			continue
+		}
+		repairedBlocks[violation.BlockID] = struct{}{}
+
+		previousHeight, ok, err := p.repairBlock(ctx, databaseTransaction, violation)
+		if err != nil {
+			// enhanced error description
+			return 0, errors.Wrapf(err, "Could not repair block %s", violation.BlockHash)
+		}
+		if !ok {
+			unrepairable[violation.BlockID] = struct{}{}
+			report.Unrepairable = append(report.Unrepairable, violation.BlockHash)
+			continue
+		}
+		heightGroups[previousHeight] = struct{}{}
+		repaired++
+	}
+
+	for height := range heightGroups {
+		err := p.database.RenumberHeightGroup(databaseTransaction, height)
+		if err != nil {
+			// enhanced error description
+			return 0, errors.Wrapf(err, "Could not renumber height group %d", height)
+		}
+		repaired++
+	}
+	return repaired, nil
+}
+
+// repairBlock rewrites the parents, height, edges, selected parent and merge set of the block
+// referenced by `violation` from the data of the node. Blocks unknown to the node only get
+// their parents, height and edges rewritten from their stored parents. Returns the height
+// of the block before the repair, and false if the violation cannot be repaired.
+func (p *Processing) repairBlock(ctx context.Context, databaseTransaction databasePackage.Transaction,
+	violation *ConsistencyViolation) (uint64, bool, error) {
+
+	blockHash, err := externalapi.NewDomainHashFromString(violation.BlockHash)
+	if err != nil {
+		return 0, false, err
+	}
+	databaseBlock, err := p.database.GetBlock(databaseTransaction, violation.BlockID)
+	if err != nil {
+		return 0, false, err
+	}
+
+	var rpcBlock *appmessage.GetBlockResponseMessage
+	if p.blockSource != nil {
+		rpcBlock, err = p.blockSource.GetBlock(ctx, violation.BlockHash, false)
+		if err != nil {
+			log.Warnf("Block %s is not provided by the node: %s", violation.BlockHash, err)
+			rpcBlock = nil
+		}
+	}
+	if rpcBlock == nil && (violation.Invariant == InvariantSelectedParent || violation.Invariant == InvariantMergeSet) {
+		log.Warnf("Block %s cannot be repaired without the node", violation.BlockHash)
+		return 0, false, nil
+	}
+
+	var parentIDs []uint64
+	if rpcBlock != nil {
+		block, err := appmessage.RPCBlockToDomainBlock(rpcBlock.Block)
+		if err != nil {
+			return 0, false, err
+		}
+		parentIDs, err = p.resolveBlockIDs(ctx, databaseTransaction, block.Header.DirectParents())
+		if err != nil {
+			return 0, false, err
+		}
+	} else {
+		parentIDs, err = p.database.ExistingBlockIDs(databaseTransaction, databaseBlock.ParentIDs)
+		if err != nil {
+			return 0, false, err
+		}
+	}
+
+	// Blocks without stored parents keep their height
+	blockHeight := databaseBlock.Height
+	if len(parentIDs) > 0 {
+		highestParentHeight, err := p.database.HighestBlockHeight(databaseTransaction, parentIDs)
+		if err != nil {
+			return 0, false, err
+		}
+		blockHeight = highestParentHeight + 1
+	}
+	blockHeightGroupIndex := databaseBlock.HeightGroupIndex
+	if blockHeight != databaseBlock.Height {
+		log.Infof("Moving block %s from height %d to height %d", blockHash, databaseBlock.Height, blockHeight)
+		heightGroupSize, err := p.database.HeightGroupSize(databaseTransaction, blockHeight)
+		if err != nil {
+			return 0, false, err
+		}
+		blockHeightGroupIndex = heightGroupSize
+		err = p.database.InsertOrUpdateHeightGroup(databaseTransaction, &model.HeightGroup{
+			Height: blockHeight,
+			Size:   heightGroupSize + 1,
+		})
+		if err != nil {
+			return 0, false, err
+		}
+	}
+
+	err = p.database.UpdateBlockParents(databaseTransaction, violation.BlockID, blockHash, parentIDs, blockHeight, blockHeightGroupIndex)
+	if err != nil {
+		return 0, false, err
+	}
+	err = p.database.DeleteEdgesFromBlock(databaseTransaction, violation.BlockID)
+	if err != nil {
+		return 0, false, err
+	}
+	err = p.insertBlockEdges(databaseTransaction, blockHash, violation.BlockID, blockHeight, blockHeightGroupIndex, parentIDs)
+	if err != nil {
+		return 0, false, err
+	}
+	// The edges from the children hold the previous coordinates of the block
+	err = p.database.RefreshEdgesOfBlock(databaseTransaction, violation.BlockID)
+	if err != nil {
+		return 0, false, err
+	}
+	if blockHeight != databaseBlock.Height {
+		children, err := p.database.ChildBlocks(databaseTransaction, violation.BlockID)
+		if err != nil {
+			return 0, false, err
+		}
+		err = p.recomputeHeights(ctx, databaseTransaction, children)
+		if err != nil {
+			return 0, false, err
+		}
+	}
+
+	if rpcBlock == nil || rpcBlock.Block.VerboseData.IsHeaderOnly {
+		return databaseBlock.Height, true, nil
+	}
+
+	selectedParent, err := externalapi.NewDomainHashFromString(rpcBlock.Block.VerboseData.SelectedParentHash)
+	if err != nil {
+		return 0, false, err
+	}
+	selectedParentIDs, err := p.resolveBlockIDs(ctx, databaseTransaction, []*externalapi.DomainHash{selectedParent})
+	if err != nil {
+		return 0, false, err
+	}
+	if len(selectedParentIDs) == 1 {
+		err = p.database.UpdateBlockSelectedParent(databaseTransaction, violation.BlockID, selectedParentIDs[0])
+		if err != nil {
+			return 0, false, err
+		}
+	}
+
+	mergeSetReds, err := hashesFromStrings(rpcBlock.Block.VerboseData.MergeSetRedsHashes)
+	if err != nil {
+		return 0, false, err
+	}
+	mergeSetRedIDs, err := p.resolveBlockIDs(ctx, databaseTransaction, mergeSetReds)
+	if err != nil {
+		return 0, false, err
+	}
+	mergeSetBlues, err := hashesFromStrings(rpcBlock.Block.VerboseData.MergeSetBluesHashes)
+	if err != nil {
+		return 0, false, err
+	}
+	mergeSetBlueIDs, err := p.resolveBlockIDs(ctx, databaseTransaction, mergeSetBlues)
+	if err != nil {
+		return 0, false, err
+	}
+	err = p.database.UpdateBlockMergeSet(databaseTransaction, violation.BlockID, mergeSetRedIDs, mergeSetBlueIDs)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return databaseBlock.Height, true, nil
+}
+
+// resolveBlockIDs returns the IDs of the blocks identified by `blockHashes`, processing
+// the blocks missing in the database. Blocks the node does not provide are skipped.
+func (p *Processing) resolveBlockIDs(ctx context.Context, databaseTransaction databasePackage.Transaction,
+	blockHashes []*externalapi.DomainHash) ([]uint64, error) {
+
+	blockIDs := make([]uint64, 0, len(blockHashes))
+	for _, blockHash := range blockHashes {
+		blockExists, err := p.database.DoesBlockExist(databaseTransaction, blockHash)
+		if err != nil {
+			return nil, err
+		}
+		if !blockExists {
+			rpcBlock, err := p.blockSource.GetBlock(ctx, blockHash.String(), false)
+			if err != nil {
+				log.Warnf("Block %s is missing in the database and not provided by the node: %s", blockHash, err)
+				continue
+			}
+			block, err := appmessage.RPCBlockToDomainBlock(rpcBlock.Block)
+			if err != nil {
+				return nil, err
+			}
+			err = p.processBlockAndDependencies(ctx, databaseTransaction, blockHash, block, nil)
+			if err != nil {
+				return nil, err
+			}
+		}
+		blockID, err := p.database.BlockIDByHash(databaseTransaction, blockHash)
+		if err != nil {
+			// enhanced error description
+			return nil, errors.Wrapf(err, "Could not get id for block %s", blockHash)
+		}
+		blockIDs = append(blockIDs, blockID)
+	}
+	return blockIDs, nil
+}
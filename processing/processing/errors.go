@@ -0,0 +1,22 @@
+package processing
+
+import (
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/processing/batch"
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidNotification is returned when a node notification cannot be decoded
+var ErrInvalidNotification = errors.New("invalid node notification")
+
+// ErrPanic is returned when a supervised operation panics
+var ErrPanic = errors.New("unexpected panic")
+
+// ErrTooManyFailures is the error the processing stops with once
+// the supervisor gave up recovering from failures
+var ErrTooManyFailures = errors.New("too many consecutive failures")
+
+// requiresResync returns true if `err` means that the database cannot be
+// brought back in sync with the node by retrying the failed operation
+func requiresResync(err error) bool {
+	return errors.Is(err, batch.ErrTooManyMissingDependencies) || errors.Is(err, ErrInvalidNotification)
+}
@@ -0,0 +1,208 @@
+package eventqueue
+
+import (
+	"context"
+	"sync"
+
+	"github.com/kaspanet/kaspad/app/appmessage"
+)
+
+// Event is a node event waiting to be processed
+type Event interface {
+	isEvent()
+}
+
+// BlockAdded is the event of a block added to the node DAG
+type BlockAdded struct {
+	Notification *appmessage.BlockAddedNotificationMessage
+}
+
+// ChainChanged is the event of a change in the node virtual selected parent chain.
+// It may be the result of several coalesced consecutive changes.
+type ChainChanged struct {
+	RemovedChainBlockHashes []string
+	AddedChainBlockHashes   []string
+}
+
+// Overflow replaces all the events discarded when the queue overflowed.
+// The database must be resynced with the node to recover the lost events.
+type Overflow struct {
+	Discarded int
+}
+
+func (*BlockAdded) isEvent()   {}
+func (*ChainChanged) isEvent() {}
+func (*Overflow) isEvent()     {}
+
+// Queue is a bounded FIFO queue of node events.
+//
+// Pushing never blocks so that the RPC router is never held back by a slow processing.
+// When the queue is full, all its events get discarded and replaced by a single Overflow event.
+type Queue struct {
+	capacity        int
+	coalesceChanges bool
+
+	events   []Event
+	overflow *Overflow
+	signal   chan struct{}
+	// isHandling is true from the pop of an event until the consumer reports it as done
+	isHandling bool
+	// idle gets closed once the queue gets empty with no event being handled
+	idle chan struct{}
+	sync.Mutex
+}
+
+// New creates a queue holding at most `capacity` events.
+// If `coalesceChanges` is true, consecutive chain changes are merged into one event.
+func New(capacity int, coalesceChanges bool) *Queue {
+	return &Queue{
+		capacity:        capacity,
+		coalesceChanges: coalesceChanges,
+		events:          make([]Event, 0, capacity),
+		signal:          make(chan struct{}, 1),
+	}
+}
+
+// Push appends `event` to the queue.
+// Returns false if the queue overflowed.
+func (q *Queue) Push(event Event) bool {
+	q.Lock()
+	defer q.Unlock()
+	defer q.notify()
+
+	if chainChanged, ok := event.(*ChainChanged); ok && q.coalesceChanges && len(q.events) > 0 {
+		if last, ok := q.events[len(q.events)-1].(*ChainChanged); ok {
+			last.coalesce(chainChanged)
+			return true
+		}
+	}
+
+	if len(q.events) < q.capacity {
+		q.events = append(q.events, event)
+		return true
+	}
+
+	// The queue is full so its events are replaced by an overflow event.
+	// If an overflow event is already pending, it is kept and accounts for the new losses.
+	discarded := len(q.events) + 1
+	if q.overflow != nil {
+		discarded += q.overflow.Discarded - 1
+	}
+	q.overflow = &Overflow{Discarded: discarded}
+	q.events = append(q.events[:0], q.overflow)
+	return false
+}
+
+// Pop removes and returns the oldest event of the queue, waiting for one if the queue is empty.
+// Returns an error if `ctx` gets done first.
+func (q *Queue) Pop(ctx context.Context) (Event, error) {
+	for {
+		event, ok := q.tryPop()
+		if ok {
+			return event, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-q.signal:
+		}
+	}
+}
+
+func (q *Queue) tryPop() (Event, bool) {
+	q.Lock()
+	defer q.Unlock()
+
+	if len(q.events) == 0 {
+		return nil, false
+	}
+	event := q.events[0]
+	q.events[0] = nil
+	q.events = q.events[1:]
+	if event == q.overflow {
+		q.overflow = nil
+	}
+	q.isHandling = true
+	return event, true
+}
+
+// Done reports that the consumer is done handling the last popped event
+func (q *Queue) Done() {
+	q.Lock()
+	defer q.Unlock()
+
+	q.isHandling = false
+	if len(q.events) == 0 && q.idle != nil {
+		close(q.idle)
+		q.idle = nil
+	}
+}
+
+// WaitIdle waits until the queue is empty and the consumer is done handling the last popped event.
+// Returns an error if `ctx` gets done first.
+func (q *Queue) WaitIdle(ctx context.Context) error {
+	q.Lock()
+	if len(q.events) == 0 && !q.isHandling {
+		q.Unlock()
+		return nil
+	}
+	if q.idle == nil {
+		q.idle = make(chan struct{})
+	}
+	idle := q.idle
+	q.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-idle:
+		return nil
+	}
+}
+
+// Len returns the number of events in the queue
+func (q *Queue) Len() int {
+	q.Lock()
+	defer q.Unlock()
+
+	return len(q.events)
+}
+
+func (q *Queue) notify() {
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
+
+// coalesce merges `next`, the chain change following `c`, into `c`.
+// Blocks added by `c` and removed by `next` end up in neither list since
+// they were not part of the chain before `c` either.
+func (c *ChainChanged) coalesce(next *ChainChanged) {
+	removedByNext := make(map[string]struct{}, len(next.RemovedChainBlockHashes))
+	for _, hash := range next.RemovedChainBlockHashes {
+		removedByNext[hash] = struct{}{}
+	}
+	addedByCurrent := make(map[string]struct{}, len(c.AddedChainBlockHashes))
+	for _, hash := range c.AddedChainBlockHashes {
+		addedByCurrent[hash] = struct{}{}
+	}
+
+	added := make([]string, 0, len(c.AddedChainBlockHashes)+len(next.AddedChainBlockHashes))
+	for _, hash := range c.AddedChainBlockHashes {
+		if _, ok := removedByNext[hash]; !ok {
+			added = append(added, hash)
+		}
+	}
+	added = append(added, next.AddedChainBlockHashes...)
+
+	removed := c.RemovedChainBlockHashes
+	for _, hash := range next.RemovedChainBlockHashes {
+		if _, ok := addedByCurrent[hash]; !ok {
+			removed = append(removed, hash)
+		}
+	}
+
+	c.RemovedChainBlockHashes = removed
+	c.AddedChainBlockHashes = added
+}
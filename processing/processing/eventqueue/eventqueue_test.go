@@ -0,0 +1,197 @@
+package eventqueue
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kaspanet/kaspad/app/appmessage"
+)
+
+const waitTimeout = 10 * time.Second
+
+// blockAdded returns a distinct BlockAdded event
+func blockAdded() *BlockAdded {
+	return &BlockAdded{Notification: &appmessage.BlockAddedNotificationMessage{}}
+}
+
+// pop pops the next event of `q`, failing the test if there is none
+func pop(t *testing.T, q *Queue) Event {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+	defer cancel()
+	event, err := q.Pop(ctx)
+	if err != nil {
+		t.Fatalf("Pop: %s", err)
+	}
+	q.Done()
+	return event
+}
+
+// requireOverflow fails the test unless `event` is an overflow of `discarded` events
+func requireOverflow(t *testing.T, event Event, discarded int) {
+	t.Helper()
+
+	overflow, ok := event.(*Overflow)
+	if !ok {
+		t.Fatalf("Popped %T, expected an overflow", event)
+	}
+	if overflow.Discarded != discarded {
+		t.Fatalf("The overflow discarded %d events, expected %d", overflow.Discarded, discarded)
+	}
+}
+
+func TestPushOverflow(t *testing.T) {
+	q := New(3, false)
+	for i := 0; i < 3; i++ {
+		if !q.Push(blockAdded()) {
+			t.Fatalf("Push %d overflowed a queue of capacity 3", i)
+		}
+	}
+	if q.Push(blockAdded()) {
+		t.Fatalf("Push did not overflow a full queue")
+	}
+	if q.Len() != 1 {
+		t.Fatalf("The overflowed queue holds %d events, expected 1", q.Len())
+	}
+	requireOverflow(t, pop(t, q), 4)
+
+	// The events pushed after the overflow got popped are accounted for by a new overflow
+	for i := 0; i < 3; i++ {
+		q.Push(blockAdded())
+	}
+	q.Push(blockAdded())
+	requireOverflow(t, pop(t, q), 4)
+}
+
+func TestPushPendingOverflow(t *testing.T) {
+	q := New(3, false)
+	for i := 0; i < 4; i++ {
+		q.Push(blockAdded())
+	}
+	// The events following the pending overflow are discarded along with it on the next overflow
+	event := blockAdded()
+	q.Push(event)
+	q.Push(blockAdded())
+	if q.Push(blockAdded()) {
+		t.Fatalf("Push did not overflow a full queue")
+	}
+	requireOverflow(t, pop(t, q), 7)
+	if q.Len() != 0 {
+		t.Fatalf("The queue holds %d events after its overflow got popped, expected none", q.Len())
+	}
+
+	// An overflow pending with room left is followed by the new events
+	for i := 0; i < 4; i++ {
+		q.Push(blockAdded())
+	}
+	q.Push(event)
+	requireOverflow(t, pop(t, q), 4)
+	if popped := pop(t, q); popped != event {
+		t.Fatalf("Popped %v after the overflow, expected %v", popped, event)
+	}
+}
+
+func TestCoalesceChainChanges(t *testing.T) {
+	q := New(10, true)
+	q.Push(&ChainChanged{RemovedChainBlockHashes: []string{"x"}, AddedChainBlockHashes: []string{"a", "b"}})
+	// b leaves the chain right after joining it, and x joins it again
+	q.Push(&ChainChanged{RemovedChainBlockHashes: []string{"b"}, AddedChainBlockHashes: []string{"x", "c"}})
+	q.Push(&ChainChanged{AddedChainBlockHashes: []string{"d"}})
+	// A block added breaks the coalescing, the following chain changes keeping their order
+	event := blockAdded()
+	q.Push(event)
+	q.Push(&ChainChanged{RemovedChainBlockHashes: []string{"d"}, AddedChainBlockHashes: []string{"e"}})
+	if q.Len() != 3 {
+		t.Fatalf("The queue holds %d events, expected 3", q.Len())
+	}
+
+	expected := &ChainChanged{RemovedChainBlockHashes: []string{"x"}, AddedChainBlockHashes: []string{"a", "x", "c", "d"}}
+	if popped := pop(t, q); !reflect.DeepEqual(popped, expected) {
+		t.Fatalf("Popped %+v, expected %+v", popped, expected)
+	}
+	if popped := pop(t, q); popped != event {
+		t.Fatalf("Popped %+v, expected the block added", popped)
+	}
+	expected = &ChainChanged{RemovedChainBlockHashes: []string{"d"}, AddedChainBlockHashes: []string{"e"}}
+	if popped := pop(t, q); !reflect.DeepEqual(popped, expected) {
+		t.Fatalf("Popped %+v, expected %+v", popped, expected)
+	}
+
+	// Without coalescing, every chain change is kept
+	q = New(10, false)
+	q.Push(&ChainChanged{AddedChainBlockHashes: []string{"a"}})
+	q.Push(&ChainChanged{AddedChainBlockHashes: []string{"b"}})
+	if q.Len() != 2 {
+		t.Fatalf("The queue holds %d events, expected 2", q.Len())
+	}
+}
+
+func TestWaitIdle(t *testing.T) {
+	q := New(10, false)
+	err := q.WaitIdle(context.Background())
+	if err != nil {
+		t.Fatalf("WaitIdle on an empty queue: %s", err)
+	}
+
+	requireBusy := func() {
+		t.Helper()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		err := q.WaitIdle(ctx)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("WaitIdle returned %v, expected the queue to be busy", err)
+		}
+	}
+	q.Push(blockAdded())
+	q.Push(blockAdded())
+	requireBusy()
+
+	idle := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+		defer cancel()
+		idle <- q.WaitIdle(ctx)
+	}()
+	_, err = q.Pop(context.Background())
+	if err != nil {
+		t.Fatalf("Pop: %s", err)
+	}
+	// The queue is not idle while events are left or the popped one is being handled
+	q.Done()
+	requireBusy()
+	_, err = q.Pop(context.Background())
+	if err != nil {
+		t.Fatalf("Pop: %s", err)
+	}
+	requireBusy()
+	q.Done()
+	err = <-idle
+	if err != nil {
+		t.Fatalf("WaitIdle: %s", err)
+	}
+	err = q.WaitIdle(context.Background())
+	if err != nil {
+		t.Fatalf("WaitIdle on an idle queue: %s", err)
+	}
+}
+
+func TestPopWaitsForEvents(t *testing.T) {
+	q := New(10, false)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := q.Pop(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Pop on an empty queue returned %v, expected the context to be canceled", err)
+	}
+
+	event := blockAdded()
+	go q.Push(event)
+	if popped := pop(t, q); popped != event {
+		t.Fatalf("Popped %v, expected the pushed event", popped)
+	}
+}
@@ -0,0 +1,131 @@
+package processing
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"time"
+)
+
+const (
+	healthStatusOK          = "ok"
+	healthStatusUnavailable = "unavailable"
+)
+
+// HealthReport describes the state of the processing tier
+// as reported by the /healthz and /readyz endpoints
+type HealthReport struct {
+	Status                      string     `json:"status"`
+	Reasons                     []string   `json:"reasons,omitempty"`
+	RPCConnected                bool       `json:"rpcConnected"`
+	DatabaseConnected           bool       `json:"databaseConnected"`
+	Syncing                     bool       `json:"syncing"`
+	LastProcessedBlockTime      *time.Time `json:"lastProcessedBlockTime,omitempty"`
+	LastProcessedBlockTimestamp int64      `json:"lastProcessedBlockTimestamp,omitempty"`
+	LastProcessedBlockDAAScore  uint64     `json:"lastProcessedBlockDaaScore,omitempty"`
+	VirtualDAAScore             uint64     `json:"virtualDaaScore,omitempty"`
+	DAAScoreLag                 uint64     `json:"daaScoreLag,omitempty"`
+}
+
+func (r *HealthReport) fail(reason string) {
+	r.Status = healthStatusUnavailable
+	r.Reasons = append(r.Reasons, reason)
+}
+
+// healthReport collects the state shared by the liveness and readiness reports
+func (p *Processing) healthReport() *HealthReport {
+	report := &HealthReport{
+		Status:       healthStatusOK,
+		RPCConnected: p.blockSource.IsConnected(),
+		Syncing:      p.IsSyncing(),
+	}
+
+	err := p.database.Ping()
+	report.DatabaseConnected = err == nil
+	if err != nil {
+		log.Warnf("Health check could not reach the database: %s", err)
+	}
+
+	processedAt, blockTimestamp, blockDAAScore := p.progress.latest()
+	if !processedAt.IsZero() {
+		report.LastProcessedBlockTime = &processedAt
+		report.LastProcessedBlockTimestamp = blockTimestamp
+		report.LastProcessedBlockDAAScore = blockDAAScore
+	}
+	return report
+}
+
+// LivenessReport reports whether the instance is alive, that is whether it
+// can reach the database and keeps processing blocks.
+// A wedged instance should be restarted when this report fails.
+func (p *Processing) LivenessReport() *HealthReport {
+	report := p.healthReport()
+
+	if !report.DatabaseConnected {
+		report.fail("database is unreachable")
+	}
+	// The idle check only starts once a first block got processed, since waiting
+	// for the node to finish its IBD may take a long time
+	if p.config.HealthMaxIdle > 0 && report.LastProcessedBlockTime != nil &&
+		time.Since(*report.LastProcessedBlockTime) > p.config.HealthMaxIdle {
+		report.fail("no block processed since " + report.LastProcessedBlockTime.Format(time.RFC3339))
+	}
+	return report
+}
+
+// ReadinessReport reports whether the instance is ready, that is whether it
+// is connected to both the node and the database and is close to the node tip
+func (p *Processing) ReadinessReport() *HealthReport {
+	report := p.healthReport()
+
+	if !report.DatabaseConnected {
+		report.fail("database is unreachable")
+	}
+	if !report.RPCConnected {
+		report.fail("node RPC is disconnected")
+	}
+	if report.Syncing {
+		report.fail("database is syncing")
+	}
+	// The lag is computed from cached state: querying the node from a probe would interfere
+	// with the processing, and would consume the recorded responses of a replay
+	nodeDAAScore, lag := p.progress.daaScoreLag()
+	report.VirtualDAAScore = nodeDAAScore
+	report.DAAScoreLag = lag
+	if report.LastProcessedBlockTime == nil {
+		report.fail("no block processed yet")
+	} else if report.DAAScoreLag > p.config.ReadyMaxDAAScoreLag {
+		report.fail("lagging behind the node")
+	}
+	return report
+}
+
+// metricsDAAScoreLag reports the DAA score lag to the metrics, NaN if it is unknown
+func (p *Processing) metricsDAAScoreLag() float64 {
+	nodeDAAScore, lag := p.progress.daaScoreLag()
+	if nodeDAAScore == 0 {
+		return math.NaN()
+	}
+	return float64(lag)
+}
+
+// HandleHealthz serves the liveness report
+func (p *Processing) HandleHealthz(writer http.ResponseWriter, _ *http.Request) {
+	writeHealthReport(writer, p.LivenessReport())
+}
+
+// HandleReadyz serves the readiness report
+func (p *Processing) HandleReadyz(writer http.ResponseWriter, _ *http.Request) {
+	writeHealthReport(writer, p.ReadinessReport())
+}
+
+func writeHealthReport(writer http.ResponseWriter, report *HealthReport) {
+	writer.Header().Set("Content-Type", "application/json")
+	if report.Status != healthStatusOK {
+		writer.WriteHeader(http.StatusServiceUnavailable)
+	}
+	err := json.NewEncoder(writer).Encode(report)
+	if err != nil {
+		log.Warnf("Could not write health report: %s", err)
+	}
+}
@@ -0,0 +1,84 @@
+package processing
+
+import (
+	"time"
+
+	databasePackage "github.com/kaspa-live/kaspa-graph-inspector/processing/database"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/tracing"
+)
+
+func (p *Processing) partitioningOptions() *databasePackage.PartitioningOptions {
+	return &databasePackage.PartitioningOptions{
+		Mode:               p.config.Partitioning,
+		PartitionSize:      p.config.PartitionSize,
+		PartitionsAhead:    p.config.PartitionsAhead,
+		RetainedPartitions: p.config.RetainedPartitions,
+	}
+}
+
+// setUpPartitioning converts the blocks and edges tables to the configured partitioning mode
+// and maintains their partitions
+func (p *Processing) setUpPartitioning() error {
+	options := p.partitioningOptions()
+	if options.Mode == databasePackage.PartitioningNone {
+		return nil
+	}
+
+	err := p.database.RunInTransaction(p.ctx, func(databaseTransaction databasePackage.Transaction) error {
+		return p.database.SetUpPartitioning(databaseTransaction, options)
+	})
+	if err != nil {
+		return err
+	}
+	return p.MaintainPartitions()
+}
+
+// startPartitionMaintenance starts maintaining the partitions in the background, if partitioning is configured
+func (p *Processing) startPartitionMaintenance() {
+	if p.config.Partitioning == databasePackage.PartitioningNone {
+		return
+	}
+
+	p.consumer.Add(1)
+	go func() {
+		defer p.consumer.Done()
+		p.maintainPartitionsPeriodically()
+	}()
+}
+
+// maintainPartitionsPeriodically maintains the partitions at the configured interval until the
+// processing stops. A failed maintenance counts as a processing failure and is retried at the next interval.
+func (p *Processing) maintainPartitionsPeriodically() {
+	ticker := time.NewTicker(p.config.PartitionMaintenance)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+			_ = p.supervisor.attempt("partition maintenance", p.MaintainPartitions)
+		}
+	}
+}
+
+// MaintainPartitions creates the partitions ahead of the highest block
+// and detaches the partitions older than the retained ones
+func (p *Processing) MaintainPartitions() (err error) {
+	p.Lock()
+	defer p.Unlock()
+
+	ctx, span := tracing.Start(p.ctx, "Processing.MaintainPartitions")
+	defer func() { tracing.End(span, err) }()
+
+	return p.database.RunInTransaction(ctx, func(databaseTransaction databasePackage.Transaction) error {
+		created, detached, err := p.database.MaintainPartitions(databaseTransaction, p.partitioningOptions())
+		if err != nil {
+			return err
+		}
+		if created > 0 || detached > 0 {
+			log.Infof("Partition maintenance created %d partitions and detached %d partitions", created, detached)
+		}
+		return nil
+	})
+}
@@ -1,19 +1,23 @@
 package processing
 
 import (
+	"context"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/kaspanet/kaspad/app/appmessage"
 
-	"github.com/go-pg/pg/v10"
 	databasePackage "github.com/kaspa-live/kaspa-graph-inspector/processing/database"
 	"github.com/kaspa-live/kaspa-graph-inspector/processing/database/model"
 	configPackage "github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/config"
 	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/logging"
-	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/network/rpcclient"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/metrics"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/network/blocksource"
 	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/tools"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/tracing"
 	"github.com/kaspa-live/kaspa-graph-inspector/processing/processing/batch"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/processing/eventqueue"
 	versionPackage "github.com/kaspa-live/kaspa-graph-inspector/processing/version"
 	"github.com/kaspanet/kaspad/domain/consensus/model/externalapi"
 	"github.com/kaspanet/kaspad/domain/consensus/utils/consensushashing"
@@ -26,17 +30,25 @@ var log = logging.Logger()
 const RpcRouteCapacity = 1000
 
 type Processing struct {
-	config    *configPackage.Config
-	database  *databasePackage.Database
-	rpcClient *rpcclient.RPCClient
-	appConfig *model.AppConfig
-	syncing   bool
+	// ctx is derived from the lifecycle context given to Start.
+	// It gets done on shutdown or when the supervisor gives up.
+	ctx         context.Context
+	cancel      context.CancelFunc
+	config      *configPackage.Config
+	database    databasePackage.Storage
+	blockSource blocksource.BlockSource
+	appConfig   *model.AppConfig
+	syncing     uint32
+	progress    *progress
+	supervisor  *supervisor
+	events      *eventqueue.Queue
+	consumer    sync.WaitGroup
 
 	sync.Mutex
 }
 
 func NewProcessing(config *configPackage.Config,
-	database *databasePackage.Database, rpcClient *rpcclient.RPCClient) (*Processing, error) {
+	database databasePackage.Storage, blockSource blocksource.BlockSource) (*Processing, error) {
 
 	appConfig := &model.AppConfig{
 		ID:                true,
@@ -46,25 +58,86 @@ func NewProcessing(config *configPackage.Config,
 	}
 
 	processing := &Processing{
-		config:    config,
-		database:  database,
-		rpcClient: rpcClient,
-		appConfig: appConfig,
-		syncing:   false,
+		config:      config,
+		database:    database,
+		blockSource: blockSource,
+		appConfig:   appConfig,
+		progress:    &progress{},
 	}
+	processing.ctx, processing.cancel = context.WithCancel(context.Background())
+	processing.supervisor = newSupervisor(processing, config.MaxFailures, config.FailureBackoff)
+	processing.events = eventqueue.New(config.EventQueueCapacity, config.CoalesceChainChanges)
+	metrics.SetDAAScoreLag(processing.metricsDAAScoreLag)
+	metrics.SetEventQueueLength(func() float64 { return float64(processing.events.Len()) })
 
-	processing.initRpcClientEventHandler()
+	return processing, nil
+}
+
+// Start syncs the database with the node and then starts listening to node events.
+// All processing stops as soon as `ctx` is done, leaving the database in its last committed state.
+func (p *Processing) Start(ctx context.Context) error {
+	p.ctx, p.cancel = context.WithCancel(ctx)
+	p.initBlockSourceEventHandler()
 
-	err := processing.init()
+	p.consumer.Add(1)
+	go func() {
+		defer p.consumer.Done()
+		p.processEvents()
+	}()
+
+	err := p.init()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return processing, nil
+	// The chain is reconciled from the pruning point only once since it requests every chain block
+	// from the node, blocking the processing meanwhile. The resyncs done by init after reconnections
+	// already fix the chain from the highest stored chain block.
+	_, err = p.ReconcileVirtualSelectedParentChain()
+	if err != nil {
+		return err
+	}
+
+	// The background jobs are started once, init being run again after each reconnection
+	p.startPartitionMaintenance()
+	p.startRetention()
+	return nil
+}
+
+// Wait waits for the processing of the current event to end once the processing is stopping
+func (p *Processing) Wait() {
+	p.consumer.Wait()
+}
+
+// Done returns a channel that is closed once the processing stopped,
+// either because its lifecycle context is done or because it gave up on failures
+func (p *Processing) Done() <-chan struct{} {
+	return p.ctx.Done()
+}
+
+// Err returns the error that made the processing give up, if any
+func (p *Processing) Err() error {
+	return p.supervisor.Err()
+}
+
+func (p *Processing) stop() {
+	p.cancel()
+}
+
+// isStopping returns true once the processing is stopping
+func (p *Processing) isStopping() bool {
+	return p.ctx.Err() != nil
+}
+
+// IsSyncing returns true while the database is being resynced with the node
+func (p *Processing) IsSyncing() bool {
+	return atomic.LoadUint32(&p.syncing) == 1
 }
 
 func (p *Processing) init() error {
-	err := p.updateRpcClientVersion()
+	ctx := p.ctx
+
+	err := p.updateNodeVersion(ctx)
 	if err != nil {
 		return err
 	}
@@ -74,7 +147,12 @@ func (p *Processing) init() error {
 		return err
 	}
 
-	err = p.waitForSyncedRpcClient()
+	err = p.setUpPartitioning()
+	if err != nil {
+		return err
+	}
+
+	err = p.waitForSyncedNode(ctx)
 	if err != nil {
 		return err
 	}
@@ -85,7 +163,7 @@ func (p *Processing) init() error {
 	}
 
 	// Start listening to events only after resyncing is done, otherwise we get overwhelmed
-	err = p.initConsensusEventsHandler()
+	err = p.initConsensusEventsHandler(ctx)
 	if err != nil {
 		return err
 	}
@@ -93,76 +171,114 @@ func (p *Processing) init() error {
 	return nil
 }
 
-func (p *Processing) initRpcClientEventHandler() {
-	p.rpcClient.SetOnReconnectedHandler(func() {
-		log.Infof("Handling a RPC client reconnected event...")
-		if p.syncing {
+func (p *Processing) initBlockSourceEventHandler() {
+	p.blockSource.SetOnReconnectedHandler(func() {
+		log.Infof("Handling a block source reconnected event...")
+		if p.IsSyncing() {
 			log.Infof("Disconnected during database syncing so ignoring the event")
 			return
 		}
 
 		// Resync the database and resubscribe to node events
 		log.Infof("Resync the database and resubscribe to the relevant node events")
-		err := p.init()
-		if err != nil {
-			panic(err)
-		}
+		p.supervisor.retry("resync after reconnection", p.init)
 	})
 }
 
-func (p *Processing) initConsensusEventsHandler() error {
-	err := p.rpcClient.RegisterForVirtualSelectedParentChainChangedNotifications(false, func(notification *appmessage.VirtualSelectedParentChainChangedNotificationMessage) {
-		added, err := hashesFromStrings(notification.AddedChainBlockHashes)
-		if err != nil {
-			panic(err)
-		}
-
-		removed, err := hashesFromStrings(notification.RemovedChainBlockHashes)
-		if err != nil {
-			panic(err)
-		}
-
-		event := &externalapi.VirtualChangeSet{
-			VirtualSelectedParentChainChanges: &externalapi.SelectedChainPath{
-				Added:   added,
-				Removed: removed,
-			},
-			VirtualUTXODiff:                nil,
-			VirtualParents:                 nil,
-			VirtualSelectedParentBlueScore: 0,
-			VirtualDAAScore:                0,
-		}
+// initConsensusEventsHandler subscribes to the node events, which get queued
+// in order to be processed one at a time by processEvents
+func (p *Processing) initConsensusEventsHandler(ctx context.Context) error {
+	err := p.blockSource.RegisterForVirtualSelectedParentChainChangedNotifications(ctx, false, func(notification *appmessage.VirtualSelectedParentChainChangedNotificationMessage) {
+		p.pushEvent(&eventqueue.ChainChanged{
+			RemovedChainBlockHashes: notification.RemovedChainBlockHashes,
+			AddedChainBlockHashes:   notification.AddedChainBlockHashes,
+		})
+	})
+	if err != nil {
+		return err
+	}
 
-		err = p.ProcessVirtualChange(event)
-		if err != nil {
-			logging.LogErrorAndExit("Failed to process virtual change consensus event: %s", err)
+	err = p.blockSource.RegisterForBlockAddedNotifications(ctx, func(notification *appmessage.BlockAddedNotificationMessage) {
+		if notification.Block != nil && notification.Block.Header != nil {
+			p.progress.nodeDAAScoreSeen(notification.Block.Header.DAAScore)
 		}
+		p.pushEvent(&eventqueue.BlockAdded{Notification: notification})
 	})
 	if err != nil {
 		return err
 	}
 
-	err = p.rpcClient.RegisterForBlockAddedNotifications(func(notification *appmessage.BlockAddedNotificationMessage) {
-		block, err := appmessage.RPCBlockToDomainBlock(notification.Block)
+	return nil
+}
+
+func (p *Processing) pushEvent(event eventqueue.Event) {
+	if !p.events.Push(event) {
+		metrics.EventQueueOverflowed()
+		log.Warnf("The event queue is full; its events are discarded and the database will be resynced")
+	}
+}
+
+// processEvents processes the queued node events in order until the processing stops
+func (p *Processing) processEvents() {
+	for {
+		event, err := p.events.Pop(p.ctx)
 		if err != nil {
-			panic(err)
+			return
 		}
 
-		log.Debugf("Consensus event handler gets block %s", consensushashing.BlockHash(block))
-		err = p.ProcessBlock(block)
-		if err != nil {
-			logging.LogErrorAndExit("Failed to process block added consensus event: %s", err)
+		switch event := event.(type) {
+		case *eventqueue.BlockAdded:
+			p.supervisor.run("block added consensus event processing", func() error {
+				block, err := appmessage.RPCBlockToDomainBlock(event.Notification.Block)
+				if err != nil {
+					return errors.Wrapf(ErrInvalidNotification, "block: %s", err)
+				}
+
+				log.Debugf("Consensus event handler gets block %s", consensushashing.BlockHash(block))
+				return p.ProcessBlock(block)
+			})
+
+		case *eventqueue.ChainChanged:
+			p.supervisor.run("virtual change consensus event processing", func() error {
+				added, err := hashesFromStrings(event.AddedChainBlockHashes)
+				if err != nil {
+					return errors.Wrapf(ErrInvalidNotification, "added chain block hashes: %s", err)
+				}
+
+				removed, err := hashesFromStrings(event.RemovedChainBlockHashes)
+				if err != nil {
+					return errors.Wrapf(ErrInvalidNotification, "removed chain block hashes: %s", err)
+				}
+
+				virtualChangeSet := &externalapi.VirtualChangeSet{
+					VirtualSelectedParentChainChanges: &externalapi.SelectedChainPath{
+						Added:   added,
+						Removed: removed,
+					},
+					VirtualUTXODiff:                nil,
+					VirtualParents:                 nil,
+					VirtualSelectedParentBlueScore: 0,
+					VirtualDAAScore:                0,
+				}
+				return p.ProcessVirtualChange(virtualChangeSet)
+			})
+
+		case *eventqueue.Overflow:
+			log.Warnf("%d events were discarded by the event queue so resyncing the database", event.Discarded)
+			p.supervisor.retry("resync after an event queue overflow", p.ResyncDatabase)
 		}
-	})
-	if err != nil {
-		return err
+		p.events.Done()
 	}
+}
 
-	return nil
+// WaitForIdleEvents waits until all the queued node events got processed.
+// Returns an error if `ctx` gets done first.
+func (p *Processing) WaitForIdleEvents(ctx context.Context) error {
+	return p.events.WaitIdle(ctx)
 }
 
-func (p *Processing) updateRpcClientVersion() error {
-	info, err := p.rpcClient.GetInfo()
+func (p *Processing) updateNodeVersion(ctx context.Context) error {
+	info, err := p.blockSource.GetInfo(ctx)
 	if err != nil {
 		return err
 	}
@@ -171,7 +287,10 @@ func (p *Processing) updateRpcClientVersion() error {
 }
 
 func (p *Processing) RegisterAppConfig() error {
-	return p.database.RunInTransaction(func(databaseTransaction *pg.Tx) error {
+	ctx, span := tracing.Start(p.ctx, "Processing.RegisterAppConfig")
+	defer span.End()
+
+	return p.database.RunInTransaction(ctx, func(databaseTransaction databasePackage.Transaction) error {
 		log.Infof("Registering app config")
 		log.Infof("Config = KGI version: %s, Node version: %s, Network: %s", p.appConfig.ProcessingVersion, p.appConfig.KaspadVersion, p.appConfig.Network)
 		defer log.Infof("Finished registering app config")
@@ -180,9 +299,9 @@ func (p *Processing) RegisterAppConfig() error {
 	})
 }
 
-func (p *Processing) waitForSyncedRpcClient() error {
+func (p *Processing) waitForSyncedNode(ctx context.Context) error {
 	for cycle := 0; ; cycle++ {
-		info, err := p.rpcClient.GetInfo()
+		info, err := p.blockSource.GetInfo(ctx)
 		if err != nil {
 			return err
 		}
@@ -194,30 +313,55 @@ func (p *Processing) waitForSyncedRpcClient() error {
 			log.Infof("Waiting for the node to finish IBD...")
 		}
 		// Wait for 3 seconds
-		time.Sleep(3 * time.Second)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(3 * time.Second):
+		}
+	}
+}
+
+// runInTransaction runs `operation` in a database transaction and accounts for the blocks
+// it processed in the progress once the transaction committed
+func (p *Processing) runInTransaction(ctx context.Context, operation func(databaseTransaction databasePackage.Transaction) error) error {
+	err := p.database.RunInTransaction(ctx, func(databaseTransaction databasePackage.Transaction) error {
+		// A transaction run again after a transient error starts over
+		p.progress.discard()
+		return operation(databaseTransaction)
+	})
+	if err != nil {
+		p.progress.discard()
+		return err
 	}
+	p.progress.commit()
+	return nil
 }
 
-func (p *Processing) ResyncDatabase() error {
+func (p *Processing) ResyncDatabase() (err error) {
 	p.Lock()
 	defer p.Unlock()
 
-	return p.database.RunInTransaction(func(databaseTransaction *pg.Tx) error {
-		p.syncing = true
+	ctx, span := tracing.Start(p.ctx, "Processing.ResyncDatabase")
+	defer func() { tracing.End(span, err) }()
+
+	return p.runInTransaction(ctx, func(databaseTransaction databasePackage.Transaction) error {
+		atomic.StoreUint32(&p.syncing, 1)
+		defer atomic.StoreUint32(&p.syncing, 0)
 		log.Infof("Resyncing database")
 		defer log.Infof("Finished resyncing database")
 
-		dagInfo, err := p.rpcClient.GetBlockDAGInfo()
+		dagInfo, err := p.blockSource.GetBlockDAGInfo(ctx)
 		if err != nil {
 			return err
 		}
+		p.progress.nodeDAAScoreSeen(dagInfo.VirtualDAAScore)
 
 		pruningPointHash, err := externalapi.NewDomainHashFromString(dagInfo.PruningPointHash)
 		if err != nil {
 			return err
 		}
 
-		rpcPruning, err := p.rpcClient.GetBlock(dagInfo.PruningPointHash, false)
+		rpcPruning, err := p.blockSource.GetBlock(ctx, dagInfo.PruningPointHash, false)
 		if err != nil {
 			return err
 		}
@@ -236,6 +380,13 @@ func (p *Processing) ResyncDatabase() error {
 		lowHash := dagInfo.PruningPointHash
 
 		keepDatabase := hasPruningBlock && !p.config.ClearDB
+		if !keepDatabase && p.config.Archival && !p.config.ClearDB {
+			// The history stored before the pruning point is kept
+			keepDatabase, err = p.appendSegment(ctx, databaseTransaction, pruningPointHash, pruningPointBlock, rpcPruning)
+			if err != nil {
+				return err
+			}
+		}
 		if keepDatabase {
 			// The pruning block is already in the database
 			// so we keep the database as it is and sync the new blocks
@@ -252,7 +403,7 @@ func (p *Processing) ResyncDatabase() error {
 			log.Infof("Cache loaded from the database")
 
 			log.Infof("Searching for an optimal sync starting point")
-			lowHash = p.findOptimalSyncStartingBlock(databaseTransaction, dagInfo.PruningPointHash, pruningPointBlock.Header.DAAScore())
+			lowHash = p.findOptimalSyncStartingBlock(ctx, databaseTransaction, dagInfo.PruningPointHash, pruningPointBlock.Header.DAAScore())
 			if lowHash != dagInfo.PruningPointHash {
 				log.Infof("Optimal sync starting point set at %s", lowHash)
 			} else {
@@ -267,27 +418,7 @@ func (p *Processing) ResyncDatabase() error {
 			}
 			log.Infof("Database cleared")
 
-			pruningPointDatabaseBlock := &model.Block{
-				BlockHash:                      pruningPointHash.String(),
-				Timestamp:                      rpcPruning.Block.Header.Timestamp,
-				ParentIDs:                      []uint64{},
-				Height:                         0,
-				HeightGroupIndex:               0,
-				SelectedParentID:               nil,
-				Color:                          model.ColorGray,
-				IsInVirtualSelectedParentChain: true,
-				MergeSetRedIDs:                 []uint64{},
-				MergeSetBlueIDs:                []uint64{},
-			}
-			err = p.database.InsertBlock(databaseTransaction, pruningPointHash, pruningPointDatabaseBlock)
-			if err != nil {
-				return err
-			}
-			heightGroup := &model.HeightGroup{
-				Height: 0,
-				Size:   1,
-			}
-			err = p.database.InsertOrUpdateHeightGroup(databaseTransaction, heightGroup)
+			err = p.insertPruningPoint(databaseTransaction, pruningPointHash, rpcPruning, 0)
 			if err != nil {
 				return err
 			}
@@ -295,8 +426,12 @@ func (p *Processing) ResyncDatabase() error {
 		}
 
 		for cycle := 0; ; cycle++ {
+			if ctx.Err() != nil {
+				log.Infof("Resyncing database interrupted")
+				return ctx.Err()
+			}
 			log.Infof("Cycle %d - Load node blocks", cycle)
-			hashesBetweenPruningPointAndHeadersSelectedTip, err := p.getHashesToSelectedTip(&lowHash, dagInfo.VirtualDAAScore, rpcPruning.Block.Header.DAAScore)
+			hashesBetweenPruningPointAndHeadersSelectedTip, err := p.getHashesToSelectedTip(ctx, &lowHash, dagInfo.VirtualDAAScore, rpcPruning.Block.Header.DAAScore)
 			if err != nil {
 				return err
 			}
@@ -320,7 +455,7 @@ func (p *Processing) ResyncDatabase() error {
 				}
 				if pruningPointDatabaseBlock.DAAScore == 0 && noDAAScoreCount > uint32(p.config.NetParams().K) {
 					log.Infof("Cycle %d - Updating DAA score of %d blocks in the database", cycle, len(hashesBetweenPruningPointAndHeadersSelectedTip))
-					blockIDsToDAAScores, err := p.getBlocksDAAScores(databaseTransaction, hashesBetweenPruningPointAndHeadersSelectedTip)
+					blockIDsToDAAScores, err := p.getBlocksDAAScores(ctx, databaseTransaction, hashesBetweenPruningPointAndHeadersSelectedTip)
 					log.Infof("Cycle %d - DAA scores of %d blocks collected", cycle, len(blockIDsToDAAScores))
 					if err != nil {
 						return err
@@ -350,8 +485,12 @@ func (p *Processing) ResyncDatabase() error {
 			totalToAdd := len(hashesBetweenPruningPointAndHeadersSelectedTip) - startIndex
 
 			for i := startIndex; i < len(hashesBetweenPruningPointAndHeadersSelectedTip); i++ {
+				if ctx.Err() != nil {
+					log.Infof("Cycle %d - Resyncing database interrupted after %d/%d blocks", cycle, i-startIndex, totalToAdd)
+					return ctx.Err()
+				}
 				blockHash := hashesBetweenPruningPointAndHeadersSelectedTip[i]
-				rpcBlock, err := p.rpcClient.GetBlock(blockHash.String(), false)
+				rpcBlock, err := p.blockSource.GetBlock(ctx, blockHash.String(), false)
 				if err != nil {
 					return err
 				}
@@ -360,9 +499,9 @@ func (p *Processing) ResyncDatabase() error {
 					return err
 				}
 				if p.config.Resync || i-startIndex >= 6000 {
-					err = p.processBlock(databaseTransaction, block)
+					err = p.processBlock(ctx, databaseTransaction, block)
 				} else {
-					err = p.processBlockAndDependencies(databaseTransaction, blockHash, block, pruningPointBlock)
+					err = p.processBlockAndDependencies(ctx, databaseTransaction, blockHash, block, pruningPointBlock)
 				}
 				if err != nil {
 					return err
@@ -376,7 +515,7 @@ func (p *Processing) ResyncDatabase() error {
 
 			// Resync the VPSC when getting close to the tip
 			if len(hashesBetweenPruningPointAndHeadersSelectedTip) < 20 {
-				err := p.resyncVirtualSelectedParentChain(databaseTransaction, true)
+				err := p.resyncVirtualSelectedParentChain(ctx, databaseTransaction, true)
 				if err != nil {
 					return err
 				}
@@ -391,12 +530,11 @@ func (p *Processing) ResyncDatabase() error {
 			keepDatabase = true
 		}
 
-		p.syncing = false
 		return nil
 	})
 }
 
-func (p *Processing) findOptimalSyncStartingBlock(databaseTransaction *pg.Tx, pruningPointHash string, pruningPointDAAScore uint64) string {
+func (p *Processing) findOptimalSyncStartingBlock(ctx context.Context, databaseTransaction databasePackage.Transaction, pruningPointHash string, pruningPointDAAScore uint64) string {
 	const OPTIMAL_START_DAA_SCORE_OFFSET = 600
 
 	highestVspcBlock, err := p.database.HighestBlockInVirtualSelectedParentChain(databaseTransaction)
@@ -415,7 +553,7 @@ func (p *Processing) findOptimalSyncStartingBlock(databaseTransaction *pg.Tx, pr
 			return pruningPointHash
 		}
 
-		_, err = p.rpcClient.GetBlock(startBlock.BlockHash, false)
+		_, err = p.blockSource.GetBlock(ctx, startBlock.BlockHash, false)
 		if err != nil {
 			return pruningPointHash
 		}
@@ -426,8 +564,8 @@ func (p *Processing) findOptimalSyncStartingBlock(databaseTransaction *pg.Tx, pr
 	return pruningPointHash
 }
 
-func (p *Processing) getHashesToSelectedTip(lowHash *string, virtualDAAScore uint64, pruningDAAScore uint64) ([]*externalapi.DomainHash, error) {
-	selectedTipHash, err := p.rpcClient.GetSelectedTipHash()
+func (p *Processing) getHashesToSelectedTip(ctx context.Context, lowHash *string, virtualDAAScore uint64, pruningDAAScore uint64) ([]*externalapi.DomainHash, error) {
+	selectedTipHash, err := p.blockSource.GetSelectedTipHash(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -436,14 +574,17 @@ func (p *Processing) getHashesToSelectedTip(lowHash *string, virtualDAAScore uin
 	count := 0
 outer:
 	for i := 0; ; i++ {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		log.Debugf("Requesting GetBlocks with lowHash %s", *lowHash)
-		getBlocks, err := p.rpcClient.GetBlocks(*lowHash, false, false)
+		getBlocks, err := p.blockSource.GetBlocks(ctx, *lowHash, false, false)
 		if err != nil {
 			return nil, err
 		}
 		count += len(getBlocks.BlockHashes)
 		if i%1000 == 0 {
-			rpcBlock, err := p.rpcClient.GetBlock(getBlocks.BlockHashes[0], false)
+			rpcBlock, err := p.blockSource.GetBlock(ctx, getBlocks.BlockHashes[0], false)
 			if err != nil {
 				return nil, err
 			}
@@ -474,16 +615,19 @@ outer:
 	return hashesToSelectedTip, nil
 }
 
-func (p *Processing) ResyncVirtualSelectedParentChain() error {
+func (p *Processing) ResyncVirtualSelectedParentChain() (err error) {
 	p.Lock()
 	defer p.Unlock()
 
-	return p.database.RunInTransaction(func(databaseTransaction *pg.Tx) error {
-		return p.resyncVirtualSelectedParentChain(databaseTransaction, false)
+	ctx, span := tracing.Start(p.ctx, "Processing.ResyncVirtualSelectedParentChain")
+	defer func() { tracing.End(span, err) }()
+
+	return p.runInTransaction(ctx, func(databaseTransaction databasePackage.Transaction) error {
+		return p.resyncVirtualSelectedParentChain(ctx, databaseTransaction, false)
 	})
 }
 
-func (p *Processing) resyncVirtualSelectedParentChain(databaseTransaction *pg.Tx, withDependencies bool) error {
+func (p *Processing) resyncVirtualSelectedParentChain(ctx context.Context, databaseTransaction databasePackage.Transaction, withDependencies bool) error {
 	log.Infof("Resyncing virtual selected parent chain")
 	defer log.Infof("Finished resyncing virtual selected parent chain")
 
@@ -497,7 +641,7 @@ func (p *Processing) resyncVirtualSelectedParentChain(databaseTransaction *pg.Tx
 	}
 	log.Infof("Resyncing virtual selected parent chain from block %s", highestBlockHash)
 
-	chainFromBlock, err := p.rpcClient.GetVirtualSelectedParentChainFromBlock(highestBlockVirtualSelectedParentChain.BlockHash, false)
+	chainFromBlock, err := p.blockSource.GetVirtualSelectedParentChainFromBlock(ctx, highestBlockVirtualSelectedParentChain.BlockHash, false)
 	if err != nil {
 		// This may occur when restoring a kgi database on a system which kaspad database
 		// is older than the kgi database.
@@ -511,7 +655,7 @@ func (p *Processing) resyncVirtualSelectedParentChain(databaseTransaction *pg.Tx
 			return err
 		}
 
-		virtualSelectedParentBlockRPCBlock, err := p.rpcClient.GetBlock(chainFromBlock.AddedChainBlockHashes[len(chainFromBlock.AddedChainBlockHashes)-1], false)
+		virtualSelectedParentBlockRPCBlock, err := p.blockSource.GetBlock(ctx, chainFromBlock.AddedChainBlockHashes[len(chainFromBlock.AddedChainBlockHashes)-1], false)
 		if err != nil {
 			return err
 		}
@@ -526,7 +670,7 @@ func (p *Processing) resyncVirtualSelectedParentChain(databaseTransaction *pg.Tx
 			return err
 		}
 
-		removed, err := hashesFromStrings(chainFromBlock.AddedChainBlockHashes)
+		removed, err := hashesFromStrings(chainFromBlock.RemovedChainBlockHashes)
 		if err != nil {
 			return err
 		}
@@ -538,12 +682,12 @@ func (p *Processing) resyncVirtualSelectedParentChain(databaseTransaction *pg.Tx
 			},
 		}
 		if withDependencies {
-			err = p.processBlockAndDependencies(databaseTransaction, virtualSelectedParentHash, virtualSelectedParentBlock, nil)
+			err = p.processBlockAndDependencies(ctx, databaseTransaction, virtualSelectedParentHash, virtualSelectedParentBlock, nil)
 			if err != nil {
 				return err
 			}
 		}
-		err = p.processVirtualChange(databaseTransaction, blockInsertionResult, withDependencies)
+		err = p.processVirtualChange(ctx, databaseTransaction, blockInsertionResult, withDependencies)
 		if err != nil {
 			return err
 		}
@@ -552,24 +696,32 @@ func (p *Processing) resyncVirtualSelectedParentChain(databaseTransaction *pg.Tx
 	return nil
 }
 
-func (p *Processing) ProcessBlock(block *externalapi.DomainBlock) error {
+func (p *Processing) ProcessBlock(block *externalapi.DomainBlock) (err error) {
 	p.Lock()
 	defer p.Unlock()
 
-	return p.database.RunInTransaction(func(databaseTransaction *pg.Tx) error {
-		return p.processBlockAndDependencies(databaseTransaction, consensushashing.BlockHash(block), block, nil)
+	blockHash := consensushashing.BlockHash(block)
+	ctx, span := tracing.Start(p.ctx, "Processing.ProcessBlock", tracing.BlockHash(blockHash))
+	defer func() { tracing.End(span, err) }()
+
+	return p.runInTransaction(ctx, func(databaseTransaction databasePackage.Transaction) error {
+		return p.processBlockAndDependencies(ctx, databaseTransaction, blockHash, block, nil)
 	})
 }
 
 // processBlockAndDependencies processes `block` and all its missing dependencies
-func (p *Processing) processBlockAndDependencies(databaseTransaction *pg.Tx, hash *externalapi.DomainHash,
-	block, pruningBlock *externalapi.DomainBlock) error {
+func (p *Processing) processBlockAndDependencies(ctx context.Context, databaseTransaction databasePackage.Transaction, hash *externalapi.DomainHash,
+	block, pruningBlock *externalapi.DomainBlock) (err error) {
+
+	ctx, span := tracing.Start(ctx, "Processing.processBlockAndDependencies", tracing.BlockHash(hash))
+	defer func() { tracing.End(span, err) }()
 
-	batch := batch.New(p.database, p.rpcClient, pruningBlock)
-	err := batch.CollectBlockAndDependencies(databaseTransaction, hash, block)
+	batch := batch.New(p.database, p.blockSource, pruningBlock)
+	err = batch.CollectBlockAndDependencies(ctx, databaseTransaction, hash, block)
 	if err != nil {
 		return err
 	}
+	span.SetAttributes(tracing.Count(batch.Len()))
 	for {
 		_, block, ok := batch.Pop()
 		if !ok {
@@ -578,7 +730,7 @@ func (p *Processing) processBlockAndDependencies(databaseTransaction *pg.Tx, has
 		if !batch.Empty() {
 			log.Warnf("Handling missing dependency block %s", consensushashing.BlockHash(block))
 		}
-		err = p.processBlock(databaseTransaction, block)
+		err = p.processBlock(ctx, databaseTransaction, block)
 		if err != nil {
 			return err
 		}
@@ -586,9 +738,15 @@ func (p *Processing) processBlockAndDependencies(databaseTransaction *pg.Tx, has
 	return nil
 }
 
-func (p *Processing) processBlock(databaseTransaction *pg.Tx, block *externalapi.DomainBlock) error {
+func (p *Processing) processBlock(ctx context.Context, databaseTransaction databasePackage.Transaction, block *externalapi.DomainBlock) error {
+
+	start := time.Now()
+	defer func() { metrics.BlockProcessed(time.Since(start)) }()
 
 	blockHash := consensushashing.BlockHash(block)
+	ctx, span := tracing.Start(ctx, "Processing.processBlock", tracing.BlockHash(blockHash))
+	defer span.End()
+
 	log.Debugf("Processing block %s", blockHash)
 	defer log.Debugf("Finished processing block %s", blockHash)
 
@@ -598,6 +756,7 @@ func (p *Processing) processBlock(databaseTransaction *pg.Tx, block *externalapi
 		// enhanced error description
 		return errors.Wrapf(err, "Could not check if block %s does exist in database", blockHash)
 	}
+	var blockID uint64
 	if !blockExists {
 		parentHashes := block.Header.DirectParents()
 		existingParentHashes := make([]*externalapi.DomainHash, 0, len(parentHashes))
@@ -646,12 +805,13 @@ func (p *Processing) processBlock(databaseTransaction *pg.Tx, block *externalapi
 			MergeSetBlueIDs:                []uint64{},
 			DAAScore:                       block.Header.DAAScore(),
 		}
+		span.SetAttributes(tracing.BlockHeight(blockHeight))
 		err = p.database.InsertBlock(databaseTransaction, blockHash, databaseBlock)
 		if err != nil {
 			return errors.Wrapf(err, "Could not insert block %s", blockHash)
 		}
 
-		blockID, err := p.database.BlockIDByHash(databaseTransaction, blockHash)
+		blockID, err = p.database.BlockIDByHash(databaseTransaction, blockHash)
 		if err != nil {
 			// enhanced error description
 			return errors.Wrapf(err, "Could not get id for block %s", blockHash)
@@ -666,44 +826,37 @@ func (p *Processing) processBlock(databaseTransaction *pg.Tx, block *externalapi
 			return errors.Wrapf(err, "Could not insert or update height group %d for block %s", blockHeight, blockHash)
 		}
 
-		for _, parentID := range parentIDs {
-			parentHeight, err := p.database.BlockHeight(databaseTransaction, parentID)
-			if err != nil {
-				// enhanced error description
-				return errors.Wrapf(err, "Could not get block height of parent id %d for block %s", parentID, blockHash)
-			}
-			parentHeightGroupIndex, err := p.database.BlockHeightGroupIndex(databaseTransaction, parentID)
-			if err != nil {
-				// enhanced error description
-				return errors.Wrapf(err, "Could not get height group index of parent id %d for block %s", parentID, blockHash)
-			}
-			edge := &model.Edge{
-				FromBlockID:          blockID,
-				ToBlockID:            parentID,
-				FromHeight:           blockHeight,
-				ToHeight:             parentHeight,
-				FromHeightGroupIndex: blockHeightGroupIndex,
-				ToHeightGroupIndex:   parentHeightGroupIndex,
-			}
-			err = p.database.InsertEdge(databaseTransaction, edge)
-			if err != nil {
-				// enhanced error description
-				return errors.Wrapf(err, "Could not insert edge from block %s to parent id %d", blockHash, parentID)
-			}
+		err = p.insertBlockEdges(databaseTransaction, blockHash, blockID, blockHeight, blockHeightGroupIndex, parentIDs)
+		if err != nil {
+			return err
 		}
 	} else {
 		log.Debugf("Block %s already exists in database; not processed", blockHash)
 	}
 
-	rpcBlock, err := p.rpcClient.GetBlock(blockHash.String(), false)
+	rpcBlock, err := p.blockSource.GetBlock(ctx, blockHash.String(), false)
 	if err != nil {
 		return err
 	}
 
+	if !blockExists {
+		// Children stored before this block as incomplete blocks are missing it among their parents.
+		// They are looked up only when the node reports children, which a block just added has not yet.
+		err = p.linkStoredChildren(ctx, databaseTransaction, blockHash, blockID, rpcBlock.Block.VerboseData.ChildrenHashes)
+		if err != nil {
+			return err
+		}
+	}
+
 	if rpcBlock.Block.VerboseData.IsHeaderOnly || isIncompleteBlock {
 		log.Infof("Block %s is incomplete so leaving block processing", blockHash)
 		return nil
 	}
+	if rpcBlock.Block.VerboseData.SelectedParentHash == "" {
+		// kaspad reports no selected parent for the genesis, whose merge set is empty
+		p.progress.blockProcessed(block)
+		return nil
+	}
 
 	selectedParent, err := externalapi.NewDomainHashFromString(rpcBlock.Block.VerboseData.SelectedParentHash)
 	if err != nil {
@@ -714,10 +867,12 @@ func (p *Processing) processBlock(databaseTransaction *pg.Tx, block *externalapi
 		return errors.Wrapf(err, "Could not get id of selected parent block %s", selectedParent)
 	}
 
-	blockID, err := p.database.BlockIDByHash(databaseTransaction, blockHash)
-	if err != nil {
-		// enhanced error description
-		return errors.Wrapf(err, "Could not get id of block %s", blockHash)
+	if blockExists {
+		blockID, err = p.database.BlockIDByHash(databaseTransaction, blockHash)
+		if err != nil {
+			// enhanced error description
+			return errors.Wrapf(err, "Could not get id of block %s", blockHash)
+		}
 	}
 
 	err = p.database.UpdateBlockSelectedParent(databaseTransaction, blockID, selectedParentID)
@@ -765,11 +920,45 @@ func (p *Processing) processBlock(databaseTransaction *pg.Tx, block *externalapi
 		return errors.Wrapf(err, "Could not update merge sets colors for block %s", blockHash)
 	}
 
+	p.progress.blockProcessed(block)
+	return nil
+}
+
+// insertBlockEdges inserts the edges going from the block identified by `blockHash` and `blockID`
+// to each of its parents
+func (p *Processing) insertBlockEdges(databaseTransaction databasePackage.Transaction, blockHash *externalapi.DomainHash, blockID uint64,
+	blockHeight uint64, blockHeightGroupIndex uint32, parentIDs []uint64) error {
+
+	for _, parentID := range parentIDs {
+		parentHeight, err := p.database.BlockHeight(databaseTransaction, parentID)
+		if err != nil {
+			// enhanced error description
+			return errors.Wrapf(err, "Could not get block height of parent id %d for block %s", parentID, blockHash)
+		}
+		parentHeightGroupIndex, err := p.database.BlockHeightGroupIndex(databaseTransaction, parentID)
+		if err != nil {
+			// enhanced error description
+			return errors.Wrapf(err, "Could not get height group index of parent id %d for block %s", parentID, blockHash)
+		}
+		edge := &model.Edge{
+			FromBlockID:          blockID,
+			ToBlockID:            parentID,
+			FromHeight:           blockHeight,
+			ToHeight:             parentHeight,
+			FromHeightGroupIndex: blockHeightGroupIndex,
+			ToHeightGroupIndex:   parentHeightGroupIndex,
+		}
+		err = p.database.InsertEdge(databaseTransaction, edge)
+		if err != nil {
+			// enhanced error description
+			return errors.Wrapf(err, "Could not insert edge from block %s to parent id %d", blockHash, parentID)
+		}
+	}
 	return nil
 }
 
-func (p *Processing) processMissingBlock(databaseTransaction *pg.Tx, blockHash *externalapi.DomainHash) (uint64, error) {
-	rpcBlock, err := p.rpcClient.GetBlock(blockHash.String(), false)
+func (p *Processing) processMissingBlock(ctx context.Context, databaseTransaction databasePackage.Transaction, blockHash *externalapi.DomainHash) (uint64, error) {
+	rpcBlock, err := p.blockSource.GetBlock(ctx, blockHash.String(), false)
 	if err != nil {
 		return 0, err
 	}
@@ -777,7 +966,7 @@ func (p *Processing) processMissingBlock(databaseTransaction *pg.Tx, blockHash *
 	if err != nil {
 		return 0, err
 	}
-	err = p.processBlockAndDependencies(databaseTransaction, consensushashing.BlockHash(block), block, nil)
+	err = p.processBlockAndDependencies(ctx, databaseTransaction, consensushashing.BlockHash(block), block, nil)
 	if err != nil {
 		return 0, err
 	}
@@ -801,19 +990,24 @@ func hashesFromStrings(strs []string) ([]*externalapi.DomainHash, error) {
 	return hashes, nil
 }
 
-func (p *Processing) ProcessVirtualChange(blockInsertionResult *externalapi.VirtualChangeSet) error {
+func (p *Processing) ProcessVirtualChange(blockInsertionResult *externalapi.VirtualChangeSet) (err error) {
 	p.Lock()
 	defer p.Unlock()
 
-	return p.database.RunInTransaction(func(databaseTransaction *pg.Tx) error {
-		return p.processVirtualChange(databaseTransaction, blockInsertionResult, true)
+	ctx, span := tracing.Start(p.ctx, "Processing.ProcessVirtualChange")
+	defer func() { tracing.End(span, err) }()
+
+	return p.runInTransaction(ctx, func(databaseTransaction databasePackage.Transaction) error {
+		return p.processVirtualChange(ctx, databaseTransaction, blockInsertionResult, true)
 	})
 }
 
-func (p *Processing) processVirtualChange(databaseTransaction *pg.Tx, blockInsertionResult *externalapi.VirtualChangeSet, withDependencies bool) error {
+func (p *Processing) processVirtualChange(ctx context.Context, databaseTransaction databasePackage.Transaction, blockInsertionResult *externalapi.VirtualChangeSet, withDependencies bool) error {
 	if blockInsertionResult == nil || blockInsertionResult.VirtualSelectedParentChainChanges == nil {
 		return nil
 	}
+	start := time.Now()
+	defer func() { metrics.VirtualChangeProcessed(time.Since(start)) }()
 
 	blockColors := make(map[uint64]string)
 	blockIsInVirtualSelectedParentChain := make(map[uint64]bool)
@@ -825,7 +1019,7 @@ func (p *Processing) processVirtualChange(databaseTransaction *pg.Tx, blockInser
 				blockColors[removedBlockID] = model.ColorGray
 				blockIsInVirtualSelectedParentChain[removedBlockID] = false
 			} else if withDependencies {
-				removedBlockID, err = p.processMissingBlock(databaseTransaction, removedBlockHash)
+				removedBlockID, err = p.processMissingBlock(ctx, databaseTransaction, removedBlockHash)
 				if err == nil {
 					blockIsInVirtualSelectedParentChain[removedBlockID] = false
 				} else {
@@ -842,7 +1036,7 @@ func (p *Processing) processVirtualChange(databaseTransaction *pg.Tx, blockInser
 			if err == nil {
 				blockIsInVirtualSelectedParentChain[addedBlockID] = true
 			} else if withDependencies {
-				addedBlockID, err = p.processMissingBlock(databaseTransaction, addedBlockHash)
+				addedBlockID, err = p.processMissingBlock(ctx, databaseTransaction, addedBlockHash)
 				if err == nil {
 					blockIsInVirtualSelectedParentChain[addedBlockID] = true
 				} else {
@@ -858,7 +1052,7 @@ func (p *Processing) processVirtualChange(databaseTransaction *pg.Tx, blockInser
 	}
 
 	for _, addedBlockHash := range addedBlockHashes {
-		rpcBlock, err := p.rpcClient.GetBlock(addedBlockHash.String(), false)
+		rpcBlock, err := p.blockSource.GetBlock(ctx, addedBlockHash.String(), false)
 		if err != nil {
 			return err
 		}
@@ -902,10 +1096,10 @@ func (p *Processing) processVirtualChange(databaseTransaction *pg.Tx, blockInser
 // The blocks are retrieved from the DAG by hash.
 // Their DAG DAA score is then associated to their id in the database.
 // Only matching DAG and database blocks are added to the returned map.
-func (p *Processing) getBlocksDAAScores(databaseTransaction *pg.Tx, blockHashes []*externalapi.DomainHash) (map[uint64]uint64, error) {
+func (p *Processing) getBlocksDAAScores(ctx context.Context, databaseTransaction databasePackage.Transaction, blockHashes []*externalapi.DomainHash) (map[uint64]uint64, error) {
 	results := make(map[uint64]uint64)
 	for _, blockHash := range blockHashes {
-		block, err := p.rpcClient.GetBlock(blockHash.String(), false)
+		block, err := p.blockSource.GetBlock(ctx, blockHash.String(), false)
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,274 @@
+package processing_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	databasePackage "github.com/kaspa-live/kaspa-graph-inspector/processing/database"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/database/memorystorage"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/database/model"
+	configPackage "github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/config"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/network/fakekaspad"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/network/rpcclient"
+	processingPackage "github.com/kaspa-live/kaspa-graph-inspector/processing/processing"
+	"github.com/kaspanet/kaspad/domain/consensus/model/externalapi"
+	"github.com/kaspanet/kaspad/domain/dagconfig"
+	kaspaConfigPackage "github.com/kaspanet/kaspad/infrastructure/config"
+)
+
+const waitTimeout = 10 * time.Second
+
+// harness runs the processing against a fake node, storing the blocks in memory.
+// The blocks of the fake node are named for the tests to refer to them.
+type harness struct {
+	t          *testing.T
+	server     *fakekaspad.Server
+	storage    *memorystorage.Storage
+	rpcClient  *rpcclient.RPCClient
+	processing *processingPackage.Processing
+	names      []string
+}
+
+func newHarness(t *testing.T) *harness {
+	dag := fakekaspad.NewDAG()
+	return &harness{
+		t:       t,
+		server:  fakekaspad.NewServer(dag),
+		storage: memorystorage.New(),
+		names:   []string{fakekaspad.GenesisName},
+	}
+}
+
+// testConfig returns the configuration of a processing giving up on its first failure
+func testConfig() *configPackage.Config {
+	return &configPackage.Config{
+		NetName: dagconfig.SimnetParams.Name,
+		Flags: &configPackage.Flags{
+			NetworkFlags:       kaspaConfigPackage.NetworkFlags{ActiveNetParams: &dagconfig.SimnetParams},
+			Partitioning:       databasePackage.PartitioningNone,
+			MaxFailures:        1,
+			FailureBackoff:     time.Millisecond,
+			EventQueueCapacity: 1000,
+		},
+	}
+}
+
+// start starts the processing, which syncs the blocks already added
+func (h *harness) start() {
+	h.t.Helper()
+
+	var err error
+	h.server.SetNetworkName(dagconfig.SimnetParams.Name)
+	h.rpcClient, err = h.server.NewRPCClient(processingPackage.RpcRouteCapacity)
+	if err != nil {
+		h.t.Fatalf("NewRPCClient: %s", err)
+	}
+	h.processing, err = processingPackage.NewProcessing(testConfig(), h.storage, h.rpcClient)
+	if err != nil {
+		h.t.Fatalf("NewProcessing: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	h.t.Cleanup(func() {
+		cancel()
+		h.processing.Wait()
+		h.rpcClient.Close()
+	})
+	err = h.processing.Start(ctx)
+	if err != nil {
+		h.t.Fatalf("Start: %s", err)
+	}
+}
+
+// addBlock adds a block named `name` on top of the blocks named `parentNames`, notifying the processing of it
+func (h *harness) addBlock(name string, parentNames ...string) {
+	h.t.Helper()
+
+	_, err := h.server.AddBlock(name, parentNames...)
+	if err != nil {
+		h.t.Fatalf("AddBlock(%s): %s", name, err)
+	}
+	h.names = append(h.names, name)
+}
+
+// addUnnotifiedBlock adds a block the processing is not notified of, as if the node got it while disconnected
+func (h *harness) addUnnotifiedBlock(name string, parentNames ...string) {
+	h.t.Helper()
+
+	_, _, err := h.server.DAG().AddBlock(name, parentNames...)
+	if err != nil {
+		h.t.Fatalf("AddBlock(%s): %s", name, err)
+	}
+	h.names = append(h.names, name)
+}
+
+// waitProcessed waits for the notifications sent by the fake node to be processed
+func (h *harness) waitProcessed() {
+	h.t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+	defer cancel()
+	err := h.server.WaitDelivered(ctx)
+	if err != nil {
+		h.t.Fatalf("The notifications were not delivered: %s", err)
+	}
+	err = h.processing.WaitForIdleEvents(ctx)
+	if err != nil {
+		h.t.Fatalf("The notifications were not processed: %s", err)
+	}
+	if h.processing.Err() != nil {
+		h.t.Fatalf("The processing stopped: %s", h.processing.Err())
+	}
+}
+
+// storedBlock returns the stored block named `name`
+func (h *harness) storedBlock(name string) *model.Block {
+	h.t.Helper()
+
+	hash, err := externalapi.NewDomainHashFromString(h.server.DAG().HashOf(name))
+	if err != nil {
+		h.t.Fatalf("Block %s has an invalid hash: %s", name, err)
+	}
+	var block *model.Block
+	err = h.storage.RunInTransaction(context.Background(), func(databaseTransaction databasePackage.Transaction) error {
+		blockID, err := h.storage.BlockIDByHash(databaseTransaction, hash)
+		if err != nil {
+			return err
+		}
+		block, err = h.storage.GetBlock(databaseTransaction, blockID)
+		return err
+	})
+	if err != nil {
+		h.t.Fatalf("Block %s is not stored: %s", name, err)
+	}
+	return block
+}
+
+// expectedColors returns the colors the blocks of the fake node get from the merge sets of the chain blocks
+func (h *harness) expectedColors() map[string]string {
+	dag := h.server.DAG()
+	colors := make(map[string]string)
+	for hash := dag.SelectedTip(); hash != ""; hash = dag.Block(hash).SelectedParent {
+		block := dag.Block(hash)
+		for _, blue := range block.MergeSetBlues {
+			colors[blue] = model.ColorBlue
+		}
+		for _, red := range block.MergeSetReds {
+			colors[red] = model.ColorRed
+		}
+	}
+	return colors
+}
+
+// requireSynced fails the test if the stored blocks do not match the blocks of the fake node
+func (h *harness) requireSynced() {
+	h.t.Helper()
+
+	dag := h.server.DAG()
+	colors := h.expectedColors()
+	for _, name := range h.names {
+		hash := dag.HashOf(name)
+		block := h.storedBlock(name)
+		isChainBlock := dag.IsChainBlock(hash)
+		if block.IsInVirtualSelectedParentChain != isChainBlock {
+			h.t.Fatalf("Block %s is stored with is_in_virtual_selected_parent_chain %t, expected %t",
+				name, block.IsInVirtualSelectedParentChain, isChainBlock)
+		}
+		if hash == dag.PruningPoint() {
+			continue
+		}
+		color, ok := colors[hash]
+		if !ok {
+			color = model.ColorGray
+		}
+		if block.Color != color {
+			h.t.Fatalf("Block %s is stored with color %s, expected %s", name, block.Color, color)
+		}
+		if len(block.ParentIDs) != len(dag.Block(hash).Parents) {
+			h.t.Fatalf("Block %s is stored with %d parents, expected %d", name, len(block.ParentIDs), len(dag.Block(hash).Parents))
+		}
+		selectedParent := h.storedBlockByHash(dag.Block(hash).SelectedParent)
+		if block.SelectedParentID == nil || *block.SelectedParentID != selectedParent.ID {
+			h.t.Fatalf("Block %s is stored with selected parent %v, expected %d", name, block.SelectedParentID, selectedParent.ID)
+		}
+	}
+}
+
+// storedBlockByHash returns the stored block of the fake node having the hash `hash`
+func (h *harness) storedBlockByHash(hash string) *model.Block {
+	h.t.Helper()
+
+	for _, name := range h.names {
+		if h.server.DAG().HashOf(name) == hash {
+			return h.storedBlock(name)
+		}
+	}
+	h.t.Fatalf("Block %s is unknown", hash)
+	return nil
+}
+
+func TestProcessingInitialSync(t *testing.T) {
+	h := newHarness(t)
+	h.addBlock("a", fakekaspad.GenesisName)
+	h.addBlock("b", fakekaspad.GenesisName)
+	h.addBlock("c", "a", "b")
+	h.addBlock("d", "c")
+
+	h.start()
+	h.waitProcessed()
+	h.requireSynced()
+
+	// The blocks added after the sync are notified
+	h.addBlock("e", "d")
+	h.addBlock("f", "d")
+	h.addBlock("g", "e", "f")
+	h.waitProcessed()
+	h.requireSynced()
+}
+
+func TestProcessingReorg(t *testing.T) {
+	h := newHarness(t)
+	h.start()
+
+	h.addBlock("a1", fakekaspad.GenesisName)
+	h.addBlock("a2", "a1")
+	h.addBlock("a3", "a2")
+	h.addBlock("b1", fakekaspad.GenesisName)
+	h.addBlock("b2", "b1")
+	h.waitProcessed()
+	h.requireSynced()
+	if !h.storedBlock("a3").IsInVirtualSelectedParentChain {
+		t.Fatalf("Block a3 is not in the chain before the reorg")
+	}
+
+	// c merges a1 into the b side, which then gets more blue work than the a side
+	h.addBlock("c", "b2", "a1")
+	h.waitProcessed()
+	h.requireSynced()
+	for _, name := range []string{"a2", "a3"} {
+		block := h.storedBlock(name)
+		if block.IsInVirtualSelectedParentChain || block.Color != model.ColorGray {
+			t.Fatalf("Block %s is still in the chain after the reorg", name)
+		}
+	}
+}
+
+func TestProcessingReconnect(t *testing.T) {
+	h := newHarness(t)
+	h.addBlock("a", fakekaspad.GenesisName)
+	h.start()
+	h.waitProcessed()
+
+	// The node gets blocks reorganizing the chain while the processing is disconnected from it
+	h.addUnnotifiedBlock("b1", fakekaspad.GenesisName)
+	h.addUnnotifiedBlock("b2", "b1")
+	h.addUnnotifiedBlock("b3", "b2", "a")
+	h.server.Reconnect()
+	h.waitProcessed()
+	h.requireSynced()
+
+	// The processing subscribed again to the notifications
+	h.addBlock("c", "b3")
+	h.waitProcessed()
+	h.requireSynced()
+}
@@ -0,0 +1,93 @@
+package processing
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kaspanet/kaspad/domain/consensus/model/externalapi"
+)
+
+// progress keeps track of the latest block handled by processing.
+// It has its own lock so it can be read while a long transaction holds the processing lock.
+//
+// The blocks processed by a transaction are only accounted for once the transaction committed,
+// and the reported DAA score never decreases, so the reported block is always in the database.
+type progress struct {
+	processedAt    time.Time
+	blockTimestamp int64
+	blockDAAScore  uint64
+
+	// pending holds the highest block processed by the running transaction, if any
+	pending *externalapi.DomainBlock
+
+	// nodeDAAScore is the highest virtual DAA score the node reported, raised by the DAA
+	// scores of the notified blocks, so the lag can be computed without querying the node
+	nodeDAAScore uint64
+
+	sync.RWMutex
+}
+
+// blockProcessed records `block` as processed by the running transaction
+func (pr *progress) blockProcessed(block *externalapi.DomainBlock) {
+	pr.Lock()
+	defer pr.Unlock()
+
+	if pr.pending == nil || block.Header.DAAScore() > pr.pending.Header.DAAScore() {
+		pr.pending = block
+	}
+}
+
+// commit accounts for the blocks processed by the transaction that just committed
+func (pr *progress) commit() {
+	pr.Lock()
+	defer pr.Unlock()
+
+	if pr.pending == nil {
+		return
+	}
+	pr.processedAt = time.Now()
+	if pr.pending.Header.DAAScore() >= pr.blockDAAScore {
+		pr.blockTimestamp = pr.pending.Header.TimeInMilliseconds()
+		pr.blockDAAScore = pr.pending.Header.DAAScore()
+	}
+	pr.pending = nil
+}
+
+// discard forgets the blocks processed by a transaction that got rolled back
+func (pr *progress) discard() {
+	pr.Lock()
+	defer pr.Unlock()
+
+	pr.pending = nil
+}
+
+// latest returns the time the latest block got processed, its timestamp and its DAA score.
+// The returned time is zero if no block was processed yet.
+func (pr *progress) latest() (time.Time, int64, uint64) {
+	pr.RLock()
+	defer pr.RUnlock()
+
+	return pr.processedAt, pr.blockTimestamp, pr.blockDAAScore
+}
+
+// nodeDAAScoreSeen records `daaScore` as reached by the node
+func (pr *progress) nodeDAAScoreSeen(daaScore uint64) {
+	pr.Lock()
+	defer pr.Unlock()
+
+	if daaScore > pr.nodeDAAScore {
+		pr.nodeDAAScore = daaScore
+	}
+}
+
+// daaScoreLag returns the highest DAA score reached by the node and how far behind it
+// the latest processed block is. The returned DAA score is zero if it is unknown yet.
+func (pr *progress) daaScoreLag() (nodeDAAScore uint64, lag uint64) {
+	pr.RLock()
+	defer pr.RUnlock()
+
+	if pr.blockDAAScore < pr.nodeDAAScore {
+		lag = pr.nodeDAAScore - pr.blockDAAScore
+	}
+	return pr.nodeDAAScore, lag
+}
@@ -0,0 +1,389 @@
+package processing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	databasePackage "github.com/kaspa-live/kaspa-graph-inspector/processing/database"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/database/model"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/tracing"
+	"github.com/kaspanet/kaspad/app/appmessage"
+	"github.com/kaspanet/kaspad/domain/consensus/model/externalapi"
+	"github.com/pkg/errors"
+)
+
+// ChainReconciliationReport describes the repairs made by a chain reconciliation
+type ChainReconciliationReport struct {
+	PruningPointHash    string `json:"pruningPointHash"`
+	NodeChainLength     int    `json:"nodeChainLength"`
+	DatabaseChainBlocks int    `json:"databaseChainBlocks"`
+	RemovedFromChain    int    `json:"removedFromChain"`
+	AddedToChain        int    `json:"addedToChain"`
+	Recolored           int    `json:"recolored"`
+}
+
+// reconciliationBatchSize is the number of chain blocks reconciled per database transaction
+const reconciliationBatchSize = 100
+
+// chainReconciliation is the state of a chain reconciliation carried across its batches
+type chainReconciliation struct {
+	report *ChainReconciliationReport
+	// pruningPointDAAScore bounds the blocks flagged as being in the chain in the database
+	pruningPointDAAScore uint64
+	// nodeChain is the virtual selected parent chain of the node from its pruning point,
+	// brought up to date with the chain changes of the node before each batch
+	nodeChain []string
+	// reconciledCount is the number of blocks of nodeChain already reconciled
+	reconciledCount int
+	// mergedHashes holds the hashes of the blocks merged by the reconciled chain blocks
+	mergedHashes map[string]struct{}
+}
+
+// ReconcileVirtualSelectedParentChain compares the virtual selected parent chain of the node,
+// from its pruning point, with the blocks flagged as being in the chain in the database and
+// repairs the chain flags and the block colors that do not match. The chain is reconciled in
+// batches, each in a transaction of its own, so block processing goes on meanwhile.
+func (p *Processing) ReconcileVirtualSelectedParentChain() (report *ChainReconciliationReport, err error) {
+	ctx, span := tracing.Start(p.ctx, "Processing.ReconcileVirtualSelectedParentChain")
+	defer func() { tracing.End(span, err) }()
+
+	log.Infof("Reconciling the virtual selected parent chain")
+	defer log.Infof("Finished reconciling the virtual selected parent chain")
+
+	dagInfo, err := p.blockSource.GetBlockDAGInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.progress.nodeDAAScoreSeen(dagInfo.VirtualDAAScore)
+	chainFromPruningPoint, err := p.blockSource.GetVirtualSelectedParentChainFromBlock(ctx, dagInfo.PruningPointHash, false)
+	if err != nil {
+		return nil, err
+	}
+	reconciliation := &chainReconciliation{
+		report:       &ChainReconciliationReport{PruningPointHash: dagInfo.PruningPointHash},
+		nodeChain:    append([]string{dagInfo.PruningPointHash}, chainFromPruningPoint.AddedChainBlockHashes...),
+		mergedHashes: make(map[string]struct{}),
+	}
+
+	err = p.runReconciliationBatch(ctx, reconciliation, func(databaseTransaction databasePackage.Transaction) error {
+		return p.countDatabaseChain(databaseTransaction, reconciliation)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for reconciliation.reconciledCount < len(reconciliation.nodeChain) {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		// The chain blocks are fetched before taking the lock, the node chain possibly changing meanwhile
+		rpcBlocks, err := p.getChainBlocks(ctx, reconciliation, nil)
+		if err != nil {
+			return nil, err
+		}
+		var batchReport *ChainReconciliationReport
+		var batchSize int
+		err = p.runReconciliationBatch(ctx, reconciliation, func(databaseTransaction databasePackage.Transaction) error {
+			batchReport, batchSize, err = p.reconcileChainBatch(ctx, databaseTransaction, reconciliation, rpcBlocks)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		reconciliation.add(batchReport)
+		reconciliation.reconciledCount += batchSize
+	}
+	var removalReport *ChainReconciliationReport
+	err = p.runReconciliationBatch(ctx, reconciliation, func(databaseTransaction databasePackage.Transaction) error {
+		removalReport, err = p.reconcileRemovedChainBlocks(databaseTransaction, reconciliation)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	reconciliation.add(removalReport)
+
+	report = reconciliation.report
+	report.NodeChainLength = len(reconciliation.nodeChain)
+	log.Infof("Virtual selected parent chain reconciled: %d blocks removed from the chain, %d added, %d recolored",
+		report.RemovedFromChain, report.AddedToChain, report.Recolored)
+	return report, nil
+}
+
+// runReconciliationBatch runs `operation` in a transaction of its own, holding the processing lock
+// meanwhile only. The node chain of `reconciliation` is first brought up to date with the chain
+// changes of the node, so a batch never reverts the chain changes processed between the batches.
+func (p *Processing) runReconciliationBatch(ctx context.Context, reconciliation *chainReconciliation,
+	operation func(databaseTransaction databasePackage.Transaction) error) error {
+
+	p.Lock()
+	defer p.Unlock()
+
+	err := p.refreshNodeChain(ctx, reconciliation)
+	if err != nil {
+		return err
+	}
+	return p.runInTransaction(ctx, operation)
+}
+
+// refreshNodeChain applies the chain changes of the node since the last refresh to the node chain of `reconciliation`
+func (p *Processing) refreshNodeChain(ctx context.Context, reconciliation *chainReconciliation) error {
+	nodeChain := reconciliation.nodeChain
+	chainChange, err := p.blockSource.GetVirtualSelectedParentChainFromBlock(ctx, nodeChain[len(nodeChain)-1], false)
+	if err != nil {
+		return err
+	}
+	removedCount := len(chainChange.RemovedChainBlockHashes)
+	if removedCount >= len(nodeChain) {
+		return errors.Errorf("The virtual selected parent chain of the node changed below its pruning point %s",
+			nodeChain[0])
+	}
+	for i, blockHash := range chainChange.RemovedChainBlockHashes {
+		if nodeChain[len(nodeChain)-1-i] != blockHash {
+			return errors.Errorf("Block %s removed from the virtual selected parent chain is not at its tip", blockHash)
+		}
+	}
+	nodeChain = append(nodeChain[:len(nodeChain)-removedCount], chainChange.AddedChainBlockHashes...)
+	reconciliation.nodeChain = nodeChain
+	if reconciliation.reconciledCount > len(nodeChain)-len(chainChange.AddedChainBlockHashes) {
+		reconciliation.reconciledCount = len(nodeChain) - len(chainChange.AddedChainBlockHashes)
+	}
+	return nil
+}
+
+// countDatabaseChain records the number of blocks flagged as being in the chain in the database
+func (p *Processing) countDatabaseChain(databaseTransaction databasePackage.Transaction,
+	reconciliation *chainReconciliation) error {
+
+	pruningPointHash, err := externalapi.NewDomainHashFromString(reconciliation.nodeChain[0])
+	if err != nil {
+		return err
+	}
+	pruningPointID, err := p.database.BlockIDByHash(databaseTransaction, pruningPointHash)
+	if err != nil {
+		return errors.Wrapf(err, "Could not find the pruning point %s in the database", pruningPointHash)
+	}
+	pruningPointBlock, err := p.database.GetBlock(databaseTransaction, pruningPointID)
+	if err != nil {
+		return err
+	}
+	reconciliation.pruningPointDAAScore = pruningPointBlock.DAAScore
+
+	databaseChain, err := p.database.BlockHashesInVirtualSelectedParentChain(databaseTransaction, pruningPointBlock.DAAScore)
+	if err != nil {
+		return err
+	}
+	reconciliation.report.DatabaseChainBlocks = len(databaseChain)
+	return nil
+}
+
+// getChainBlocks fetches the blocks of the next batch of `reconciliation` missing in `rpcBlocks`
+func (p *Processing) getChainBlocks(ctx context.Context, reconciliation *chainReconciliation,
+	rpcBlocks map[string]*appmessage.RPCBlock) (map[string]*appmessage.RPCBlock, error) {
+
+	if rpcBlocks == nil {
+		rpcBlocks = make(map[string]*appmessage.RPCBlock, reconciliationBatchSize)
+	}
+	for _, blockHash := range reconciliation.nextBatch() {
+		if _, ok := rpcBlocks[blockHash]; ok {
+			continue
+		}
+		response, err := p.blockSource.GetBlock(ctx, blockHash, false)
+		if err != nil {
+			return nil, err
+		}
+		rpcBlocks[blockHash] = response.Block
+	}
+	return rpcBlocks, nil
+}
+
+// nextBatch returns the hashes of the chain blocks to reconcile next
+func (reconciliation *chainReconciliation) nextBatch() []string {
+	end := reconciliation.reconciledCount + reconciliationBatchSize
+	if end > len(reconciliation.nodeChain) {
+		end = len(reconciliation.nodeChain)
+	}
+	return reconciliation.nodeChain[reconciliation.reconciledCount:end]
+}
+
+// add adds the repairs of a batch to the report of `reconciliation`
+func (reconciliation *chainReconciliation) add(batchReport *ChainReconciliationReport) {
+	reconciliation.report.RemovedFromChain += batchReport.RemovedFromChain
+	reconciliation.report.AddedToChain += batchReport.AddedToChain
+	reconciliation.report.Recolored += batchReport.Recolored
+}
+
+// reconcileChainBatch repairs the chain flags of the next batch of chain blocks and the colors of the blocks
+// they merge. It returns the repairs made and the number of chain blocks reconciled.
+func (p *Processing) reconcileChainBatch(ctx context.Context, databaseTransaction databasePackage.Transaction,
+	reconciliation *chainReconciliation, rpcBlocks map[string]*appmessage.RPCBlock) (*ChainReconciliationReport, int, error) {
+
+	// The node chain may have changed since the blocks got fetched
+	rpcBlocks, err := p.getChainBlocks(ctx, reconciliation, rpcBlocks)
+	if err != nil {
+		return nil, 0, err
+	}
+	batch := reconciliation.nextBatch()
+
+	blockIsInVirtualSelectedParentChain := make(map[uint64]bool)
+	expectedColors := make(map[uint64]string)
+	for i, blockHash := range batch {
+		hash, err := externalapi.NewDomainHashFromString(blockHash)
+		if err != nil {
+			return nil, 0, err
+		}
+		blockID, err := p.database.BlockIDByHash(databaseTransaction, hash)
+		if err != nil {
+			blockID, err = p.processMissingBlock(ctx, databaseTransaction, hash)
+			if err != nil {
+				return nil, 0, errors.Wrapf(err, "Could not add missing chain block %s", blockHash)
+			}
+		}
+		block, err := p.database.GetBlock(databaseTransaction, blockID)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !block.IsInVirtualSelectedParentChain {
+			log.Warnf("Block %s is missing its virtual selected parent chain flag", blockHash)
+			blockIsInVirtualSelectedParentChain[blockID] = true
+		}
+
+		// The latest chain block merging a block decides its color. The merge set of the pruning
+		// point is skipped since it reaches beyond the blocks known to the node.
+		if reconciliation.reconciledCount+i == 0 {
+			continue
+		}
+		verboseData := rpcBlocks[blockHash].VerboseData
+		for _, mergeSet := range []struct {
+			hashes []string
+			color  string
+		}{
+			{verboseData.MergeSetBluesHashes, model.ColorBlue},
+			{verboseData.MergeSetRedsHashes, model.ColorRed},
+		} {
+			for _, mergedBlockHash := range mergeSet.hashes {
+				reconciliation.mergedHashes[mergedBlockHash] = struct{}{}
+				blockID, err := p.blockIDByHashString(databaseTransaction, mergedBlockHash)
+				if err != nil {
+					log.Debugf("Merged block %s not found in the database: %s", mergedBlockHash, err)
+					continue
+				}
+				expectedColors[blockID] = mergeSet.color
+			}
+		}
+	}
+	err = p.database.UpdateBlockIsInVirtualSelectedParentChain(databaseTransaction, blockIsInVirtualSelectedParentChain)
+	if err != nil {
+		// enhanced error description
+		return nil, 0, errors.Wrapf(err, "Could not repair the virtual selected parent chain flags")
+	}
+	recolored, err := p.repairBlockColors(databaseTransaction, expectedColors)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &ChainReconciliationReport{
+		AddedToChain: len(blockIsInVirtualSelectedParentChain),
+		Recolored:    recolored,
+	}, len(batch), nil
+}
+
+// reconcileRemovedChainBlocks clears the chain flags of the blocks no longer in the chain of the node
+// and returns the repairs made
+func (p *Processing) reconcileRemovedChainBlocks(databaseTransaction databasePackage.Transaction,
+	reconciliation *chainReconciliation) (*ChainReconciliationReport, error) {
+
+	databaseChain, err := p.database.BlockHashesInVirtualSelectedParentChain(databaseTransaction,
+		reconciliation.pruningPointDAAScore)
+	if err != nil {
+		return nil, err
+	}
+	nodeChainSet := make(map[string]struct{}, len(reconciliation.nodeChain))
+	for _, blockHash := range reconciliation.nodeChain {
+		nodeChainSet[blockHash] = struct{}{}
+	}
+
+	blockIsInVirtualSelectedParentChain := make(map[uint64]bool)
+	expectedColors := make(map[uint64]string)
+	for _, blockHash := range databaseChain {
+		if _, ok := nodeChainSet[blockHash]; ok {
+			continue
+		}
+		blockID, err := p.blockIDByHashString(databaseTransaction, blockHash)
+		if err != nil {
+			return nil, err
+		}
+		log.Warnf("Block %s is wrongly flagged as being in the virtual selected parent chain", blockHash)
+		blockIsInVirtualSelectedParentChain[blockID] = false
+		// Blocks leaving the chain and not merged by any chain block are not colored yet
+		if _, ok := reconciliation.mergedHashes[blockHash]; !ok {
+			expectedColors[blockID] = model.ColorGray
+		}
+	}
+	err = p.database.UpdateBlockIsInVirtualSelectedParentChain(databaseTransaction, blockIsInVirtualSelectedParentChain)
+	if err != nil {
+		// enhanced error description
+		return nil, errors.Wrapf(err, "Could not repair the virtual selected parent chain flags")
+	}
+	recolored, err := p.repairBlockColors(databaseTransaction, expectedColors)
+	if err != nil {
+		return nil, err
+	}
+	return &ChainReconciliationReport{
+		RemovedFromChain: len(blockIsInVirtualSelectedParentChain),
+		Recolored:        recolored,
+	}, nil
+}
+
+// repairBlockColors updates the blocks whose color differs from `expectedColors` and returns their number
+func (p *Processing) repairBlockColors(databaseTransaction databasePackage.Transaction,
+	expectedColors map[uint64]string) (int, error) {
+
+	blockIDs := make([]uint64, 0, len(expectedColors))
+	for blockID := range expectedColors {
+		blockIDs = append(blockIDs, blockID)
+	}
+	actualColors, err := p.database.BlockColors(databaseTransaction, blockIDs)
+	if err != nil {
+		return 0, err
+	}
+	blockColors := make(map[uint64]string)
+	for blockID, expectedColor := range expectedColors {
+		if actualColors[blockID] != expectedColor {
+			blockColors[blockID] = expectedColor
+		}
+	}
+	err = p.database.UpdateBlockColors(databaseTransaction, blockColors)
+	if err != nil {
+		// enhanced error description
+		return 0, errors.Wrapf(err, "Could not repair the block colors")
+	}
+	return len(blockColors), nil
+}
+
+func (p *Processing) blockIDByHashString(databaseTransaction databasePackage.Transaction, blockHash string) (uint64, error) {
+	hash, err := externalapi.NewDomainHashFromString(blockHash)
+	if err != nil {
+		return 0, err
+	}
+	return p.database.BlockIDByHash(databaseTransaction, hash)
+}
+
+// HandleReconcile runs a chain reconciliation on demand and serves its report
+func (p *Processing) HandleReconcile(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		writer.Header().Set("Allow", http.MethodPost)
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	report, err := p.ReconcileVirtualSelectedParentChain()
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(writer).Encode(report)
+	if err != nil {
+		log.Warnf("Could not write the chain reconciliation report: %s", err)
+	}
+}
@@ -0,0 +1,162 @@
+package processing_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	databasePackage "github.com/kaspa-live/kaspa-graph-inspector/processing/database"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/database/model"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/network/fakekaspad"
+	processingPackage "github.com/kaspa-live/kaspa-graph-inspector/processing/processing"
+)
+
+// corrupt overrides the stored chain flags and colors of the blocks named in `isInVirtualSelectedParentChain`
+// and `colors`, as a processing missing notifications would leave them
+func (h *harness) corrupt(isInVirtualSelectedParentChain map[string]bool, colors map[string]string) {
+	h.t.Helper()
+
+	blockIDsToIsInVirtualSelectedParentChain := make(map[uint64]bool, len(isInVirtualSelectedParentChain))
+	for name, isInChain := range isInVirtualSelectedParentChain {
+		blockIDsToIsInVirtualSelectedParentChain[h.storedBlock(name).ID] = isInChain
+	}
+	blockIDsToColors := make(map[uint64]string, len(colors))
+	for name, color := range colors {
+		blockIDsToColors[h.storedBlock(name).ID] = color
+	}
+	err := h.storage.RunInTransaction(context.Background(), func(databaseTransaction databasePackage.Transaction) error {
+		err := h.storage.UpdateBlockIsInVirtualSelectedParentChain(databaseTransaction, blockIDsToIsInVirtualSelectedParentChain)
+		if err != nil {
+			return err
+		}
+		return h.storage.UpdateBlockColors(databaseTransaction, blockIDsToColors)
+	})
+	if err != nil {
+		h.t.Fatalf("Could not corrupt the stored blocks: %s", err)
+	}
+}
+
+// reconcile runs a chain reconciliation, failing the test if its report differs from `expected`
+func (h *harness) reconcile(expected *processingPackage.ChainReconciliationReport) {
+	h.t.Helper()
+
+	report, err := h.processing.ReconcileVirtualSelectedParentChain()
+	if err != nil {
+		h.t.Fatalf("ReconcileVirtualSelectedParentChain: %s", err)
+	}
+	if *report != *expected {
+		h.t.Fatalf("Reconciliation reported %+v, expected %+v", report, expected)
+	}
+}
+
+func TestReconcileRepairsChain(t *testing.T) {
+	h := newHarness(t)
+	h.start()
+	h.addBlock("a1", fakekaspad.GenesisName)
+	h.addBlock("a2", "a1")
+	h.addBlock("a3", "a2")
+	h.addBlock("b1", fakekaspad.GenesisName)
+	h.addBlock("b2", "b1")
+	h.addBlock("c", "b2", "a1")
+	h.waitProcessed()
+	h.requireSynced()
+
+	// The chain of the node is the genesis, b1, b2 and c, merging a1 as blue
+	report := &processingPackage.ChainReconciliationReport{
+		PruningPointHash:    h.server.DAG().PruningPoint(),
+		NodeChainLength:     4,
+		DatabaseChainBlocks: 4,
+	}
+	h.reconcile(report)
+
+	// a3 is left in the chain as blue, as if the reorg was missed, b1 is missing from it and a1 is red
+	h.corrupt(map[string]bool{"a3": true, "b1": false}, map[string]string{"a3": model.ColorBlue, "a1": model.ColorRed})
+	report.RemovedFromChain = 1
+	report.AddedToChain = 1
+	report.Recolored = 2
+	h.reconcile(report)
+	h.requireSynced()
+
+	// Nothing is left to repair
+	report.RemovedFromChain = 0
+	report.AddedToChain = 0
+	report.Recolored = 0
+	h.reconcile(report)
+}
+
+func TestReconcileRepairsMissedReorg(t *testing.T) {
+	h := newHarness(t)
+	h.start()
+	h.addBlock("a1", fakekaspad.GenesisName)
+	h.addBlock("a2", "a1")
+	h.addBlock("a3", "a2")
+	h.waitProcessed()
+
+	// The reorg is not notified, leaving a1, a2 and a3 in the stored chain
+	h.addUnnotifiedBlock("b1", fakekaspad.GenesisName)
+	h.addUnnotifiedBlock("b2", "b1")
+	h.addUnnotifiedBlock("c", "b2", "a1")
+	h.reconcile(&processingPackage.ChainReconciliationReport{
+		PruningPointHash:    h.server.DAG().PruningPoint(),
+		NodeChainLength:     4,
+		DatabaseChainBlocks: 4,
+		RemovedFromChain:    3,
+		AddedToChain:        3,
+		Recolored:           3,
+	})
+	h.requireSynced()
+	for _, name := range []string{"a1", "a2", "a3"} {
+		if h.storedBlock(name).IsInVirtualSelectedParentChain {
+			t.Fatalf("Block %s is still in the chain after the reconciliation", name)
+		}
+	}
+}
+
+func TestReconcileRepairsChainInBatches(t *testing.T) {
+	h := newHarness(t)
+	h.start()
+	parentName := fakekaspad.GenesisName
+	for i := 1; i <= 250; i++ {
+		name := fmt.Sprintf("a%d", i)
+		h.addBlock(name, parentName)
+		parentName = name
+	}
+	h.waitProcessed()
+
+	// The corrupted blocks lie in different batches of the reconciliation
+	h.corrupt(map[string]bool{"a50": false, "a150": false, "a240": false}, map[string]string{"a120": model.ColorRed})
+	h.reconcile(&processingPackage.ChainReconciliationReport{
+		PruningPointHash:    h.server.DAG().PruningPoint(),
+		NodeChainLength:     251,
+		DatabaseChainBlocks: 248,
+		AddedToChain:        3,
+		Recolored:           1,
+	})
+	h.requireSynced()
+}
+
+func TestResyncRemovesChainBlocks(t *testing.T) {
+	h := newHarness(t)
+	h.start()
+	h.addBlock("a1", fakekaspad.GenesisName)
+	h.addBlock("a2", "a1")
+	h.addBlock("a3", "a2")
+	h.waitProcessed()
+
+	// The chain is reorganized while the processing is disconnected, the resync on reconnection
+	// getting a1, a2 and a3 as removed from the chain
+	h.addUnnotifiedBlock("b1", fakekaspad.GenesisName)
+	h.addUnnotifiedBlock("b2", "b1")
+	h.addUnnotifiedBlock("c", "b2", "a1")
+	h.server.Reconnect()
+	h.waitProcessed()
+	h.requireSynced()
+	for _, name := range []string{"a1", "a2", "a3"} {
+		if h.storedBlock(name).IsInVirtualSelectedParentChain {
+			t.Fatalf("Block %s is still in the chain after the resync", name)
+		}
+	}
+	if color := h.storedBlock("a3").Color; color != model.ColorGray {
+		t.Fatalf("Block a3 left the chain unmerged with color %s, expected %s", color, model.ColorGray)
+	}
+}
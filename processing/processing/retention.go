@@ -0,0 +1,120 @@
+package processing
+
+import (
+	"time"
+
+	databasePackage "github.com/kaspa-live/kaspa-graph-inspector/processing/database"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/infrastructure/tracing"
+)
+
+func (p *Processing) retentionWindow() *databasePackage.RetentionWindow {
+	return &databasePackage.RetentionWindow{
+		Height:   p.config.RetentionHeight,
+		DAAScore: p.config.RetentionDAAScore,
+		Age:      p.config.RetentionAge,
+	}
+}
+
+// startRetention starts enforcing the retention window in the background, if any is configured
+func (p *Processing) startRetention() {
+	window := p.retentionWindow()
+	if window.Height == 0 && window.DAAScore == 0 && window.Age == 0 {
+		return
+	}
+
+	p.consumer.Add(1)
+	go func() {
+		defer p.consumer.Done()
+		p.enforceRetentionPeriodically()
+	}()
+}
+
+// enforceRetentionPeriodically enforces the retention window at the configured interval until the
+// processing stops. A failed enforcement counts as a processing failure and is retried at the next interval.
+func (p *Processing) enforceRetentionPeriodically() {
+	ticker := time.NewTicker(p.config.RetentionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+			_ = p.supervisor.attempt("retention enforcement", p.EnforceRetention)
+		}
+	}
+}
+
+// EnforceRetention deletes the blocks lying outside the retention window, in batches so block
+// processing goes on meanwhile. Each batch deletes the lowest blocks first, leaving the database
+// consistent for the API between the batches. The pruning point of the node and the blocks
+// following it are always kept, otherwise the next resync would clear the database.
+func (p *Processing) EnforceRetention() (err error) {
+	ctx, span := tracing.Start(p.ctx, "Processing.EnforceRetention")
+	defer func() { tracing.End(span, err) }()
+
+	dagInfo, err := p.blockSource.GetBlockDAGInfo(ctx)
+	if err != nil {
+		return err
+	}
+	p.progress.nodeDAAScoreSeen(dagInfo.VirtualDAAScore)
+	pruningPoint, err := p.blockSource.GetBlock(ctx, dagInfo.PruningPointHash, false)
+	if err != nil {
+		return err
+	}
+	keptDAAScore := pruningPoint.Block.Header.DAAScore
+
+	window := p.retentionWindow()
+	prunedBlockCount := 0
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		batchCount, err := p.pruneRetentionBatch(window, keptDAAScore)
+		if err != nil {
+			return err
+		}
+		prunedBlockCount += batchCount
+		if batchCount < p.config.RetentionBatchSize {
+			break
+		}
+	}
+	if prunedBlockCount == 0 {
+		return nil
+	}
+	log.Infof("Retention deleted %d blocks", prunedBlockCount)
+
+	if p.config.RetentionRebase {
+		return p.rebaseHeights()
+	}
+	return nil
+}
+
+func (p *Processing) pruneRetentionBatch(window *databasePackage.RetentionWindow, keptDAAScore uint64) (int, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	var prunedBlockCount int
+	err := p.database.RunInTransaction(p.ctx, func(databaseTransaction databasePackage.Transaction) error {
+		var err error
+		prunedBlockCount, err = p.database.PruneBlocksOutsideWindow(databaseTransaction, window, keptDAAScore, p.config.RetentionBatchSize)
+		return err
+	})
+	return prunedBlockCount, err
+}
+
+func (p *Processing) rebaseHeights() error {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.database.RunInTransaction(p.ctx, func(databaseTransaction databasePackage.Transaction) error {
+		rebasedHeight, err := p.database.RebaseHeights(databaseTransaction)
+		if err != nil {
+			return err
+		}
+		if rebasedHeight > 0 {
+			log.Infof("Rebased height %d to height 0", rebasedHeight)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,110 @@
+package processing
+
+import (
+	"context"
+	"time"
+
+	databasePackage "github.com/kaspa-live/kaspa-graph-inspector/processing/database"
+	"github.com/kaspa-live/kaspa-graph-inspector/processing/database/model"
+	"github.com/kaspanet/kaspad/app/appmessage"
+	"github.com/kaspanet/kaspad/domain/consensus/model/externalapi"
+	"github.com/pkg/errors"
+)
+
+// insertPruningPoint inserts the pruning point of the node at `height`, as the first block of the
+// virtual selected parent chain and without any stored parent
+func (p *Processing) insertPruningPoint(databaseTransaction databasePackage.Transaction, pruningPointHash *externalapi.DomainHash,
+	rpcPruning *appmessage.GetBlockResponseMessage, height uint64) error {
+
+	heightGroupSize, err := p.database.HeightGroupSize(databaseTransaction, height)
+	if err != nil {
+		// enhanced error description
+		return errors.Wrapf(err, "Could not resolve group size for height %d for pruning point %s", height, pruningPointHash)
+	}
+	pruningPointDatabaseBlock := &model.Block{
+		BlockHash:                      pruningPointHash.String(),
+		Timestamp:                      rpcPruning.Block.Header.Timestamp,
+		ParentIDs:                      []uint64{},
+		Height:                         height,
+		HeightGroupIndex:               heightGroupSize,
+		SelectedParentID:               nil,
+		Color:                          model.ColorGray,
+		IsInVirtualSelectedParentChain: true,
+		MergeSetRedIDs:                 []uint64{},
+		MergeSetBlueIDs:                []uint64{},
+	}
+	err = p.database.InsertBlock(databaseTransaction, pruningPointHash, pruningPointDatabaseBlock)
+	if err != nil {
+		return err
+	}
+	heightGroup := &model.HeightGroup{
+		Height: height,
+		Size:   heightGroupSize + 1,
+	}
+	return p.database.InsertOrUpdateHeightGroup(databaseTransaction, heightGroup)
+}
+
+// appendSegment adds the pruning point of the node, missing in the database, after the stored history
+// instead of clearing it. The pruning point gets connected to those of its parents that are stored.
+// When none is, the pruning point starts a new segment above the highest stored block, an empty height
+// marking the gap in the history.
+// Returns false if the database stores no block, in which case nothing is added.
+func (p *Processing) appendSegment(ctx context.Context, databaseTransaction databasePackage.Transaction, pruningPointHash *externalapi.DomainHash,
+	pruningPointBlock *externalapi.DomainBlock, rpcPruning *appmessage.GetBlockResponseMessage) (bool, error) {
+
+	maxHeight, hasBlocks, err := p.database.MaxBlockHeight(databaseTransaction)
+	if err != nil {
+		return false, err
+	}
+	if !hasBlocks {
+		return false, nil
+	}
+
+	isConnected := false
+	for _, parentHash := range pruningPointBlock.Header.DirectParents() {
+		isConnected, err = p.database.DoesBlockExist(databaseTransaction, parentHash)
+		if err != nil {
+			return false, err
+		}
+		if isConnected {
+			break
+		}
+	}
+
+	if isConnected {
+		log.Infof("Connecting the pruning point %s to the stored history", pruningPointHash)
+		err = p.processBlock(ctx, databaseTransaction, pruningPointBlock)
+		if err != nil {
+			return false, err
+		}
+	} else {
+		// An empty height separates the segments
+		startHeight := maxHeight + 2
+		log.Warnf("The pruning point %s is not connected to the stored history, "+
+			"starting a new segment at height %d", pruningPointHash, startHeight)
+		err = p.insertPruningPoint(databaseTransaction, pruningPointHash, rpcPruning, startHeight)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	pruningPointID, err := p.database.BlockIDByHash(databaseTransaction, pruningPointHash)
+	if err != nil {
+		return false, err
+	}
+	pruningPointHeight, err := p.database.BlockHeight(databaseTransaction, pruningPointID)
+	if err != nil {
+		return false, err
+	}
+	err = p.database.InsertSegment(databaseTransaction, &model.Segment{
+		PruningPointID: pruningPointID,
+		StartHeight:    pruningPointHeight,
+		IsConnected:    isConnected,
+		CreatedAt:      time.Now().UnixMilli(),
+	})
+	if err != nil {
+		// enhanced error description
+		return false, errors.Wrapf(err, "Could not record the segment starting at pruning point %s", pruningPointHash)
+	}
+	return true, nil
+}
@@ -0,0 +1,126 @@
+package processing
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxOperationAttempts is the number of times a failing operation is tried
+	// before falling back to a full resync of the database
+	maxOperationAttempts = 3
+
+	maxFailureBackoff = time.Minute
+)
+
+// supervisor runs the operations triggered by node events and recovers from their failures.
+// A failed operation is retried with an exponential backoff. When it keeps failing, or when
+// the database is found out of sync with the node, the whole database gets resynced instead.
+// The processing is stopped only after `maxFailures` consecutive failures.
+type supervisor struct {
+	processing     *Processing
+	maxFailures    int
+	initialBackoff time.Duration
+
+	failures int
+	err      error
+	sync.Mutex
+}
+
+func newSupervisor(processing *Processing, maxFailures int, initialBackoff time.Duration) *supervisor {
+	return &supervisor{
+		processing:     processing,
+		maxFailures:    maxFailures,
+		initialBackoff: initialBackoff,
+	}
+}
+
+// run runs `operation` and recovers from its failures, falling back to a full resync
+// of the database when retrying is not enough
+func (s *supervisor) run(name string, operation func() error) {
+	for attempt := 1; ; attempt++ {
+		err := s.attempt(name, operation)
+		if err == nil || !s.backOff() {
+			return
+		}
+		if requiresResync(err) || attempt == maxOperationAttempts {
+			log.Warnf("Recovering from the failure of %s with a full resync of the database", name)
+			s.retry("database resync", s.processing.ResyncDatabase)
+			return
+		}
+	}
+}
+
+// retry runs `operation` until it succeeds, the supervisor gives up or the processing stops
+func (s *supervisor) retry(name string, operation func() error) {
+	for {
+		err := s.attempt(name, operation)
+		if err == nil || !s.backOff() {
+			return
+		}
+	}
+}
+
+// attempt runs `operation` once, turning a panic into an error, and accounts for its outcome
+func (s *supervisor) attempt(name string, operation func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Wrapf(ErrPanic, "%s", r)
+		}
+
+		s.Lock()
+		defer s.Unlock()
+
+		if err == nil {
+			s.failures = 0
+			return
+		}
+		if s.processing.isStopping() {
+			log.Debugf("Shutting down so ignoring the failure of %s: %s", name, err)
+			return
+		}
+		s.failures++
+		log.Errorf("Failure %d/%d - %s failed: %s", s.failures, s.maxFailures, name, err)
+		if s.failures >= s.maxFailures {
+			s.err = errors.Wrapf(ErrTooManyFailures, "%s failed: %s", name, err)
+			s.processing.stop()
+		}
+	}()
+
+	return operation()
+}
+
+// backOff waits before the next attempt. It returns false if no further
+// attempt should be made because the processing is stopping.
+func (s *supervisor) backOff() bool {
+	if s.processing.isStopping() {
+		return false
+	}
+
+	s.Lock()
+	backoff := s.initialBackoff
+	if s.failures > 1 {
+		backoff <<= s.failures - 1
+	}
+	s.Unlock()
+	if backoff <= 0 || backoff > maxFailureBackoff {
+		backoff = maxFailureBackoff
+	}
+
+	select {
+	case <-s.processing.ctx.Done():
+		return false
+	case <-time.After(backoff):
+		return true
+	}
+}
+
+// Err returns the error the supervisor gave up on, if any
+func (s *supervisor) Err() error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.err
+}
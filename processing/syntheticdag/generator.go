@@ -0,0 +1,204 @@
+package syntheticdag
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Options configures a Generator
+type Options struct {
+	// K is the GHOSTDAG parameter the blocks get colored with
+	K uint64
+	// Width is the number of blocks created in each round. The blocks of a round are created
+	// concurrently: none of them sees the others, so they are in the anticone of each other.
+	Width int
+	// MaxParents is the maximum number of parents of a block
+	MaxParents int
+	// RedRate is the probability for a block to be created on a view of the DAG lagging enough
+	// rounds behind for its anticone to hold more than K blocks, which makes it red
+	RedRate float64
+	// ReorgRate is the probability for a round to start a network split, during which the blocks
+	// are created by two groups not seeing the blocks of each other. The virtual selected parent
+	// chain then switches between the two sides of the split.
+	ReorgRate float64
+	// ReorgDepth is the number of rounds a network split lasts
+	ReorgDepth int
+	// RoundInterval is the time between the timestamps of two rounds
+	RoundInterval time.Duration
+	// Seed seeds the random choices, the same seed generating the same DAG
+	Seed int64
+}
+
+// Validate returns an error if the options cannot generate a DAG
+func (o *Options) Validate() error {
+	if o.Width < 1 {
+		return errors.Errorf("The width must be at least 1")
+	}
+	if o.MaxParents < 1 {
+		return errors.Errorf("The maximum number of parents must be at least 1")
+	}
+	if o.RedRate < 0 || o.RedRate > 1 {
+		return errors.Errorf("The red rate must be between 0 and 1")
+	}
+	if o.ReorgRate < 0 || o.ReorgRate > 1 {
+		return errors.Errorf("The reorg rate must be between 0 and 1")
+	}
+	if o.ReorgRate > 0 && o.ReorgDepth < 1 {
+		return errors.Errorf("The reorg depth must be at least 1")
+	}
+	return nil
+}
+
+// generatedBlock is a block along with the round it got created in and the side of the network split
+// it got created by, if any
+type generatedBlock struct {
+	*Block
+	round int
+	side  int
+}
+
+// Generator generates a GHOSTDAG-consistent DAG round by round
+type Generator struct {
+	options  *Options
+	ghostdag *GHOSTDAG
+	random   *rand.Rand
+
+	genesis *Block
+	round   int
+	// recent holds the blocks of the rounds a block can still be created on
+	recent []*generatedBlock
+	// splitUntil is the last round of the current network split, if any
+	splitUntil int
+	// splitSince is the first round of the current network split, if any
+	splitSince int
+	lag        int
+	count      int
+}
+
+// NewGenerator creates a generator of a DAG starting at `genesis`
+func NewGenerator(genesis *Block, options *Options) (*Generator, error) {
+	err := options.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return &Generator{
+		options:    options,
+		ghostdag:   NewGHOSTDAG(options.K, genesis),
+		random:     rand.New(rand.NewSource(options.Seed)),
+		genesis:    genesis,
+		recent:     []*generatedBlock{{Block: genesis}},
+		splitUntil: -1,
+		splitSince: -1,
+		// A block created on a view lagging this many rounds behind has more than K blocks in its anticone
+		lag: int(options.K)/options.Width + 2,
+	}, nil
+}
+
+// GHOSTDAG returns the DAG of the generated blocks
+func (g *Generator) GHOSTDAG() *GHOSTDAG {
+	return g.ghostdag
+}
+
+// Next generates the blocks of the next round, in an order they can be added to a DAG in
+func (g *Generator) Next() ([]*Block, error) {
+	g.round++
+	if g.round > g.splitUntil && g.options.ReorgRate > 0 && g.random.Float64() < g.options.ReorgRate {
+		g.splitSince = g.round
+		g.splitUntil = g.round + g.options.ReorgDepth - 1
+	}
+	isSplit := g.round <= g.splitUntil
+
+	roundBlocks := make([]*generatedBlock, 0, g.options.Width)
+	for i := 0; i < g.options.Width; i++ {
+		side := 0
+		if isSplit {
+			side = i%2 + 1
+		}
+		viewRound := g.round - 1
+		if g.options.RedRate > 0 && g.random.Float64() < g.options.RedRate {
+			viewRound -= g.lag
+		}
+		parents := g.parents(viewRound, side)
+
+		g.count++
+		timestamp := g.genesis.Timestamp + int64(g.round)*g.options.RoundInterval.Milliseconds() + int64(i)
+		block, err := g.ghostdag.Add(parents, timestamp, uint64(g.count))
+		if err != nil {
+			return nil, err
+		}
+		roundBlocks = append(roundBlocks, &generatedBlock{Block: block, round: g.round, side: side})
+	}
+
+	g.recent = append(g.recent, roundBlocks...)
+	g.forgetOldRounds()
+
+	// The blocks of a network split get added alternately by the two sides
+	blocks := make([]*Block, len(roundBlocks))
+	for i, block := range roundBlocks {
+		blocks[i] = block.Block
+	}
+	if !isSplit {
+		g.random.Shuffle(len(blocks), func(i, j int) { blocks[i], blocks[j] = blocks[j], blocks[i] })
+	}
+	return blocks, nil
+}
+
+// parents returns the parents of a block created by the side `side` of a network split, or by
+// everyone if `side` is 0, seeing the blocks up to round `viewRound`: the tips of its view with
+// the highest blue work, up to MaxParents
+func (g *Generator) parents(viewRound int, side int) []string {
+	isVisible := func(block *generatedBlock) bool {
+		if block.round > viewRound {
+			return false
+		}
+		return side == 0 || block.side == 0 || block.side == side || block.round < g.splitSince
+	}
+
+	hasVisibleChild := make(map[string]struct{})
+	for _, block := range g.recent {
+		if isVisible(block) {
+			for _, parent := range block.Parents {
+				hasVisibleChild[parent] = struct{}{}
+			}
+		}
+	}
+	var tips []string
+	for _, block := range g.recent {
+		if _, ok := hasVisibleChild[block.Hash]; !ok && isVisible(block) {
+			tips = append(tips, block.Hash)
+		}
+	}
+	if len(tips) == 0 {
+		// The view lags behind the rounds kept in memory
+		tips = []string{g.recent[0].Hash}
+	}
+
+	sort.Slice(tips, func(i, j int) bool {
+		return g.ghostdag.isHeavier(tips[i], tips[j])
+	})
+	if len(tips) > g.options.MaxParents {
+		tips = tips[:g.options.MaxParents]
+	}
+	return tips
+}
+
+// forgetOldRounds drops the blocks of the rounds no view reaches anymore, but the ones still being tips
+func (g *Generator) forgetOldRounds() {
+	oldestRound := g.round - g.lag - g.options.ReorgDepth - 1
+	hasChild := make(map[string]struct{})
+	for _, block := range g.recent {
+		for _, parent := range block.Parents {
+			hasChild[parent] = struct{}{}
+		}
+	}
+	recent := g.recent[:0]
+	for _, block := range g.recent {
+		if _, ok := hasChild[block.Hash]; block.round >= oldestRound || !ok {
+			recent = append(recent, block)
+		}
+	}
+	g.recent = recent
+}
@@ -0,0 +1,243 @@
+package syntheticdag
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// Block is a block of a synthetic DAG along with its GHOSTDAG data
+type Block struct {
+	// Hash is the hash of the header of the block, see HeaderHash
+	Hash    string
+	Parents []string
+	// SelectedParent is empty for the genesis
+	SelectedParent string
+	// MergeSetBlues starts with the selected parent, as kaspad reports it
+	MergeSetBlues []string
+	MergeSetReds  []string
+	BlueScore     uint64
+	BlueWork      uint64
+	DAAScore      uint64
+	// Timestamp is in milliseconds
+	Timestamp int64
+	// Nonce tells apart the blocks otherwise sharing their header
+	Nonce uint64
+}
+
+// ghostdagBlock is a block along with the anticone sizes GHOSTDAG colors its merge set with
+type ghostdagBlock struct {
+	*Block
+	// bluesAnticoneSizes holds the size of the blue anticone of each block of MergeSetBlues
+	// from the point of view of the block
+	bluesAnticoneSizes map[string]uint64
+}
+
+// GHOSTDAG computes the GHOSTDAG data of the blocks added to a DAG, with the same rules as kaspad.
+// Every block has the same difficulty, so the blue work of a block grows along with its blue score.
+type GHOSTDAG struct {
+	k      uint64
+	blocks map[string]*ghostdagBlock
+}
+
+// NewGHOSTDAG creates a DAG holding only `genesis`, whose blocks get colored with the parameter `k`
+func NewGHOSTDAG(k uint64, genesis *Block) *GHOSTDAG {
+	return &GHOSTDAG{
+		k: k,
+		blocks: map[string]*ghostdagBlock{
+			genesis.Hash: {Block: genesis, bluesAnticoneSizes: map[string]uint64{}},
+		},
+	}
+}
+
+// K returns the GHOSTDAG parameter the blocks get colored with
+func (g *GHOSTDAG) K() uint64 {
+	return g.k
+}
+
+// Block returns the block `hash`, or nil if the DAG does not hold it
+func (g *GHOSTDAG) Block(hash string) *Block {
+	block, ok := g.blocks[hash]
+	if !ok {
+		return nil
+	}
+	return block.Block
+}
+
+// Add adds a block on top of `parents` and returns it along with its GHOSTDAG data.
+// Its hash is the one of its header, which holds `timestamp` and `nonce`.
+func (g *GHOSTDAG) Add(parents []string, timestamp int64, nonce uint64) (*Block, error) {
+	if len(parents) == 0 {
+		return nil, errors.New("A block has no parents")
+	}
+	for _, parent := range parents {
+		if _, ok := g.blocks[parent]; !ok {
+			return nil, errors.Errorf("Parent %s of a block is unknown", parent)
+		}
+	}
+
+	selectedParent := g.highestBlueWork(parents)
+	block := &ghostdagBlock{
+		Block: &Block{
+			Parents:        parents,
+			SelectedParent: selectedParent,
+			MergeSetBlues:  []string{selectedParent},
+			Timestamp:      timestamp,
+			Nonce:          nonce,
+		},
+		bluesAnticoneSizes: map[string]uint64{selectedParent: 0},
+	}
+	for _, candidate := range g.mergeSetWithoutSelectedParent(selectedParent, parents) {
+		isBlue, candidateAnticoneSize, candidateBluesAnticoneSizes := g.checkBlueCandidate(block, candidate)
+		if !isBlue {
+			block.MergeSetReds = append(block.MergeSetReds, candidate)
+			continue
+		}
+		block.MergeSetBlues = append(block.MergeSetBlues, candidate)
+		block.bluesAnticoneSizes[candidate] = candidateAnticoneSize
+		for blue, anticoneSize := range candidateBluesAnticoneSizes {
+			block.bluesAnticoneSizes[blue] = anticoneSize + 1
+		}
+	}
+
+	parent := g.blocks[selectedParent]
+	block.BlueScore = parent.BlueScore + uint64(len(block.MergeSetBlues))
+	block.BlueWork = parent.BlueWork + uint64(len(block.MergeSetBlues))
+	block.DAAScore = parent.DAAScore + uint64(len(block.MergeSetBlues)+len(block.MergeSetReds))
+
+	hash, err := HeaderHash(block.Block)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := g.blocks[hash]; ok {
+		return nil, errors.Errorf("Block %s already exists", hash)
+	}
+	block.Hash = hash
+	g.blocks[hash] = block
+	return block.Block, nil
+}
+
+// checkBlueCandidate returns whether `candidate` can be colored blue in the merge set of `block`,
+// along with the size of its blue anticone and the sizes of the blue anticones of the blue blocks
+// in its anticone. The candidate is red if adding it to the blues would give a blue block more than
+// K blue blocks in its anticone.
+func (g *GHOSTDAG) checkBlueCandidate(block *ghostdagBlock, candidate string) (bool, uint64, map[string]uint64) {
+	// The merge set of a block holds at most K+1 blue blocks, its selected parent included
+	if uint64(len(block.MergeSetBlues)) == g.k+1 {
+		return false, 0, nil
+	}
+
+	candidateBluesAnticoneSizes := make(map[string]uint64)
+	var candidateAnticoneSize uint64
+	// The blue blocks in the anticone of the candidate are in the merge sets of the chain blocks
+	// down to the first one in the past of the candidate
+	for chainBlock := block; ; chainBlock = g.blocks[chainBlock.SelectedParent] {
+		if chainBlock != block && g.isInPast(chainBlock.Hash, candidate) {
+			return true, candidateAnticoneSize, candidateBluesAnticoneSizes
+		}
+		for _, blue := range chainBlock.MergeSetBlues {
+			// The blue blocks are in the anticone of the candidate unless in its past since the
+			// candidate is in the merge set of `block`, and the merge set is sorted by blue work
+			if g.isInPast(blue, candidate) {
+				continue
+			}
+			blueAnticoneSize := g.blueAnticoneSize(block, blue)
+			candidateBluesAnticoneSizes[blue] = blueAnticoneSize
+			candidateAnticoneSize++
+			if candidateAnticoneSize > g.k || blueAnticoneSize == g.k {
+				return false, 0, nil
+			}
+		}
+	}
+}
+
+// blueAnticoneSize returns the size of the blue anticone of the blue block `blue` from the point of view of `block`
+func (g *GHOSTDAG) blueAnticoneSize(block *ghostdagBlock, blue string) uint64 {
+	for current := block; ; current = g.blocks[current.SelectedParent] {
+		anticoneSize, ok := current.bluesAnticoneSizes[blue]
+		if ok {
+			return anticoneSize
+		}
+		if current.SelectedParent == "" {
+			// Every blue block is in the merge set of a chain block
+			panic(errors.Errorf("Block %s is not in the blue past of %s", blue, block.Hash))
+		}
+	}
+}
+
+// highestBlueWork returns the block of `hashes` with the highest blue work, the highest hash breaking ties
+func (g *GHOSTDAG) highestBlueWork(hashes []string) string {
+	highest := hashes[0]
+	for _, hash := range hashes[1:] {
+		if g.isHeavier(hash, highest) {
+			highest = hash
+		}
+	}
+	return highest
+}
+
+// isHeavier returns true if the block `hash` has more blue work than the block `other`, the hash breaking ties
+func (g *GHOSTDAG) isHeavier(hash string, other string) bool {
+	block, otherBlock := g.blocks[hash], g.blocks[other]
+	if block.BlueWork != otherBlock.BlueWork {
+		return block.BlueWork > otherBlock.BlueWork
+	}
+	return hash > other
+}
+
+// mergeSetWithoutSelectedParent returns the blocks in the past of `parents` but neither in the past
+// of `selectedParent` nor `selectedParent` itself, ordered by blue work
+func (g *GHOSTDAG) mergeSetWithoutSelectedParent(selectedParent string, parents []string) []string {
+	var mergeSet []string
+	visited := map[string]struct{}{selectedParent: {}}
+	var queue []string
+	for _, parent := range parents {
+		if parent != selectedParent {
+			queue = append(queue, parent)
+		}
+	}
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		if _, ok := visited[hash]; ok {
+			continue
+		}
+		visited[hash] = struct{}{}
+		if g.isInPast(hash, selectedParent) {
+			continue
+		}
+		mergeSet = append(mergeSet, hash)
+		queue = append(queue, g.blocks[hash].Parents...)
+	}
+	sort.Slice(mergeSet, func(i, j int) bool {
+		return g.isHeavier(mergeSet[j], mergeSet[i])
+	})
+	return mergeSet
+}
+
+// isInPast returns true if the block `hash` is in the past of the block `of`.
+// The blue work strictly increases from a block to its children, so the search
+// skips the blocks having no more blue work than `hash`.
+func (g *GHOSTDAG) isInPast(hash string, of string) bool {
+	blueWork := g.blocks[hash].BlueWork
+	if g.blocks[of].BlueWork <= blueWork {
+		return false
+	}
+	visited := make(map[string]struct{})
+	queue := []string{of}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, parent := range g.blocks[current].Parents {
+			if parent == hash {
+				return true
+			}
+			if _, ok := visited[parent]; ok || g.blocks[parent].BlueWork <= blueWork {
+				continue
+			}
+			visited[parent] = struct{}{}
+			queue = append(queue, parent)
+		}
+	}
+	return false
+}
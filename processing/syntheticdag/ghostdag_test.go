@@ -0,0 +1,194 @@
+package syntheticdag
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// generate returns the blocks of a DAG generated by `rounds` rounds with `options`, the genesis first,
+// every block following its parents
+func generate(t *testing.T, options *Options, rounds int) []*Block {
+	t.Helper()
+
+	genesis := &Block{Timestamp: 1_700_000_000_000}
+	hash, err := HeaderHash(genesis)
+	if err != nil {
+		t.Fatalf("HeaderHash: %s", err)
+	}
+	genesis.Hash = hash
+
+	generator, err := NewGenerator(genesis, options)
+	if err != nil {
+		t.Fatalf("NewGenerator: %s", err)
+	}
+	blocks := []*Block{genesis}
+	for i := 0; i < rounds; i++ {
+		roundBlocks, err := generator.Next()
+		if err != nil {
+			t.Fatalf("Next: %s", err)
+		}
+		blocks = append(blocks, roundBlocks...)
+	}
+	return blocks
+}
+
+// pasts returns the hashes of the blocks in the past of each block of `blocks`
+func pasts(blocks []*Block) map[string]map[string]struct{} {
+	pasts := make(map[string]map[string]struct{}, len(blocks))
+	for _, block := range blocks {
+		past := make(map[string]struct{})
+		for _, parent := range block.Parents {
+			past[parent] = struct{}{}
+			for hash := range pasts[parent] {
+				past[hash] = struct{}{}
+			}
+		}
+		pasts[block.Hash] = past
+	}
+	return pasts
+}
+
+// blueSet returns the hashes of the blue blocks in the past of `block`, from the merge sets of its selected chain
+func blueSet(blocksByHash map[string]*Block, block *Block) map[string]struct{} {
+	blues := make(map[string]struct{})
+	for current := block; current.SelectedParent != ""; current = blocksByHash[current.SelectedParent] {
+		for _, blue := range current.MergeSetBlues {
+			blues[blue] = struct{}{}
+		}
+	}
+	return blues
+}
+
+func TestGHOSTDAGProperties(t *testing.T) {
+	for _, k := range []uint64{0, 1, 3, 18} {
+		k := k
+		t.Run(fmt.Sprintf("K=%d", k), func(t *testing.T) {
+			options := &Options{
+				K:             k,
+				Width:         4,
+				MaxParents:    5,
+				RedRate:       0.2,
+				ReorgRate:     0.1,
+				ReorgDepth:    3,
+				RoundInterval: time.Second,
+				Seed:          int64(k),
+			}
+			blocks := generate(t, options, 40)
+			blocksByHash := make(map[string]*Block, len(blocks))
+			for _, block := range blocks {
+				blocksByHash[block.Hash] = block
+			}
+			pasts := pasts(blocks)
+			isInAnticone := func(hash string, other string) bool {
+				_, isInPast := pasts[other][hash]
+				_, isInFuture := pasts[hash][other]
+				return hash != other && !isInPast && !isInFuture
+			}
+
+			redCount := 0
+			for _, block := range blocks[1:] {
+				redCount += len(block.MergeSetReds)
+				requireSelectedParentHeaviest(t, blocksByHash, block)
+				requireMergeSetPartition(t, k, pasts, block)
+				requireKCluster(t, k, blocksByHash, isInAnticone, block)
+				requireScores(t, blocksByHash, pasts, block)
+			}
+			if redCount == 0 {
+				t.Fatalf("No red block got generated")
+			}
+		})
+	}
+}
+
+// requireSelectedParentHeaviest fails the test if a parent of `block` has more blue work than its selected parent,
+// the highest hash breaking ties
+func requireSelectedParentHeaviest(t *testing.T, blocksByHash map[string]*Block, block *Block) {
+	t.Helper()
+
+	selectedParent := blocksByHash[block.SelectedParent]
+	for _, parentHash := range block.Parents {
+		parent := blocksByHash[parentHash]
+		if parent.BlueWork > selectedParent.BlueWork ||
+			(parent.BlueWork == selectedParent.BlueWork && parent.Hash > selectedParent.Hash) {
+			t.Fatalf("Parent %s of block %s has more blue work than its selected parent %s",
+				parent.Hash, block.Hash, selectedParent.Hash)
+		}
+	}
+}
+
+// requireMergeSetPartition fails the test if the blues and the reds of the merge set of `block` do not
+// partition its merge set: its selected parent and the blocks in its past but not in the past of its selected parent
+func requireMergeSetPartition(t *testing.T, k uint64, pasts map[string]map[string]struct{}, block *Block) {
+	t.Helper()
+
+	if len(block.MergeSetBlues) == 0 || block.MergeSetBlues[0] != block.SelectedParent {
+		t.Fatalf("Merge set blues of block %s do not start with its selected parent", block.Hash)
+	}
+	if uint64(len(block.MergeSetBlues)) > k+1 {
+		t.Fatalf("Block %s has %d blues in its merge set, more than K+1", block.Hash, len(block.MergeSetBlues))
+	}
+
+	mergeSet := map[string]struct{}{block.SelectedParent: {}}
+	for hash := range pasts[block.Hash] {
+		if _, ok := pasts[block.SelectedParent][hash]; !ok {
+			mergeSet[hash] = struct{}{}
+		}
+	}
+	colored := make(map[string]struct{})
+	for _, hash := range append(append([]string{}, block.MergeSetBlues...), block.MergeSetReds...) {
+		if _, ok := colored[hash]; ok {
+			t.Fatalf("Block %s is colored twice in the merge set of %s", hash, block.Hash)
+		}
+		if _, ok := mergeSet[hash]; !ok {
+			t.Fatalf("Block %s is colored in the merge set of %s without being in it", hash, block.Hash)
+		}
+		colored[hash] = struct{}{}
+	}
+	if len(colored) != len(mergeSet) {
+		t.Fatalf("%d blocks of the merge set of %s are colored, expected %d", len(colored), block.Hash, len(mergeSet))
+	}
+}
+
+// requireKCluster fails the test if a blue block in the past of `block` has more than K blue blocks in its anticone
+func requireKCluster(t *testing.T, k uint64, blocksByHash map[string]*Block,
+	isInAnticone func(hash string, other string) bool, block *Block) {
+
+	t.Helper()
+
+	blues := blueSet(blocksByHash, block)
+	for blue := range blues {
+		var anticoneSize uint64
+		for other := range blues {
+			if isInAnticone(blue, other) {
+				anticoneSize++
+			}
+		}
+		if anticoneSize > k {
+			t.Fatalf("Blue block %s has %d blue blocks in its anticone from the point of view of %s, more than K",
+				blue, anticoneSize, block.Hash)
+		}
+	}
+}
+
+// requireScores fails the test if the DAA score of `block` is not the size of its past, or its blue score
+// the number of blue blocks in its past. The DAA score then increases from every parent to its children.
+func requireScores(t *testing.T, blocksByHash map[string]*Block, pasts map[string]map[string]struct{}, block *Block) {
+	t.Helper()
+
+	if block.DAAScore != uint64(len(pasts[block.Hash])) {
+		t.Fatalf("Block %s has DAA score %d, expected %d", block.Hash, block.DAAScore, len(pasts[block.Hash]))
+	}
+	for _, parent := range block.Parents {
+		if blocksByHash[parent].DAAScore >= block.DAAScore {
+			t.Fatalf("Block %s has a DAA score not above the one of its parent %s", block.Hash, parent)
+		}
+	}
+	blueCount := uint64(len(blueSet(blocksByHash, block)))
+	if block.BlueScore != blueCount {
+		t.Fatalf("Block %s has blue score %d, expected %d", block.Hash, block.BlueScore, blueCount)
+	}
+	if block.BlueWork != block.BlueScore {
+		t.Fatalf("Block %s has blue work %d, expected its blue score %d", block.Hash, block.BlueWork, block.BlueScore)
+	}
+}
@@ -0,0 +1,52 @@
+package syntheticdag
+
+import (
+	"math/big"
+
+	"github.com/kaspanet/kaspad/domain/consensus/model/externalapi"
+	"github.com/kaspanet/kaspad/domain/consensus/utils/blockheader"
+	"github.com/kaspanet/kaspad/domain/consensus/utils/consensushashing"
+)
+
+const headerVersion = 1
+
+// Header returns the kaspad header of `block`. Its merkle roots, UTXO commitment and pruning point are
+// zero hashes, so its hash only depends on the parents, the GHOSTDAG data, the timestamp and the nonce.
+// Returns an error if a parent hash is malformed.
+func Header(block *Block) (externalapi.BlockHeader, error) {
+	var parents []externalapi.BlockLevelParents
+	if len(block.Parents) > 0 {
+		directParents := make(externalapi.BlockLevelParents, len(block.Parents))
+		for i, parent := range block.Parents {
+			parentHash, err := externalapi.NewDomainHashFromString(parent)
+			if err != nil {
+				return nil, err
+			}
+			directParents[i] = parentHash
+		}
+		parents = []externalapi.BlockLevelParents{directParents}
+	}
+	return blockheader.NewImmutableBlockHeader(
+		headerVersion,
+		parents,
+		externalapi.NewZeroHash(),
+		externalapi.NewZeroHash(),
+		externalapi.NewZeroHash(),
+		block.Timestamp,
+		0,
+		block.Nonce,
+		block.DAAScore,
+		block.BlueScore,
+		new(big.Int).SetUint64(block.BlueWork),
+		externalapi.NewZeroHash(),
+	), nil
+}
+
+// HeaderHash returns the hash of the header of `block`, the hash kaspad identifies it with
+func HeaderHash(block *Block) (string, error) {
+	header, err := Header(block)
+	if err != nil {
+		return "", err
+	}
+	return consensushashing.HeaderHash(header).String(), nil
+}